@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"filachat/internal/core"
+	"filachat/internal/importer"
+	"filachat/internal/models"
+	"filachat/internal/storage"
+	"filachat/pkg/config"
+	"filachat/pkg/server"
+	"flag"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Operational tasks run as subcommands of the server binary, e.g.
+//
+//	filagram create-admin -username alice -email alice@filagram.pl
+//
+// Without a subcommand the server starts, as before.
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the API and MQTT broker (default)", serve},
+	{"migrate", "create the database indexes", migrate},
+	{"encrypt-fields", "encrypt sensitive fields stored before field encryption was on", encryptFields},
+	{"rebuild-summaries", "recompute the conversation list from the stored messages", rebuildSummaries},
+	{"create-admin", "create an admin user or promote an existing one", createAdmin},
+	{"rotate-keys", "replace the token signing keys, signing everyone out", rotateKeys},
+	{"purge-user", "delete a user and all their data right away", purgeUser},
+	{"import", "import a conversation exported from Telegram or WhatsApp", importConversation},
+	{"keygen", "generate the signing keys and token secrets for a fresh install", keygen},
+}
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n", name)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	os.Exit(2)
+}
+
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg := config.Load()
+
+	db, err := server.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	if err := db.Migrate(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("indexes up to date")
+	return nil
+}
+
+func encryptFields(args []string) error {
+	flags := flag.NewFlagSet("encrypt-fields", flag.ExitOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg := config.Load()
+
+	db, err := server.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	if db.Fields == nil {
+		return errors.New("field encryption needs KMS_MASTER_KEY or KMS_VAULT_ADDRESS")
+	}
+	changed, err := db.EncryptFields(context.Background())
+	for collection, count := range changed {
+		fmt.Printf("%s: %d documents encrypted\n", collection, count)
+	}
+	return err
+}
+
+func rebuildSummaries(args []string) error {
+	flags := flag.NewFlagSet("rebuild-summaries", flag.ExitOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg := config.Load()
+
+	db, err := server.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	if err := db.RebuildConversationSummaries(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("conversation summaries rebuilt")
+	return nil
+}
+
+func createAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := flags.String("username", "", "username of the admin")
+	email := flags.String("email", "", "email, required for a new user")
+	password := flags.String("password", "", "password for a new user, read from stdin when empty")
+	role := flags.String("role", string(models.RoleAdmin), "admin, or moderator for the moderation routes only")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" {
+		return errors.New("-username is required")
+	}
+	if *role != string(models.RoleAdmin) && *role != string(models.RoleModerator) {
+		return errors.New("-role must be admin or moderator")
+	}
+	cfg := config.Load()
+
+	db, err := server.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	existing, err := db.GetUserByName(ctx, *username)
+	if err == nil {
+		if err := db.UpdateUser(ctx, existing.Id, bson.M{"role": *role}); err != nil {
+			return err
+		}
+		fmt.Printf("promoted %s (%s) to %s\n", existing.Username, existing.Id.Hex(), *role)
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("-email is required for a new user")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		return errors.New("empty password")
+	}
+
+	hash, err := core.NewHashing().Hash([]byte(*password))
+	if err != nil {
+		return err
+	}
+	user := models.User{
+		Id:       bson.NewObjectID(),
+		Username: *username,
+		Email:    *email,
+		Password: hash,
+		Role:     models.Role(*role),
+	}
+	if err := db.InsertUser(ctx, &user); err != nil {
+		return err
+	}
+	fmt.Printf("created %s %s (%s)\n", *role, user.Username, user.Id.Hex())
+	return nil
+}
+
+func keygen(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	dir := flags.String("dir", core.KeyDir, "directory for the key files")
+	write := flags.Bool("write", false, "append the generated secrets to CONFIG_FILE instead of only printing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg := config.Load()
+
+	envFile := ""
+	if *write {
+		envFile = cfg.ConfigFile
+	}
+	generated, secrets, err := server.EnsureKeys(*dir, envFile)
+	if err != nil {
+		return err
+	}
+
+	if generated {
+		fmt.Println("signing keys written to", *dir)
+	} else {
+		fmt.Println("signing keys already present in", *dir+", use rotate-keys to replace them")
+	}
+	if len(secrets) == 0 {
+		fmt.Println("token secrets already set")
+		return nil
+	}
+	if *write {
+		fmt.Println("token secrets appended to", envFile)
+		return nil
+	}
+	fmt.Println("add to your env file:")
+	for _, secret := range secrets {
+		fmt.Printf("%s=%s\n", secret[0], secret[1])
+	}
+	return nil
+}
+
+// rotateKeys moves the current keys aside before generating new ones, so a
+// rotation done by mistake can be undone by moving them back.
+func rotateKeys(args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	dir := flags.String("dir", core.KeyDir, "directory for the key files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	backup := filepath.Join(*dir, "rotated-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(backup, 0o700); err != nil {
+		return err
+	}
+	for _, name := range []string{"AccessPrivateKey.pem", "AccessPublicKey.pem", "RefreshPrivateKey.pem", "RefreshPublicKey.pem"} {
+		err := os.Rename(filepath.Join(*dir, name), filepath.Join(backup, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err := core.GenerateKeys(*dir, true); err != nil {
+		return err
+	}
+	fmt.Println("new signing keys written to", *dir+", old ones moved to", backup)
+	fmt.Println("restart the server; every issued token is invalid from then on")
+	return nil
+}
+
+func purgeUser(args []string) error {
+	flags := flag.NewFlagSet("purge-user", flag.ExitOnError)
+	id := flags.String("id", "", "id of the user")
+	username := flags.String("username", "", "username of the user")
+	yes := flags.Bool("yes", false, "confirm, nothing is deleted without it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg := config.Load()
+
+	db, err := server.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	var user models.User
+	switch {
+	case *id != "":
+		userId, err := bson.ObjectIDFromHex(*id)
+		if err != nil {
+			return err
+		}
+		// GetUser skips soft-deleted users, purging those is fine too
+		user = models.User{Id: userId}
+		if found, err := db.GetUser(ctx, userId); err == nil {
+			user = found
+		}
+	case *username != "":
+		if user, err = db.GetUserByName(ctx, *username); err != nil {
+			return err
+		}
+	default:
+		return errors.New("-id or -username is required")
+	}
+
+	if !*yes {
+		fmt.Printf("would purge user %s %s, rerun with -yes\n", user.Id.Hex(), user.Username)
+		return nil
+	}
+
+	attachments, uploads, err := db.PurgeUser(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	blobs := &storage.DiskStore{Root: cfg.StorageDir}
+	for _, attachment := range attachments {
+		_ = blobs.Delete(attachment.StorageKey)
+		if attachment.Thumbnail != nil {
+			_ = blobs.Delete(attachment.Thumbnail.StorageKey)
+		}
+	}
+	for _, upload := range uploads {
+		_ = blobs.Delete(storage.PartialKey(upload.Id.Hex()))
+	}
+	fmt.Printf("purged user %s with %d attachments\n", user.Id.Hex(), len(attachments))
+	return nil
+}
+
+// importConversation brings a conversation exported from another app into
+// the one of two users, like POST /imports does for a signed-in user.
+func importConversation(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "", "export file, e.g. result.json of Telegram Desktop")
+	source := flags.String("source", importer.SourceTelegram, "app the export comes from: telegram or whatsapp")
+	username := flags.String("username", "", "user importing the conversation")
+	peerName := flags.String("peer", "", "username of the other side of the conversation")
+	self := flags.String("self", "", "name or id of the importing user in the export")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || *username == "" || *peerName == "" || *self == "" {
+		return errors.New("-file, -username, -peer and -self are required")
+	}
+	cfg := config.Load()
+
+	db, err := server.OpenDB(cfg)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	user, err := db.GetUserByName(ctx, *username)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", *username, err)
+	}
+	peer, err := db.GetUserByName(ctx, *peerName)
+	if err != nil {
+		return fmt.Errorf("peer %s: %w", *peerName, err)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	export, err := importer.Parse(*source, f)
+	if err != nil {
+		return err
+	}
+	messages, err := importer.Build(export, user.Id, peer.Id, *self)
+	if err != nil {
+		return err
+	}
+
+	imported, err := db.ImportMessages(ctx, messages)
+	if err != nil {
+		return err
+	}
+	if err := db.RebuildConversationSummary(ctx, user.Id, peer.Id); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d messages, %d already there\n", imported, len(messages)-imported)
+	return nil
+}
@@ -1,23 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha3"
-	"filachat/internal/api/handlers"
-	"filachat/internal/api/hooks"
-imiddleware "filachat/internal/api/middleware"
-	"filachat/internal/core"
-	database "filachat/internal/data"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	"github.com/mochi-mqtt/server/v2"
-	"github.com/mochi-mqtt/server/v2/listeners"
+	"filachat/pkg/config"
+	"filachat/pkg/server"
+	"flag"
 	"golang.org/x/crypto/curve25519"
 	"io"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 type User struct {
@@ -46,59 +43,21 @@ func generateKeyPair() ([]byte, []byte) {
 	return publicKey, privateKey
 }
 
-func main() {
-	err := core.LoadKeys()
-	if err != nil {
-		panic(err)
-	}
-
-	mqttServer := mqtt.New(nil)
-
-	err = mqttServer.AddHook(new(hooks.JWTHook), nil);
-
-	tcp := listeners.NewTCP(listeners.Config{
-		Address: "0.0.0.0:1883",})
-
-	err = mqttServer.AddListener(tcp)
-	if err != nil {
-		return
+// serve runs the server until it fails or the process is told to stop.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
 	}
+	cfg := config.Load()
 
-	err = mqttServer.Serve()
+	srv, err := server.New(cfg)
 	if err != nil {
-		return
+		return err
 	}
-
-	e := echo.New()
-	e.Use(middleware.Logger())
-	e.Use(middleware.Recover())
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
-	}))
-	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
-		XSSProtection:      "1; mode=block",
-		XFrameOptions:      "SAMEORIGIN",
-		ContentTypeNosniff: "nosniff",
-		HSTSMaxAge:         3600,
-	}))
-	e.Use(middleware.BodyLimit("1M"))
-
-	client, err := database.Connect()
-	if err != nil {
-		panic(err)
-	}
-	db := database.DB{Db: client.Database("filagram")}
-	h := &handlers.Handler{DB: &db}
-	e.POST("/signup", imiddleware.UserAuth(h.SignUp))
-	e.POST("/signin", imiddleware.UserAuth(h.SignIn))
-	e.POST("/refresh-token", imiddleware.JWTRefreshAuth(h.RefreshToken))
-
-	e.File("/", "./public/index.html")
-
-	// go e.Logger.Fatal(e.StartTLS(":8080", "./secrets/cert.pem", "./secrets/key.pem"))
-	e.Logger.Fatal(e.StartAutoTLS("0.0.0.0:8080"))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return srv.Start(ctx)
 }
 
 func EncryptMessage(content, publicKey, privateKey []byte) (Message, error) {
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+// maxInboundEmail is what mail servers commonly accept, attachments
+// included.
+const maxInboundEmail = 25 << 20
+
+// maxBrokerSnapshot bounds the broker snapshots admins import.
+const maxBrokerSnapshot = 256 << 20
+
+type bodyLimit func(cfg *config.Config) int64
+
+func authBody(cfg *config.Config) int64 { return cfg.AuthBodyLimit }
+
+// streamed routes are not limited here, their handlers bound what they
+// store while reading the body, by quota or by a size the client declared.
+func streamed(*config.Config) int64 { return 0 }
+
+func fixedBody(limit int64) bodyLimit {
+	return func(*config.Config) int64 { return limit }
+}
+
+// bodyLimits holds the body size limit of every route that does not get
+// BODY_LIMIT, keyed by method and route path. Sign-in and other routes
+// open to anyone take small forms only.
+var bodyLimits = map[string]bodyLimit{
+	http.MethodPost + " /signup":                   authBody,
+	http.MethodPost + " /signin":                   authBody,
+	http.MethodPost + " /signin/magic":             authBody,
+	http.MethodPost + " /signin/magic/redeem":      authBody,
+	http.MethodPost + " /refresh-token":            authBody,
+	http.MethodPost + " /signout":                  authBody,
+	http.MethodPost + " /passkeys/login/begin":     authBody,
+	http.MethodPost + " /passkeys/login/finish":    authBody,
+	http.MethodPost + " /email/confirm":            authBody,
+	http.MethodPost + " /logins/report":            authBody,
+	http.MethodPost + " /attachments":              streamed,
+	http.MethodPatch + " /uploads/:id":             streamed,
+	http.MethodPut + " /attachments/:id/thumbnail": streamed,
+	http.MethodPost + " /imports":                  streamed,
+	http.MethodPost + " /email/inbound":            fixedBody(maxInboundEmail),
+	http.MethodPut + " /admin/broker/snapshot":     fixedBody(maxBrokerSnapshot),
+}
+
+// BodyLimit returns the body size limit of the route c matched, 0 for
+// none. Limits are looked up per request, so they follow config reloads.
+func BodyLimit(c echo.Context) int64 {
+	cfg := config.Current()
+	if limit, ok := bodyLimits[c.Request().Method+" "+c.Path()]; ok {
+		return limit(cfg)
+	}
+	return cfg.BodyLimit
+}
+
+// fileResponses are the routes answering with files and archives, which
+// the body log would have to hold in memory whole.
+var fileResponses = map[string]bool{
+	http.MethodGet + " /attachments/:id":              true,
+	http.MethodGet + " /attachments/:id/thumbnail":    true,
+	http.MethodGet + " /media/:id":                    true,
+	http.MethodGet + " /conversations/:peerId/export": true,
+	http.MethodGet + " /admin/broker/snapshot":        true,
+}
+
+// LogsBody reports whether the body log may buffer the request and response
+// of the route c matched: JSON requests of a bounded size, answered with
+// something other than a file.
+func LogsBody(c echo.Context) bool {
+	route := c.Request().Method + " " + c.Path()
+	if limit, ok := bodyLimits[route]; ok && limit(config.Current()) <= 0 {
+		return false
+	}
+	if contentType := c.Request().Header.Get(echo.HeaderContentType); contentType != "" && !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+		return false
+	}
+	return !fileResponses[route]
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// accountError refuses to sign in a user whose account is not active or
+// was locked after a reported login.
+func accountError(user models.User, now time.Time) error {
+	if state := user.State(now); state != models.AccountActive {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "account " + string(state)}
+	}
+	if user.Locked {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "account locked"}
+	}
+	return nil
+}
+
+// SetAccountState moves an account to another state, see
+// models.AccountState for the transitions allowed. Leaving the active state
+// ends the sessions of the user and disconnects their clients. Making an
+// account active also lifts the lock of a reported login, which is how a
+// locked account is given back to its owner.
+func (h *Handler) SetAccountState(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	var body struct {
+		State  models.AccountState `json:"state"`
+		Reason string              `json:"reason"`
+		Until  time.Time           `json:"until"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if !body.State.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid account state"}
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" && body.State != models.AccountActive {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing reason"}
+	}
+	now := time.Now()
+	if !body.Until.IsZero() && (body.State != models.AccountSuspended || !body.Until.After(now)) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid suspension end"}
+	}
+	if id == admin.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "cannot change own account state"}
+	}
+
+	user, err := h.DB.GetUser(ctx, id)
+	if err != nil || user.Host != "" {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	unlock := body.State == models.AccountActive && user.Locked
+	if !user.State(now).CanBecome(body.State) && !unlock {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "invalid account state transition"}
+	}
+
+	status := models.AccountStatus{State: body.State, Reason: body.Reason, Until: body.Until, ChangedBy: admin.Id, ChangedAt: now}
+	fields := bson.M{"account": status, "deactivated": body.State == models.AccountDeactivated}
+	if body.State == models.AccountActive {
+		fields["locked"] = false
+	}
+	if err := h.DB.UpdateUser(ctx, id, fields); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "account state not changed"}
+	}
+	h.Restricted.Update(id, status)
+	if body.State == models.AccountActive {
+		h.Restricted.Unlock(id)
+	} else {
+		h.endAccess(ctx, id, "account_"+string(body.State))
+	}
+
+	details := map[string]string{"reason": status.Reason}
+	if !status.Until.IsZero() {
+		details["until"] = status.Until.Format(time.RFC3339)
+	}
+	if unlock {
+		details["unlocked"] = "true"
+	}
+	h.audit(c, admin.Id, "account."+string(body.State), id, details)
+	return c.JSON(http.StatusOK, status)
+}
+
+// endAccess ends the sessions of a user, tells their clients why on the
+// control topic and disconnects them from the embedded broker. An external
+// broker keeps them connected until their access tokens expire, the
+// /mqtt/auth webhook refuses them from then on.
+func (h *Handler) endAccess(ctx context.Context, user bson.ObjectID, reason string) {
+	if _, err := h.DB.DeleteSessions(ctx, user, bson.ObjectID{}); err != nil {
+		log.Println("[WARN] sessions not ended", user.Hex(), err)
+	}
+	h.publishControl(ctx, models.ControlEvent{Type: models.ControlLogout, Reason: reason}, user)
+
+	if h.Embedded == nil {
+		return
+	}
+	for _, client := range h.Embedded.Clients.GetAll() {
+		if client.Net.Inline || string(client.Properties.Username) != user.Hex() {
+			continue
+		}
+		if err := h.Embedded.DisconnectClient(client, packets.ErrNotAuthorized); err != nil {
+			log.Println("[WARN] client not disconnected", client.ID, err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// requireAdmin returns the caller of an admin route. imiddleware.Authorize
+// checked the policy of the route and loaded them, a route outside it has
+// no caller and is refused.
+func (h *Handler) requireAdmin(c echo.Context) (models.User, error) {
+	user, ok := c.Get("account").(models.User)
+	if !ok {
+		return models.NilUser, &echo.HTTPError{Code: http.StatusForbidden, Message: "admin only"}
+	}
+	return user, nil
+}
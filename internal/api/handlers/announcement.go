@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (h *Handler) GetAnnouncements(c echo.Context) error {
+	announcements, err := h.DB.GetActiveAnnouncements(c.Request().Context(), time.Now())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "announcements not loaded"}
+	}
+	return c.JSON(http.StatusOK, announcements)
+}
+
+func (h *Handler) ListAllAnnouncements(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	announcements, err := h.DB.GetAnnouncements(c.Request().Context())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "announcements not loaded"}
+	}
+	return c.JSON(http.StatusOK, announcements)
+}
+
+// CreateAnnouncement publishes right away unless publish_at lies in the
+// future, the announcer picks scheduled ones up when they are due.
+func (h *Handler) CreateAnnouncement(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Title     string    `json:"title"`
+		Body      string    `json:"body"`
+		PublishAt time.Time `json:"publish_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Title) == "" || strings.TrimSpace(body.Body) == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing title or body"}
+	}
+
+	now := time.Now()
+	announcement := models.Announcement{
+		Id:        bson.NewObjectID(),
+		AuthorId:  admin.Id,
+		Title:     body.Title,
+		Body:      body.Body,
+		PublishAt: body.PublishAt,
+		ExpiresAt: body.ExpiresAt,
+		CreatedAt: now,
+	}
+	if announcement.PublishAt.IsZero() {
+		announcement.PublishAt = now
+	}
+	if !announcement.ExpiresAt.IsZero() && !announcement.ExpiresAt.After(announcement.PublishAt) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "expires_at must be after publish_at"}
+	}
+
+	if err := h.DB.SaveAnnouncement(c.Request().Context(), &announcement); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "announcement not saved"}
+	}
+	h.Announcer.Refresh(c.Request().Context())
+	h.audit(c, admin.Id, "announcement.create", announcement.Id, map[string]string{"title": announcement.Title})
+	return c.JSON(http.StatusCreated, announcement)
+}
+
+func (h *Handler) DeleteAnnouncement(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid announcement id"}
+	}
+	if err := h.DB.DeleteAnnouncement(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "announcement not found"}
+	}
+	h.Announcer.Refresh(c.Request().Context())
+	h.audit(c, admin.Id, "announcement.delete", id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
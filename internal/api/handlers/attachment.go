@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"errors"
+	database "filachat/internal/data"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Attachments are uploaded as the raw request body. For end-to-end encrypted
+// media the body is ciphertext and the server only ever sees its size.
+
+var errAttachmentTooLarge = &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "attachment too large"}
+var errQuotaExceeded = &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "storage quota exceeded"}
+
+func (h *Handler) UploadAttachment(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	req := c.Request()
+
+	owner, err := h.DB.GetUser(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if req.ContentLength > h.Config.MaxAttachmentSize {
+		return errAttachmentTooLarge
+	}
+	if req.ContentLength > 0 && owner.StorageUsed+req.ContentLength > h.Config.StorageQuota {
+		return errQuotaExceeded
+	}
+
+	attachment := models.Attachment{
+		Id:          bson.NewObjectID(),
+		OwnerId:     user.Id,
+		ContentType: req.Header.Get(echo.HeaderContentType),
+		CreatedAt:   time.Now(),
+	}
+	if attachment.ContentType == "" {
+		attachment.ContentType = echo.MIMEOctetStream
+	}
+	if raw := c.QueryParam("recipient_id"); raw != "" {
+		if attachment.RecipientId, err = bson.ObjectIDFromHex(raw); err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid recipient id"}
+		}
+	}
+	attachment.StorageKey = attachment.Id.Hex()
+	if err := bindPreviewParams(c, &attachment); err != nil {
+		return err
+	}
+
+	// read at most one byte past the limit to tell an exact fit from an overflow
+	size, err := h.Storage.Put(attachment.StorageKey, io.LimitReader(req.Body, h.Config.MaxAttachmentSize+1))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "attachment not stored"}
+	}
+	if size > h.Config.MaxAttachmentSize {
+		h.discardBlob(attachment.StorageKey)
+		return errAttachmentTooLarge
+	}
+	attachment.Size = size
+
+	if err := h.storeAttachment(c, &attachment); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+// storeAttachment takes a blob already written under attachment.StorageKey
+// through scanning, previews and quota accounting, and records it. The blob
+// is removed on any failure.
+func (h *Handler) storeAttachment(c echo.Context, attachment *models.Attachment) error {
+	if err := h.scanAttachment(c, attachment); err != nil {
+		return err
+	}
+	if !attachment.Encrypted && !attachment.Quarantined {
+		h.generatePreview(attachment)
+	}
+
+	if err := h.DB.ReserveStorage(c.Request().Context(), attachment.OwnerId, attachment.Size, h.Config.StorageQuota); err != nil {
+		h.discardAttachment(*attachment)
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			return errQuotaExceeded
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "attachment not stored"}
+	}
+	if err := h.DB.SaveAttachment(c.Request().Context(), attachment); err != nil {
+		h.discardAttachment(*attachment)
+		_ = h.DB.ReleaseStorage(c.Request().Context(), attachment.OwnerId, attachment.Size)
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "attachment not stored"}
+	}
+	if attachment.Quarantined {
+		return errAttachmentInfected
+	}
+	return nil
+}
+
+var errAttachmentInfected = &echo.HTTPError{Code: http.StatusUnprocessableEntity, Message: "attachment rejected by malware scan"}
+
+// scanAttachment runs the stored blob through the scanner. Infected uploads
+// are either dropped right away or kept flagged for an admin to review,
+// depending on SCAN_QUARANTINE. A scanner that cannot be reached fails the
+// upload rather than letting unscanned files through.
+func (h *Handler) scanAttachment(c echo.Context, attachment *models.Attachment) error {
+	blob, err := h.Storage.Open(attachment.StorageKey)
+	if err != nil {
+		h.discardBlob(attachment.StorageKey)
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "attachment not stored"}
+	}
+	result, err := h.Scanner.Scan(c.Request().Context(), blob)
+	blob.Close()
+	if err != nil {
+		log.Println("[WARN] attachment scan failed", attachment.Id.Hex(), err)
+		h.discardBlob(attachment.StorageKey)
+		return &echo.HTTPError{Code: http.StatusServiceUnavailable, Message: "attachment scan unavailable"}
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	details := map[string]string{"signature": result.Signature, "attachment_id": attachment.Id.Hex()}
+	if !h.Config.ScanQuarantine {
+		h.discardBlob(attachment.StorageKey)
+		h.audit(c, attachment.OwnerId, "attachment.rejected", attachment.Id, details)
+		return errAttachmentInfected
+	}
+	attachment.Quarantined = true
+	attachment.Signature = result.Signature
+	h.audit(c, attachment.OwnerId, "attachment.quarantined", attachment.Id, details)
+	return nil
+}
+
+func (h *Handler) discardAttachment(attachment models.Attachment) {
+	h.discardBlob(attachment.StorageKey)
+	if attachment.Thumbnail != nil {
+		h.discardBlob(attachment.Thumbnail.StorageKey)
+	}
+}
+
+func (h *Handler) discardBlob(key string) {
+	if err := h.Storage.Delete(key); err != nil {
+		log.Println("[WARN] blob not deleted", key, err)
+	}
+}
+
+// DownloadAttachment redirects to a short lived signed link, so clients can
+// keep using the plain path while the bytes are only served through /media.
+func (h *Handler) DownloadAttachment(c echo.Context) error {
+	return h.redirectToMedia(c, "")
+}
+
+// loadAttachment fetches an attachment the user may download: their own or
+// one sent to them, and never while quarantined.
+func (h *Handler) loadAttachment(c echo.Context, userId bson.ObjectID) (models.Attachment, error) {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return models.Attachment{}, &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid attachment id"}
+	}
+	attachment, err := h.DB.GetAttachment(c.Request().Context(), id)
+	if err != nil || attachment.Quarantined || (attachment.OwnerId != userId && attachment.RecipientId != userId) {
+		return models.Attachment{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+	return attachment, nil
+}
+
+func (h *Handler) DeleteAttachment(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid attachment id"}
+	}
+	attachment, err := h.DB.DeleteAttachment(c.Request().Context(), id, user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+
+	h.discardAttachment(attachment)
+	if err := h.DB.ReleaseStorage(c.Request().Context(), user.Id, attachment.Size); err != nil {
+		log.Println("[WARN] storage usage not released", user.Id.Hex(), err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) GetUsage(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	return c.JSON(http.StatusOK, models.StorageUsage{Used: user.StorageUsed, Quota: h.Config.StorageQuota})
+}
+
+func (h *Handler) ListQuarantine(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	attachments, err := h.DB.GetQuarantinedAttachments(c.Request().Context(), page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "quarantine not loaded"}
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(attachments, page, func(a models.Attachment) pagination.Cursor {
+		return pagination.Cursor{Time: a.CreatedAt, ID: a.Id}
+	}))
+}
+
+// ReleaseAttachment clears a quarantine flag after an admin judged the scan
+// a false positive.
+func (h *Handler) ReleaseAttachment(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid attachment id"}
+	}
+	if err := h.DB.ReleaseAttachment(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not quarantined"}
+	}
+	h.audit(c, admin.Id, "attachment.release", id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) PurgeAttachment(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid attachment id"}
+	}
+	attachment, err := h.DB.PurgeAttachment(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+
+	h.discardAttachment(attachment)
+	if err := h.DB.ReleaseStorage(c.Request().Context(), attachment.OwnerId, attachment.Size); err != nil {
+		log.Println("[WARN] storage usage not released", attachment.OwnerId.Hex(), err)
+	}
+	h.audit(c, admin.Id, "attachment.purge", id, map[string]string{"signature": attachment.Signature})
+	return c.NoContent(http.StatusNoContent)
+}
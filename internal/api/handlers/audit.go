@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// audit records a sensitive action. The audit trail is best effort, a failed
+// write is logged but does not fail the request.
+func (h *Handler) audit(c echo.Context, actor bson.ObjectID, action string, target bson.ObjectID, details map[string]string) {
+	entry := models.AuditEntry{
+		Id:        bson.NewObjectID(),
+		ActorId:   actor,
+		Action:    action,
+		TargetId:  target,
+		IP:        c.RealIP(),
+		Details:   details,
+		Timestamp: time.Now(),
+	}
+	if err := h.DB.SaveAuditEntry(c.Request().Context(), &entry); err != nil {
+		log.Println("[WARN] audit entry not saved", action, actor.Hex(), err)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+// publishBadges recounts the unread messages of users and publishes the
+// counts, retained, on their badges topic so app icons stay current without
+// a sync. Counting runs in the background, off the request that changed
+// them.
+func (h *Handler) publishBadges(ctx context.Context, users ...bson.ObjectID) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, user := range users {
+			direct, groups, err := h.DB.UnreadCounts(ctx, user)
+			if err != nil {
+				log.Println("[WARN] unread counts not loaded", user.Hex(), err)
+				continue
+			}
+			payload, _ := json.Marshal(models.Badges{
+				Unread:    direct + groups,
+				Direct:    direct,
+				Groups:    groups,
+				Timestamp: time.Now(),
+			})
+			if err := h.Broker.Publish(ctx, models.BadgesTopic(user), payload, true, broker.QoS(broker.ClassBadges)); err != nil {
+				log.Println("[WARN] badges not published", user.Hex(), err)
+			}
+		}
+	}()
+}
+
+// MarkMessagesRead flags direct messages the user received as read.
+func (h *Handler) MarkMessagesRead(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		MessageIds []bson.ObjectID `json:"message_ids"`
+	}
+	if err := c.Bind(&body); err != nil || len(body.MessageIds) == 0 || len(body.MessageIds) > maxReceiptBatch {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "between 1 and 500 message ids required"}
+	}
+
+	// direct messages have read receipts only, the first one tells they
+	// were delivered
+	unread, err := h.DB.UnreadReceiptMessages(c.Request().Context(), user.Id, body.MessageIds)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not loaded"}
+	}
+	changed, err := h.DB.MarkRead(c.Request().Context(), user.Id, body.MessageIds)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not updated"}
+	}
+	for _, message := range unread {
+		metrics.ObserveMessageStage("delivered", message.Via, message.ReceivedAt)
+	}
+	if changed > 0 {
+		h.publishBadges(c.Request().Context(), user.Id)
+		h.Summaries.Read(user.Id, body.MessageIds)
+	}
+	h.publishDebugEvent(c.Request().Context(), user.Id, models.DebugEvent{Type: models.DebugReceiptRecorded, Status: models.StatusRead, Count: int(changed)})
+	return c.NoContent(http.StatusNoContent)
+}
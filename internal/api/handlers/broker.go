@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"regexp"
+)
+
+// Chat events go out with MQTT 5 properties: the message id, its type and
+// the W3C trace context of the request that caused them as user properties,
+// so clients and tooling can route and correlate them without parsing the
+// payload, and an expiry on typing indicators, which are worthless late.
+// Clients on older protocol versions get the plain payload, as do all
+// clients in bridge mode, where the paho client speaks MQTT 3.1.1.
+
+const typingExpiry = 5 // seconds
+
+var traceparentPattern = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// traceparent continues the trace of a request, or starts one.
+func traceparent(c echo.Context) string {
+	if header := c.Request().Header.Get("traceparent"); traceparentPattern.MatchString(header) {
+		return header
+	}
+	return newTraceparent()
+}
+
+func newTraceparent() string {
+	ids := make([]byte, 24)
+	_, _ = rand.Read(ids)
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"
+}
+
+// publishMessage publishes a chat event through the broker's inline client,
+// the way Broker.Publish does, with the properties above.
+func (h *Handler) publishMessage(ctx context.Context, topic string, message *models.Message, payload []byte, qos byte) error {
+	if h.Embedded == nil {
+		return h.Broker.Publish(ctx, topic, payload, false, qos)
+	}
+	inline, ok := h.Embedded.Clients.Get(mqtt.InlineClientId)
+	if !ok {
+		return errors.New("inline client not enabled")
+	}
+
+	properties := packets.Properties{
+		ContentType:       "application/json",
+		PayloadFormat:     1,
+		PayloadFormatFlag: true,
+		User: []packets.UserProperty{
+			{Key: "message_id", Val: message.Id.Hex()},
+			{Key: "message_type", Val: string(message.Type)},
+		},
+	}
+	if message.Trace != "" {
+		properties.User = append(properties.User, packets.UserProperty{Key: "traceparent", Val: message.Trace})
+	}
+	if message.Type == models.TypeTyping {
+		properties.MessageExpiryInterval = typingExpiry
+	}
+
+	return h.Embedded.InjectPacket(inline, packets.Packet{
+		FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: qos},
+		TopicName:   topic,
+		Payload:     payload,
+		Properties:  properties,
+		PacketID:    uint16(qos),
+	})
+}
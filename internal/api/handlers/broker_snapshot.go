@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"filachat/internal/broker"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+// ExportBrokerSnapshot downloads the retained messages and subscriptions of
+// the embedded broker, to be imported with ImportBrokerSnapshot on the host
+// the broker moves to.
+func (h *Handler) ExportBrokerSnapshot(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+	if h.Embedded == nil {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "broker not embedded"}
+	}
+
+	snapshot := broker.TakeSnapshot(h.Embedded)
+	h.audit(c, admin.Id, "broker.export", admin.Id, map[string]string{
+		"retained":      strconv.Itoa(len(snapshot.Retained)),
+		"subscriptions": strconv.Itoa(len(snapshot.Subscriptions)),
+	})
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="broker-snapshot.json"`)
+	return c.JSON(http.StatusOK, snapshot)
+}
+
+// ImportBrokerSnapshot restores an exported snapshot into the embedded
+// broker. Retained messages replace those on the same topics, everything
+// else the broker holds is kept.
+func (h *Handler) ImportBrokerSnapshot(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+	if h.Embedded == nil {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "broker not embedded"}
+	}
+
+	var snapshot broker.Snapshot
+	if err := c.Bind(&snapshot); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if !snapshot.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid broker snapshot"}
+	}
+
+	retained, subscriptions := broker.RestoreSnapshot(h.Embedded, snapshot)
+	h.audit(c, admin.Id, "broker.import", admin.Id, map[string]string{
+		"retained":      strconv.Itoa(retained),
+		"subscriptions": strconv.Itoa(subscriptions),
+	})
+	return c.JSON(http.StatusOK, struct {
+		Retained      int `json:"retained"`
+		Subscriptions int `json:"subscriptions"`
+	}{retained, subscriptions})
+}
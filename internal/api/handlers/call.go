@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/i18n"
+	"filachat/internal/models"
+	"filachat/internal/push"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Calls are negotiated over REST and the WebRTC offer/answer/ICE exchange is
+// relayed by the broker on the call topic. Call state changes are also pushed
+// to both participants' calls topics.
+
+const callRingTimeout = 45 * time.Second
+
+func (h *Handler) publishCallEvent(ctx context.Context, eventType string, call models.Call) {
+	payload, _ := json.Marshal(models.CallEvent{Type: eventType, Call: call, Timestamp: time.Now()})
+	for _, user := range []bson.ObjectID{call.CallerId, call.CalleeId} {
+		if err := h.Broker.Publish(ctx, models.CallsTopic(user), payload, false, broker.QoS(broker.ClassCalls)); err != nil {
+			log.Println("[WARN] call event not published", call.Id.Hex(), err)
+		}
+	}
+}
+
+func (h *Handler) StartCall(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		CalleeId bson.ObjectID    `json:"callee_id"`
+		Media    models.CallMedia `json:"media"`
+	}
+	if err := c.Bind(&body); err != nil || body.CalleeId.IsZero() || body.CalleeId == user.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid callee"}
+	}
+	if body.Media == "" {
+		body.Media = models.CallAudio
+	}
+	if body.Media != models.CallAudio && body.Media != models.CallVideo {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid media"}
+	}
+	if _, err := h.DB.GetUser(c.Request().Context(), body.CalleeId); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "callee not found"}
+	}
+
+	call := models.Call{
+		Id:        bson.NewObjectID(),
+		CallerId:  user.Id,
+		CalleeId:  body.CalleeId,
+		Media:     body.Media,
+		Status:    models.CallRinging,
+		Topic:     models.CallTopic(user.Id, body.CalleeId),
+		CreatedAt: time.Now(),
+	}
+	if err := h.DB.SaveCall(c.Request().Context(), &call); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "call not started"}
+	}
+
+	h.publishCallEvent(c.Request().Context(), "ringing", call)
+	// the timer outlives the request, keep its values but not its deadline
+	ctx := context.WithoutCancel(c.Request().Context())
+	time.AfterFunc(callRingTimeout, func() { h.expireCall(ctx, call.Id) })
+	return c.JSON(http.StatusCreated, call)
+}
+
+// expireCall marks a call that is still ringing as missed.
+func (h *Handler) expireCall(ctx context.Context, id bson.ObjectID) {
+	call, err := h.DB.TransitionCall(ctx, id, []models.CallStatus{models.CallRinging}, models.CallMissed, bson.M{"ended_at": time.Now()})
+	if err != nil {
+		return
+	}
+	h.publishCallEvent(ctx, "missed", call)
+	h.notifyMissedCall(ctx, call)
+}
+
+// notifyMissedCall leaves a system message in the callee's history and
+// pushes a notification, the callee may not have been connected at all.
+func (h *Handler) notifyMissedCall(ctx context.Context, call models.Call) {
+	params := map[string]string{"call_id": call.Id.Hex(), "media": string(call.Media)}
+	if err := h.postSystemMessage(ctx, call.CalleeId, call.CallerId, models.SystemMissedCall, params); err != nil {
+		log.Println("[WARN] missed call message not saved", call.Id.Hex(), err)
+	}
+
+	locale := i18n.Default
+	if callee, err := h.DB.GetUser(ctx, call.CalleeId); err == nil {
+		locale = h.locale(callee)
+	}
+	title := i18n.T(locale, "push.missed_call", nil)
+	if caller, err := h.DB.GetUser(ctx, call.CallerId); err == nil {
+		title = i18n.T(locale, "push.missed_call_from", map[string]string{"username": caller.Username})
+	}
+	h.Push.Notify(push.Notification{
+		UserId: call.CalleeId,
+		Title:  title,
+		Body:   i18n.T(locale, "push.call_back", nil),
+		Data:   params,
+	})
+}
+
+func (h *Handler) AcceptCall(c echo.Context) error {
+	return h.answerCall(c, models.CallAccepted, bson.M{"answered_at": time.Now()})
+}
+
+func (h *Handler) DeclineCall(c echo.Context) error {
+	return h.answerCall(c, models.CallDeclined, bson.M{"ended_at": time.Now()})
+}
+
+func (h *Handler) answerCall(c echo.Context, to models.CallStatus, fields bson.M) error {
+	user := c.Get("user").(*models.User)
+
+	call, err := h.loadCall(c)
+	if err != nil {
+		return err
+	}
+	if call.CalleeId != user.Id {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "not the callee"}
+	}
+
+	call, err = h.DB.TransitionCall(c.Request().Context(), call.Id, []models.CallStatus{models.CallRinging}, to, fields)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "call no longer ringing"}
+	}
+	h.publishCallEvent(c.Request().Context(), string(to), call)
+	return c.JSON(http.StatusOK, call)
+}
+
+func (h *Handler) EndCall(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	call, err := h.loadCall(c)
+	if err != nil {
+		return err
+	}
+	if call.CallerId != user.Id && call.CalleeId != user.Id {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "not a participant"}
+	}
+
+	// hanging up before the callee answered cancels the call
+	from, to := []models.CallStatus{models.CallAccepted}, models.CallEnded
+	if call.Status == models.CallRinging && call.CallerId == user.Id {
+		from, to = []models.CallStatus{models.CallRinging}, models.CallCanceled
+	}
+	now := time.Now()
+	fields := bson.M{"ended_at": now}
+	if to == models.CallEnded {
+		fields["duration"] = int64(now.Sub(call.AnsweredAt).Seconds())
+	}
+	call, err = h.DB.TransitionCall(c.Request().Context(), call.Id, from, to, fields)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "call already finished"}
+	}
+	h.publishCallEvent(c.Request().Context(), string(to), call)
+	if to == models.CallCanceled {
+		h.notifyMissedCall(c.Request().Context(), call)
+	}
+	return c.JSON(http.StatusOK, call)
+}
+
+func (h *Handler) GetCalls(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	calls, err := h.DB.GetCalls(c.Request().Context(), user.Id, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "calls not loaded"}
+	}
+
+	return c.JSON(http.StatusOK, pagination.NewResult(calls, page, func(call models.Call) pagination.Cursor {
+		return pagination.Cursor{Time: call.CreatedAt, ID: call.Id}
+	}))
+}
+
+func (h *Handler) loadCall(c echo.Context) (models.Call, error) {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return models.Call{}, &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid call id"}
+	}
+	call, err := h.DB.GetCall(c.Request().Context(), id)
+	if err != nil {
+		return models.Call{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "call not found"}
+	}
+	return call, nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type capabilities struct {
+	Features   map[string]bool        `json:"features"`
+	Limits     map[string]int64       `json:"limits"`
+	Encryption encryptionCapabilities `json:"encryption"`
+	// CommandKey verifies signed logout and wipe commands, see
+	// models.ControlEvent, and sender certificates of sealed messages.
+	CommandKey []byte `json:"command_key,omitempty"`
+}
+
+type encryptionCapabilities struct {
+	Default          models.EncryptionMode   `json:"default"`
+	Modes            []models.EncryptionMode `json:"modes"`
+	EnvelopeVersions []int                   `json:"envelope_versions"`
+	// PaddingBuckets are the sizes clients pad plain text to before
+	// encrypting it, see padding.Buckets.Pad.
+	PaddingBuckets []int64 `json:"padding_buckets,omitempty"`
+}
+
+// GetCapabilities tells clients, signed in or not, what this deployment
+// offers, so they adapt to it instead of to a server version.
+func (h *Handler) GetCapabilities(c echo.Context) error {
+	cfg := config.Current()
+	embedded := h.Config.BrokerMode != broker.ModeBridge
+
+	result := capabilities{
+		Features: map[string]bool{
+			"groups":            cfg.FeatureGroups,
+			"calls":             cfg.FeatureCalls,
+			"channels":          cfg.FeatureChannels,
+			"organizations":     true,
+			"passkeys":          h.WebAuthn != nil,
+			"magic_links":       true,
+			"resumable_uploads": true,
+			"invite_only":       cfg.InviteOnly,
+			"email_gateway":     h.Config.EmailGatewayDomain != "",
+			"federation":        h.Federation != nil,
+			"sealed_sender":     true,
+			// publishing messages over MQTT and CBOR payloads are done by
+			// hooks of the embedded broker
+			"mqtt_send": embedded,
+			"cbor":      embedded,
+		},
+		Limits: map[string]int64{
+			"max_attachment_size": h.Config.MaxAttachmentSize,
+			"max_body_size":       cfg.BodyLimit,
+			"max_group_members":   maxGroupMembers,
+			"max_key_fetch":       maxKeyFetch,
+			"max_receipt_batch":   maxReceiptBatch,
+		},
+		Encryption: encryptionCapabilities{
+			Default:          models.EncryptionMode(h.Config.DefaultEncryption),
+			Modes:            []models.EncryptionMode{models.EncryptionE2E},
+			EnvelopeVersions: models.EnvelopeVersions,
+			PaddingBuckets:   cfg.PaddingBuckets,
+		},
+		CommandKey: h.Tokens.CommandKey(),
+	}
+	if h.Keys != nil {
+		result.Encryption.Modes = append(result.Encryption.Modes, models.EncryptionServer)
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"filachat/internal/moderation"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Public channels are not end-to-end encrypted, which is what makes them
+// moderatable: every post runs through the moderation pipeline before it is
+// published.
+
+func (h *Handler) CreateChannel(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		Name  string        `json:"name"`
+		OrgId bson.ObjectID `json:"org_id"`
+	}
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Name) == "" || len(body.Name) > 64 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid channel name"}
+	}
+	// channels speak for an organization, so only its admins open them
+	if !body.OrgId.IsZero() {
+		if err := h.requireOrgRole(c.Request().Context(), body.OrgId, user.Id, true); err != nil {
+			return err
+		}
+	}
+
+	channel := models.Channel{
+		Id:        bson.NewObjectID(),
+		Name:      strings.TrimSpace(body.Name),
+		OwnerId:   user.Id,
+		OrgId:     body.OrgId,
+		CreatedAt: time.Now(),
+	}
+	if err := h.DB.SaveChannel(c.Request().Context(), &channel); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "channel not created"}
+	}
+	return c.JSON(http.StatusCreated, channel)
+}
+
+func (h *Handler) GetChannelPosts(c echo.Context) error {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid channel id"}
+	}
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	posts, err := h.DB.GetPosts(c.Request().Context(), id, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "posts not loaded"}
+	}
+	for i := range posts {
+		posts[i].Reasons = nil
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(posts, page, func(p models.ChannelPost) pagination.Cursor {
+		return pagination.Cursor{Time: p.Timestamp, ID: p.Id}
+	}))
+}
+
+func (h *Handler) CreatePost(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid channel id"}
+	}
+	if _, err := h.DB.GetChannel(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "channel not found"}
+	}
+
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Content) == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing content"}
+	}
+
+	decision, err := h.Moderation.Evaluate(c.Request().Context(), body.Content)
+	if err != nil {
+		log.Println("[WARN] moderation failed", err)
+		return &echo.HTTPError{Code: http.StatusServiceUnavailable, Message: "moderation unavailable"}
+	}
+	if decision.Verdict == moderation.Reject {
+		h.audit(c, user.Id, "post.rejected", id, map[string]string{"reasons": strings.Join(decision.Reasons, ",")})
+		return &echo.HTTPError{Code: http.StatusUnprocessableEntity, Message: "post rejected by moderation"}
+	}
+
+	post := models.ChannelPost{
+		Id:        bson.NewObjectID(),
+		ChannelId: id,
+		AuthorId:  user.Id,
+		Content:   body.Content,
+		Status:    models.PostPublished,
+		Reasons:   decision.Reasons,
+		Timestamp: time.Now(),
+	}
+	switch decision.Verdict {
+	case moderation.Flag:
+		post.Status = models.PostFlagged
+	case moderation.Hold:
+		post.Status = models.PostHeld
+	}
+
+	if err := h.DB.SavePost(c.Request().Context(), &post); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "post not saved"}
+	}
+	if post.Status != models.PostHeld {
+		h.publishPost(c.Request().Context(), post)
+		return c.JSON(http.StatusCreated, post)
+	}
+	return c.JSON(http.StatusAccepted, post)
+}
+
+func (h *Handler) publishPost(ctx context.Context, post models.ChannelPost) {
+	post.Reasons = nil
+	payload, _ := json.Marshal(post)
+	if err := h.Broker.Publish(ctx, models.ChannelTopic(post.ChannelId), payload, false, broker.QoS(broker.ClassChannels)); err != nil {
+		log.Println("[WARN] post not published", post.Id.Hex(), err)
+	}
+}
+
+func (h *Handler) ListModerationQueue(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	posts, err := h.DB.GetReviewQueue(c.Request().Context(), page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "queue not loaded"}
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(posts, page, func(p models.ChannelPost) pagination.Cursor {
+		return pagination.Cursor{Time: p.Timestamp, ID: p.Id}
+	}))
+}
+
+func (h *Handler) ApprovePost(c echo.Context) error {
+	return h.reviewPost(c, models.PostPublished)
+}
+
+func (h *Handler) RejectPost(c echo.Context) error {
+	return h.reviewPost(c, models.PostRejected)
+}
+
+// reviewPost settles a held or flagged post. Approving a held post publishes
+// it, rejecting a flagged one cannot unpublish what readers already got but
+// removes it from the channel history.
+func (h *Handler) reviewPost(c echo.Context, status models.PostStatus) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid post id"}
+	}
+	post, err := h.DB.ReviewPost(c.Request().Context(), id, status, admin.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "post not in review"}
+	}
+
+	if status == models.PostPublished && post.Status == models.PostHeld {
+		post.Status = status
+		h.publishPost(c.Request().Context(), post)
+	}
+	h.audit(c, admin.Id, "post."+string(status), id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
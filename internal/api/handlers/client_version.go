@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+)
+
+type clientVersionPolicy struct {
+	Minimum     string `json:"minimum"`
+	Recommended string `json:"recommended"`
+}
+
+func (h *Handler) GetClientVersionPolicy(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	cfg := config.Current()
+	return c.JSON(http.StatusOK, clientVersionPolicy{Minimum: cfg.MinClientVersion, Recommended: cfg.RecommendedClientVersion})
+}
+
+// SetClientVersionPolicy stores the policy in the runtime settings, so
+// every instance applies it on its next config reload, this one right away.
+// Empty versions turn the checks off.
+func (h *Handler) SetClientVersionPolicy(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body clientVersionPolicy
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	var minimum, recommended models.ClientVersion
+	if body.Minimum != "" {
+		if minimum, err = models.ParseClientVersion(body.Minimum); err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid minimum version"}
+		}
+		body.Minimum = minimum.String()
+	}
+	if body.Recommended != "" {
+		if recommended, err = models.ParseClientVersion(body.Recommended); err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid recommended version"}
+		}
+		if recommended.Less(minimum) {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "recommended version below the minimum"}
+		}
+		body.Recommended = recommended.String()
+	}
+
+	err = h.DB.SetSettings(c.Request().Context(), map[string]string{
+		"MIN_CLIENT_VERSION":         body.Minimum,
+		"RECOMMENDED_CLIENT_VERSION": body.Recommended,
+	})
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "policy not saved"}
+	}
+	if h.Watcher != nil {
+		if err := h.Watcher.Reload(); err != nil {
+			log.Println("[WARN] config reload failed", err)
+		}
+	}
+
+	h.broadcastControl(c.Request().Context(), models.ControlEvent{
+		Type:    models.ControlPolicy,
+		Reason:  "client_version",
+		Details: map[string]string{"minimum": body.Minimum, "recommended": body.Recommended},
+	})
+	h.audit(c, admin.Id, "client_version.set", admin.Id, map[string]string{"minimum": body.Minimum, "recommended": body.Recommended})
+	return c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"time"
+)
+
+// SetPublicKey publishes the X25519 key peers encrypt messages to. Adding
+// a contact through a token hands it out along with the profile.
+func (h *Handler) SetPublicKey(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		PublicKey []byte `json:"public_key"`
+	}
+	if err := c.Bind(&body); err != nil || len(body.PublicKey) != 32 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid public key"}
+	}
+	if err := h.DB.UpdateUser(c.Request().Context(), user.Id, bson.M{"public_key": body.PublicKey}); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "public key not saved"}
+	}
+	return c.JSON(http.StatusOK, models.User{Id: user.Id, PublicKey: body.PublicKey})
+}
+
+// CreateContactToken issues a token to show as QR code or share as link,
+// whoever scans it is shown the caller's profile. Tokens are not stored,
+// they simply expire.
+func (h *Handler) CreateContactToken(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	token, expires := h.Contacts.Issue(user.Id)
+	return c.JSON(http.StatusCreated, struct {
+		Token     string    `json:"token"`
+		Link      string    `json:"link"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{token, h.Config.PublicURL + "/add/" + token, expires})
+}
+
+func (h *Handler) ResolveContactToken(c echo.Context) error {
+	id, err := h.Contacts.Resolve(c.Param("token"))
+	if errors.Is(err, core.ErrTokenExpired) {
+		return &echo.HTTPError{Code: http.StatusGone, Message: "contact token expired"}
+	}
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid contact token"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), id)
+	if err != nil || user.Deactivated {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	return c.JSON(http.StatusOK, models.User{Id: user.Id, Username: user.Username, PublicKey: user.PublicKey})
+}
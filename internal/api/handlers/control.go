@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// publishControl publishes event, retained, on the control topic of each
+// user.
+func (h *Handler) publishControl(ctx context.Context, event models.ControlEvent, users ...bson.ObjectID) {
+	event.Id = bson.NewObjectID()
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	for _, user := range users {
+		h.publishControlOn(ctx, models.ControlTopic(user), event)
+	}
+}
+
+func (h *Handler) publishControlOn(ctx context.Context, topic string, event models.ControlEvent) {
+	if event.Id.IsZero() {
+		event.Id = bson.NewObjectID()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	payload, _ := json.Marshal(event)
+	if err := h.Broker.Publish(ctx, topic, payload, true, broker.QoS(broker.ClassSystem)); err != nil {
+		log.Println("[WARN] control event not published", event.Type, topic, err)
+	}
+}
+
+// broadcastControl publishes event to every user connected to the embedded
+// broker and returns them. Clients of an external broker only get the
+// broadcast system topics, such as MaintenanceTopic.
+func (h *Handler) broadcastControl(ctx context.Context, event models.ControlEvent) []bson.ObjectID {
+	if h.Embedded == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var users []bson.ObjectID
+	for _, client := range h.Embedded.Clients.GetAll() {
+		name := string(client.Properties.Username)
+		if client.Net.Inline || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if user, err := bson.ObjectIDFromHex(name); err == nil {
+			users = append(users, user)
+		}
+	}
+	h.publishControl(ctx, event, users...)
+	return users
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Conversations are end-to-end encrypted unless their participants switch
+// them to server side encryption, where clients send plain text and the
+// server seals it at rest under a per-conversation data key from the KMS.
+// Only the server mode leaves chats searchable and moderatable.
+
+var errEncryptionUnavailable = errors.New("server side encryption not configured")
+
+// conversation loads the settings between two users, falling back to the
+// configured default for conversations nobody changed yet.
+func (h *Handler) conversation(ctx context.Context, a bson.ObjectID, b bson.ObjectID) (models.Conversation, error) {
+	id := models.ConversationId(a, b)
+	conversation, err := h.DB.GetConversation(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.Conversation{
+			Id:           id,
+			Participants: []bson.ObjectID{a, b},
+			Encryption:   models.EncryptionMode(h.Config.DefaultEncryption),
+		}, nil
+	}
+	return conversation, err
+}
+
+// dataKey returns the wrapped key of a conversation, creating it the first
+// time the conversation needs one.
+func (h *Handler) dataKey(ctx context.Context, conversation *models.Conversation) ([]byte, error) {
+	if h.Keys == nil {
+		return nil, errEncryptionUnavailable
+	}
+	if conversation.DataKey != nil {
+		return conversation.DataKey, nil
+	}
+	wrapped, err := h.Keys.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	conversation.DataKey, err = h.DB.EnsureDataKey(ctx, conversation, wrapped)
+	return conversation.DataKey, err
+}
+
+// sealForStorage returns a message the way it is stored: with its content
+// sealed when the conversation uses server side encryption. Group messages
+// are always end-to-end encrypted.
+func (h *Handler) sealForStorage(ctx context.Context, message models.Message) (models.Message, error) {
+	if message.Content == "" || !message.GroupId.IsZero() {
+		return message, nil
+	}
+	conversation, err := h.conversation(ctx, message.SenderId, message.RecipientId)
+	if err != nil {
+		return message, err
+	}
+	if conversation.Encryption != models.EncryptionServer {
+		return message, nil
+	}
+
+	key, err := h.dataKey(ctx, &conversation)
+	if err != nil {
+		return message, err
+	}
+	message.Content, err = h.Keys.SealString(key, message.Content)
+	message.Sealed = true
+	// sealing changed the length the padding was for
+	pad(&message)
+	return message, err
+}
+
+// messageOpener returns a func that unseals stored messages in place. Keys
+// are looked up once per conversation for the lifetime of the func.
+func (h *Handler) messageOpener(ctx context.Context) func(message *models.Message) {
+	keys := make(map[string][]byte)
+	return func(message *models.Message) {
+		if !message.Sealed {
+			return
+		}
+		id := models.ConversationId(message.SenderId, message.RecipientId)
+		key, ok := keys[id]
+		if !ok {
+			if conversation, err := h.DB.GetConversation(ctx, id); err == nil {
+				key = conversation.DataKey
+			}
+			keys[id] = key
+		}
+
+		content := ""
+		if key != nil && h.Keys != nil {
+			var err error
+			if content, err = h.Keys.OpenString(key, message.Content); err != nil {
+				log.Println("[WARN] message not unsealed", message.Id.Hex(), err)
+			}
+		}
+		message.Content, message.Sealed = content, false
+	}
+}
+
+func (h *Handler) openMessages(ctx context.Context, messages []models.Message) {
+	open := h.messageOpener(ctx)
+	for i := range messages {
+		open(&messages[i])
+	}
+}
+
+// ListConversations pages through the direct conversations of the user,
+// most recent first, from the summaries kept by jobs.SummaryProjector.
+// ?label= keeps those the user put the label on.
+func (h *Handler) ListConversations(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	label := models.NormalizeLabel(c.QueryParam("label"))
+	settings, err := h.DB.FindConversationSettings(c.Request().Context(), user.Id, label)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "conversations not loaded"}
+	}
+	labels := make(map[string][]string, len(settings))
+	for _, peer := range settings {
+		labels[models.ConversationId(user.Id, peer.PeerId)] = peer.Labels
+	}
+	var ids []string
+	if label != "" {
+		ids = make([]string, 0, len(labels))
+		for id := range labels {
+			ids = append(ids, id)
+		}
+	}
+
+	summaries, err := h.DB.GetConversationSummaries(c.Request().Context(), user.Id, ids, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "conversations not loaded"}
+	}
+	for i := range summaries {
+		summaries[i].UnreadCount = summaries[i].Unread[user.Id.Hex()]
+		summaries[i].Labels = labels[summaries[i].Id]
+		summaries[i].Self = summaries[i].Id == models.ConversationId(user.Id, user.Id)
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(summaries, page, func(s models.ConversationSummary) pagination.Cursor {
+		return pagination.Cursor{Time: s.UpdatedAt, ID: s.LastMessageId}
+	}))
+}
+
+func (h *Handler) GetConversation(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	conversation, err := h.conversation(c.Request().Context(), user.Id, peerId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "conversation not loaded"}
+	}
+	return c.JSON(http.StatusOK, conversation)
+}
+
+// SetConversationEncryption switches how new messages of a conversation are
+// protected. Messages already stored keep the mode they were sent with.
+func (h *Handler) SetConversationEncryption(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil || peerId == user.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	var body struct {
+		Encryption models.EncryptionMode `json:"encryption"`
+	}
+	if err := c.Bind(&body); err != nil || !body.Encryption.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid encryption mode"}
+	}
+	if _, err := h.DB.GetUser(c.Request().Context(), peerId); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "peer not found"}
+	}
+
+	conversation, err := h.conversation(c.Request().Context(), user.Id, peerId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "conversation not loaded"}
+	}
+	if body.Encryption == models.EncryptionServer {
+		// fail before switching rather than on the next message
+		if _, err := h.dataKey(c.Request().Context(), &conversation); err != nil {
+			if errors.Is(err, errEncryptionUnavailable) {
+				return &echo.HTTPError{Code: http.StatusServiceUnavailable, Message: err.Error()}
+			}
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "conversation key not created"}
+		}
+	}
+	if conversation.Encryption == body.Encryption {
+		return c.JSON(http.StatusOK, conversation)
+	}
+
+	conversation.Encryption = body.Encryption
+	conversation.UpdatedBy = user.Id
+	conversation.UpdatedAt = time.Now()
+	if err := h.DB.SetConversationEncryption(c.Request().Context(), &conversation); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "conversation not saved"}
+	}
+	h.audit(c, user.Id, "conversation.encryption", peerId, map[string]string{"encryption": string(body.Encryption)})
+
+	// both sides see the switch in their history
+	params := map[string]string{"encryption": string(body.Encryption), "by": user.Id.Hex()}
+	if err := h.postSystemMessage(c.Request().Context(), peerId, user.Id, models.SystemEncryptionChanged, params); err != nil {
+		log.Println("[WARN] encryption change not posted", conversation.Id, err)
+	}
+	if err := h.postSystemMessage(c.Request().Context(), user.Id, peerId, models.SystemEncryptionChanged, params); err != nil {
+		log.Println("[WARN] encryption change not posted", conversation.Id, err)
+	}
+	return c.JSON(http.StatusOK, conversation)
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// publishDebugEvent tells the clients of user listening on their events
+// topic what the server did. With the embedded broker nothing is published
+// while no one listens, which is the usual case.
+func (h *Handler) publishDebugEvent(ctx context.Context, user bson.ObjectID, event models.DebugEvent) {
+	topic := models.EventsTopic(user)
+	if h.Embedded != nil {
+		subscribers := h.Embedded.Topics.Subscribers(topic)
+		if len(subscribers.Subscriptions) == 0 && len(subscribers.InlineSubscriptions) == 0 {
+			return
+		}
+	}
+	event.At = time.Now()
+	payload, _ := json.Marshal(event)
+	if err := h.Broker.Publish(ctx, topic, payload, false, broker.QoS(broker.ClassEvents)); err != nil {
+		log.Println("[WARN] debug event not published", user.Hex(), err)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+// MarkDeviceReceipts lets a device report direct messages it got or read.
+// The sender hears of a message once per status, from the first device of
+// the recipient to get there; GetDeviceReceipts has the rest.
+func (h *Handler) MarkDeviceReceipts(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	ctx := c.Request().Context()
+
+	var body struct {
+		DeviceId   bson.ObjectID     `json:"device_id"`
+		Status     models.StatusType `json:"status"`
+		MessageIds []bson.ObjectID   `json:"message_ids"`
+	}
+	if err := c.Bind(&body); err != nil || !body.Status.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid receipt status"}
+	}
+	if len(body.MessageIds) == 0 || len(body.MessageIds) > maxReceiptBatch {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "between 1 and 500 message ids required"}
+	}
+	// the id the device has in its MQTT client id, see MQTTToken
+	if body.DeviceId.IsZero() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing device id"}
+	}
+
+	messages, err := h.DB.DirectReceiptMessages(ctx, user.Id, body.MessageIds)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not loaded"}
+	}
+	ids := make([]bson.ObjectID, len(messages))
+	byId := make(map[bson.ObjectID]models.Message, len(messages))
+	for i, message := range messages {
+		ids[i] = message.Id
+		byId[message.Id] = message
+	}
+	now := time.Now()
+	first, err := h.DB.MarkDeviceReceipts(ctx, user.Id, body.DeviceId, ids, body.Status, now)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "receipts not saved"}
+	}
+
+	if body.Status == models.StatusRead && len(first) > 0 {
+		changed, err := h.DB.MarkRead(ctx, user.Id, first)
+		if err != nil {
+			log.Println("[WARN] messages not marked read", user.Id.Hex(), err)
+		}
+		if changed > 0 {
+			h.publishBadges(ctx, user.Id)
+			h.Summaries.Read(user.Id, first)
+		}
+	}
+	for _, id := range first {
+		message := byId[id]
+		if body.Status == models.StatusDelivered {
+			metrics.ObserveMessageStage("delivered", message.Via, message.ReceivedAt)
+		}
+		if message.SenderId != user.Id {
+			h.publishStatus(ctx, user.Id, message, body.Status, now)
+		}
+	}
+	h.publishDebugEvent(ctx, user.Id, models.DebugEvent{Type: models.DebugReceiptRecorded, Status: body.Status, Count: len(ids)})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// publishStatus tells the sender of a direct message that its recipient got
+// or read it.
+func (h *Handler) publishStatus(ctx context.Context, recipient bson.ObjectID, message models.Message, status models.StatusType, at time.Time) {
+	payload, _ := json.Marshal(models.StatusUpdate{
+		Type:      models.TypeStatus,
+		Sender:    recipient,
+		Receiver:  message.SenderId,
+		MessageID: message.Id,
+		Status:    status,
+		Timestamp: at,
+	})
+	if err := h.Broker.Publish(ctx, models.MessageTopic(message.SenderId), payload, false, broker.QoS(broker.ClassMessages)); err != nil {
+		log.Println("[WARN] status not published", message.Id.Hex(), err)
+	}
+}
+
+// GetDeviceReceipts shows the sender of a direct message how far it got on
+// each device of the recipient.
+func (h *Handler) GetDeviceReceipts(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	message, err := h.DB.GetMessage(c.Request().Context(), id)
+	if err != nil || message.SenderId != user.Id || !message.GroupId.IsZero() {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "message not found"}
+	}
+
+	receipts, err := h.DB.GetDeviceReceipts(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "receipts not loaded"}
+	}
+	if receipts == nil {
+		receipts = []models.DeviceReceipt{}
+	}
+	return c.JSON(http.StatusOK, receipts)
+}
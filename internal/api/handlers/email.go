@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"filachat/internal/mail"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+// newOneTimeToken returns a random token for links sent to the user and the
+// hash that is stored in its place.
+func newOneTimeToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) RequestEmailChange(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&body); err != nil || body.Email == "" || body.Password == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing email or password"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if h.Hashing.Verify([]byte(body.Password), user.Password) != nil {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid password"}
+	}
+	if body.Email == user.Email {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "email unchanged"}
+	}
+	if userExists, err := h.DB.Exists(c.Request().Context(), "", body.Email); err != nil || userExists {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "email already in use"}
+	}
+
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "email change not started"}
+	}
+	change := models.EmailChange{
+		Id:        bson.NewObjectID(),
+		UserId:    user.Id,
+		NewEmail:  body.Email,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(emailChangeTTL),
+	}
+	if err := h.DB.SaveEmailChange(c.Request().Context(), &change); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "email change not started"}
+	}
+
+	link := h.Config.PublicURL + "/email/confirm?token=" + url.QueryEscape(token)
+	message, err := mail.Render("verification", h.locale(user), map[string]string{"username": user.Username, "link": link})
+	if err == nil {
+		message.To = body.Email
+		err = h.Mailer.Send(message)
+	}
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "verification email not sent"}
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (h *Handler) ConfirmEmailChange(c echo.Context) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&body); err != nil || body.Token == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing token"}
+	}
+
+	change, err := h.DB.TakeEmailChange(c.Request().Context(), hashToken(body.Token))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid or expired token"}
+	}
+	user, err := h.DB.GetUser(c.Request().Context(), change.UserId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if userExists, err := h.DB.Exists(c.Request().Context(), "", change.NewEmail); err != nil || userExists {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "email already in use"}
+	}
+
+	if err := h.DB.UpdateUser(c.Request().Context(), user.Id, bson.M{"email": change.NewEmail}); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "email not changed"}
+	}
+
+	if user.Email != "" {
+		message, err := mail.Render("email_changed", h.locale(user), map[string]string{"username": user.Username, "email": change.NewEmail})
+		if err == nil {
+			message.To = user.Email
+			err = h.Mailer.Send(message)
+		}
+		if err != nil {
+			log.Println("[WARN] email change notice not sent", user.Id.Hex(), err)
+		}
+	}
+	return c.JSON(http.StatusOK, models.User{Id: user.Id, Username: user.Username, Email: change.NewEmail})
+}
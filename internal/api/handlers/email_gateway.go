@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"filachat/internal/mail"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// The email gateway turns mail to username@EMAIL_GATEWAY_DOMAIN into
+// messages from the gateway user, for notifications of services that only
+// speak email. The inbound mail provider parses the mail and posts it to
+// /email/inbound with basic auth user "email" and EMAIL_GATEWAY_SECRET as
+// password; a route in the provider maps its fields to inboundEmail.
+// Emails arrive as system messages of kind email, with the sender and
+// subject in the params and the text as the message text, and users answer
+// them by email through /messages/:id/email-reply.
+
+// maxEmailText bounds the text kept of an email, longer ones are cut.
+const maxEmailText = 64 << 10
+
+type inboundEmail struct {
+	From      string `json:"from" form:"from"`
+	To        string `json:"to" form:"to"`
+	Subject   string `json:"subject" form:"subject"`
+	Text      string `json:"text" form:"text"`
+	MessageId string `json:"message_id" form:"message_id"`
+}
+
+// gatewayUser is the identity emails are delivered from.
+func (h *Handler) gatewayUser(c echo.Context) (models.User, error) {
+	user, err := h.DB.GetUserByName(c.Request().Context(), h.Config.EmailGatewayUsername)
+	if err != nil || user.Host != "" {
+		log.Println("[WARN] email gateway user not found", h.Config.EmailGatewayUsername, err)
+		return user, &echo.HTTPError{Code: http.StatusServiceUnavailable, Message: "email gateway not configured"}
+	}
+	return user, nil
+}
+
+// headerSafe drops values that would break out of a mail header.
+func headerSafe(value string) string {
+	if strings.ContainsAny(value, "\r\n") {
+		return ""
+	}
+	return value
+}
+
+// ReceiveEmail delivers an email to each of its recipients on this server.
+// Recipients of other domains or without an account are skipped.
+func (h *Handler) ReceiveEmail(c echo.Context) error {
+	username, password, ok := c.Request().BasicAuth()
+	if !ok || h.Config.EmailGatewaySecret == "" || username != "email" ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.Config.EmailGatewaySecret)) != 1 {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid credentials"}
+	}
+
+	var email inboundEmail
+	if err := c.Bind(&email); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid body"}
+	}
+	from, err := netmail.ParseAddress(email.From)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid sender"}
+	}
+	recipients, err := netmail.ParseAddressList(email.To)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid recipients"}
+	}
+	gateway, err := h.gatewayUser(c)
+	if err != nil {
+		return err
+	}
+	if len(email.Text) > maxEmailText {
+		email.Text = strings.ToValidUTF8(email.Text[:maxEmailText], "")
+	}
+
+	delivered := 0
+	for _, recipient := range recipients {
+		name, domain, _ := strings.Cut(recipient.Address, "@")
+		if !strings.EqualFold(domain, h.Config.EmailGatewayDomain) {
+			continue
+		}
+		user, err := h.DB.GetUserByName(c.Request().Context(), name)
+		if err != nil || user.Host != "" || user.Id == gateway.Id {
+			continue
+		}
+		message := models.Message{
+			Id:          bson.NewObjectID(),
+			SenderId:    gateway.Id,
+			RecipientId: user.Id,
+			Type:        models.TypeSystem,
+			System: &models.SystemEvent{
+				Kind: models.SystemEmail,
+				Params: map[string]string{
+					"from":       from.Address,
+					"from_name":  from.Name,
+					"subject":    email.Subject,
+					"message_id": headerSafe(email.MessageId),
+				},
+				Text: email.Text,
+			},
+			Timestamp: time.Now(),
+		}
+		if err := h.deliver(c.Request().Context(), &message); err != nil {
+			// the provider retries the whole email
+			log.Println("[WARN] email not delivered", user.Id.Hex(), err)
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "email not delivered"}
+		}
+		delivered++
+	}
+	if delivered == 0 {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "recipient not found"}
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// ReplyToEmail answers an email received through the gateway. The reply
+// goes out from the server address with the user's gateway address as
+// Reply-To, so the next answer lands in chat again.
+func (h *Handler) ReplyToEmail(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Content) == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing content"}
+	}
+
+	message, err := h.DB.GetMessage(c.Request().Context(), id)
+	if err != nil || message.RecipientId != user.Id || message.System == nil || message.System.Kind != models.SystemEmail {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "email not found"}
+	}
+	account, err := h.DB.GetUser(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+
+	subject := message.System.Params["subject"]
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+	reply := mail.Message{
+		To:        message.System.Params["from"],
+		Subject:   subject,
+		Text:      body.Content,
+		ReplyTo:   account.Username + "@" + h.Config.EmailGatewayDomain,
+		InReplyTo: message.System.Params["message_id"],
+	}
+	if err := h.Mailer.Send(reply); err != nil {
+		log.Println("[WARN] email reply not sent", id.Hex(), err)
+		return &echo.HTTPError{Code: http.StatusBadGateway, Message: "reply not sent"}
+	}
+	return c.NoContent(http.StatusAccepted)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// Exports contain the stored ciphertext only, clients decrypt the archive
+// with their own keys.
+
+type exportHeader struct {
+	Type       string        `json:"type"`
+	UserId     bson.ObjectID `json:"user_id"`
+	PeerId     bson.ObjectID `json:"peer_id"`
+	PeerName   string        `json:"peer_name"`
+	ExportedAt time.Time     `json:"exported_at"`
+}
+
+var exportPage = template.Must(template.New("export").Parse(`{{define "head"}}<!doctype html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Conversation with {{.PeerName}}</title>
+<style>body{font-family:sans-serif}td{padding:4px 8px;vertical-align:top}.blob{font-family:monospace;word-break:break-all}</style>
+</head>
+<body>
+<h2>Conversation with {{.PeerName}}</h2>
+<p>Exported {{.ExportedAt.UTC.Format "2006-01-02 15:04:05 MST"}}. Message contents are end-to-end encrypted and can only be read with your keys.</p>
+<table>
+<tr><th>Time</th><th>From</th><th>Type</th><th>Content</th></tr>
+{{end}}{{define "row"}}<tr><td>{{.Timestamp.UTC.Format "2006-01-02 15:04:05"}}</td><td>{{.SenderId.Hex}}</td><td>{{.Type}}</td><td class="blob" data-aes-secret="{{.AesSecret}}">{{.Content}}</td></tr>
+{{end}}{{define "foot"}}</table>
+</body>
+</html>
+{{end}}`))
+
+func (h *Handler) ExportConversation(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	peer, err := h.DB.GetUser(c.Request().Context(), peerId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "peer not found"}
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	if format != "jsonl" && format != "html" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid format"}
+	}
+	h.audit(c, user.Id, "conversation.export", peerId, map[string]string{"format": format})
+
+	open := h.messageOpener(c.Request().Context())
+	header := exportHeader{Type: "header", UserId: user.Id, PeerId: peerId, PeerName: peer.Username, ExportedAt: time.Now()}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="conversation-`+peerId.Hex()+`.`+format+`"`)
+
+	if format == "html" {
+		res.Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+		res.WriteHeader(http.StatusOK)
+		if err := exportPage.ExecuteTemplate(res, "head", header); err != nil {
+			return err
+		}
+		err = h.DB.StreamConversation(c.Request().Context(), user.Id, peerId, func(message models.Message) error {
+			open(&message)
+			return exportPage.ExecuteTemplate(res, "row", message)
+		})
+		if err != nil {
+			return err
+		}
+		return exportPage.ExecuteTemplate(res, "foot", nil)
+	}
+
+	res.Header().Set(echo.HeaderContentType, "application/jsonl")
+	res.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(res)
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+	return h.DB.StreamConversation(c.Request().Context(), user.Id, peerId, func(message models.Message) error {
+		open(&message)
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	})
+}
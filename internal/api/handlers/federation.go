@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"filachat/internal/federation"
+	"filachat/internal/models"
+	"filachat/internal/spam"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// inboundMemory is how long message ids from other servers are remembered,
+// longer than any server keeps retrying a delivery.
+const inboundMemory = 7 * 24 * time.Hour
+
+// maxFederationBody bounds the body of a request from another server.
+const maxFederationBody = 1 << 20
+
+// GetServerInfo publishes the key this server signs its requests to other
+// servers with.
+func (h *Handler) GetServerInfo(c echo.Context) error {
+	return c.JSON(http.StatusOK, federation.ServerInfo{Domain: h.Federation.Domain, PublicKey: h.Federation.Signer.PublicKey()})
+}
+
+// verifyServer reads the body of a request from another server and checks
+// its signature, returning the server it came from.
+func (h *Handler) verifyServer(c echo.Context) (string, []byte, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxFederationBody))
+	if err != nil {
+		return "", nil, &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid body"}
+	}
+	origin, err := h.Federation.Verifier.Verify(c.Request().Context(), c.Request(), body)
+	if err != nil {
+		log.Println("[WARN] federation request refused", c.Request().Header.Get(federation.HeaderOrigin), err)
+		return "", nil, &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid server signature"}
+	}
+	return origin, body, nil
+}
+
+// ReceiveFederatedMessage takes a message from a user of another server
+// to a user of this one. The remote sender gets a local stand-in the
+// message is stored against, then it is delivered like any other.
+func (h *Handler) ReceiveFederatedMessage(c echo.Context) error {
+	ctx := c.Request().Context()
+	origin, body, err := h.verifyServer(c)
+	if err != nil {
+		return err
+	}
+
+	var message models.FederatedMessage
+	if err := json.Unmarshal(body, &message); err != nil || message.Id.IsZero() || message.Content == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message"}
+	}
+	from, err := federation.ParseAddress(message.From)
+	if err != nil || from.Host != origin {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "sender not on origin server"}
+	}
+	to, err := federation.ParseAddress(message.To)
+	if err != nil || to.Host != h.Federation.Domain {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "recipient not found"}
+	}
+	recipient, err := h.DB.GetUserByName(ctx, to.User)
+	if err != nil || recipient.Host != "" {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "recipient not found"}
+	}
+	sender, err := h.DB.GetOrCreateRemoteUser(ctx, from.String(), from.Host)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "message not delivered"}
+	}
+
+	local := models.Message{
+		Id:               bson.NewObjectID(),
+		SenderId:         sender.Id,
+		RecipientId:      recipient.Id,
+		Type:             models.TypeMessage,
+		Content:          message.Content,
+		AesSecret:        message.AesSecret,
+		SharedSecretSalt: message.SharedSecretSalt,
+		Timestamp:        time.Now(),
+	}
+	switch h.checkSpam(ctx, sender.Id, &local) {
+	case spam.Throttle:
+		return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: "too many messages"}
+	case spam.Shadow:
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	fresh, err := h.DB.RecordInbound(ctx, origin, message.Id, time.Now().Add(inboundMemory))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "message not delivered"}
+	}
+	if !fresh {
+		// a retry of a message delivered already
+		return c.NoContent(http.StatusAccepted)
+	}
+	if err := h.deliver(ctx, &local); err != nil {
+		if err := h.DB.ForgetInbound(ctx, origin, message.Id); err != nil {
+			log.Println("[WARN] federated message not forgotten, the retry will be lost", message.Id.Hex(), err)
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "message not delivered"}
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// GetFederatedKeys answers another server asking for the public keys of a
+// user of this one.
+func (h *Handler) GetFederatedKeys(c echo.Context) error {
+	if _, _, err := h.verifyServer(c); err != nil {
+		return err
+	}
+
+	user, err := h.DB.GetUserByName(c.Request().Context(), c.Param("username"))
+	if err != nil || user.Host != "" {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	devices, err := h.DB.GetDevices(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "keys not loaded"}
+	}
+	address := federation.Address{User: user.Username, Host: h.Federation.Domain}
+	return c.JSON(http.StatusOK, models.RemoteKeys{Address: address.String(), PublicKey: user.PublicKey, Devices: devices})
+}
+
+type remoteProfile struct {
+	models.User
+	Devices []models.Device `json:"devices,omitempty"`
+}
+
+// getRemoteProfile looks up a user of another server by address, asking
+// their server for their current keys, and returns their local stand-in
+// for messages to be sent to.
+func (h *Handler) getRemoteProfile(c echo.Context, raw string) error {
+	ctx := c.Request().Context()
+	address, err := federation.ParseAddress(raw)
+	if err != nil || h.Federation == nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if address.Host == h.Federation.Domain {
+		c.Response().Header().Set(echo.HeaderLocation, "/users/by-name/"+url.PathEscape(address.User))
+		return c.NoContent(http.StatusMovedPermanently)
+	}
+
+	keys, err := h.Federation.Client.FetchKeys(ctx, address)
+	if errors.Is(err, federation.ErrRejected) {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if err != nil {
+		log.Println("[WARN] remote keys not fetched", address, err)
+		return &echo.HTTPError{Code: http.StatusBadGateway, Message: "remote server unavailable"}
+	}
+	user, err := h.DB.GetOrCreateRemoteUser(ctx, address.String(), address.Host)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "user not loaded"}
+	}
+	if !bytes.Equal(user.PublicKey, keys.PublicKey) {
+		if err := h.DB.SetRemoteUserKey(ctx, user.Id, keys.PublicKey); err != nil {
+			log.Println("[WARN] remote key not saved", address, err)
+		}
+	}
+	return c.JSON(http.StatusOK, remoteProfile{
+		User:    models.User{Id: user.Id, Username: user.Username, Host: user.Host, PublicKey: keys.PublicKey},
+		Devices: keys.Devices,
+	})
+}
+
+// remoteRecipient reports whether a message goes to a user of another
+// server, returning them.
+func (h *Handler) remoteRecipient(ctx context.Context, message *models.Message) (models.User, bool) {
+	if h.Federation == nil || message.Type != models.TypeMessage || !message.GroupId.IsZero() {
+		return models.User{}, false
+	}
+	recipient, err := h.DB.GetUser(ctx, message.RecipientId)
+	if err != nil || recipient.Host == "" {
+		return models.User{}, false
+	}
+	return recipient, true
+}
+
+// federate queues a message for the server of its recipient. The stored
+// copy stays as the sender's history.
+func (h *Handler) federate(ctx context.Context, message *models.Message, recipient models.User) error {
+	sender, err := h.DB.GetUser(ctx, message.SenderId)
+	if err != nil {
+		return err
+	}
+	return h.Federation.Outbox.Send(ctx, recipient.Host, models.FederatedMessage{
+		Id:               message.Id,
+		From:             federation.Address{User: sender.Username, Host: h.Federation.Domain}.String(),
+		To:               recipient.Username,
+		Content:          message.Content,
+		AesSecret:        message.AesSecret,
+		SharedSecretSalt: message.SharedSecretSalt,
+		Timestamp:        message.Timestamp,
+	})
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Group messages are stored once and published to every member's own
+// message topic. Receipts are kept per member and message in a collection
+// of their own, so a large group does not grow the message document.
+
+const (
+	maxGroupMembers = 1000
+	maxReceiptBatch = 500
+)
+
+func (h *Handler) CreateGroup(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		Name    string          `json:"name"`
+		OrgId   bson.ObjectID   `json:"org_id"`
+		Members []bson.ObjectID `json:"members"`
+	}
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Name) == "" || len(body.Name) > 64 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid group name"}
+	}
+	if !body.OrgId.IsZero() {
+		if err := h.requireOrgRole(c.Request().Context(), body.OrgId, user.Id, false); err != nil {
+			return err
+		}
+	}
+
+	members := []bson.ObjectID{user.Id}
+	for _, member := range body.Members {
+		if !member.IsZero() && !slices.Contains(members, member) {
+			members = append(members, member)
+		}
+	}
+	if len(members) > maxGroupMembers {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "too many members"}
+	}
+	for _, member := range members[1:] {
+		if err := h.checkGroupCandidate(c.Request().Context(), body.OrgId, member); err != nil {
+			return err
+		}
+	}
+
+	group := models.Group{
+		Id:        bson.NewObjectID(),
+		Name:      strings.TrimSpace(body.Name),
+		OwnerId:   user.Id,
+		OrgId:     body.OrgId,
+		Members:   members,
+		CreatedAt: time.Now(),
+	}
+	if err := h.DB.SaveGroup(c.Request().Context(), &group); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "group not created"}
+	}
+	return c.JSON(http.StatusCreated, group)
+}
+
+// memberGroup loads the group named by the id param, hiding groups the
+// user is not a member of.
+func (h *Handler) memberGroup(c echo.Context, user bson.ObjectID) (models.Group, error) {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return models.Group{}, &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid group id"}
+	}
+	group, err := h.DB.GetGroup(c.Request().Context(), id)
+	if err != nil || !group.IsMember(user) {
+		return models.Group{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "group not found"}
+	}
+	return group, nil
+}
+
+// checkGroupCandidate makes sure a user exists on this server and, for
+// groups owned by an organization, belongs to it.
+func (h *Handler) checkGroupCandidate(ctx context.Context, orgId bson.ObjectID, user bson.ObjectID) error {
+	candidate, err := h.DB.GetUser(ctx, user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "member " + user.Hex() + " not found"}
+	}
+	if candidate.Host != "" {
+		// groups do not span servers
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "member " + user.Hex() + " is on another server"}
+	}
+	if orgId.IsZero() {
+		return nil
+	}
+	if _, err := h.DB.GetOrgMember(ctx, orgId, user); err != nil {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "member " + user.Hex() + " is not in the organization"}
+	}
+	return nil
+}
+
+// AddGroupMember lets the owner of a group add someone, and for groups of
+// an organization its admins too.
+func (h *Handler) AddGroupMember(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid group id"}
+	}
+	group, err := h.DB.GetGroup(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "group not found"}
+	}
+	if group.OwnerId != user.Id {
+		if group.OrgId.IsZero() || h.requireOrgRole(c.Request().Context(), group.OrgId, user.Id, true) != nil {
+			return &echo.HTTPError{Code: http.StatusForbidden, Message: "access denied"}
+		}
+	}
+
+	var body struct {
+		UserId bson.ObjectID `json:"user_id"`
+	}
+	if err := c.Bind(&body); err != nil || body.UserId.IsZero() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	if group.IsMember(body.UserId) {
+		return c.JSON(http.StatusOK, group)
+	}
+	if len(group.Members) >= maxGroupMembers {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "too many members"}
+	}
+	if err := h.checkGroupCandidate(c.Request().Context(), group.OrgId, body.UserId); err != nil {
+		return err
+	}
+
+	if err := h.DB.AddGroupMember(c.Request().Context(), group.Id, body.UserId); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "member not added"}
+	}
+	group.Members = append(group.Members, body.UserId)
+	return c.JSON(http.StatusOK, group)
+}
+
+func (h *Handler) GetGroup(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	group, err := h.memberGroup(c, user.Id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, group)
+}
+
+func (h *Handler) GetGroupMessages(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	group, err := h.memberGroup(c, user.Id)
+	if err != nil {
+		return err
+	}
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	messages, err := h.DB.GetGroupMessages(c.Request().Context(), group.Id, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not loaded"}
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(messages, page, func(m models.Message) pagination.Cursor {
+		return pagination.Cursor{Time: m.Timestamp, ID: m.Id}
+	}))
+}
+
+// deliverToGroup stores a group message and publishes it to every member
+// but the sender.
+func (h *Handler) deliverToGroup(ctx context.Context, message *models.Message, group models.Group) error {
+	if err := h.DB.SaveMessage(ctx, message); err != nil {
+		return err
+	}
+	metrics.ObserveMessageStage("persisted", message.Via, message.ReceivedAt)
+	h.Typing.Stopped(group.Id, message.SenderId)
+
+	payload, _ := json.Marshal(message)
+	recipients := make([]bson.ObjectID, 0, len(group.Members))
+	for _, member := range group.Members {
+		if member == message.SenderId {
+			continue
+		}
+		if err := h.publishMessage(ctx, models.MessageTopic(member), message, payload, broker.QoS(broker.ClassMessages)); err != nil {
+			log.Println("[WARN] group message not published", message.Id.Hex(), member.Hex(), err)
+		}
+		recipients = append(recipients, member)
+	}
+	metrics.ObserveMessageStage("published", message.Via, message.ReceivedAt)
+	h.publishBadges(ctx, recipients...)
+	return nil
+}
+
+// SendGroupTyping tells the group a member is typing. Members get a
+// summary of everyone typing from the aggregator instead of an event per
+// typist; clients send this again for as long as the user keeps typing.
+func (h *Handler) SendGroupTyping(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	group, err := h.memberGroup(c, user.Id)
+	if err != nil {
+		return err
+	}
+	typist, err := h.DB.GetUser(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	h.Typing.Typing(group, user.Id, typist.Username, time.Now())
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) StopGroupTyping(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	group, err := h.memberGroup(c, user.Id)
+	if err != nil {
+		return err
+	}
+	h.Typing.Stopped(group.Id, user.Id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// MarkGroupReceipts lets a member report a batch of group messages as
+// delivered or read. Ids of other groups or of the member's own messages
+// are skipped.
+func (h *Handler) MarkGroupReceipts(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	group, err := h.memberGroup(c, user.Id)
+	if err != nil {
+		return err
+	}
+	var body struct {
+		Status     models.StatusType `json:"status"`
+		MessageIds []bson.ObjectID   `json:"message_ids"`
+	}
+	if err := c.Bind(&body); err != nil || !body.Status.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid receipt status"}
+	}
+	if len(body.MessageIds) == 0 || len(body.MessageIds) > maxReceiptBatch {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "between 1 and 500 message ids required"}
+	}
+
+	messages, err := h.DB.GroupReceiptMessages(c.Request().Context(), group.Id, user.Id, body.MessageIds)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not loaded"}
+	}
+	ids := make([]bson.ObjectID, len(messages))
+	for i, message := range messages {
+		ids[i] = message.Id
+	}
+	if err := h.DB.MarkReceipts(c.Request().Context(), user.Id, ids, body.Status, time.Now()); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "receipts not saved"}
+	}
+	if body.Status == models.StatusDelivered {
+		for _, message := range messages {
+			metrics.ObserveMessageStage("delivered", message.Via, message.ReceivedAt)
+		}
+	}
+	if body.Status == models.StatusRead && len(ids) > 0 {
+		h.publishBadges(c.Request().Context(), user.Id)
+	}
+	h.publishDebugEvent(c.Request().Context(), user.Id, models.DebugEvent{Type: models.DebugReceiptRecorded, Status: body.Status, Count: len(ids)})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetMessageReceipts lists who got or read a group message, one page of
+// members at a time, along with the totals.
+func (h *Handler) GetMessageReceipts(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	status := models.StatusType(c.QueryParam("status"))
+	if status == "" {
+		status = models.StatusDelivered
+	}
+	if !status.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid receipt status"}
+	}
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	message, err := h.DB.GetMessage(c.Request().Context(), id)
+	if err != nil || message.GroupId.IsZero() {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "message not found"}
+	}
+	group, err := h.DB.GetGroup(c.Request().Context(), message.GroupId)
+	if err != nil || !group.IsMember(user.Id) {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "message not found"}
+	}
+
+	receipts, err := h.DB.GetReceipts(c.Request().Context(), id, status, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "receipts not loaded"}
+	}
+	delivered, read, err := h.DB.CountReceipts(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "receipts not counted"}
+	}
+
+	result := pagination.NewResult(receipts, page, func(r models.Receipt) pagination.Cursor {
+		if status == models.StatusRead {
+			return pagination.Cursor{Time: r.ReadAt, ID: r.Id}
+		}
+		return pagination.Cursor{Time: r.DeliveredAt, ID: r.Id}
+	})
+	return c.JSON(http.StatusOK, struct {
+		pagination.Result[models.Receipt]
+		Counts models.ReceiptCounts `json:"counts"`
+	}{result, models.ReceiptCounts{Members: len(group.Members) - 1, Delivered: delivered, Read: read}})
+}
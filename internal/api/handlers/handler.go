@@ -1,9 +1,55 @@
 package handlers
 
-import database "filachat/internal/data"
+import (
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/app"
+	"filachat/internal/broker"
+	"filachat/internal/core"
+	"filachat/internal/crypto"
+	database "filachat/internal/data"
+	"filachat/internal/federation"
+	"filachat/internal/jobs"
+	"filachat/internal/mail"
+	"filachat/internal/maintenance"
+	"filachat/internal/media"
+	"filachat/internal/moderation"
+	"filachat/internal/presence"
+	"filachat/internal/push"
+	"filachat/internal/scan"
+	"filachat/internal/spam"
+	"filachat/internal/storage"
+	"filachat/pkg/config"
+	"github.com/go-webauthn/webauthn/webauthn"
+	mqtt "github.com/mochi-mqtt/server/v2"
+)
 
 type (
 	Handler struct {
-		DB *database.DB
+		*app.App
+		DB          *database.DB
+		Config      *config.Config
+		WebAuthn    *webauthn.WebAuthn
+		Mailer      mail.Sender
+		Broker      broker.Publisher
+		Embedded    *mqtt.Server // nil in bridge mode
+		IPFilter    *imiddleware.IPFilter
+		Restricted  *imiddleware.Restrictions
+		Push        *push.Worker
+		Storage     storage.BlobStore
+		Scanner     scan.Scanner
+		Signer      *media.URLSigner
+		Contacts    *core.ContactTokens
+		ClientIDs   *core.ClientIDs
+		Moderation  *moderation.Pipeline
+		Names       *moderation.NameFilter
+		Spam        *spam.Detector
+		Announcer   *jobs.Announcer
+		Summaries   *jobs.SummaryProjector
+		Typing      *jobs.TypingAggregator
+		Maintenance *maintenance.State
+		Presence    presence.PresenceStore
+		Keys        *crypto.Keyring
+		Watcher     *config.Watcher
+		Federation  *federation.Federation // nil unless federation is enabled
 	}
-)
\ No newline at end of file
+)
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"filachat/internal/importer"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"io"
+	"log"
+	"net/http"
+)
+
+type importResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// ImportConversation brings the history of a direct conversation exported
+// from another app into the conversation with peer_id. The body is the
+// export file, source names the app and self is the name or id the user
+// has in the export. Importing the same export again skips what is there.
+func (h *Handler) ImportConversation(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.QueryParam("peer_id"))
+	if err != nil || peerId == user.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	self := c.QueryParam("self")
+	if self == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing self"}
+	}
+	if c.Request().ContentLength > h.Config.MaxImportSize {
+		return &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "export too large"}
+	}
+	if peer, err := h.DB.GetUser(c.Request().Context(), peerId); err != nil || peer.Host != "" {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "peer not found"}
+	}
+
+	export, err := importer.Parse(c.QueryParam("source"), io.LimitReader(c.Request().Body, h.Config.MaxImportSize))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid export: " + err.Error()}
+	}
+	messages, err := importer.Build(export, user.Id, peerId, self)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	imported, err := h.DB.ImportMessages(c.Request().Context(), messages)
+	if err != nil {
+		log.Println("[WARN] import failed", user.Id.Hex(), imported, err)
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "export not imported"}
+	}
+	if imported > 0 {
+		if err := h.DB.RebuildConversationSummary(c.Request().Context(), user.Id, peerId); err != nil {
+			log.Println("[WARN] conversation summary not rebuilt after import", user.Id.Hex(), err)
+		}
+	}
+	return c.JSON(http.StatusOK, importResult{Imported: imported, Skipped: len(messages) - imported})
+}
@@ -0,0 +1,757 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"filachat/internal/api/handlers"
+	"filachat/internal/broker"
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"filachat/pkg/testserver"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignUpAndSignIn(t *testing.T) {
+	server := testserver.NewTestServer(t)
+
+	user := server.SignUp(t, "ala")
+	if user.Id.IsZero() || user.AccessToken == "" || user.RefreshToken == "" {
+		t.Fatalf("Expected id and tokens after sign-in, got %+v", user)
+	}
+	var profile map[string]any
+	if status := server.Do(t, http.MethodGet, "/users/"+user.Id.Hex()+"/profile", user.AccessToken, nil, &profile); status != http.StatusOK {
+		t.Fatalf("Expected the access token to work, got status %d", status)
+	}
+}
+
+func TestSignInBodyLimit(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	server.SignUp(t, "ala")
+
+	credentials := map[string]string{
+		"username": "ala",
+		"password": strings.Repeat("x", int(server.Config.AuthBodyLimit)),
+	}
+	if status := server.Do(t, http.MethodPost, "/signin", "", credentials, nil); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a sign-in over AUTH_BODY_LIMIT, got %d", status)
+	}
+}
+
+func TestSignUpRejectsTakenUsername(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	server.SignUp(t, "ala")
+
+	credentials := map[string]string{"username": "ala", "email": "other@example.com", "password": "another password"}
+	if status := server.Do(t, http.MethodPost, "/signup", "", credentials, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a taken username, got %d", status)
+	}
+}
+
+func TestSignInRejectsWrongPassword(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	server.SignUp(t, "ala")
+
+	credentials := map[string]string{"username": "ala", "email": "ala@example.com", "password": "wrong"}
+	if status := server.Do(t, http.MethodPost, "/signin", "", credentials, nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong password, got %d", status)
+	}
+}
+
+func TestTwoFactorLocksAfterWrongCodes(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	server.Config.TwoFactorMaxFailures = 3
+	ala := server.SignUp(t, "ala")
+
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	if status := server.Do(t, http.MethodPost, "/me/two-factor/totp", ala.AccessToken, nil, &setup); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	step := core.TOTPStep(time.Now())
+	code, err := core.TOTPCode(setup.Secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := server.Do(t, http.MethodPost, "/me/two-factor/totp/confirm", ala.AccessToken, map[string]string{"code": code}, nil); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+
+	wrong, err := core.TOTPCode(setup.Secret, step+100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := map[string]string{"username": "ala", "email": "ala@example.com", "password": "correct horse battery staple", "totp_code": wrong}
+	for i := 0; i < 3; i++ {
+		if status := server.Do(t, http.MethodPost, "/signin", "", credentials, nil); status != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 for a wrong code, got %d", status)
+		}
+	}
+	next, err := core.TOTPCode(setup.Secret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials["totp_code"] = next
+	if status := server.Do(t, http.MethodPost, "/signin", "", credentials, nil); status != http.StatusTooManyRequests {
+		t.Errorf("Expected even a right code to be refused while locked, got %d", status)
+	}
+}
+
+func TestSendMessageReachesRecipient(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+	received := server.Subscribe(t, models.MessageTopic(ola.Id))
+
+	var sent models.Message
+	body := map[string]any{"recipient_id": ola.Id.Hex(), "content": "hej"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, &sent); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	select {
+	case payload := <-received:
+		var published models.Message
+		if err := json.Unmarshal(payload, &published); err != nil {
+			t.Fatalf("Expected a JSON message, got %q", payload)
+		}
+		if published.Id != sent.Id || published.SenderId != ala.Id || published.Content != "hej" {
+			t.Errorf("Expected the sent message, got %+v", published)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the message to be published to the recipient")
+	}
+
+	var unread pagination.Result[models.Message]
+	if status := server.Do(t, http.MethodGet, "/messages/unread", ola.AccessToken, nil, &unread); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(unread.Items) != 1 || unread.Items[0].Id != sent.Id {
+		t.Errorf("Expected the message among the unread ones, got %+v", unread.Items)
+	}
+}
+
+func TestSendMessageToUnknownRecipient(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+
+	body := map[string]any{"recipient_id": "000000000000000000000001", "content": "hej"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", status)
+	}
+}
+
+func TestNotesToSelfSyncAcrossDevices(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	received := server.Subscribe(t, models.MessageTopic(ala.Id))
+
+	var sent models.Message
+	body := map[string]any{"recipient_id": ala.Id.Hex(), "content": "buy milk"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, &sent); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the note to be published to the other devices of the sender")
+	}
+
+	var unread pagination.Result[models.Message]
+	if status := server.Do(t, http.MethodGet, "/messages/unread", ala.AccessToken, nil, &unread); status != http.StatusOK || len(unread.Items) != 0 {
+		t.Errorf("Expected notes to self never to be unread, got %d %+v", status, unread.Items)
+	}
+
+	// the summary is written in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var conversations pagination.Result[models.ConversationSummary]
+		if status := server.Do(t, http.MethodGet, "/conversations", ala.AccessToken, nil, &conversations); status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if len(conversations.Items) == 1 {
+			if saved := conversations.Items[0]; !saved.Self || saved.UnreadCount != 0 || saved.LastMessageId != sent.Id {
+				t.Errorf("Expected the saved messages entry, got %+v", saved)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the saved messages in the conversation list, got %+v", conversations.Items)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStarredMessagesArePrivate(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+	ela := server.SignUp(t, "ela")
+
+	var sent models.Message
+	body := map[string]any{"recipient_id": ola.Id.Hex(), "content": "hej"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, &sent); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	path := "/messages/" + sent.Id.Hex() + "/star"
+	if status := server.Do(t, http.MethodPut, path, ela.AccessToken, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 starring a message of others, got %d", status)
+	}
+	if status := server.Do(t, http.MethodPut, path, ola.AccessToken, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", status)
+	}
+
+	var starred pagination.Result[models.Star]
+	if status := server.Do(t, http.MethodGet, "/messages/starred", ola.AccessToken, nil, &starred); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(starred.Items) != 1 || starred.Items[0].Message == nil || starred.Items[0].Message.Content != "hej" {
+		t.Errorf("Expected the starred message, got %+v", starred.Items)
+	}
+	if status := server.Do(t, http.MethodGet, "/messages/starred", ala.AccessToken, nil, &starred); status != http.StatusOK || len(starred.Items) != 0 {
+		t.Errorf("Expected no stars for the sender, got %d %+v", status, starred.Items)
+	}
+}
+
+func TestDebugEventsReportAcceptedMessage(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+	events := server.Subscribe(t, models.EventsTopic(ala.Id))
+
+	var sent models.Message
+	body := map[string]any{"recipient_id": ola.Id.Hex(), "content": "hej"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, &sent); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	select {
+	case payload := <-events:
+		var event models.DebugEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatalf("Expected a JSON event, got %q", payload)
+		}
+		if event.Type != models.DebugMessageAccepted || event.MessageId != sent.Id {
+			t.Errorf("Expected the message to be reported accepted, got %+v", event)
+		}
+		if bytes.Contains(payload, []byte("hej")) {
+			t.Errorf("Expected no content in the event, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an event on the events topic")
+	}
+}
+
+func TestCapabilitiesListEnabledFeatures(t *testing.T) {
+	server := testserver.NewTestServer(t)
+
+	var capabilities struct {
+		Features map[string]bool `json:"features"`
+	}
+	if status := server.Do(t, http.MethodGet, "/capabilities", "", nil, &capabilities); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if !capabilities.Features["groups"] {
+		t.Errorf("Expected groups to be enabled by default, got %v", capabilities.Features)
+	}
+}
+
+func TestEmailGatewayRoundTrip(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	server.Config.EmailGatewayDomain = "chat.example.com"
+	server.Config.EmailGatewaySecret = "secret"
+	server.SignUp(t, server.Config.EmailGatewayUsername)
+	ala := server.SignUp(t, "ala")
+
+	email := `{"from":"Shop <orders@shop.example>","to":"ala@chat.example.com","subject":"Order shipped","text":"On its way","message_id":"<1@shop.example>"}`
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/email/inbound", strings.NewReader(email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("email", "secret")
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", res.StatusCode)
+	}
+
+	var unread pagination.Result[models.Message]
+	if status := server.Do(t, http.MethodGet, "/messages/unread", ala.AccessToken, nil, &unread); status != http.StatusOK || len(unread.Items) != 1 {
+		t.Fatalf("Expected the email as a message, got %d %+v", status, unread.Items)
+	}
+	received := unread.Items[0]
+	if received.System == nil || received.System.Kind != models.SystemEmail || received.System.Text != "On its way" {
+		t.Fatalf("Expected an email system message, got %+v", received)
+	}
+
+	path := "/messages/" + received.Id.Hex() + "/email-reply"
+	if status := server.Do(t, http.MethodPost, path, ala.AccessToken, map[string]string{"content": "Thanks"}, nil); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	sent := server.SentMail()
+	reply := sent[len(sent)-1]
+	if reply.To != "orders@shop.example" || reply.Subject != "Re: Order shipped" || reply.ReplyTo != "ala@chat.example.com" || reply.InReplyTo != "<1@shop.example>" {
+		t.Errorf("Expected a threaded reply to the sender, got %+v", reply)
+	}
+}
+
+func TestSupportSessionViewsAccountUntilRevoked(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	admin := server.SignUp(t, "admin")
+	ala := server.SignUp(t, "ala")
+	if err := server.DB.UpdateUser(context.Background(), admin.Id, bson.M{"role": models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/admin/users/" + ala.Id.Hex() + "/support-sessions"
+	if status := server.Do(t, http.MethodPost, path, admin.AccessToken, map[string]string{}, nil); status != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 without a reason, got %d", status)
+	}
+	var session models.SupportSession
+	if status := server.Do(t, http.MethodPost, path, admin.AccessToken, map[string]string{"reason": "ticket 42"}, &session); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	var account handlers.SupportAccount
+	if status := server.Do(t, http.MethodGet, "/support/account", session.Token, nil, &account); status != http.StatusOK || account.Id != ala.Id {
+		t.Fatalf("Expected the account of ala, got %d %+v", status, account)
+	}
+	if status := server.Do(t, http.MethodGet, "/messages/unread", session.Token, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected the support token to be refused outside support routes, got %d", status)
+	}
+
+	if status := server.Do(t, http.MethodDelete, "/admin/support-sessions/"+session.Id.Hex(), admin.AccessToken, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", status)
+	}
+	if status := server.Do(t, http.MethodGet, "/support/account", session.Token, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected a revoked support session to be refused, got %d", status)
+	}
+}
+
+func TestEndSessionWipesDevice(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	if ala.SessionId.IsZero() {
+		t.Fatal("Expected sign-in to name the session")
+	}
+	control := server.Subscribe(t, models.SessionControlTopic(ala.Id, ala.SessionId))
+
+	path := "/me/sessions/" + ala.SessionId.Hex() + "/logout"
+	if status := server.Do(t, http.MethodPost, path, ala.AccessToken, map[string]bool{"wipe": true}, nil); status != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", status)
+	}
+	select {
+	case payload := <-control:
+		var event models.ControlEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatalf("Expected a JSON control event, got %q", payload)
+		}
+		if event.Type != models.ControlWipe || event.SessionId != ala.SessionId || event.Command == "" {
+			t.Errorf("Expected a signed wipe of the session, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the wipe to be published to the session")
+	}
+
+	if status := server.Do(t, http.MethodPost, "/refresh-token", ala.RefreshToken, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected the ended session not to refresh, got %d", status)
+	}
+}
+
+func TestCookieModeRefreshPassesCSRF(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	server.SignUp(t, "ala")
+	server.Config.SessionCookieMode = true
+	server.Config.CSRFEnabled = true
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client()
+	client.Jar = jar
+	send := func(path, token string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, server.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	credentials := []byte(`{"username":"ala","email":"ala@example.com","password":"correct horse battery staple"}`)
+	res := send("/signin", "", credentials)
+	token := res.Header.Get("X-CSRF-Token")
+	if res.StatusCode != http.StatusOK || token == "" {
+		t.Fatalf("Expected sign-in to issue a CSRF token, got %d %q", res.StatusCode, token)
+	}
+
+	if res := send("/refresh-token", "", nil); res.StatusCode != http.StatusBadRequest && res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a refresh without the CSRF token to be refused, got %d", res.StatusCode)
+	}
+	res = send("/refresh-token", token, nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-CSRF-Token") != token {
+		t.Fatalf("Expected the refresh to pass CSRF and pass the token on, got %d", res.StatusCode)
+	}
+	if res := send("/refresh-token", token, nil); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected a second refresh to pass CSRF, got %d", res.StatusCode)
+	}
+}
+
+func TestReservedNameGrantedOnApprovedClaim(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	admin := server.SignUp(t, "admin")
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+	if err := server.DB.UpdateUser(context.Background(), admin.Id, bson.M{"role": models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	reserve := map[string]string{"name": "Filagram", "reason": "brand"}
+	if status := server.Do(t, http.MethodPost, "/admin/reserved-names", admin.AccessToken, reserve, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	credentials := map[string]string{"username": "fi1agram", "email": "squatter@example.com", "password": "correct horse battery staple"}
+	if status := server.Do(t, http.MethodPost, "/signup", "", credentials, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 signing up with a lookalike of a reserved name, got %d", status)
+	}
+
+	var claim models.NameClaim
+	body := map[string]string{"username": "filagram", "reason": "we own the brand"}
+	if status := server.Do(t, http.MethodPost, "/me/name-claims", ala.AccessToken, body, &claim); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	if status := server.Do(t, http.MethodPost, "/admin/name-claims/"+claim.Id.Hex()+"/approve", admin.AccessToken, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", status)
+	}
+
+	user, err := server.DB.GetUser(context.Background(), ala.Id)
+	if err != nil || user.Username != "filagram" {
+		t.Fatalf("Expected ala renamed to filagram, got %q (%v)", user.Username, err)
+	}
+	if status := server.Do(t, http.MethodPut, "/me/username", ola.AccessToken, map[string]string{"username": "FILAGRAM"}, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 taking a name granted to someone else, got %d", status)
+	}
+}
+
+func TestSuspendedAccountLosesAccess(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	admin := server.SignUp(t, "admin")
+	ala := server.SignUp(t, "ala")
+	if err := server.DB.UpdateUser(context.Background(), admin.Id, bson.M{"role": models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/admin/users/" + ala.Id.Hex() + "/account"
+	suspend := map[string]any{"state": "suspended", "reason": "spam", "until": time.Now().Add(time.Hour)}
+	if status := server.Do(t, http.MethodPut, path, admin.AccessToken, suspend, nil); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if status := server.Do(t, http.MethodGet, "/me/usage", ala.AccessToken, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected status 403 with the token of a suspended account, got %d", status)
+	}
+	credentials := map[string]string{"username": "ala", "email": "ala@example.com", "password": "correct horse battery staple"}
+	if status := server.Do(t, http.MethodPost, "/signin", "", credentials, nil); status != http.StatusForbidden {
+		t.Errorf("Expected status 403 signing in while suspended, got %d", status)
+	}
+
+	ban := map[string]any{"state": "banned", "reason": "spam again"}
+	if status := server.Do(t, http.MethodPut, path, admin.AccessToken, ban, nil); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if status := server.Do(t, http.MethodPut, path, admin.AccessToken, suspend, nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409 suspending a banned account, got %d", status)
+	}
+	if status := server.Do(t, http.MethodPut, path, admin.AccessToken, map[string]any{"state": "active"}, nil); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if status := server.Do(t, http.MethodPost, "/signin", "", credentials, nil); status != http.StatusOK {
+		t.Errorf("Expected status 200 signing in once active again, got %d", status)
+	}
+}
+
+func TestModeratorLimitedToModerationRoutes(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	moderator := server.SignUp(t, "moderator")
+	ala := server.SignUp(t, "ala")
+	if err := server.DB.UpdateUser(context.Background(), moderator.Id, bson.M{"role": models.RoleModerator}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := server.Do(t, http.MethodGet, "/admin/moderation", moderator.AccessToken, nil, nil); status != http.StatusOK {
+		t.Errorf("Expected status 200 for a moderator on the moderation queue, got %d", status)
+	}
+	if status := server.Do(t, http.MethodGet, "/admin/stats", moderator.AccessToken, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a moderator on the stats, got %d", status)
+	}
+	if status := server.Do(t, http.MethodGet, "/admin/moderation", ala.AccessToken, nil, nil); status != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a user, got %d", status)
+	}
+}
+
+func TestMQTTTokenSignsClientID(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	device := bson.NewObjectID()
+
+	var issued struct {
+		ClientId string `json:"client_id"`
+		DeviceId string `json:"device_id"`
+	}
+	if status := server.Do(t, http.MethodPost, "/mqtt/token", ala.AccessToken, map[string]string{"device_id": device.Hex()}, &issued); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	user, got, err := server.Handler.ClientIDs.Verify(issued.ClientId)
+	if err != nil || user != ala.Id || got != device || issued.DeviceId != device.Hex() {
+		t.Errorf("Expected a client id of ala's device, got %q (%v)", issued.ClientId, err)
+	}
+}
+
+func TestBrokerSnapshotRestoresRetained(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	admin := server.SignUp(t, "admin")
+	if err := server.DB.UpdateUser(context.Background(), admin.Id, bson.M{"role": models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Broker.Publish("system/notice", []byte("hello"), true, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot broker.Snapshot
+	if status := server.Do(t, http.MethodGet, "/admin/broker/snapshot", admin.AccessToken, nil, &snapshot); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	// an empty retained payload clears the topic
+	if err := server.Broker.Publish("system/notice", nil, true, 1); err != nil {
+		t.Fatal(err)
+	}
+	if status := server.Do(t, http.MethodPut, "/admin/broker/snapshot", admin.AccessToken, snapshot, nil); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+
+	retained := server.Broker.Topics.Messages("system/notice")
+	if len(retained) != 1 || string(retained[0].Payload) != "hello" {
+		t.Errorf("Expected the retained message restored, got %+v", retained)
+	}
+}
+
+func TestDeviceReceiptsReachSenderOnce(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+
+	var sent models.Message
+	body := map[string]any{"recipient_id": ola.Id.Hex(), "content": "hej"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, &sent); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	statuses := server.Subscribe(t, models.MessageTopic(ala.Id))
+
+	phone, laptop := bson.NewObjectID(), bson.NewObjectID()
+	for _, device := range []bson.ObjectID{phone, laptop} {
+		receipt := map[string]any{"device_id": device.Hex(), "status": "delivered", "message_ids": []string{sent.Id.Hex()}}
+		if status := server.Do(t, http.MethodPost, "/messages/receipts", ola.AccessToken, receipt, nil); status != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", status)
+		}
+	}
+
+	select {
+	case payload := <-statuses:
+		var update models.StatusUpdate
+		if err := json.Unmarshal(payload, &update); err != nil || update.Status != models.StatusDelivered || update.MessageID != sent.Id {
+			t.Fatalf("Expected a delivered status, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the sender to hear of the delivery")
+	}
+	select {
+	case payload := <-statuses:
+		t.Errorf("Expected one status for both devices, got another %q", payload)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	var receipts []models.DeviceReceipt
+	if status := server.Do(t, http.MethodGet, "/messages/"+sent.Id.Hex()+"/device-receipts", ala.AccessToken, nil, &receipts); status != http.StatusOK || len(receipts) != 2 {
+		t.Errorf("Expected the receipts of both devices, got %d %+v", status, receipts)
+	}
+	if status := server.Do(t, http.MethodGet, "/messages/"+sent.Id.Hex()+"/device-receipts", ola.AccessToken, nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for the recipient, got %d", status)
+	}
+}
+
+func TestGroupTypingSummarized(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+	ela := server.SignUp(t, "ela")
+
+	var group models.Group
+	body := map[string]any{"name": "team", "members": []string{ola.Id.Hex(), ela.Id.Hex()}}
+	if status := server.Do(t, http.MethodPost, "/groups", ala.AccessToken, body, &group); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	received := server.Subscribe(t, models.MessageTopic(ela.Id))
+
+	for _, typist := range []models.User{ala, ola} {
+		// repeated reports while typing change nothing
+		for range 2 {
+			if status := server.Do(t, http.MethodPost, "/groups/"+group.Id.Hex()+"/typing", typist.AccessToken, nil, nil); status != http.StatusNoContent {
+				t.Fatalf("Expected status 204, got %d", status)
+			}
+		}
+	}
+	server.Handler.Typing.Flush(context.Background(), time.Now())
+
+	select {
+	case payload := <-received:
+		var summary models.GroupTyping
+		if err := json.Unmarshal(payload, &summary); err != nil || summary.Count != 2 || len(summary.Names) != 2 || summary.Names[0] != "ala" {
+			t.Fatalf("Expected one summary of ala and ola typing, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a typing summary")
+	}
+	select {
+	case payload := <-received:
+		t.Errorf("Expected a single summary, got another %q", payload)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	server.Handler.Typing.Flush(context.Background(), time.Now().Add(time.Minute))
+	select {
+	case payload := <-received:
+		var summary models.GroupTyping
+		if err := json.Unmarshal(payload, &summary); err != nil || summary.Count != 0 {
+			t.Errorf("Expected everyone to have stopped typing, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a summary once the reports ran out")
+	}
+}
+
+func TestMessageErrorForUnavailableRecipient(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+
+	status := models.AccountStatus{State: models.AccountDeactivated, ChangedAt: time.Now()}
+	if err := server.DB.UpdateUser(context.Background(), ola.Id, bson.M{"account": status, "deactivated": true}); err != nil {
+		t.Fatal(err)
+	}
+	errs := server.Subscribe(t, models.SystemTopic(ala.Id, "delivery"))
+
+	body := map[string]any{"recipient_id": ola.Id.Hex(), "content": "hej"}
+	if status := server.Do(t, http.MethodPost, "/messages", ala.AccessToken, body, nil); status != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", status)
+	}
+	select {
+	case payload := <-errs:
+		var messageError models.MessageError
+		if err := json.Unmarshal(payload, &messageError); err != nil || messageError.Reason != models.ErrorRecipientUnavailable || messageError.RecipientId != ola.Id {
+			t.Errorf("Expected recipient_unavailable, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the sender to hear why the message was refused")
+	}
+}
+
+func TestBulkFetchKeysHandsOutPreKeys(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+	ctx := context.Background()
+
+	phone, laptop := bson.NewObjectID(), bson.NewObjectID()
+	for _, id := range []bson.ObjectID{phone, laptop} {
+		device := models.Device{Id: id, UserId: ola.Id, IdentityKey: []byte("identity"), CreatedAt: time.Now()}
+		if err := server.DB.SaveDevice(ctx, &device); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := models.PreKey{Id: bson.NewObjectID(), UserId: ola.Id, DeviceId: phone, KeyId: 1, PublicKey: []byte("one-time"), CreatedAt: time.Now()}
+	if err := server.DB.AddPreKeys(ctx, []models.PreKey{key}); err != nil {
+		t.Fatal(err)
+	}
+
+	var bundles []models.KeyBundle
+	body := map[string]any{"targets": []map[string]any{{"user_id": ola.Id.Hex()}, {"user_id": bson.NewObjectID().Hex()}}}
+	if status := server.Do(t, http.MethodPost, "/keys/bulk-fetch", ala.AccessToken, body, &bundles); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(bundles) != 1 || len(bundles[0].Devices) != 2 {
+		t.Fatalf("Expected both devices of ola only, got %+v", bundles)
+	}
+	for _, device := range bundles[0].Devices {
+		if (device.PreKey != nil) != (device.DeviceId == phone) {
+			t.Errorf("Expected a prekey for the phone only, got %+v", device)
+		}
+	}
+
+	body = map[string]any{"targets": []map[string]any{{"user_id": ola.Id.Hex(), "device_id": phone.Hex()}}}
+	if status := server.Do(t, http.MethodPost, "/keys/bulk-fetch", ala.AccessToken, body, &bundles); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(bundles) != 1 || len(bundles[0].Devices) != 1 || bundles[0].Devices[0].PreKey != nil {
+		t.Errorf("Expected the phone without its used prekey, got %+v", bundles)
+	}
+}
+
+func TestSealedMessageHidesSender(t *testing.T) {
+	server := testserver.NewTestServer(t)
+	ala := server.SignUp(t, "ala")
+	ola := server.SignUp(t, "ola")
+
+	if status := server.Do(t, http.MethodGet, "/me/sender-certificate", ala.AccessToken, nil, nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409 without a public key, got %d", status)
+	}
+	key := map[string]any{"public_key": bytes.Repeat([]byte{1}, 32)}
+	if status := server.Do(t, http.MethodPut, "/me/public-key", ala.AccessToken, key, nil); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	var certificate struct {
+		Certificate string `json:"certificate"`
+	}
+	if status := server.Do(t, http.MethodGet, "/me/sender-certificate", ala.AccessToken, nil, &certificate); status != http.StatusOK || certificate.Certificate == "" {
+		t.Fatalf("Expected a sender certificate, got %d %+v", status, certificate)
+	}
+
+	received := server.Subscribe(t, models.SealedTopic(ola.Id))
+	body := map[string]any{"recipient_id": ola.Id.Hex(), "content": "envelope"}
+	if status := server.Do(t, http.MethodPost, "/messages/sealed", ala.AccessToken, body, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	select {
+	case payload := <-received:
+		if strings.Contains(string(payload), ala.Id.Hex()) {
+			t.Errorf("Expected the sender left out, got %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the sealed message on the recipient's topic")
+	}
+
+	var unread pagination.Result[models.Message]
+	if status := server.Do(t, http.MethodGet, "/messages/unread", ola.AccessToken, nil, &unread); status != http.StatusOK || len(unread.Items) != 1 || !unread.Items[0].SenderId.IsZero() {
+		t.Errorf("Expected the stored message without a sender, got %d %+v", status, unread)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With INVITE_ONLY on, signing up takes an invite code. Users get a few
+// codes of their own to hand out, admins create them in bulk. Only a hash
+// of each code is stored, the code itself is shown once, on creation.
+
+const maxBulkInvites = 500
+
+var inviteEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newInviteCode returns a code that is easy to read out and type, e.g.
+// K7Q2-9XMD-ABCD-EF12.
+func newInviteCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := inviteEncoding.EncodeToString(raw)
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// codeHash hashes an invite or recovery code the way it was stored,
+// ignoring case, dashes and spaces people add or drop while typing it.
+func codeHash(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(code))
+	return hashToken(normalized)
+}
+
+func (h *Handler) newInvites(ctx context.Context, createdBy bson.ObjectID, count int, bulk bool) ([]models.Invite, error) {
+	now := time.Now()
+	invites := make([]models.Invite, count)
+	for i := range invites {
+		code, err := newInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		invites[i] = models.Invite{
+			Id:        bson.NewObjectID(),
+			Code:      code,
+			CodeHash:  codeHash(code),
+			CreatedBy: createdBy,
+			Bulk:      bulk,
+			ExpiresAt: now.Add(config.Current().InviteTTL),
+			CreatedAt: now,
+		}
+	}
+	return invites, h.DB.SaveInvites(ctx, invites)
+}
+
+// CreateInvite hands a user one more code, within INVITES_PER_USER.
+func (h *Handler) CreateInvite(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	created, err := h.DB.CountInvites(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "invites not loaded"}
+	}
+	if created >= config.Current().InvitesPerUser {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "no invites left"}
+	}
+
+	invites, err := h.newInvites(c.Request().Context(), user.Id, 1, false)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "invite not created"}
+	}
+	return c.JSON(http.StatusCreated, invites[0])
+}
+
+func (h *Handler) GetMyInvites(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	return h.listInvites(c, bson.M{"created_by": user.Id, "bulk": bson.M{"$ne": true}})
+}
+
+// CreateBulkInvites lets an admin create up to 500 codes at once, e.g. for
+// an event. They do not count against the admin's own allowance.
+func (h *Handler) CreateBulkInvites(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Count int `json:"count"`
+	}
+	if err := c.Bind(&body); err != nil || body.Count < 1 || body.Count > maxBulkInvites {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "count must be between 1 and 500"}
+	}
+
+	invites, err := h.newInvites(c.Request().Context(), admin.Id, body.Count, true)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "invites not created"}
+	}
+	h.audit(c, admin.Id, "invites.created", bson.NilObjectID, map[string]string{"count": strconv.Itoa(body.Count)})
+	return c.JSON(http.StatusCreated, invites)
+}
+
+// ListInvites shows admins who invited whom, optionally for one inviter
+// with ?created_by=.
+func (h *Handler) ListInvites(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	filter := bson.M{}
+	if raw := c.QueryParam("created_by"); raw != "" {
+		id, err := bson.ObjectIDFromHex(raw)
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+		}
+		filter["created_by"] = id
+	}
+	return h.listInvites(c, filter)
+}
+
+func (h *Handler) listInvites(c echo.Context, filter bson.M) error {
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	invites, err := h.DB.GetInvites(c.Request().Context(), filter, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "invites not loaded"}
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(invites, page, func(i models.Invite) pagination.Cursor {
+		return pagination.Cursor{Time: i.CreatedAt, ID: i.Id}
+	}))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (h *Handler) ListIPRules(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	rules, err := h.DB.GetIPRules(c.Request().Context())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "rules not loaded"}
+	}
+	if rules == nil {
+		rules = []models.IPRule{}
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+func (h *Handler) CreateIPRule(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	var rule models.IPRule
+	if err := c.Bind(&rule); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid rule"}
+	}
+	switch {
+	case rule.Country != "" && rule.CIDR == "" && rule.Action == models.IPRuleDeny:
+		if len(rule.Country) != 2 {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid country code"}
+		}
+		rule.Country = strings.ToUpper(rule.Country)
+	case rule.CIDR != "" && rule.Country == "" && (rule.Action == models.IPRuleAllow || rule.Action == models.IPRuleDeny):
+		if _, err := imiddleware.ParseNetwork(rule.CIDR); err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid cidr"}
+		}
+	default:
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "rule needs an action and either a cidr or a country to deny"}
+	}
+
+	rule.Id = bson.NewObjectID()
+	rule.CreatedAt = time.Now()
+	if err := h.DB.SaveIPRule(c.Request().Context(), &rule); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "rule not saved"}
+	}
+	if err := h.reloadIPRules(c.Request().Context()); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "rules not reloaded"}
+	}
+	return c.JSON(http.StatusCreated, rule)
+}
+
+func (h *Handler) DeleteIPRule(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid rule id"}
+	}
+	if err := h.DB.DeleteIPRule(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "rule not found"}
+	}
+	if err := h.reloadIPRules(c.Request().Context()); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "rules not reloaded"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) reloadIPRules(ctx context.Context) error {
+	rules, err := h.DB.GetIPRules(ctx)
+	if err != nil {
+		return err
+	}
+	h.IPFilter.SetRules(rules)
+	return nil
+}
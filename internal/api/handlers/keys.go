@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"log"
+	"net/http"
+)
+
+// maxKeyFetch bounds the targets of one bulk key fetch.
+const maxKeyFetch = 100
+
+// BulkFetchKeys returns the keys of many users and devices in one response,
+// for a client setting up sessions with a whole group. A target without a
+// device id asks for every device of the user. Each device returned hands
+// out one of its one-time prekeys. Unknown users and devices are left out.
+func (h *Handler) BulkFetchKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var body struct {
+		Targets []struct {
+			UserId   bson.ObjectID `json:"user_id"`
+			DeviceId bson.ObjectID `json:"device_id"`
+		} `json:"targets"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if len(body.Targets) == 0 || len(body.Targets) > maxKeyFetch {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "between 1 and 100 targets required"}
+	}
+
+	var users []bson.ObjectID
+	allDevices := map[bson.ObjectID]bool{}
+	devices := map[bson.ObjectID]map[bson.ObjectID]bool{}
+	for _, target := range body.Targets {
+		if target.UserId.IsZero() {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+		}
+		if _, seen := devices[target.UserId]; !seen {
+			users = append(users, target.UserId)
+			devices[target.UserId] = map[bson.ObjectID]bool{}
+		}
+		if target.DeviceId.IsZero() {
+			allDevices[target.UserId] = true
+		} else {
+			devices[target.UserId][target.DeviceId] = true
+		}
+	}
+
+	publicKeys, err := h.DB.PublicKeys(ctx, users)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "keys not loaded"}
+	}
+	found, err := h.DB.GetUsersDevices(ctx, users)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "keys not loaded"}
+	}
+	bundles := make(map[bson.ObjectID]*models.KeyBundle, len(users))
+	for _, device := range found {
+		if !allDevices[device.UserId] && !devices[device.UserId][device.Id] {
+			continue
+		}
+		bundle := models.DeviceBundle{DeviceId: device.Id, IdentityKey: device.IdentityKey, SignedPreKey: device.SignedPreKey}
+		key, err := h.DB.TakePreKey(ctx, device.Id)
+		switch {
+		case err == nil:
+			bundle.PreKey = &key
+		case !errors.Is(err, mongo.ErrNoDocuments):
+			// the signed prekey still lets the peer start a session
+			log.Println("[WARN] prekey not taken", device.Id.Hex(), err)
+		}
+		if bundles[device.UserId] == nil {
+			bundles[device.UserId] = &models.KeyBundle{UserId: device.UserId}
+		}
+		bundles[device.UserId].Devices = append(bundles[device.UserId].Devices, bundle)
+	}
+
+	result := make([]models.KeyBundle, 0, len(users))
+	for _, user := range users {
+		bundle := bundles[user]
+		if bundle == nil {
+			if publicKeys[user] == nil {
+				continue
+			}
+			bundle = &models.KeyBundle{UserId: user, Devices: []models.DeviceBundle{}}
+		}
+		bundle.PublicKey = publicKeys[user]
+		result = append(result, *bundle)
+	}
+	return c.JSON(http.StatusOK, result)
+}
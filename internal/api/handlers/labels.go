@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"time"
+)
+
+// GetConversationSettings returns the labels and note the user keeps about
+// a peer, empty when there are none.
+func (h *Handler) GetConversationSettings(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	settings, err := h.DB.GetConversationSettings(c.Request().Context(), user.Id, peerId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.JSON(http.StatusOK, models.ConversationSettings{PeerId: peerId, Labels: []string{}})
+	}
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "settings not loaded"}
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// SetConversationSettings replaces the labels and note the user keeps
+// about a peer.
+func (h *Handler) SetConversationSettings(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil || peerId == user.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	var settings models.ConversationSettings
+	if err := c.Bind(&settings); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if err := settings.Normalize(); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if _, err := h.DB.GetUser(c.Request().Context(), peerId); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "peer not found"}
+	}
+
+	settings.Id = bson.NewObjectID()
+	settings.UserId = user.Id
+	settings.PeerId = peerId
+	settings.UpdatedAt = time.Now()
+	if err := h.DB.SaveConversationSettings(c.Request().Context(), &settings); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "settings not saved"}
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+func (h *Handler) DeleteConversationSettings(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	if err := h.DB.DeleteConversationSettings(c.Request().Context(), user.Id, peerId); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "settings not saved"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetLabels lists the labels the user has in use, for filtering the
+// conversation list.
+func (h *Handler) GetLabels(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	labels, err := h.DB.GetLabels(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "labels not loaded"}
+	}
+	if labels == nil {
+		labels = []string{}
+	}
+	return c.JSON(http.StatusOK, labels)
+}
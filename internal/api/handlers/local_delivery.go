@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"filachat/internal/broker"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"filachat/internal/spam"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// IngestPublish is hooks.LocalDeliveryHook.Ingest. A message a client
+// published gets the checks of SendMessage; when the recipient is connected
+// to this broker it is published right away and stored in the background,
+// everything else is delivered the usual way. The sender finds the message
+// with its id and time on system/{id}/sent. Having no response to read, a
+// client in debug mode learns why a message was refused on its events
+// topic.
+func (h *Handler) IngestPublish(ctx context.Context, sender bson.ObjectID, payload []byte) error {
+	message, err := h.ingestPublish(ctx, sender, payload)
+	if err != nil {
+		h.messageRefused(ctx, sender, &message, err)
+		event := models.DebugEvent{Type: models.DebugMessageRejected, Reason: err.Error()}
+		if errors.Is(err, errThrottled) {
+			event.Type = models.DebugRateLimited
+		}
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			event.Reason = fmt.Sprint(httpErr.Message)
+		}
+		h.publishDebugEvent(ctx, sender, event)
+	}
+	return err
+}
+
+var errThrottled = errors.New("too many messages")
+
+// ingestPublish returns the message as far as it got, for the refusal to
+// name its recipient.
+func (h *Handler) ingestPublish(ctx context.Context, sender bson.ObjectID, payload []byte) (models.Message, error) {
+	received := time.Now()
+	var message models.Message
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return message, err
+	}
+	if err := validateIngest(sender, &message); err != nil {
+		return message, err
+	}
+	group, err := h.messageTarget(ctx, sender, &message)
+	if err != nil {
+		return message, err
+	}
+
+	message.Id = bson.NewObjectID()
+	message.SenderId = sender
+	// notes to self are never unread
+	message.Read = message.ToSelf()
+	message.Timestamp = time.Now()
+	message.ReceivedAt = received
+	message.Via = models.ViaMQTT
+	message.Trace = newTraceparent()
+	pad(&message)
+
+	switch h.checkSpam(ctx, sender, &message) {
+	case spam.Throttle:
+		return message, errThrottled
+	case spam.Shadow:
+		h.publishSent(ctx, &message)
+		return message, nil
+	}
+
+	switch {
+	case !message.GroupId.IsZero():
+		err = h.deliverToGroup(ctx, &message, group)
+	case h.connectedHere(message.RecipientId):
+		h.deliverLocal(ctx, message)
+	default:
+		err = h.deliver(ctx, &message)
+	}
+	if err != nil {
+		return message, fmt.Errorf("%w: %v", errNotStored, err)
+	}
+	h.publishSent(ctx, &message)
+	return message, nil
+}
+
+// connectedHere reports whether a live client of the user is subscribed to
+// their messages on the embedded broker.
+func (h *Handler) connectedHere(user bson.ObjectID) bool {
+	if h.Embedded == nil {
+		return false
+	}
+	for id := range h.Embedded.Topics.Subscribers(models.MessageTopic(user)).Subscriptions {
+		if client, ok := h.Embedded.Clients.Get(id); ok && !client.Closed() {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverLocal is deliver with the publish first. A message that fails to
+// store was seen by the recipient but is missing from history, which is
+// logged; the sender's copy has it still.
+func (h *Handler) deliverLocal(ctx context.Context, message models.Message) {
+	payload, _ := json.Marshal(message)
+	if err := h.publishMessage(ctx, models.MessageTopic(message.RecipientId), &message, payload, broker.QoS(broker.ClassMessages)); err != nil {
+		log.Println("[WARN] message not published", message.Id.Hex(), err)
+	}
+	metrics.ObserveMessageStage("published", message.Via, message.ReceivedAt)
+	metrics.DeliveryLatency.WithLabelValues("local").Observe(time.Since(message.Timestamp).Seconds())
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		stored, err := h.sealForStorage(ctx, message)
+		if err == nil {
+			err = h.DB.SaveMessage(ctx, &stored)
+		}
+		if err != nil {
+			log.Println("[WARN] delivered message not stored", message.Id.Hex(), err)
+			return
+		}
+		metrics.ObserveMessageStage("persisted", message.Via, message.ReceivedAt)
+		h.Summaries.Message(message)
+		h.publishBadges(ctx, message.RecipientId)
+	}()
+}
+
+func (h *Handler) publishSent(ctx context.Context, message *models.Message) {
+	h.publishDebugEvent(ctx, message.SenderId, models.DebugEvent{Type: models.DebugMessageAccepted, MessageId: message.Id, Trace: message.Trace})
+	payload, _ := json.Marshal(message)
+	if err := h.Broker.Publish(ctx, models.SystemTopic(message.SenderId, "sent"), payload, false, broker.QoS(broker.ClassSystem)); err != nil {
+		log.Println("[WARN] sent message not published", message.Id.Hex(), err)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"filachat/internal/i18n"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+)
+
+// locale is the language server generated text for user is written in.
+func (h *Handler) locale(user models.User) string {
+	if i18n.Supported(user.Locale) {
+		return user.Locale
+	}
+	return i18n.Default
+}
+
+// SetLocale picks the language of the emails, system messages and push
+// notifications a user gets. API errors follow Accept-Language instead.
+func (h *Handler) SetLocale(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		Locale string `json:"locale"`
+	}
+	if err := c.Bind(&body); err != nil || !i18n.Supported(body.Locale) {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid locale"}
+	}
+	if err := h.DB.UpdateUser(c.Request().Context(), user.Id, bson.M{"locale": body.Locale}); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "locale not saved"}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"locale": body.Locale, "available": i18n.Locales()})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/mail"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// loginNetwork coarsens an address to the network it most likely belongs to,
+// so address churn within one ISP block does not count as a new location.
+func loginNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// recordLogin stores the sign-in and, when it comes from an unknown device,
+// alerts the user by email and on their system topic. Failures are logged
+// only, they must never block the login itself.
+func (h *Handler) recordLogin(c echo.Context, userId bson.ObjectID) {
+	login := models.Login{
+		Id:        bson.NewObjectID(),
+		UserId:    userId,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Timestamp: time.Now(),
+	}
+	login.Network = loginNetwork(login.IP)
+
+	isNew, err := h.DB.IsNewDevice(c.Request().Context(), userId, login.Network, login.UserAgent)
+	if err != nil {
+		log.Println("[WARN] login history not checked", userId.Hex(), err)
+	}
+
+	var reportToken string
+	if isNew {
+		reportToken, login.ReportHash, err = newOneTimeToken()
+		if err != nil {
+			log.Println("[WARN] login report token not generated", err)
+		}
+	}
+	if err := h.DB.SaveLogin(c.Request().Context(), &login); err != nil {
+		log.Println("[WARN] login not recorded", userId.Hex(), err)
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	payload, _ := json.Marshal(models.LoginAlert{
+		Type:      "new_login",
+		LoginId:   login.Id.Hex(),
+		IP:        login.IP,
+		UserAgent: login.UserAgent,
+		Timestamp: login.Timestamp,
+	})
+	if err := h.Broker.Publish(c.Request().Context(), models.SystemTopic(userId, "notifications"), payload, false, broker.QoS(broker.ClassSystem)); err != nil {
+		log.Println("[WARN] login alert not published", userId.Hex(), err)
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), userId)
+	if err != nil || user.Email == "" || reportToken == "" {
+		return
+	}
+	link := h.Config.PublicURL + "/logins/report?token=" + url.QueryEscape(reportToken)
+	message, err := mail.Render("login_alert", h.locale(user), map[string]string{
+		"username":   user.Username,
+		"ip":         login.IP,
+		"user_agent": login.UserAgent,
+		"time":       login.Timestamp.UTC().Format(time.RFC1123),
+		"link":       link,
+	})
+	if err == nil {
+		message.To = user.Email
+		err = h.Mailer.Send(message)
+	}
+	if err != nil {
+		log.Println("[WARN] login alert email not sent", userId.Hex(), err)
+	}
+}
+
+func (h *Handler) ReportLogin(c echo.Context) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&body); err != nil || body.Token == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing token"}
+	}
+
+	login, err := h.DB.TakeLoginReport(c.Request().Context(), hashToken(body.Token))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid token"}
+	}
+	if err := h.DB.UpdateUser(c.Request().Context(), login.UserId, bson.M{"locked": true}); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "account not locked"}
+	}
+	h.Restricted.Lock(login.UserId)
+	h.endAccess(c.Request().Context(), login.UserId, "account_locked")
+	log.Println("[INFO] account locked after login report", login.UserId.Hex(), login.IP)
+	return c.NoContent(http.StatusNoContent)
+}
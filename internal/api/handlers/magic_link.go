@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"filachat/internal/mail"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Passwordless sign-in: the user asks for a link by email and gets back a
+// device secret. Redeeming the link takes both, so a link forwarded or
+// read on another device is useless. Links are single use and short lived.
+
+const magicLinkTTL = 10 * time.Minute
+
+// RequestMagicLink answers the same whether or not the address belongs to
+// someone, so it cannot be used to find out who has an account.
+func (h *Handler) RequestMagicLink(c echo.Context) error {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&body); err != nil || body.Email == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing email"}
+	}
+
+	device, deviceHash, err := newOneTimeToken()
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "sign-in link not sent"}
+	}
+	accepted := map[string]any{"device": device, "expires_in": int(magicLinkTTL.Seconds())}
+
+	user, err := h.DB.GetUserByEmail(c.Request().Context(), body.Email)
+	if err != nil || accountError(user, time.Now()) != nil {
+		return c.JSON(http.StatusAccepted, accepted)
+	}
+
+	token, tokenHash, err := newOneTimeToken()
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "sign-in link not sent"}
+	}
+	link := models.MagicLink{
+		Id:         bson.NewObjectID(),
+		UserId:     user.Id,
+		TokenHash:  tokenHash,
+		DeviceHash: deviceHash,
+		ExpiresAt:  time.Now().Add(magicLinkTTL),
+	}
+	if err := h.DB.SaveMagicLink(c.Request().Context(), &link); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "sign-in link not sent"}
+	}
+
+	params := map[string]string{
+		"username": user.Username,
+		"minutes":  strconv.Itoa(int(magicLinkTTL.Minutes())),
+		"link":     h.Config.PublicURL + "/signin/magic?token=" + url.QueryEscape(token),
+	}
+	message, err := mail.Render("magic_link", h.locale(user), params)
+	if err == nil {
+		message.To = user.Email
+		err = h.Mailer.Send(message)
+	}
+	if err != nil {
+		log.Println("[WARN] sign-in link not sent", user.Id.Hex(), err)
+	}
+	return c.JSON(http.StatusAccepted, accepted)
+}
+
+// RedeemMagicLink signs in like SignIn does, two factor included.
+func (h *Handler) RedeemMagicLink(c echo.Context) error {
+	var body struct {
+		Token        string `json:"token"`
+		Device       string `json:"device"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.Bind(&body); err != nil || body.Token == "" || body.Device == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing token"}
+	}
+
+	link, err := h.DB.TakeMagicLink(c.Request().Context(), hashToken(body.Token), hashToken(body.Device))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid or expired token"}
+	}
+	user, err := h.DB.GetUser(c.Request().Context(), link.UserId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if err := accountError(user, time.Now()); err != nil {
+		return err
+	}
+	if user.TwoFactor {
+		if err := h.checkSecondFactor(c, user, body.TOTPCode, body.RecoveryCode); err != nil {
+			return err
+		}
+	}
+	return h.issueTokens(c, models.User{Id: user.Id, Username: user.Username})
+}
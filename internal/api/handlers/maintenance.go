@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maintenanceGrace is how long connected MQTT clients get between the
+// notice and the disconnect, enough to flush what they were sending.
+const maintenanceGrace = 10 * time.Second
+
+type maintenanceNotice struct {
+	Enabled    bool      `json:"enabled"`
+	Message    string    `json:"message,omitempty"`
+	RetryAfter int       `json:"retry_after,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+}
+
+func (h *Handler) GetMaintenance(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	status := h.Maintenance.Status()
+	return c.JSON(http.StatusOK, maintenanceNotice{
+		Enabled:    status.Enabled,
+		Message:    status.Message,
+		RetryAfter: int(status.RetryAfter.Seconds()),
+		Since:      status.Since,
+	})
+}
+
+func (h *Handler) SetMaintenance(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Enabled    bool   `json:"enabled"`
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := c.Bind(&body); err != nil || body.RetryAfter < 0 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+
+	if !body.Enabled {
+		notified := h.Maintenance.Status().Notified
+		h.Maintenance.Disable()
+		// an empty retained payload clears the notice
+		if err := h.Broker.Publish(c.Request().Context(), models.MaintenanceTopic, nil, true, broker.QoS(broker.ClassSystem)); err != nil {
+			log.Println("[WARN] maintenance notice not cleared", err)
+		}
+		// replace the retained start on the topics of everyone told about it
+		h.publishControl(c.Request().Context(), models.ControlEvent{Type: models.ControlMaintenanceEnd}, notified...)
+		h.audit(c, admin.Id, "maintenance.disable", admin.Id, nil)
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if body.RetryAfter == 0 {
+		body.RetryAfter = 300
+	}
+	status := h.Maintenance.Enable(body.Message, time.Duration(body.RetryAfter)*time.Second)
+	notice := maintenanceNotice{Enabled: true, Message: status.Message, RetryAfter: body.RetryAfter, Since: status.Since}
+	payload, _ := json.Marshal(notice)
+	if err := h.Broker.Publish(c.Request().Context(), models.MaintenanceTopic, payload, true, broker.QoS(broker.ClassSystem)); err != nil {
+		log.Println("[WARN] maintenance notice not published", err)
+	}
+	h.Maintenance.Notify(h.broadcastControl(c.Request().Context(), models.ControlEvent{
+		Type:       models.ControlMaintenance,
+		Message:    status.Message,
+		RetryAfter: body.RetryAfter,
+		Timestamp:  status.Since,
+	}))
+	time.AfterFunc(maintenanceGrace, h.disconnectClients)
+
+	h.audit(c, admin.Id, "maintenance.enable", admin.Id, map[string]string{"message": body.Message})
+	return c.JSON(http.StatusOK, notice)
+}
+
+// disconnectClients drops every connected client unless maintenance was
+// switched off again during the grace period. An external broker keeps its
+// clients, only new connections are refused through the auth webhook.
+func (h *Handler) disconnectClients() {
+	if !h.Maintenance.Enabled() || h.Embedded == nil {
+		return
+	}
+	for _, client := range h.Embedded.Clients.GetAll() {
+		if client.Net.Inline {
+			continue
+		}
+		_ = h.Embedded.DisconnectClient(client, packets.ErrServerUnavailable)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	database "filachat/internal/data"
+	"filachat/internal/media"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"strconv"
+)
+
+// Attachment bytes are only served from /media with an HMAC signed, expiring
+// link. A leaked link stops working after MEDIA_URL_TTL, or after its first
+// use when issued single-use, and every download is audited against the
+// user the link was issued to.
+
+const variantThumbnail = "thumbnail"
+
+func (h *Handler) issueMediaURL(c echo.Context, variant string, singleUse bool) (media.Grant, error) {
+	user := c.Get("user").(*models.User)
+
+	attachment, err := h.loadAttachment(c, user.Id)
+	if err != nil {
+		return media.Grant{}, err
+	}
+	switch variant {
+	case "":
+	case variantThumbnail:
+		if attachment.Thumbnail == nil {
+			return media.Grant{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "thumbnail not found"}
+		}
+	default:
+		return media.Grant{}, &echo.HTTPError{Code: http.StatusBadRequest, Message: "unknown variant"}
+	}
+
+	grant, err := h.Signer.Issue(attachment.Id.Hex(), variant, user.Id.Hex(), singleUse)
+	if err != nil {
+		return media.Grant{}, &echo.HTTPError{Code: http.StatusInternalServerError, Message: "url not issued"}
+	}
+	return grant, nil
+}
+
+func (h *Handler) redirectToMedia(c echo.Context, variant string) error {
+	grant, err := h.issueMediaURL(c, variant, false)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.Redirect(http.StatusFound, h.Signer.URL(grant))
+}
+
+func (h *Handler) GetMediaURL(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	grant, err := h.issueMediaURL(c, c.QueryParam("variant"), c.QueryParam("single_use") == "true")
+	if err != nil {
+		return err
+	}
+
+	id, _ := bson.ObjectIDFromHex(grant.AttachmentId)
+	details := map[string]string{"variant": grant.Variant}
+	if grant.Nonce != "" {
+		details["single_use"] = "true"
+	}
+	h.audit(c, user.Id, "attachment.url", id, details)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"url":        h.Signer.URL(grant),
+		"expires_at": grant.Expires,
+	})
+}
+
+func (h *Handler) ServeMedia(c echo.Context) error {
+	grant, err := h.Signer.Verify(c.Param("id"), c.QueryParams())
+	if errors.Is(err, media.ErrURLExpired) {
+		return &echo.HTTPError{Code: http.StatusGone, Message: "link expired"}
+	}
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid link"}
+	}
+
+	id, err := bson.ObjectIDFromHex(grant.AttachmentId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+	userId, err := bson.ObjectIDFromHex(grant.UserId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid link"}
+	}
+	// the link was valid when issued, access may have changed since
+	attachment, err := h.DB.GetAttachment(c.Request().Context(), id)
+	if err != nil || attachment.Quarantined || (attachment.OwnerId != userId && attachment.RecipientId != userId) {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+
+	key, size, contentType := attachment.StorageKey, attachment.Size, attachment.ContentType
+	if grant.Variant == variantThumbnail {
+		if attachment.Thumbnail == nil {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "thumbnail not found"}
+		}
+		key, size, contentType = attachment.Thumbnail.StorageKey, attachment.Thumbnail.Size, attachment.Thumbnail.ContentType
+	}
+
+	if grant.Nonce != "" {
+		err := h.DB.ConsumeMediaNonce(c.Request().Context(), grant.Nonce, grant.Expires)
+		if errors.Is(err, database.ErrNonceUsed) {
+			return &echo.HTTPError{Code: http.StatusGone, Message: "link already used"}
+		}
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "link not checked"}
+		}
+	}
+
+	blob, err := h.Storage.Open(key)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+	defer blob.Close()
+
+	h.audit(c, userId, "attachment.download", id, map[string]string{"variant": grant.Variant})
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(size, 10))
+	header.Set(echo.HeaderCacheControl, "private, no-store")
+	return c.Stream(http.StatusOK, contentType, blob)
+}
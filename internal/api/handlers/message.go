@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"filachat/internal/broker"
+	"filachat/internal/i18n"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"filachat/internal/spam"
+	"filachat/pkg/config"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+// validateIngest checks a message received from a client. System messages
+// are generated by the server only and are refused here. A sender may be
+// their own recipient, see models.Message.ToSelf.
+func validateIngest(sender bson.ObjectID, message *models.Message) error {
+	if message.Type == "" {
+		message.Type = models.TypeMessage
+	}
+	if !message.Type.Valid() {
+		return errors.New("unknown message type")
+	}
+	if message.Type != models.TypeMessage || message.System != nil {
+		return errors.New("message type not allowed")
+	}
+	if !message.GroupId.IsZero() {
+		if !message.RecipientId.IsZero() {
+			return errors.New("group messages have no recipient")
+		}
+	} else if message.RecipientId.IsZero() {
+		return errors.New("invalid recipient")
+	}
+	if message.Content == "" {
+		return errors.New("missing content")
+	}
+	return nil
+}
+
+func (h *Handler) SendMessage(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	received := time.Now()
+
+	var message models.Message
+	if err := c.Bind(&message); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if err := validateIngest(user.Id, &message); err != nil {
+		h.messageRefused(c.Request().Context(), user.Id, &message, err)
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	group, err := h.messageTarget(c.Request().Context(), user.Id, &message)
+	if err != nil {
+		h.messageRefused(c.Request().Context(), user.Id, &message, err)
+		return err
+	}
+
+	message.Id = bson.NewObjectID()
+	message.SenderId = user.Id
+	// notes to self are never unread
+	message.Read = message.ToSelf()
+	message.Timestamp = time.Now()
+	message.ReceivedAt = received
+	message.Via = models.ViaREST
+	message.Trace = traceparent(c)
+	pad(&message)
+
+	accepted := models.DebugEvent{Type: models.DebugMessageAccepted, MessageId: message.Id, Trace: message.Trace}
+	switch h.checkSpam(c.Request().Context(), user.Id, &message) {
+	case spam.Throttle:
+		h.publishDebugEvent(c.Request().Context(), user.Id, models.DebugEvent{Type: models.DebugRateLimited, Reason: "too many messages", Trace: message.Trace})
+		h.messageRefused(c.Request().Context(), user.Id, &message, errThrottled)
+		return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: "too many messages"}
+	case spam.Shadow:
+		// answer exactly like a delivered message
+		h.publishDebugEvent(c.Request().Context(), user.Id, accepted)
+		return c.JSON(http.StatusCreated, message)
+	}
+
+	if message.GroupId.IsZero() {
+		err = h.deliver(c.Request().Context(), &message)
+	} else {
+		err = h.deliverToGroup(c.Request().Context(), &message, group)
+	}
+	if err != nil {
+		h.messageRefused(c.Request().Context(), user.Id, &message, errNotStored)
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "message not sent"}
+	}
+	h.publishDebugEvent(c.Request().Context(), user.Id, accepted)
+	return c.JSON(http.StatusCreated, message)
+}
+
+// messageTarget checks the recipient of a message exists, or the sender
+// is a member of its group, which is returned.
+func (h *Handler) messageTarget(ctx context.Context, sender bson.ObjectID, message *models.Message) (models.Group, error) {
+	if message.GroupId.IsZero() {
+		recipient, err := h.DB.GetUser(ctx, message.RecipientId)
+		if err != nil || (recipient.Host != "" && h.Federation == nil) {
+			return models.Group{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "recipient not found", Internal: errRecipientNotFound}
+		}
+		// a suspension passes, messages wait for the recipient to return
+		if state := recipient.State(time.Now()); state == models.AccountBanned || state == models.AccountDeactivated {
+			return models.Group{}, &echo.HTTPError{Code: http.StatusForbidden, Message: "recipient unavailable", Internal: errRecipientUnavailable}
+		}
+		return models.Group{}, nil
+	}
+	if !config.Current().FeatureGroups {
+		return models.Group{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "feature disabled", Internal: errRecipientNotFound}
+	}
+	group, err := h.DB.GetGroup(ctx, message.GroupId)
+	if err != nil || !group.IsMember(sender) {
+		return models.Group{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "group not found", Internal: errRecipientNotFound}
+	}
+	return group, nil
+}
+
+// deliver stores a message and publishes it to the recipient, or for a
+// recipient on another server queues it for their server. A failed
+// publish is not fatal, the recipient picks the message up on next sync.
+// Sealing only applies to the stored copy, the broker link is TLS already.
+func (h *Handler) deliver(ctx context.Context, message *models.Message) error {
+	stored, err := h.sealForStorage(ctx, *message)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.SaveMessage(ctx, &stored); err != nil {
+		return err
+	}
+	metrics.ObserveMessageStage("persisted", message.Via, message.ReceivedAt)
+	h.Summaries.Message(*message)
+	if recipient, remote := h.remoteRecipient(ctx, message); remote {
+		return h.federate(ctx, message, recipient)
+	}
+
+	payload, _ := json.Marshal(message)
+	if err := h.publishMessage(ctx, models.MessageTopic(message.RecipientId), message, payload, broker.QoS(broker.ClassMessages)); err != nil {
+		log.Println("[WARN] message not published", message.Id.Hex(), err)
+	}
+	metrics.ObserveMessageStage("published", message.Via, message.ReceivedAt)
+	metrics.DeliveryLatency.WithLabelValues("stored").Observe(time.Since(message.Timestamp).Seconds())
+	h.publishBadges(ctx, message.RecipientId)
+	return nil
+}
+
+// postSystemMessage records a server-generated event, such as a missed call,
+// in the recipient's history. The event carries a text in the recipient's
+// language for clients that do not render the kind themselves.
+func (h *Handler) postSystemMessage(ctx context.Context, recipient bson.ObjectID, peer bson.ObjectID, kind models.SystemEventKind, params map[string]string) error {
+	locale := i18n.Default
+	if user, err := h.DB.GetUser(ctx, recipient); err == nil {
+		locale = h.locale(user)
+	}
+	return h.deliver(ctx, &models.Message{
+		Id:          bson.NewObjectID(),
+		SenderId:    peer,
+		RecipientId: recipient,
+		Type:        models.TypeSystem,
+		System:      &models.SystemEvent{Kind: kind, Params: params, Text: systemText(locale, kind, params)},
+		Timestamp:   time.Now(),
+	})
+}
+
+// systemText picks the catalog entry for an event, some kinds have one per
+// variant.
+func systemText(locale string, kind models.SystemEventKind, params map[string]string) string {
+	key := "system." + string(kind)
+	switch kind {
+	case models.SystemMissedCall:
+		key += "." + params["media"]
+	case models.SystemEncryptionChanged:
+		key += "." + params["encryption"]
+	}
+	return i18n.T(locale, key, params)
+}
+
+// SendTyping tells a peer the user is typing. Nothing is stored, the event
+// expires on the broker after a few seconds and clients send it again for
+// as long as the user keeps typing.
+func (h *Handler) SendTyping(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	peerId, err := bson.ObjectIDFromHex(c.Param("peerId"))
+	if err != nil || peerId == user.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid peer id"}
+	}
+	if _, err := h.DB.GetUser(c.Request().Context(), peerId); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "peer not found"}
+	}
+
+	typing := models.Message{
+		Id:          bson.NewObjectID(),
+		SenderId:    user.Id,
+		RecipientId: peerId,
+		Type:        models.TypeTyping,
+		Timestamp:   time.Now(),
+		Trace:       traceparent(c),
+	}
+	payload, _ := json.Marshal(typing)
+	if err := h.publishMessage(c.Request().Context(), models.MessageTopic(peerId), &typing, payload, broker.QoS(broker.ClassTyping)); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "typing not sent"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) GetUnreadMessages(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	messages, err := h.DB.GetUnreadMessages(c.Request().Context(), user.Id, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not loaded"}
+	}
+	h.openMessages(c.Request().Context(), messages)
+
+	return c.JSON(http.StatusOK, pagination.NewResult(messages, page, func(m models.Message) pagination.Cursor {
+		return pagination.Cursor{Time: m.Timestamp, ID: m.Id}
+	}))
+}
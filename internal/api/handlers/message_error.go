@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// errNotStored wraps the error of a message that failed to store.
+var errNotStored = errors.New("message not sent")
+
+// The refusals of messageTarget carry these as their internal error, for
+// messageErrorReason to tell them apart from malformed messages.
+var (
+	errRecipientNotFound    = errors.New("recipient not found")
+	errRecipientUnavailable = errors.New("recipient unavailable")
+)
+
+// messageErrorReason maps the refusals of SendMessage and IngestPublish to
+// what the sender is told, anything else is a malformed message.
+func messageErrorReason(err error) models.MessageErrorReason {
+	switch {
+	case errors.Is(err, errNotStored):
+		return models.ErrorNotStored
+	case errors.Is(err, errThrottled):
+		return models.ErrorRateLimited
+	case errors.Is(err, errRecipientNotFound):
+		return models.ErrorRecipientNotFound
+	case errors.Is(err, errRecipientUnavailable):
+		return models.ErrorRecipientUnavailable
+	}
+	return models.ErrorInvalid
+}
+
+// messageRefused tells the sender why a message was not taken.
+func (h *Handler) messageRefused(ctx context.Context, sender bson.ObjectID, message *models.Message, err error) {
+	h.publishMessageError(ctx, sender, models.MessageError{
+		RecipientId: message.RecipientId,
+		GroupId:     message.GroupId,
+		Reason:      messageErrorReason(err),
+	})
+}
+
+// FederationDropped is federation.Outbox.Dropped: the stored message is
+// marked undeliverable and its sender told.
+func (h *Handler) FederationDropped(ctx context.Context, federated models.FederatedMessage) {
+	message, err := h.DB.GetMessage(ctx, federated.Id)
+	if err != nil {
+		log.Println("[WARN] dropped federated message not found", federated.Id.Hex(), err)
+		return
+	}
+	messageError := models.MessageError{
+		MessageId:   message.Id,
+		RecipientId: message.RecipientId,
+		Reason:      models.ErrorRemoteUndeliverable,
+		Timestamp:   time.Now(),
+	}
+	if err := h.DB.SetMessageError(ctx, message.Id, messageError); err != nil {
+		log.Println("[WARN] message error not recorded", message.Id.Hex(), err)
+	}
+	h.publishMessageError(ctx, message.SenderId, messageError)
+}
+
+func (h *Handler) publishMessageError(ctx context.Context, sender bson.ObjectID, messageError models.MessageError) {
+	messageError.Type = models.MessageErrorType
+	if messageError.Timestamp.IsZero() {
+		messageError.Timestamp = time.Now()
+	}
+	payload, _ := json.Marshal(messageError)
+	if err := h.Broker.Publish(ctx, models.SystemTopic(sender, "delivery"), payload, false, broker.QoS(broker.ClassSystem)); err != nil {
+		log.Println("[WARN] message error not published", sender.Hex(), err)
+	}
+}
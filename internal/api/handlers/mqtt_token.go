@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+)
+
+// MQTTToken hands out the client id to connect to the broker with. A
+// client keeps its device id across connections to resume its session,
+// a new one is made up when it has none yet.
+func (h *Handler) MQTTToken(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		DeviceId string `json:"device_id"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	device := bson.NewObjectID()
+	if body.DeviceId != "" {
+		var err error
+		if device, err = bson.ObjectIDFromHex(body.DeviceId); err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid device id"}
+		}
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		ClientId string `json:"client_id"`
+		Username string `json:"username"`
+		DeviceId string `json:"device_id"`
+	}{h.ClientIDs.Issue(auth.Id, device), auth.Id.Hex(), device.Hex()})
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"filachat/internal/api/hooks"
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// In bridge mode an external broker delegates client authentication and
+// topic authorization to these webhooks. The bodies cover what EMQX and
+// mosquitto-go-auth send, as JSON or form; the answer is 200 with result
+// "allow" or 403 with result "deny", which both understand.
+//
+// Clients connect with their user id as username, an access token as
+// password and a client id from /mqtt/token, the embedded broker ignores
+// the username instead.
+
+type mqttAuthRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	ClientID string `json:"clientid" form:"clientid"`
+}
+
+type mqttACLRequest struct {
+	Username string `json:"username" form:"username"`
+	Topic    string `json:"topic" form:"topic"`
+	// Action is what EMQX sends, publish or subscribe
+	Action string `json:"action" form:"action"`
+	// Access is what mosquitto-go-auth sends: 1 read, 2 write, 3 both,
+	// 4 subscribe
+	Access int `json:"acc" form:"acc"`
+}
+
+func mqttAllow(c echo.Context, superuser bool) error {
+	return c.JSON(http.StatusOK, map[string]any{"result": "allow", "is_superuser": superuser})
+}
+
+func mqttDeny(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]any{"result": "deny"})
+}
+
+// isBridge reports whether username is the backend's own bridge client,
+// which publishes to every topic.
+func (h *Handler) isBridge(username string) bool {
+	return h.Config.BridgePassword != "" && username == h.Config.BridgeUsername
+}
+
+func (h *Handler) MQTTAuth(c echo.Context) error {
+	var body mqttAuthRequest
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+
+	if h.isBridge(body.Username) {
+		if subtle.ConstantTimeCompare([]byte(body.Password), []byte(h.Config.BridgePassword)) != 1 {
+			return mqttDeny(c)
+		}
+		return mqttAllow(c, true)
+	}
+
+	// new connections are turned away during maintenance, as in MaintenanceHook
+	if h.Maintenance.Enabled() || body.Password == "" {
+		return mqttDeny(c)
+	}
+	claims, err := h.Tokens.Open(body.Password, core.AccessToken)
+	if err != nil || claims.IsSupport() || claims.Subject.Hex() != body.Username {
+		return mqttDeny(c)
+	}
+	if user, _, err := h.ClientIDs.Verify(body.ClientID); err != nil || user != claims.Subject {
+		return mqttDeny(c)
+	}
+	if h.Restricted.State(claims.Subject, time.Now()) != models.AccountActive {
+		return mqttDeny(c)
+	}
+	return mqttAllow(c, false)
+}
+
+func (h *Handler) MQTTACL(c echo.Context) error {
+	var body mqttACLRequest
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+
+	if h.isBridge(body.Username) {
+		return mqttAllow(c, true)
+	}
+	write := body.Action == "publish" || body.Access == 2 || body.Access == 3
+	if !hooks.TopicAllowed(body.Username, body.Topic, write) {
+		return mqttDeny(c)
+	}
+	return mqttAllow(c, false)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Organizations sit above individual users: they own groups and channels,
+// keep a member directory and have admins of their own who manage members
+// and settings without being server admins.
+
+const maxAllowedDomains = 20
+
+// orgMember loads the organization named by the id param along with the
+// caller's membership. Non-members get a 404, adminOnly also turns away
+// plain members.
+func (h *Handler) orgMember(c echo.Context, user bson.ObjectID, adminOnly bool) (models.Organization, models.OrgMember, error) {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return models.Organization{}, models.OrgMember{}, &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid organization id"}
+	}
+	member, err := h.DB.GetOrgMember(c.Request().Context(), id, user)
+	if err != nil {
+		return models.Organization{}, models.OrgMember{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "organization not found"}
+	}
+	if adminOnly && !member.Role.CanAdmin() {
+		return models.Organization{}, models.OrgMember{}, &echo.HTTPError{Code: http.StatusForbidden, Message: "organization admins only"}
+	}
+	org, err := h.DB.GetOrganization(c.Request().Context(), id)
+	if err != nil {
+		return models.Organization{}, models.OrgMember{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "organization not found"}
+	}
+	return org, member, nil
+}
+
+func (h *Handler) CreateOrganization(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.Bind(&body); err != nil || strings.TrimSpace(body.Name) == "" || len(body.Name) > 64 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid organization name"}
+	}
+
+	org := models.Organization{
+		Id:        bson.NewObjectID(),
+		Name:      strings.TrimSpace(body.Name),
+		OwnerId:   user.Id,
+		CreatedAt: time.Now(),
+	}
+	if err := h.DB.SaveOrganization(c.Request().Context(), &org); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "organization not created"}
+	}
+	return c.JSON(http.StatusCreated, org)
+}
+
+func (h *Handler) GetOrganization(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	org, _, err := h.orgMember(c, user.Id, false)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, org)
+}
+
+// SetOrgSettings replaces the settings of an organization. Allowed domains
+// only apply to members added from now on.
+func (h *Handler) SetOrgSettings(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	org, _, err := h.orgMember(c, user.Id, true)
+	if err != nil {
+		return err
+	}
+	var settings models.OrgSettings
+	if err := c.Bind(&settings); err != nil || settings.RetentionDays < 0 || len(settings.AllowedDomains) > maxAllowedDomains {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid settings"}
+	}
+	for i, domain := range settings.AllowedDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || strings.ContainsAny(domain, "@ /") {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid domain"}
+		}
+		settings.AllowedDomains[i] = domain
+	}
+
+	if err := h.DB.SetOrgSettings(c.Request().Context(), org.Id, settings); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "settings not saved"}
+	}
+	h.audit(c, user.Id, "org.settings", org.Id, map[string]string{
+		"retention_days":  strconv.Itoa(settings.RetentionDays),
+		"allowed_domains": strings.Join(settings.AllowedDomains, ","),
+	})
+	org.Settings = settings
+	return c.JSON(http.StatusOK, org)
+}
+
+// GetOrgMembers is the member directory, visible to every member.
+func (h *Handler) GetOrgMembers(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	org, _, err := h.orgMember(c, user.Id, false)
+	if err != nil {
+		return err
+	}
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	members, err := h.DB.GetOrgMembers(c.Request().Context(), org.Id, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "members not loaded"}
+	}
+	ids := make([]bson.ObjectID, len(members))
+	for i, member := range members {
+		ids[i] = member.UserId
+	}
+	names, err := h.DB.Usernames(c.Request().Context(), ids)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "members not loaded"}
+	}
+	for i := range members {
+		members[i].Username = names[members[i].UserId]
+	}
+	return c.JSON(http.StatusOK, pagination.NewResult(members, page, func(m models.OrgMember) pagination.Cursor {
+		return pagination.Cursor{Time: m.JoinedAt, ID: m.Id}
+	}))
+}
+
+// SetOrgMember adds a user as member or admin, or changes their role. There
+// is one owner, the creator, whose role stays put.
+func (h *Handler) SetOrgMember(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	org, _, err := h.orgMember(c, user.Id, true)
+	if err != nil {
+		return err
+	}
+	userId, err := bson.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	var body struct {
+		Role models.OrgRole `json:"role"`
+	}
+	if err := c.Bind(&body); err != nil || !body.Role.Valid() || body.Role == models.OrgRoleOwner {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid role"}
+	}
+	if userId == org.OwnerId {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "the owner's role cannot be changed"}
+	}
+
+	target, err := h.DB.GetUser(c.Request().Context(), userId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if _, err := h.DB.GetOrgMember(c.Request().Context(), org.Id, userId); errors.Is(err, mongo.ErrNoDocuments) && !org.Settings.AllowsEmail(target.Email) {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "email domain not allowed"}
+	}
+
+	member := models.OrgMember{
+		Id:       bson.NewObjectID(),
+		OrgId:    org.Id,
+		UserId:   userId,
+		Role:     body.Role,
+		JoinedAt: time.Now(),
+	}
+	if err := h.DB.SetOrgMember(c.Request().Context(), &member); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "member not saved"}
+	}
+	h.audit(c, user.Id, "org.member", userId, map[string]string{"org": org.Id.Hex(), "role": string(body.Role)})
+	member.Username = target.Username
+	return c.JSON(http.StatusOK, member)
+}
+
+// RemoveOrgMember lets admins remove members and members leave. The owner
+// cannot be removed.
+func (h *Handler) RemoveOrgMember(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	userId, err := bson.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	org, _, err := h.orgMember(c, user.Id, userId != user.Id)
+	if err != nil {
+		return err
+	}
+	if userId == org.OwnerId {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "the owner cannot be removed"}
+	}
+
+	if err := h.DB.RemoveOrgMember(c.Request().Context(), org.Id, userId); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "member not removed"}
+	}
+	h.audit(c, user.Id, "org.member.removed", userId, map[string]string{"org": org.Id.Hex()})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetOrgContent lists the channels of an organization and the groups of it
+// the caller belongs to.
+func (h *Handler) GetOrgContent(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	org, _, err := h.orgMember(c, user.Id, false)
+	if err != nil {
+		return err
+	}
+	groups, err := h.DB.GetOrgGroups(c.Request().Context(), org.Id, user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "groups not loaded"}
+	}
+	channels, err := h.DB.GetOrgChannels(c.Request().Context(), org.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "channels not loaded"}
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+	if channels == nil {
+		channels = []models.Channel{}
+	}
+	return c.JSON(http.StatusOK, map[string]any{"groups": groups, "channels": channels})
+}
+
+// requireOrgRole checks that user belongs to an organization, as an admin
+// when adminOnly, before they create something it owns.
+func (h *Handler) requireOrgRole(ctx context.Context, orgId bson.ObjectID, user bson.ObjectID, adminOnly bool) error {
+	member, err := h.DB.GetOrgMember(ctx, orgId, user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "organization not found"}
+	}
+	if adminOnly && !member.Role.CanAdmin() {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "organization admins only"}
+	}
+	return nil
+}
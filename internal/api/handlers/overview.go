@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"filachat/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const overviewTopics = 10
+
+type overview struct {
+	Uptime           string         `json:"uptime"`
+	StartedAt        time.Time      `json:"started_at"`
+	ConnectedClients *int64         `json:"connected_clients,omitempty"`
+	Queues           map[string]int `json:"queues"`
+	Database         databaseHealth `json:"database"`
+	// over the last five minutes
+	Requests    int64                `json:"requests"`
+	Errors      int64                `json:"errors"`
+	ErrorRate   float64              `json:"error_rate"`
+	NoisyTopics []metrics.TopicCount `json:"noisy_topics"`
+}
+
+type databaseHealth struct {
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// GetOverview sums up the state of this instance for an admin dashboard.
+// Connected clients are only known with the embedded broker.
+func (h *Handler) GetOverview(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	result := overview{
+		Uptime:      time.Since(metrics.StartedAt).Round(time.Second).String(),
+		StartedAt:   metrics.StartedAt,
+		Queues:      map[string]int{"push": h.Push.Len(), "summaries": h.Summaries.Len()},
+		Requests:    metrics.RecentRequests.Total(),
+		Errors:      metrics.RecentErrors.Total(),
+		NoisyTopics: metrics.Topics.Top(overviewTopics),
+	}
+	if h.Embedded != nil {
+		connected := atomic.LoadInt64(&h.Embedded.Info.ClientsConnected)
+		result.ConnectedClients = &connected
+	}
+	if result.Requests > 0 {
+		result.ErrorRate = float64(result.Errors) / float64(result.Requests)
+	}
+
+	start := time.Now()
+	err := h.DB.Ping(c.Request().Context())
+	result.Database = databaseHealth{OK: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Database.Error = err.Error()
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"filachat/pkg/padding"
+)
+
+// pad fills a message up to its bucket, so neither the stored nor the
+// relayed copy gives away how long the content is. Content the client
+// padded itself with padding.Buckets.Pad usually fills a bucket already.
+func pad(message *models.Message) {
+	message.Padding = padding.Buckets(config.Current().PaddingBuckets).Filler(len(message.Content))
+}
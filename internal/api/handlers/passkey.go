@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"filachat/internal/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"time"
+)
+
+const passkeySessionTTL = 5 * time.Minute
+
+type passkeyUser struct {
+	user        models.User
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return u.user.Id[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (h *Handler) loadPasskeyUser(ctx context.Context, user models.User) (*passkeyUser, error) {
+	stored, err := h.DB.GetPasskeyCredentials(ctx, user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, credential := range stored {
+		credentials = append(credentials, credential.Credential)
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+func (h *Handler) BeginPasskeyRegistration(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	pkUser, err := h.loadPasskeyUser(c.Request().Context(), user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "passkeys not loaded"}
+	}
+
+	options, data, err := h.WebAuthn.BeginRegistration(pkUser)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "registration not started"}
+	}
+
+	session := models.PasskeySession{
+		Id:        bson.NewObjectID(),
+		UserId:    user.Id,
+		Type:      models.PasskeyRegistration,
+		Data:      *data,
+		ExpiresAt: time.Now().Add(passkeySessionTTL),
+	}
+	if err := h.DB.SavePasskeySession(c.Request().Context(), &session); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "registration not started"}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"session_id": session.Id, "options": options})
+}
+
+func (h *Handler) FinishPasskeyRegistration(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	sessionId, err := bson.ObjectIDFromHex(c.QueryParam("session_id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid session"}
+	}
+	session, err := h.DB.TakePasskeySession(c.Request().Context(), sessionId, models.PasskeyRegistration)
+	if err != nil || session.UserId != auth.Id {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid session"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	pkUser, err := h.loadPasskeyUser(c.Request().Context(), user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "passkeys not loaded"}
+	}
+
+	credential, err := h.WebAuthn.FinishRegistration(pkUser, session.Data, c.Request())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid passkey"}
+	}
+
+	stored := models.PasskeyCredential{
+		Id:         bson.NewObjectID(),
+		UserId:     user.Id,
+		Credential: *credential,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.DB.SavePasskeyCredential(c.Request().Context(), &stored); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "passkey not saved"}
+	}
+	return c.JSON(http.StatusCreated, stored)
+}
+
+func (h *Handler) BeginPasskeyLogin(c echo.Context) error {
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := c.Bind(&body); err != nil || body.Username == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing username"}
+	}
+
+	user, err := h.DB.GetUserByName(c.Request().Context(), body.Username)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "invalid user or passkey"}
+	}
+	pkUser, err := h.loadPasskeyUser(c.Request().Context(), user)
+	if err != nil || len(pkUser.credentials) == 0 {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "invalid user or passkey"}
+	}
+
+	options, data, err := h.WebAuthn.BeginLogin(pkUser)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "login not started"}
+	}
+
+	session := models.PasskeySession{
+		Id:        bson.NewObjectID(),
+		UserId:    user.Id,
+		Type:      models.PasskeyLogin,
+		Data:      *data,
+		ExpiresAt: time.Now().Add(passkeySessionTTL),
+	}
+	if err := h.DB.SavePasskeySession(c.Request().Context(), &session); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "login not started"}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"session_id": session.Id, "options": options})
+}
+
+func (h *Handler) FinishPasskeyLogin(c echo.Context) error {
+	sessionId, err := bson.ObjectIDFromHex(c.QueryParam("session_id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid session"}
+	}
+	session, err := h.DB.TakePasskeySession(c.Request().Context(), sessionId, models.PasskeyLogin)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid session"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), session.UserId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "invalid user or passkey"}
+	}
+	if err := accountError(user, time.Now()); err != nil {
+		return err
+	}
+	pkUser, err := h.loadPasskeyUser(c.Request().Context(), user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "passkeys not loaded"}
+	}
+
+	credential, err := h.WebAuthn.FinishLogin(pkUser, session.Data, c.Request())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid user or passkey"}
+	}
+	if credential.Authenticator.CloneWarning {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid user or passkey"}
+	}
+	if err := h.DB.UpdatePasskeyCredential(c.Request().Context(), user.Id, credential); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "passkey not updated"}
+	}
+
+	return h.issueTokens(c, models.User{Id: user.Id, Username: user.Username})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"filachat/internal/presence"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Heartbeat keeps clients without a broker connection, REST or SSE only,
+// online. They call it again after Interval seconds; once heartbeats stop
+// for the presence TTL the user goes offline as with a dropped connection.
+func (h *Handler) Heartbeat(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	if err := h.Presence.Touch(user.Id, h.Config.PresenceTTL); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "presence not updated"}
+	}
+	return c.JSON(http.StatusOK, models.Heartbeat{
+		Interval:  int((h.Config.PresenceTTL / 3).Seconds()),
+		ExpiresAt: time.Now().Add(h.Config.PresenceTTL),
+	})
+}
+
+// SetStatus stores the state and status text a user picked. It outlives
+// their connections, and everyone watching their presence gets the change.
+func (h *Handler) SetStatus(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var setting models.StatusSetting
+	if err := c.Bind(&setting); err != nil || !setting.State.Valid() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid state"}
+	}
+	setting.Text = strings.TrimSpace(setting.Text)
+	if utf8.RuneCountInString(setting.Text) > 140 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "status text too long"}
+	}
+	// a single emoji can take several code points with modifiers and joiners
+	if utf8.RuneCountInString(setting.Emoji) > 8 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid emoji"}
+	}
+	setting.UpdatedAt = time.Now()
+
+	if err := h.DB.UpdateUser(c.Request().Context(), user.Id, bson.M{"status": setting}); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "status not saved"}
+	}
+	if err := h.Presence.Refresh(user.Id); err != nil {
+		log.Println("[WARN] status change not broadcast", user.Id.Hex(), err)
+	}
+	return c.JSON(http.StatusOK, setting)
+}
+
+// GetStatus returns the presence of a user as peers see it.
+func (h *Handler) GetStatus(c echo.Context) error {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	target, err := h.DB.GetUser(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+
+	status, err := h.Presence.Status(id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "presence not loaded"}
+	}
+	status = presence.Remembered(status, target.LastSeen)
+	return c.JSON(http.StatusOK, presence.Paused(presence.Resolve(status, target.Status), target.QuietHours, time.Now()))
+}
+
+func (h *Handler) GetQuietHours(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	stored, err := h.DB.GetUser(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if stored.QuietHours == nil {
+		return c.JSON(http.StatusOK, models.QuietHours{Timezone: "UTC", Ranges: []models.QuietRange{}})
+	}
+	return c.JSON(http.StatusOK, stored.QuietHours)
+}
+
+// SetQuietHours replaces the quiet hours of a user. Peers watching their
+// presence learn about the change when ShowPeers is on.
+func (h *Handler) SetQuietHours(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var quiet models.QuietHours
+	if err := c.Bind(&quiet); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if err := quiet.Validate(); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	quiet.UpdatedAt = time.Now()
+
+	if err := h.DB.UpdateUser(c.Request().Context(), user.Id, bson.M{"quiet_hours": quiet}); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "quiet hours not saved"}
+	}
+	if err := h.Presence.Refresh(user.Id); err != nil {
+		log.Println("[WARN] quiet hours not broadcast", user.Id.Hex(), err)
+	}
+	return c.JSON(http.StatusOK, quiet)
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+)
+
+func (h *Handler) GetProfile(c echo.Context) error {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+
+	return c.JSON(http.StatusOK, models.User{Id: user.Id, Username: user.Username, PublicKey: user.PublicKey})
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"filachat/internal/models"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Simplified SCIM 2.0 user provisioning: users are matched on userName,
+// deactivation replaces deletion so message history stays intact.
+
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+var scimUserNameFilter = regexp.MustCompile(`^userName eq "([^"]*)"$`)
+
+type (
+	scimEmail struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary,omitempty"`
+	}
+	scimUser struct {
+		Schemas    []string    `json:"schemas"`
+		Id         string      `json:"id,omitempty"`
+		ExternalId string      `json:"externalId,omitempty"`
+		UserName   string      `json:"userName"`
+		Emails     []scimEmail `json:"emails,omitempty"`
+		Active     *bool       `json:"active,omitempty"`
+		Password   string      `json:"password,omitempty"`
+	}
+	scimListResponse struct {
+		Schemas      []string   `json:"schemas"`
+		TotalResults int64      `json:"totalResults"`
+		StartIndex   int64      `json:"startIndex"`
+		ItemsPerPage int        `json:"itemsPerPage"`
+		Resources    []scimUser `json:"Resources"`
+	}
+	scimPatch struct {
+		Schemas    []string `json:"schemas"`
+		Operations []struct {
+			Op    string `json:"op"`
+			Path  string `json:"path"`
+			Value any    `json:"value"`
+		} `json:"Operations"`
+	}
+)
+
+func toSCIMUser(user models.User) scimUser {
+	active := !user.Deactivated
+	result := scimUser{
+		Schemas:    []string{scimUserSchema},
+		Id:         user.Id.Hex(),
+		ExternalId: user.ExternalId,
+		UserName:   user.Username,
+		Active:     &active,
+	}
+	if user.Email != "" {
+		result.Emails = []scimEmail{{Value: user.Email, Primary: true}}
+	}
+	return result
+}
+
+func (u scimUser) primaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// bindSCIM decodes the body directly, identity providers send
+// application/scim+json which echo's binder refuses.
+func bindSCIM(c echo.Context, v any) error {
+	return json.NewDecoder(c.Request().Body).Decode(v)
+}
+
+func scimError(c echo.Context, status int, detail string) error {
+	return c.JSON(status, echo.Map{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	})
+}
+
+func (h *Handler) ListProvisionedUsers(c echo.Context) error {
+	filter := bson.M{}
+	if raw := c.QueryParam("filter"); raw != "" {
+		match := scimUserNameFilter.FindStringSubmatch(raw)
+		if match == nil {
+			return scimError(c, http.StatusBadRequest, "unsupported filter")
+		}
+		filter["username"] = match[1]
+	}
+
+	startIndex, err := strconv.ParseInt(c.QueryParam("startIndex"), 10, 64)
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.ParseInt(c.QueryParam("count"), 10, 64)
+	if err != nil || count < 0 || count > 100 {
+		count = 100
+	}
+
+	users, total, err := h.DB.ListUsers(c.Request().Context(), filter, startIndex-1, count)
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "users not loaded")
+	}
+
+	resources := make([]scimUser, 0, len(users))
+	for _, user := range users {
+		resources = append(resources, toSCIMUser(user))
+	}
+	return c.JSON(http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *Handler) GetProvisionedUser(c echo.Context) error {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "user not found")
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), id)
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "user not found")
+	}
+	return c.JSON(http.StatusOK, toSCIMUser(user))
+}
+
+func (h *Handler) CreateProvisionedUser(c echo.Context) error {
+	var body scimUser
+	if err := bindSCIM(c, &body); err != nil || body.UserName == "" {
+		return scimError(c, http.StatusBadRequest, "invalid user")
+	}
+
+	if err := h.checkUsername(c.Request().Context(), body.UserName, bson.NilObjectID); err != nil {
+		return scimCheckError(c, err)
+	}
+	email := body.primaryEmail()
+	if userExists, err := h.DB.Exists(c.Request().Context(), body.UserName, email); err != nil || userExists {
+		return scimError(c, http.StatusConflict, "user already exists")
+	}
+
+	// Provisioned users normally sign in through the identity provider, so
+	// without a password they get an unguessable one instead of an empty hash.
+	password := body.Password
+	if password == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return scimError(c, http.StatusInternalServerError, "user not created")
+		}
+		password = base64.RawStdEncoding.EncodeToString(random)
+	}
+	hash, err := h.Hashing.Hash([]byte(password))
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "hashing failed")
+	}
+
+	user := models.User{
+		Id:          bson.NewObjectID(),
+		Username:    body.UserName,
+		Email:       email,
+		Password:    hash,
+		ExternalId:  body.ExternalId,
+		Deactivated: body.Active != nil && !*body.Active,
+	}
+	if err := h.DB.InsertUser(c.Request().Context(), &user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return scimError(c, http.StatusConflict, "user already exists")
+		}
+		return scimError(c, http.StatusInternalServerError, "user not created")
+	}
+	return c.JSON(http.StatusCreated, toSCIMUser(user))
+}
+
+func (h *Handler) PatchProvisionedUser(c echo.Context) error {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "user not found")
+	}
+
+	var body scimPatch
+	if err := bindSCIM(c, &body); err != nil {
+		return scimError(c, http.StatusBadRequest, "invalid patch")
+	}
+
+	fields := bson.M{}
+	var username string
+	var active *bool
+	for _, op := range body.Operations {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			return scimError(c, http.StatusBadRequest, "unsupported operation")
+		}
+		switch op.Path {
+		case "active":
+			value, ok := op.Value.(bool)
+			if !ok {
+				return scimError(c, http.StatusBadRequest, "invalid active value")
+			}
+			active = &value
+		case "userName":
+			value, ok := op.Value.(string)
+			if !ok || value == "" {
+				return scimError(c, http.StatusBadRequest, "invalid userName value")
+			}
+			username = value
+		case "externalId":
+			externalId, ok := op.Value.(string)
+			if !ok {
+				return scimError(c, http.StatusBadRequest, "invalid externalId value")
+			}
+			fields["external_id"] = externalId
+		default:
+			return scimError(c, http.StatusBadRequest, "unsupported path")
+		}
+	}
+	if len(fields) == 0 && username == "" && active == nil {
+		return scimError(c, http.StatusBadRequest, "empty patch")
+	}
+
+	if username != "" {
+		if err := h.renameProvisionedUser(c, id, username); err != nil {
+			return err
+		}
+	}
+	if len(fields) > 0 {
+		if err := h.DB.UpdateUser(c.Request().Context(), id, fields); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return scimError(c, http.StatusNotFound, "user not found")
+			}
+			return scimError(c, http.StatusInternalServerError, "user not updated")
+		}
+	}
+	if active != nil {
+		if err := h.setProvisionedActive(c, id, *active); err != nil {
+			return err
+		}
+	}
+	return h.GetProvisionedUser(c)
+}
+
+// setProvisionedActive deactivates or reactivates an account the way
+// SetAccountState does, deactivation ends its sessions and disconnects its
+// clients. Reactivation only undoes a deactivation, the identity provider
+// does not lift bans or suspensions.
+func (h *Handler) setProvisionedActive(c echo.Context, id bson.ObjectID, active bool) error {
+	ctx := c.Request().Context()
+	user, err := h.DB.GetUser(ctx, id)
+	if err != nil || user.Host != "" {
+		return scimError(c, http.StatusNotFound, "user not found")
+	}
+	now := time.Now()
+	deactivated := user.State(now) == models.AccountDeactivated
+	if active != deactivated {
+		return nil
+	}
+
+	status := models.AccountStatus{State: models.AccountActive, ChangedAt: now}
+	if !active {
+		status = models.AccountStatus{State: models.AccountDeactivated, Reason: "deprovisioned", ChangedAt: now}
+	}
+	if err := h.DB.UpdateUser(ctx, id, bson.M{"account": status, "deactivated": !active}); err != nil {
+		return scimError(c, http.StatusInternalServerError, "user not updated")
+	}
+	h.Restricted.Update(id, status)
+	if !active {
+		h.endAccess(ctx, id, "account_"+string(models.AccountDeactivated))
+	}
+	return nil
+}
+
+// renameProvisionedUser takes the checks of ChangeUsername, the identity
+// provider is not held to the cooldown between changes.
+func (h *Handler) renameProvisionedUser(c echo.Context, id bson.ObjectID, username string) error {
+	ctx := c.Request().Context()
+	user, err := h.DB.GetUser(ctx, id)
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "user not found")
+	}
+	if user.Username == username {
+		return nil
+	}
+	if err := h.checkUsername(ctx, username, user.Id); err != nil {
+		return scimCheckError(c, err)
+	}
+	if err := h.DB.ChangeUsername(ctx, user.Id, user.Username, username, h.Config.UsernameHoldPeriod); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return scimError(c, http.StatusConflict, "username taken")
+		}
+		return scimError(c, http.StatusInternalServerError, "user not updated")
+	}
+	return nil
+}
+
+// scimCheckError answers a refusal of checkUsername in SCIM form.
+func scimCheckError(c echo.Context, err error) error {
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) {
+		return scimError(c, http.StatusInternalServerError, "username not checked")
+	}
+	return scimError(c, httpErr.Code, fmt.Sprint(httpErr.Message))
+}
+
+func (h *Handler) DeleteProvisionedUser(c echo.Context) error {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "user not found")
+	}
+
+	if err := h.setProvisionedActive(c, id, false); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
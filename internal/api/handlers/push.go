@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) RegisterPushToken(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var token models.PushToken
+	if err := c.Bind(&token); err != nil || token.Token == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing token"}
+	}
+	if token.Platform != models.PlatformIOS && token.Platform != models.PlatformAndroid {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid platform"}
+	}
+
+	token.Id = bson.NewObjectID()
+	token.UserId = user.Id
+	token.UpdatedAt = time.Now()
+	if err := h.DB.SavePushToken(c.Request().Context(), &token); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "token not saved"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) DeletePushToken(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	if err := h.DB.DeletePushToken(c.Request().Context(), user.Id, c.Param("token")); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "token not deleted"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"filachat/internal/models"
+	"filachat/internal/moderation"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (h *Handler) ListReservedNames(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	names, err := h.DB.GetReservedNames(c.Request().Context())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "reserved names not loaded"}
+	}
+	if names == nil {
+		names = []models.ReservedName{}
+	}
+	return c.JSON(http.StatusOK, names)
+}
+
+// CreateReservedName reserves a name and its lookalikes. Users already
+// going by it keep it.
+func (h *Handler) CreateReservedName(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	reserved := models.ReservedName{
+		Id:        bson.NewObjectID(),
+		Name:      strings.TrimSpace(body.Name),
+		Skeleton:  moderation.Skeleton(body.Name),
+		Reason:    strings.TrimSpace(body.Reason),
+		CreatedBy: admin.Id,
+		CreatedAt: time.Now(),
+	}
+	if reserved.Skeleton == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing name"}
+	}
+	if err := h.DB.SaveReservedName(c.Request().Context(), &reserved); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &echo.HTTPError{Code: http.StatusConflict, Message: "name already reserved"}
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "name not reserved"}
+	}
+
+	h.audit(c, admin.Id, "reserved_name.create", reserved.Id, map[string]string{"name": reserved.Name})
+	return c.JSON(http.StatusCreated, reserved)
+}
+
+func (h *Handler) DeleteReservedName(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid reserved name id"}
+	}
+	if err := h.DB.DeleteReservedName(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "reserved name not found"}
+	}
+
+	h.audit(c, admin.Id, "reserved_name.delete", id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ClaimName asks for a reserved name, e.g. by the owner of a brand. An admin
+// settles the claim with ApproveNameClaim or RejectNameClaim.
+func (h *Handler) ClaimName(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+	ctx := c.Request().Context()
+
+	var body struct {
+		Username string `json:"username"`
+		Reason   string `json:"reason"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if body.Username == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing username"}
+	}
+	if strings.Contains(body.Username, "@") {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username may not contain @"}
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing reason"}
+	}
+
+	reserved, err := h.DB.GetReservedName(ctx, moderation.Skeleton(body.Username))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username not reserved"}
+	}
+	if !reserved.GrantedTo.IsZero() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username taken"}
+	}
+
+	claim := models.NameClaim{
+		Id:         bson.NewObjectID(),
+		UserId:     auth.Id,
+		ReservedId: reserved.Id,
+		Username:   body.Username,
+		Reason:     body.Reason,
+		Status:     models.ClaimPending,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.DB.SaveNameClaim(ctx, &claim); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &echo.HTTPError{Code: http.StatusConflict, Message: "name already claimed"}
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "claim not saved"}
+	}
+	return c.JSON(http.StatusCreated, claim)
+}
+
+func (h *Handler) ListMyNameClaims(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	claims, err := h.DB.GetUserNameClaims(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "claims not loaded"}
+	}
+	if claims == nil {
+		claims = []models.NameClaim{}
+	}
+	return c.JSON(http.StatusOK, claims)
+}
+
+// ListNameClaims lists the claims waiting for an admin, oldest first.
+func (h *Handler) ListNameClaims(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	claims, err := h.DB.GetNameClaims(c.Request().Context(), models.ClaimPending)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "claims not loaded"}
+	}
+	if claims == nil {
+		claims = []models.NameClaim{}
+	}
+	return c.JSON(http.StatusOK, claims)
+}
+
+func (h *Handler) ApproveNameClaim(c echo.Context) error {
+	return h.reviewNameClaim(c, models.ClaimApproved)
+}
+
+func (h *Handler) RejectNameClaim(c echo.Context) error {
+	return h.reviewNameClaim(c, models.ClaimRejected)
+}
+
+// reviewNameClaim settles a pending claim. Approving it grants the reserved
+// name to the claimant and renames them, the cooldown on username changes
+// does not apply.
+func (h *Handler) reviewNameClaim(c echo.Context, status models.ClaimStatus) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid claim id"}
+	}
+	claim, err := h.DB.GetNameClaim(ctx, id)
+	if err != nil || claim.Status != models.ClaimPending {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "claim not pending"}
+	}
+
+	var user models.User
+	if status == models.ClaimApproved {
+		if user, err = h.DB.GetUser(ctx, claim.UserId); err != nil {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+		}
+		if owner, err := h.DB.GetUserByName(ctx, claim.Username); err == nil && owner.Id != user.Id {
+			return &echo.HTTPError{Code: http.StatusConflict, Message: "username taken"}
+		}
+	}
+	if err := h.DB.ReviewNameClaim(ctx, claim, status, admin.Id, time.Now()); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "claim not pending"}
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "claim not reviewed"}
+	}
+
+	if status == models.ClaimApproved && user.Username != claim.Username {
+		// the name is granted either way, the claimant can still take it
+		// with ChangeUsername should this fail
+		if err := h.DB.ChangeUsername(ctx, user.Id, user.Username, claim.Username, h.Config.UsernameHoldPeriod); err != nil {
+			log.Println("[WARN] claimed username not set", claim.Id.Hex(), err)
+		}
+	}
+	h.audit(c, admin.Id, "name_claim."+string(status), claim.UserId, map[string]string{"claim": claim.Id.Hex(), "username": claim.Username})
+	return c.NoContent(http.StatusNoContent)
+}
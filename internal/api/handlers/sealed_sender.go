@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"filachat/internal/spam"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+// In sealed sender mode the server routes a message by its recipient
+// alone. The sender encrypts a certificate from GetSenderCertificate into
+// the envelope, the recipient checks it with the command key from
+// GET /capabilities. Neither the stored message nor the topic it is
+// published on names the sender.
+
+const senderCertificateTTL = 24 * time.Hour
+
+// GetSenderCertificate signs the public key of the caller for their sealed
+// messages. Clients fetch a new one before it expires.
+func (h *Handler) GetSenderCertificate(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	if len(user.PublicKey) == 0 {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "public key not set"}
+	}
+	expires := time.Now().Add(senderCertificateTTL)
+	certificate, err := h.Tokens.SignSenderCertificate(user.Id, user.PublicKey, expires)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "error signing token"}
+	}
+	return c.JSON(http.StatusOK, struct {
+		Certificate string    `json:"certificate"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{certificate, expires})
+}
+
+// SendSealedMessage delivers a sealed sender message to a user of this
+// server. The sender is only checked against the message rate, new
+// recipients and fanout are not tracked for it.
+func (h *Handler) SendSealedMessage(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+	ctx := c.Request().Context()
+
+	var body struct {
+		RecipientId bson.ObjectID `json:"recipient_id"`
+		Content     string        `json:"content"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	if body.RecipientId.IsZero() {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid recipient"}
+	}
+	if body.Content == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing content"}
+	}
+	recipient, err := h.DB.GetUser(ctx, body.RecipientId)
+	if err != nil || recipient.Host != "" {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "recipient not found"}
+	}
+	if state := recipient.State(time.Now()); state == models.AccountBanned || state == models.AccountDeactivated {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "recipient unavailable"}
+	}
+
+	message := models.Message{
+		Id:          bson.NewObjectID(),
+		RecipientId: recipient.Id,
+		Type:        models.TypeSealed,
+		Content:     body.Content,
+		Timestamp:   time.Now(),
+		ReceivedAt:  time.Now(),
+		Via:         models.ViaREST,
+	}
+	pad(&message)
+	sealed := models.SealedMessage{
+		Id:          message.Id,
+		Type:        message.Type,
+		RecipientId: message.RecipientId,
+		Content:     message.Content,
+		Padding:     message.Padding,
+		Timestamp:   message.Timestamp,
+	}
+
+	digest := sha256.Sum256([]byte(message.Content))
+	action, score := h.Spam.Observe(user.Id.Hex(), string(models.TypeSealed), hex.EncodeToString(digest[:]), false, spam.Override(user.SpamOverride))
+	metrics.SpamScore.Observe(score)
+	switch action {
+	case spam.Throttle:
+		metrics.SpamActions.WithLabelValues(string(action)).Inc()
+		return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: "too many messages"}
+	case spam.Shadow:
+		metrics.SpamActions.WithLabelValues(string(action)).Inc()
+		// answer exactly like a delivered message
+		return c.JSON(http.StatusCreated, sealed)
+	}
+
+	if err := h.DB.SaveMessage(ctx, &message); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "message not sent"}
+	}
+	metrics.ObserveMessageStage("persisted", message.Via, message.ReceivedAt)
+
+	payload, _ := json.Marshal(sealed)
+	if err := h.publishMessage(ctx, models.SealedTopic(message.RecipientId), &message, payload, broker.QoS(broker.ClassMessages)); err != nil {
+		log.Println("[WARN] sealed message not published", message.Id.Hex(), err)
+	}
+	metrics.ObserveMessageStage("published", message.Via, message.ReceivedAt)
+	h.publishBadges(ctx, message.RecipientId)
+	return c.JSON(http.StatusCreated, sealed)
+}
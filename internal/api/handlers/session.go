@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+func sessionPolicy() models.SessionPolicy {
+	cfg := config.Current()
+	return models.SessionPolicy{
+		TTL:         cfg.SessionTTL,
+		Sliding:     cfg.SessionSliding,
+		MaxAge:      cfg.SessionMaxAge,
+		IdleTimeout: cfg.SessionIdleTimeout,
+	}
+}
+
+// startSession records a sign-in. Its refresh token is valid until the
+// latest the session could last, refreshes check the record for the rest.
+func (h *Handler) startSession(c echo.Context, user models.User) (models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		Id:         bson.NewObjectID(),
+		UserId:     user.Id,
+		IP:         c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(sessionPolicy().TTL),
+	}
+	return session, h.DB.SaveSession(c.Request().Context(), &session)
+}
+
+// continueSession checks that the session of a refresh token has neither
+// expired nor sat idle too long, and records the refresh. Tokens from
+// before sessions were tracked run out on their own.
+func (h *Handler) continueSession(ctx context.Context, claims *core.Claims) error {
+	if claims.Session.IsZero() {
+		return nil
+	}
+	session, err := h.DB.GetSession(ctx, claims.Session, claims.Subject)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "session expired"}
+	}
+	now, policy := time.Now(), sessionPolicy()
+	if !session.Active(now, policy) {
+		if err := h.DB.DeleteSession(ctx, session.Id, session.UserId); err != nil {
+			log.Println("[WARN] session not deleted", session.Id.Hex(), err)
+		}
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "session expired"}
+	}
+	session.Touch(now, policy)
+	if err := h.DB.TouchSession(ctx, &session); err != nil {
+		log.Println("[WARN] session not updated", session.Id.Hex(), err)
+	}
+	return nil
+}
+
+// commandTTL is how long a signed logout or wipe stays good, long enough
+// for a lost device to come back online.
+const commandTTL = 30 * 24 * time.Hour
+
+// ListSessions shows the user where they are signed in, for ending the
+// session of a lost device.
+func (h *Handler) ListSessions(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	sessions, err := h.DB.GetSessions(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "sessions not loaded"}
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// EndSession ends one session of the user and orders its device to log out
+// or, with wipe, to delete what it keeps as well.
+func (h *Handler) EndSession(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid session id"}
+	}
+	return h.forceLogout(c, user.Id, user.Id, id)
+}
+
+// EndUserSession is EndSession for an admin, e.g. for a user who lost
+// their device and access to the account with it.
+func (h *Handler) EndUserSession(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	userId, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	id, err := bson.ObjectIDFromHex(c.Param("sessionId"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid session id"}
+	}
+	return h.forceLogout(c, admin.Id, userId, id)
+}
+
+// forceLogout deletes a session of user and publishes a signed logout or
+// wipe, retained, on the control topic of the session. The refresh token
+// of the session stops working at once, its access token runs out soon.
+func (h *Handler) forceLogout(c echo.Context, actor bson.ObjectID, user bson.ObjectID, id bson.ObjectID) error {
+	var body struct {
+		Wipe bool `json:"wipe"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	ctx := c.Request().Context()
+
+	session, err := h.DB.GetSession(ctx, id, user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "session not found"}
+	}
+	event := models.ControlEvent{Type: models.ControlLogout, Reason: "forced", SessionId: session.Id, Timestamp: time.Now()}
+	command := core.CommandLogout
+	if body.Wipe {
+		event.Type, command = models.ControlWipe, core.CommandWipe
+	}
+	event.Command, err = h.Tokens.SignCommand(user, session.Id, command, event.Timestamp.Add(commandTTL))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "command not signed"}
+	}
+	if err := h.DB.DeleteSession(ctx, session.Id, user); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "session not ended"}
+	}
+
+	h.publishControlOn(ctx, models.SessionControlTopic(user, session.Id), event)
+
+	h.audit(c, actor, "session."+string(command), user, map[string]string{"session": session.Id.Hex(), "user_agent": session.UserAgent})
+	return c.NoContent(http.StatusNoContent)
+}
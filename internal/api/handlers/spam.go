@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"filachat/internal/spam"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+)
+
+// checkSpam feeds a message into the spam heuristics. Lookups that fail
+// count in the sender's favour, a flaky database must not silence users.
+func (h *Handler) checkSpam(ctx context.Context, sender bson.ObjectID, message *models.Message) spam.Action {
+	if message.ToSelf() {
+		return spam.Allow
+	}
+	var override spam.Override
+	if user, err := h.DB.GetUser(ctx, sender); err == nil {
+		override = spam.Override(user.SpamOverride)
+	}
+	// members of a group are known to each other
+	peer, known := message.RecipientId, true
+	if message.GroupId.IsZero() {
+		var err error
+		if known, err = h.DB.HasConversation(ctx, sender, peer); err != nil {
+			log.Println("[WARN] conversation lookup failed", sender.Hex(), err)
+			known = true
+		}
+	} else {
+		peer = message.GroupId
+	}
+
+	digest := sha256.Sum256([]byte(message.Content))
+	action, score := h.Spam.Observe(sender.Hex(), peer.Hex(), hex.EncodeToString(digest[:]), !known, override)
+	metrics.SpamScore.Observe(score)
+	if action != spam.Allow {
+		metrics.SpamActions.WithLabelValues(string(action)).Inc()
+	}
+	return action
+}
+
+func (h *Handler) ListSpamSuspects(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, h.Spam.Suspects())
+}
+
+// SetSpamOverride lets an admin mark a user as trusted, always limited, or
+// hand them back to the heuristics with "auto".
+func (h *Handler) SetSpamOverride(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	var body struct {
+		Override string `json:"override"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+
+	var fields bson.M
+	switch spam.Override(body.Override) {
+	case spam.OverrideTrusted, spam.OverrideLimited:
+		fields = bson.M{"spam_override": body.Override}
+	case "auto":
+		fields = bson.M{"spam_override": ""}
+	default:
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "override must be trusted, limited or auto"}
+	}
+	if err := h.DB.UpdateUser(c.Request().Context(), id, fields); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+
+	h.Spam.Reset(id.Hex())
+	h.audit(c, admin.Id, "spam.override", id, map[string]string{"override": body.Override})
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"time"
+)
+
+// canRead reports whether user is a participant of the conversation or a
+// member of the group message belongs to.
+func (h *Handler) canRead(ctx context.Context, user bson.ObjectID, message models.Message) bool {
+	if message.GroupId.IsZero() {
+		return message.SenderId == user || message.RecipientId == user
+	}
+	group, err := h.DB.GetGroup(ctx, message.GroupId)
+	return err == nil && group.IsMember(user)
+}
+
+func (h *Handler) StarMessage(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	message, err := h.DB.GetMessage(c.Request().Context(), id)
+	if err != nil || !message.DeletedAt.IsZero() || !h.canRead(c.Request().Context(), user.Id, message) {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "message not found"}
+	}
+
+	star := models.Star{Id: bson.NewObjectID(), UserId: user.Id, MessageId: message.Id, CreatedAt: time.Now()}
+	if err := h.DB.StarMessage(c.Request().Context(), &star); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "star not saved"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) UnstarMessage(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	if err := h.DB.UnstarMessage(c.Request().Context(), user.Id, id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "star not found"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetStarredMessages pages through the messages the user starred across
+// all conversations, most recently starred first. A star outlives its
+// message, it is then listed without one for the client to clean up.
+func (h *Handler) GetStarredMessages(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	stars, err := h.DB.GetStars(c.Request().Context(), user.Id, page)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "messages not loaded"}
+	}
+	open := h.messageOpener(c.Request().Context())
+	for _, star := range stars {
+		if star.Message != nil {
+			open(star.Message)
+		}
+	}
+
+	return c.JSON(http.StatusOK, pagination.NewResult(stars, page, func(s models.Star) pagination.Cursor {
+		return pagination.Cursor{Time: s.CreatedAt, ID: s.Id}
+	}))
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxStatsDays = 366
+
+// GetStats returns the daily activity of the last days, 30 unless asked
+// otherwise, today included.
+func (h *Handler) GetStats(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	days := 30
+	if raw := c.QueryParam("days"); raw != "" {
+		var err error
+		if days, err = strconv.Atoi(raw); err != nil || days < 1 || days > maxStatsDays {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "days must be between 1 and 366"}
+		}
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	stats, err := h.DB.GetDailyStats(c.Request().Context(), since)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "stats not loaded"}
+	}
+	return c.JSON(http.StatusOK, stats)
+}
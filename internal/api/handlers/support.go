@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SupportAccount is what a support session shows of an account: settings
+// and metadata, never messages or keys.
+type SupportAccount struct {
+	Id          bson.ObjectID       `json:"id"`
+	Username    string              `json:"username,omitempty"`
+	Email       string              `json:"email,omitempty"`
+	Role        models.Role         `json:"role,omitempty"`
+	Locale      string              `json:"locale,omitempty"`
+	Deactivated bool                `json:"deactivated,omitempty"`
+	Locked      bool                `json:"locked,omitempty"`
+	TwoFactor   bool                `json:"two_factor,omitempty"`
+	LastSeen    time.Time           `json:"last_seen,omitempty"`
+	Storage     models.StorageUsage `json:"storage"`
+	Devices     []SupportDevice     `json:"devices"`
+	Sessions    []models.Session    `json:"sessions"`
+}
+
+type SupportDevice struct {
+	Id        bson.ObjectID `json:"id"`
+	Name      string        `json:"name,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// StartSupportSession hands an admin a short-lived token to look at the
+// account of a user. The reason is kept with the session and audited.
+func (h *Handler) StartSupportSession(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing reason"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), id)
+	if err != nil || user.Host != "" {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+
+	now := time.Now()
+	session := models.SupportSession{
+		Id:        bson.NewObjectID(),
+		AdminId:   admin.Id,
+		UserId:    user.Id,
+		Reason:    body.Reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.Current().SupportSessionTTL),
+	}
+	rawToken, err := h.Tokens.NewSupportToken(user.Id, session.Id, session.ExpiresAt)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "error signing token"}
+	}
+	session.Token, err = h.Tokens.Seal(rawToken, core.AccessToken)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "error creating token"}
+	}
+	if err := h.DB.SaveSupportSession(c.Request().Context(), &session); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "support session not started"}
+	}
+
+	h.audit(c, admin.Id, "support.start", user.Id, map[string]string{"session": session.Id.Hex(), "reason": session.Reason})
+	return c.JSON(http.StatusCreated, session)
+}
+
+func (h *Handler) ListSupportSessions(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	sessions, err := h.DB.GetActiveSupportSessions(c.Request().Context(), time.Now())
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "support sessions not loaded"}
+	}
+	if sessions == nil {
+		sessions = []models.SupportSession{}
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSupportSession ends a support session before it runs out. Any
+// admin may revoke any session.
+func (h *Handler) RevokeSupportSession(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid support session id"}
+	}
+	session, err := h.DB.GetSupportSession(c.Request().Context(), id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "support session not found"}
+	}
+	if err := h.DB.RevokeSupportSession(c.Request().Context(), id, admin.Id, time.Now()); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "support session not found"}
+	}
+
+	h.audit(c, admin.Id, "support.revoke", session.UserId, map[string]string{"session": session.Id.Hex()})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetSupportAccount shows the account a support token was issued for. Each
+// view is audited in the name of the admin who started the session.
+func (h *Handler) GetSupportAccount(c echo.Context) error {
+	claims := c.Get("claims").(*core.Claims)
+	ctx := c.Request().Context()
+
+	session, err := h.DB.GetSupportSession(ctx, claims.Session)
+	if err != nil || session.UserId != claims.Subject || !session.Active(time.Now()) {
+		return &echo.HTTPError{Code: http.StatusForbidden, Message: "support session expired"}
+	}
+
+	user, err := h.DB.GetUser(ctx, session.UserId)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	devices, err := h.DB.GetDevices(ctx, user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "devices not loaded"}
+	}
+	sessions, err := h.DB.GetSessions(ctx, user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "sessions not loaded"}
+	}
+
+	account := SupportAccount{
+		Id:          user.Id,
+		Username:    user.Username,
+		Email:       user.Email,
+		Role:        user.Role,
+		Locale:      user.Locale,
+		Deactivated: user.Deactivated,
+		Locked:      user.Locked,
+		TwoFactor:   user.TwoFactor,
+		LastSeen:    user.LastSeen,
+		Storage:     models.StorageUsage{Used: user.StorageUsed, Quota: h.Config.StorageQuota},
+		Devices:     make([]SupportDevice, 0, len(devices)),
+		Sessions:    sessions,
+	}
+	for _, device := range devices {
+		account.Devices = append(account.Devices, SupportDevice{Id: device.Id, Name: device.Name, CreatedAt: device.CreatedAt, UpdatedAt: device.UpdatedAt})
+	}
+	if account.Sessions == nil {
+		account.Sessions = []models.Session{}
+	}
+
+	h.audit(c, session.AdminId, "support.view", user.Id, map[string]string{"session": session.Id.Hex()})
+	return c.JSON(http.StatusOK, account)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"filachat/internal/media"
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Previews let clients render attachment lists before fetching the files.
+// Plain images get a thumbnail and blurhash generated here. For end-to-end
+// encrypted media the server cannot look inside, so the client sends the
+// blurhash and dimensions with the upload and the encrypted thumbnail after.
+
+const maxThumbnailSize = 64 << 10
+
+func bindPreviewParams(c echo.Context, attachment *models.Attachment) error {
+	attachment.Encrypted = c.QueryParam("encrypted") == "true"
+	if !attachment.Encrypted {
+		return nil
+	}
+
+	if hash := c.QueryParam("blurhash"); hash != "" {
+		if !media.ValidBlurhash(hash) {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid blurhash"}
+		}
+		attachment.Blurhash = hash
+	}
+	for param, dimension := range map[string]*int{"width": &attachment.Width, "height": &attachment.Height} {
+		raw := c.QueryParam(param)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 || value > 100_000 {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid " + param}
+		}
+		*dimension = value
+	}
+	return nil
+}
+
+// generatePreview is best effort, an image that fails to decode is still
+// stored, just without a preview.
+func (h *Handler) generatePreview(attachment *models.Attachment) {
+	if !media.IsImage(attachment.ContentType) {
+		return
+	}
+
+	blob, err := h.Storage.Open(attachment.StorageKey)
+	if err != nil {
+		return
+	}
+	defer blob.Close()
+
+	source, ok := blob.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(blob)
+		if err != nil {
+			return
+		}
+		source = bytes.NewReader(data)
+	}
+	preview, err := media.NewPreview(source)
+	if err != nil {
+		log.Println("[INFO] no preview for attachment", attachment.Id.Hex(), err)
+		return
+	}
+
+	thumbnail := models.Thumbnail{ContentType: "image/jpeg", StorageKey: attachment.StorageKey + "-thumb"}
+	if thumbnail.Size, err = h.Storage.Put(thumbnail.StorageKey, bytes.NewReader(preview.Thumbnail)); err != nil {
+		log.Println("[WARN] thumbnail not stored", attachment.Id.Hex(), err)
+		return
+	}
+	attachment.Width = preview.Width
+	attachment.Height = preview.Height
+	attachment.Blurhash = preview.Blurhash
+	attachment.Thumbnail = &thumbnail
+}
+
+func (h *Handler) UploadThumbnail(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	attachment, err := h.loadAttachment(c, user.Id)
+	if err != nil {
+		return err
+	}
+	if attachment.OwnerId != user.Id {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "attachment not found"}
+	}
+	if !attachment.Encrypted {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "thumbnails are generated for unencrypted attachments"}
+	}
+	if attachment.Thumbnail != nil {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "thumbnail already uploaded"}
+	}
+
+	thumbnail := models.Thumbnail{ContentType: c.Request().Header.Get(echo.HeaderContentType), StorageKey: attachment.StorageKey + "-thumb"}
+	if thumbnail.ContentType == "" {
+		thumbnail.ContentType = echo.MIMEOctetStream
+	}
+	thumbnail.Size, err = h.Storage.Put(thumbnail.StorageKey, io.LimitReader(c.Request().Body, maxThumbnailSize+1))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "thumbnail not stored"}
+	}
+	if thumbnail.Size > maxThumbnailSize || thumbnail.Size == 0 {
+		h.discardBlob(thumbnail.StorageKey)
+		return &echo.HTTPError{Code: http.StatusRequestEntityTooLarge, Message: "thumbnail must be between 1 byte and 64KiB"}
+	}
+
+	if err := h.DB.SetThumbnail(c.Request().Context(), attachment.Id, user.Id, thumbnail); err != nil {
+		h.discardBlob(thumbnail.StorageKey)
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "thumbnail already uploaded"}
+	}
+	attachment.Thumbnail = &thumbnail
+	return c.JSON(http.StatusOK, attachment)
+}
+
+func (h *Handler) DownloadThumbnail(c echo.Context) error {
+	return h.redirectToMedia(c, variantThumbnail)
+}
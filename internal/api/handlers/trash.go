@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) DeleteMessage(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	if err := h.DB.SoftDeleteMessage(c.Request().Context(), id, user.Id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "message not found"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) DeleteAccount(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&body); err != nil || body.Password == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing password"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if h.Hashing.Verify([]byte(body.Password), user.Password) != nil {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid password"}
+	}
+
+	if err := h.DB.SoftDeleteUser(c.Request().Context(), user.Id); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "account not deleted"}
+	}
+	h.Restricted.Update(user.Id, models.AccountStatus{State: models.AccountDeactivated})
+	h.endAccess(c.Request().Context(), user.Id, "account_deleted")
+	h.audit(c, user.Id, "account.delete", user.Id, nil)
+	c.SetCookie(refreshCookie("", -1))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) ListTrash(c echo.Context) error {
+	if _, err := h.requireAdmin(c); err != nil {
+		return err
+	}
+
+	page, err := pagination.Parse(c.QueryParam("cursor"), c.QueryParam("limit"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	switch c.QueryParam("type") {
+	case "users":
+		users, err := h.DB.GetDeletedUsers(c.Request().Context(), page)
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "trash not loaded"}
+		}
+		for i := range users {
+			users[i].Password = ""
+		}
+		return c.JSON(http.StatusOK, pagination.NewResult(users, page, func(u models.User) pagination.Cursor {
+			return pagination.Cursor{Time: u.DeletedAt, ID: u.Id}
+		}))
+	case "messages":
+		messages, err := h.DB.GetDeletedMessages(c.Request().Context(), page)
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "trash not loaded"}
+		}
+		return c.JSON(http.StatusOK, pagination.NewResult(messages, page, func(m models.Message) pagination.Cursor {
+			return pagination.Cursor{Time: m.DeletedAt, ID: m.Id}
+		}))
+	}
+	return &echo.HTTPError{Code: http.StatusBadRequest, Message: "type must be users or messages"}
+}
+
+func (h *Handler) RestoreUser(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid user id"}
+	}
+	if err := h.DB.RestoreUser(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not in trash"}
+	}
+	// back to the state the account had before it was deleted
+	if user, err := h.DB.GetUser(c.Request().Context(), id); err == nil {
+		status := models.AccountStatus{State: user.State(time.Now())}
+		if user.Account != nil && !user.Deactivated {
+			status = *user.Account
+		}
+		h.Restricted.Update(id, status)
+	}
+	h.audit(c, admin.Id, "user.restore", id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RestoreMessage(c echo.Context) error {
+	admin, err := h.requireAdmin(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid message id"}
+	}
+	if err := h.DB.RestoreMessage(c.Request().Context(), id); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "message not in trash"}
+	}
+	h.audit(c, admin.Id, "message.restore", id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Two factor sign-in uses TOTP from an authenticator app. Enabling it hands
+// out one-time recovery codes for when the device is lost; like invites
+// only their hashes are stored and the codes are shown once.
+
+const recoveryCodeCount = 10
+
+// newRecoveryCode returns a code in the invite code alphabet, e.g.
+// K7Q2X-9XMDA.
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 7)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := inviteEncoding.EncodeToString(raw)
+	return code[0:5] + "-" + code[5:10], nil
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i], hashes[i] = code, codeHash(code)
+	}
+	return codes, hashes, nil
+}
+
+// checkTOTP accepts a code of the user's authenticator once, counted
+// against the failures like any second factor.
+func (h *Handler) checkTOTP(c echo.Context, user models.User, code string) error {
+	return h.checkSecondFactor(c, user, code, "")
+}
+
+// verifyTOTP accepts a code of the user's authenticator once.
+func (h *Handler) verifyTOTP(ctx context.Context, user models.User, code string) error {
+	step, ok := core.VerifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid two factor code"}
+	}
+	fresh, err := h.DB.UseTOTPStep(ctx, user.Id, step)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "two factor code not checked"}
+	}
+	if !fresh {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid two factor code"}
+	}
+	return nil
+}
+
+// checkSecondFactor verifies a TOTP code or, with the device lost, uses up
+// a recovery code. Every recovery code used and every wrong code is
+// audited. TwoFactorMaxFailures wrong codes in a row turn away any code for
+// TwoFactorLockout, the password alone, or a stolen access token, is not
+// enough to guess one.
+func (h *Handler) checkSecondFactor(c echo.Context, user models.User, totp string, recovery string) error {
+	if time.Now().Before(user.TwoFactorLockedUntil) {
+		return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: "too many two factor attempts"}
+	}
+	err := h.verifySecondFactor(c, user, totp, recovery)
+	var httpErr *echo.HTTPError
+	switch {
+	case err == nil:
+		if user.TwoFactorFailures > 0 {
+			if err := h.DB.ResetTwoFactorFailures(c.Request().Context(), user.Id); err != nil {
+				log.Println("[WARN] two factor failures not reset", user.Id.Hex(), err)
+			}
+		}
+	case (totp != "" || recovery != "") && errors.As(err, &httpErr) && httpErr.Code == http.StatusUnauthorized:
+		cfg := config.Current()
+		locked, failErr := h.DB.FailTwoFactor(c.Request().Context(), user.Id, cfg.TwoFactorMaxFailures, cfg.TwoFactorLockout)
+		if failErr != nil {
+			log.Println("[WARN] two factor failure not counted", user.Id.Hex(), failErr)
+		}
+		h.audit(c, user.Id, "user.two_factor.fail", user.Id, map[string]string{"locked": strconv.FormatBool(locked)})
+	}
+	return err
+}
+
+func (h *Handler) verifySecondFactor(c echo.Context, user models.User, totp string, recovery string) error {
+	switch {
+	case totp != "":
+		return h.verifyTOTP(c.Request().Context(), user, totp)
+	case recovery != "":
+		left, err := h.DB.UseRecoveryCode(c.Request().Context(), user.Id, codeHash(recovery))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid recovery code"}
+		}
+		if err != nil {
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "recovery code not checked"}
+		}
+		h.audit(c, user.Id, "user.recovery_code.use", user.Id, map[string]string{"remaining": strconv.Itoa(left)})
+		return nil
+	}
+	return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "two factor code required"}
+}
+
+// BeginTOTP creates the secret to scan into an authenticator app. Two
+// factor is only switched on by ConfirmTOTP.
+func (h *Handler) BeginTOTP(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if user.TwoFactor {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "two factor already enabled"}
+	}
+	secret, err := core.NewTOTPSecret()
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "secret not generated"}
+	}
+	if err := h.DB.SetTOTPSecret(c.Request().Context(), user.Id, secret); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "secret not saved"}
+	}
+	return c.JSON(http.StatusCreated, map[string]string{
+		"secret": secret,
+		"uri":    core.TOTPURI(h.Config.WebAuthnRPDisplayName, user.Username, secret),
+	})
+}
+
+// ConfirmTOTP switches two factor on once the app produced a valid code
+// and returns the recovery codes.
+func (h *Handler) ConfirmTOTP(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&body); err != nil || body.Code == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing code"}
+	}
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if user.TwoFactor {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "two factor already enabled"}
+	}
+	if user.TOTPSecret == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "two factor setup not started"}
+	}
+	if err := h.checkTOTP(c, user, body.Code); err != nil {
+		return err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "recovery codes not generated"}
+	}
+	if err := h.DB.EnableTwoFactor(c.Request().Context(), user.Id, hashes); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "two factor not enabled"}
+	}
+	h.audit(c, user.Id, "user.two_factor.enable", user.Id, nil)
+	return c.JSON(http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not. It
+// takes a code from the app, a recovery code cannot mint new ones.
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&body); err != nil || body.Code == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing code"}
+	}
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if !user.TwoFactor {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "two factor not enabled"}
+	}
+	if err := h.checkTOTP(c, user, body.Code); err != nil {
+		return err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "recovery codes not generated"}
+	}
+	if err := h.DB.SetRecoveryCodes(c.Request().Context(), user.Id, hashes); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "recovery codes not saved"}
+	}
+	h.audit(c, user.Id, "user.recovery_codes.regenerate", user.Id, nil)
+	return c.JSON(http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTwoFactor takes either kind of code, so a user without their
+// device can still turn it off.
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid json body"}
+	}
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if !user.TwoFactor {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "two factor not enabled"}
+	}
+	if err := h.checkSecondFactor(c, user, body.Code, body.RecoveryCode); err != nil {
+		return err
+	}
+
+	if err := h.DB.DisableTwoFactor(c.Request().Context(), user.Id); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "two factor not disabled"}
+	}
+	h.audit(c, user.Id, "user.two_factor.disable", user.Id, nil)
+	return c.NoContent(http.StatusNoContent)
+}
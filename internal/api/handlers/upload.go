@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"filachat/internal/media"
+	"filachat/internal/models"
+	"filachat/internal/storage"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Resumable uploads follow the tus protocol loosely: the client announces
+// the length, sends chunks with PATCH at the offset the server reports and
+// finalizes once everything arrived. After a dropped connection HEAD tells
+// the client where to pick up.
+
+const (
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+	mimeOffsetOctets   = "application/offset+octet-stream"
+	chunkLease         = 10 * time.Minute
+)
+
+func (h *Handler) CreateUpload(c echo.Context) error {
+	user := c.Get("user").(*models.User)
+
+	var body struct {
+		Length      int64  `json:"length"`
+		ContentType string `json:"content_type"`
+		RecipientId string `json:"recipient_id"`
+		Encrypted   bool   `json:"encrypted"`
+		Blurhash    string `json:"blurhash"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+	}
+	if err := c.Bind(&body); err != nil || body.Length <= 0 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing upload length"}
+	}
+	if body.Length > h.Config.MaxAttachmentSize {
+		return errAttachmentTooLarge
+	}
+
+	owner, err := h.DB.GetUser(c.Request().Context(), user.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if owner.StorageUsed+body.Length > h.Config.StorageQuota {
+		return errQuotaExceeded
+	}
+
+	now := time.Now()
+	upload := models.Upload{
+		Id:          bson.NewObjectID(),
+		OwnerId:     user.Id,
+		ContentType: body.ContentType,
+		Length:      body.Length,
+		Encrypted:   body.Encrypted,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if upload.ContentType == "" {
+		upload.ContentType = echo.MIMEOctetStream
+	}
+	if body.RecipientId != "" {
+		if upload.RecipientId, err = bson.ObjectIDFromHex(body.RecipientId); err != nil {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid recipient id"}
+		}
+	}
+	if upload.Encrypted {
+		if body.Blurhash != "" && !media.ValidBlurhash(body.Blurhash) {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid blurhash"}
+		}
+		if body.Width < 0 || body.Width > 100_000 || body.Height < 0 || body.Height > 100_000 {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid dimensions"}
+		}
+		upload.Blurhash, upload.Width, upload.Height = body.Blurhash, body.Width, body.Height
+	}
+
+	if err := h.DB.SaveUpload(c.Request().Context(), &upload); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "upload not created"}
+	}
+	c.Response().Header().Set(echo.HeaderLocation, "/uploads/"+upload.Id.Hex())
+	c.Response().Header().Set(headerUploadOffset, "0")
+	return c.JSON(http.StatusCreated, upload)
+}
+
+func (h *Handler) loadUpload(c echo.Context) (models.Upload, error) {
+	user := c.Get("user").(*models.User)
+
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return models.Upload{}, &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid upload id"}
+	}
+	upload, err := h.DB.GetUpload(c.Request().Context(), id, user.Id)
+	if err != nil {
+		return models.Upload{}, &echo.HTTPError{Code: http.StatusNotFound, Message: "upload not found"}
+	}
+	return upload, nil
+}
+
+func (h *Handler) UploadStatus(c echo.Context) error {
+	upload, err := h.loadUpload(c)
+	if err != nil {
+		return err
+	}
+
+	header := c.Response().Header()
+	header.Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	header.Set(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	header.Set(echo.HeaderCacheControl, "no-store")
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) UploadChunk(c echo.Context) error {
+	upload, err := h.loadUpload(c)
+	if err != nil {
+		return err
+	}
+	if c.Request().Header.Get(echo.HeaderContentType) != mimeOffsetOctets {
+		return &echo.HTTPError{Code: http.StatusUnsupportedMediaType, Message: "chunks must be sent as " + mimeOffsetOctets}
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid upload offset"}
+	}
+
+	upload, err = h.DB.ClaimUpload(c.Request().Context(), upload.Id, upload.OwnerId, offset, chunkLease)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// either the offset is stale or another chunk is being written
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "upload offset mismatch"}
+	}
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "chunk not stored"}
+	}
+
+	written, err := h.Storage.WriteAt(storage.PartialKey(upload.Id.Hex()), offset, io.LimitReader(c.Request().Body, upload.Length-offset))
+	// bytes that made it to disk count even when the connection dropped, the
+	// client resumes right after them
+	if advanceErr := h.DB.AdvanceUpload(c.Request().Context(), upload.Id, offset+written); advanceErr != nil && err == nil {
+		err = advanceErr
+	}
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "chunk not stored"}
+	}
+
+	c.Response().Header().Set(headerUploadOffset, strconv.FormatInt(offset+written, 10))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) FinalizeUpload(c echo.Context) error {
+	upload, err := h.loadUpload(c)
+	if err != nil {
+		return err
+	}
+	if upload.Offset != upload.Length {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "upload incomplete"}
+	}
+	// holding the lease keeps a concurrent finalize or abort out
+	if _, err := h.DB.ClaimUpload(c.Request().Context(), upload.Id, upload.OwnerId, upload.Length, chunkLease); err != nil {
+		return &echo.HTTPError{Code: http.StatusConflict, Message: "upload busy"}
+	}
+
+	attachment := models.Attachment{
+		Id:          upload.Id,
+		OwnerId:     upload.OwnerId,
+		RecipientId: upload.RecipientId,
+		ContentType: upload.ContentType,
+		Size:        upload.Length,
+		StorageKey:  upload.Id.Hex(),
+		Encrypted:   upload.Encrypted,
+		Width:       upload.Width,
+		Height:      upload.Height,
+		Blurhash:    upload.Blurhash,
+		CreatedAt:   time.Now(),
+	}
+	if err := h.Storage.Move(storage.PartialKey(attachment.StorageKey), attachment.StorageKey); err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "attachment not stored"}
+	}
+	// the upload is used up either way, a failed pipeline already removed
+	// the blob
+	_ = h.DB.DeleteUpload(c.Request().Context(), upload.Id, upload.OwnerId)
+
+	if err := h.storeAttachment(c, &attachment); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+func (h *Handler) AbortUpload(c echo.Context) error {
+	upload, err := h.loadUpload(c)
+	if err != nil {
+		return err
+	}
+	if err := h.DB.DeleteUpload(c.Request().Context(), upload.Id, upload.OwnerId); err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "upload not found"}
+	}
+	h.discardBlob(storage.PartialKey(upload.Id.Hex()))
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,94 +1,194 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/hex"
+	imiddleware "filachat/internal/api/middleware"
 	"filachat/internal/core"
+	"filachat/internal/i18n"
 	"filachat/internal/models"
-	"github.com/joho/godotenv"
+	"filachat/internal/moderation"
+	"filachat/pkg/config"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
 func (h *Handler) SignUp(c echo.Context) error {
 	user := c.Get("user").(models.User)
 
-	if userExists, err := h.DB.Exists(user.Username, user.Email); err != nil || userExists {
+	if err := h.checkUsername(c.Request().Context(), user.Username, bson.NilObjectID); err != nil {
+		return err
+	}
+	// a deleted account keeps its email until it is purged
+	if userExists, err := h.DB.Exists(c.Request().Context(), user.Username, user.Email); err != nil || userExists {
 		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "user already exists"}
 	}
 
-	hash, err := core.Hashing.Hash([]byte(user.Password))
+	hash, err := h.Hashing.Hash([]byte(user.Password))
 	if err != nil {
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "hashing failed"}
 	}
 
-	if err := h.DB.NewUser(user.Id, user.Username, user.Email, hash); err != nil {
+	var invite models.Invite
+	if config.Current().InviteOnly {
+		if invite, err = h.DB.TakeInvite(c.Request().Context(), codeHash(user.InviteCode), user.Id); err != nil {
+			return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid invite code"}
+		}
+	}
+
+	if err := h.DB.NewUser(c.Request().Context(), user.Id, user.Username, user.Email, hash); err != nil {
+		if !invite.Id.IsZero() {
+			if err := h.DB.ReleaseInvite(c.Request().Context(), invite.Id); err != nil {
+				log.Println("[WARN] invite not released", invite.Id.Hex(), err)
+			}
+		}
 		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "user not created"}
 	}
+	if !i18n.Supported(user.Locale) {
+		user.Locale = i18n.Negotiate(c.Request().Header.Get("Accept-Language"))
+	}
+	fields := bson.M{"locale": user.Locale}
+	if !invite.Id.IsZero() {
+		fields["invited_by"] = invite.CreatedBy
+	}
+	if err := h.DB.UpdateUser(c.Request().Context(), user.Id, fields); err != nil {
+		log.Println("[WARN] signup details not saved", user.Id.Hex(), err)
+	}
 	user.Password = ""
+	user.InviteCode = ""
 	return c.JSON(http.StatusCreated, user)
 }
 func (h *Handler) SignIn(c echo.Context) error {
 	user := c.Get("user").(models.User)
 
-	if userExists, err := h.DB.Exists(user.Username, user.Email); err != nil || !userExists {
+	if userExists, err := h.DB.Exists(c.Request().Context(), user.Username, user.Email); err != nil || !userExists {
 		return &echo.HTTPError{Code: http.StatusNotFound, Message: "invalid user or password"}
 	}
 
-	dbUser, err := h.DB.GetUserByName(user.Username)
+	dbUser, err := h.DB.GetUserByName(c.Request().Context(), user.Username)
 	if err != nil {
 		return &echo.HTTPError{Code: http.StatusNotFound, Message: "invalid user or password"}
 	}
 
-	if core.Hashing.Verify([]byte(user.Password), dbUser.Password) != nil {
+	if h.Hashing.Verify([]byte(user.Password), dbUser.Password) != nil {
 		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid user or password"}
 	}
-	user.Password = ""
-	user.Email = ""
+	h.rehashPassword(c, dbUser, user.Password)
+	if err := accountError(dbUser, time.Now()); err != nil {
+		return err
+	}
+	if dbUser.TwoFactor {
+		if err := h.checkSecondFactor(c, dbUser, user.TOTPCode, user.RecoveryCode); err != nil {
+			return err
+		}
+	}
+	return h.issueTokens(c, models.User{Id: dbUser.Id, Username: dbUser.Username})
+}
 
-	rawAccessToken, err := core.JWTFactory.NewToken(user.Id, "https://auth.filagram.pl/signin", true)
-	rawRefreshToken, err := core.JWTFactory.NewToken(user.Id, "https://auth.filagram.pl/signin", false)
+// rehashPassword replaces a legacy hash, e.g. bcrypt of a user imported
+// from another system, with one of the primary algorithm now that the
+// password is known. Failing only means trying again on the next sign-in.
+func (h *Handler) rehashPassword(c echo.Context, user models.User, password string) {
+	if !h.Hashing.NeedsRehash(user.Password) {
+		return
+	}
+	hash, err := h.Hashing.Hash([]byte(password))
+	if err == nil {
+		err = h.DB.UpdateUser(c.Request().Context(), user.Id, bson.M{"password": hash})
+	}
+	if err != nil {
+		log.Println("[WARN] legacy password hash not replaced", user.Id.Hex(), err)
+	}
+}
+
+// issueTokens signs and encrypts a fresh access/refresh pair for an already
+// authenticated user and writes it to the response.
+func (h *Handler) issueTokens(c echo.Context, user models.User) error {
+	h.recordLogin(c, user.Id)
+
+	rawAccessToken, err := h.Tokens.NewToken(user.Id, core.IssuedBySignIn, core.AccessToken)
 	if err != nil {
 		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error signing token"}
 	}
-	godotenv.Load()
+	session, err := h.startSession(c, user)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "session not started"}
+	}
+	rawRefreshToken, err := h.Tokens.NewSessionToken(user.Id, session.Id, sessionPolicy().Limit(session.CreatedAt))
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error signing token"}
+	}
+	user.SessionId = session.Id
 
-	encAccessKey, err := hex.DecodeString(os.Getenv("JWT_ACCESS_SECRET"))
-	encRefreshKey, err := hex.DecodeString(os.Getenv("JWT_REFRESH_SECRET"))
+	user.AccessToken, err = h.Tokens.Seal(rawAccessToken, core.AccessToken)
 	if err != nil {
 		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error creating token"}
 	}
-	encAccessToken, err := core.JWTEncrypter.Encrypt([]byte(rawAccessToken), encAccessKey)
-	encRefreshToken, err := core.JWTEncrypter.Encrypt([]byte(rawRefreshToken), encRefreshKey)
+	user.RefreshToken, err = h.Tokens.Seal(rawRefreshToken, core.RefreshToken)
 	if err != nil {
 		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error creating token"}
 	}
 
-	user.AccessToken = base64.StdEncoding.EncodeToString(encAccessToken)
-	user.RefreshToken = base64.StdEncoding.EncodeToString(encRefreshToken)
-
+	if h.Config.SessionCookieMode {
+		c.SetCookie(refreshCookie(user.RefreshToken, int(time.Until(sessionPolicy().Limit(session.CreatedAt)).Seconds())))
+		user.RefreshToken = ""
+		if err := imiddleware.IssueCSRFToken(c); err != nil {
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "error creating token"}
+		}
+	}
 	return c.JSON(http.StatusOK, user)
 }
 
+// SignOut ends the session of the refresh token sent along, by header or
+// cookie, and clears the cookie.
+func (h *Handler) SignOut(c echo.Context) error {
+	token, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if cookie, err := c.Cookie(imiddleware.RefreshCookieName); !found && err == nil {
+		token = cookie.Value
+	}
+	if claims, err := h.Tokens.Open(token, core.RefreshToken); err == nil && !claims.Session.IsZero() {
+		if err := h.DB.DeleteSession(c.Request().Context(), claims.Session, claims.Subject); err != nil {
+			log.Println("[WARN] session not deleted", claims.Session.Hex(), err)
+		}
+	}
+	c.SetCookie(refreshCookie("", -1))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func refreshCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     imiddleware.RefreshCookieName,
+		Value:    value,
+		Path:     "/refresh-token",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
 func (h *Handler) RefreshToken(c echo.Context) error {
 	user := c.Get("user").(*models.User)
 
-	rawAccessToken, err := core.JWTFactory.NewToken(user.Id, "https://auth.filagram.pl/refresh-token", true)
-	if err != nil {
-		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error signing token"}
+	if err := h.continueSession(c.Request().Context(), c.Get("claims").(*core.Claims)); err != nil {
+		return err
 	}
 
-	encAccessKey, err := hex.DecodeString(os.Getenv("JWT_ACCESS_SECRET"))
+	rawAccessToken, err := h.Tokens.NewToken(user.Id, core.IssuedByRefresh, core.AccessToken)
 	if err != nil {
-		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error creating token"}
+		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error signing token"}
 	}
 
-	encAccessToken, err := core.JWTEncrypter.Encrypt([]byte(rawAccessToken), encAccessKey)
+	user.AccessToken, err = h.Tokens.Seal(rawAccessToken, core.AccessToken)
 	if err != nil {
 		return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "error creating token"}
 	}
-
-	user.AccessToken = base64.StdEncoding.EncodeToString(encAccessToken)
+	if h.Config.SessionCookieMode {
+		if err := imiddleware.IssueCSRFToken(c); err != nil {
+			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "error creating token"}
+		}
+	}
 	return c.JSON(http.StatusOK, user)
 }
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/internal/moderation"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// checkUsername refuses a name owner may not take: one the deployment does
+// not allow, that another user goes by, holds or has reserved. A zero
+// owner is a new user.
+func (h *Handler) checkUsername(ctx context.Context, username string, owner bson.ObjectID) error {
+	if strings.Contains(username, "@") {
+		// @ separates the server in addresses of remote users
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username may not contain @"}
+	}
+	if err := h.Names.Check(username); err != nil {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if user, err := h.DB.GetUserByName(ctx, username); err == nil && user.Id != owner {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username taken"}
+	}
+	if held, err := h.DB.UsernameHeld(ctx, username, owner); err != nil || held {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username taken"}
+	}
+	if reserved, err := h.DB.NameReserved(ctx, moderation.Skeleton(username), owner); err != nil || reserved {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username reserved"}
+	}
+	return nil
+}
+
+func (h *Handler) ChangeUsername(c echo.Context) error {
+	auth := c.Get("user").(*models.User)
+
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := c.Bind(&body); err != nil || body.Username == "" {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "missing username"}
+	}
+
+	user, err := h.DB.GetUser(c.Request().Context(), auth.Id)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	if user.Username == body.Username {
+		return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username unchanged"}
+	}
+	if next := user.UsernameChangedAt.Add(h.Config.UsernameChangeCooldown); time.Now().Before(next) {
+		return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: "username changed too recently"}
+	}
+	if err := h.checkUsername(c.Request().Context(), body.Username, user.Id); err != nil {
+		return err
+	}
+
+	if err := h.DB.ChangeUsername(c.Request().Context(), user.Id, user.Username, body.Username, h.Config.UsernameHoldPeriod); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return &echo.HTTPError{Code: http.StatusBadRequest, Message: "username taken"}
+		}
+		return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "username not changed"}
+	}
+	return c.JSON(http.StatusOK, models.User{Id: user.Id, Username: body.Username})
+}
+
+func (h *Handler) GetProfileByName(c echo.Context) error {
+	username := c.Param("username")
+	if strings.Contains(username, "@") {
+		return h.getRemoteProfile(c, username)
+	}
+
+	user, err := h.DB.GetUserByName(c.Request().Context(), username)
+	if err == nil {
+		return c.JSON(http.StatusOK, models.User{Id: user.Id, Username: user.Username})
+	}
+
+	user, err = h.DB.ResolveFormerUsername(c.Request().Context(), username)
+	if err != nil {
+		return &echo.HTTPError{Code: http.StatusNotFound, Message: "user not found"}
+	}
+	c.Response().Header().Set(echo.HeaderLocation, "/users/by-name/"+url.PathEscape(user.Username))
+	return c.JSON(http.StatusMovedPermanently, models.User{Id: user.Id, Username: user.Username})
+}
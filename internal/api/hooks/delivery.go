@@ -0,0 +1,148 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/crash"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// congestionWindow is how long a user counts as congested after a publish
+// to one of their clients was dropped.
+const congestionWindow = 10 * time.Second
+
+// DeliveryHook handles clients that read slower than they are written to.
+// Once the outbound queue of a client is full the broker drops publishes
+// to it; this hook counts those drops, tells senders about chat messages
+// that were dropped and, while a user is congested, skips typing and
+// status events to them so the room left goes to messages. Retained state
+// such as presence and badges is never skipped, it would go stale.
+type DeliveryHook struct {
+	mqtt.HookBase
+	Broker broker.Publisher
+
+	mu        sync.Mutex
+	congested map[string]time.Time
+}
+
+func (h *DeliveryHook) ID() string {
+	return "delivery-hook"
+}
+
+func (h *DeliveryHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublish,
+		mqtt.OnPublishDropped,
+	}, []byte{b})
+}
+
+// deliveryKind names what a publish carries, for metrics and to decide
+// what may be skipped.
+func deliveryKind(topic string, payload []byte) string {
+	parts := strings.Split(topic, "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "chat" && parts[2] == "messages":
+		var message struct {
+			Type models.MessageType `json:"type"`
+		}
+		_ = json.Unmarshal(payload, &message)
+		if message.Type == models.TypeTyping || message.Type == models.TypeStatus {
+			return string(message.Type)
+		}
+		return "message"
+	case len(parts) == 3 && parts[0] == "chat":
+		return parts[2]
+	case len(parts) == 4 && parts[0] == "chat":
+		return "call"
+	case parts[0] == "system" || parts[0] == "presence":
+		return parts[0]
+	case parts[0] == "channels":
+		return "channel"
+	}
+	return "other"
+}
+
+func (h *DeliveryHook) OnPublishDropped(client *mqtt.Client, pk packets.Packet) {
+	defer crash.Recover(h.ID())
+	kind := deliveryKind(pk.TopicName, pk.Payload)
+	metrics.MQTTDropped.WithLabelValues(kind).Inc()
+
+	if user := string(client.Properties.Username); user != "" {
+		h.mu.Lock()
+		now := time.Now()
+		if h.congested == nil {
+			h.congested = make(map[string]time.Time)
+		}
+		if len(h.congested) > 1024 {
+			for other, until := range h.congested {
+				if now.After(until) {
+					delete(h.congested, other)
+				}
+			}
+		}
+		h.congested[user] = now.Add(congestionWindow)
+		h.mu.Unlock()
+	}
+	if kind != "message" {
+		return
+	}
+
+	var message models.Message
+	// system messages are the server's, nobody to tell
+	if err := json.Unmarshal(pk.Payload, &message); err != nil || message.SenderId.IsZero() || message.Type == models.TypeSystem {
+		return
+	}
+	recipient := message.RecipientId
+	if id, err := bson.ObjectIDFromHex(string(client.Properties.Username)); err == nil {
+		recipient = id
+	}
+	payload, _ := json.Marshal(models.DeliveryFailure{
+		MessageId:   message.Id,
+		RecipientId: recipient,
+		Reason:      "recipient_backlog",
+		Timestamp:   time.Now(),
+	})
+	// called from within the broker's publish, so publish from outside it
+	go func() {
+		if err := h.Broker.Publish(context.Background(), models.SystemTopic(message.SenderId, "delivery"), payload, false, broker.QoS(broker.ClassSystem)); err != nil {
+			log.Println("[WARN] delivery failure not published", message.Id.Hex(), err)
+		}
+	}()
+}
+
+// OnPublish skips typing and status events to congested users.
+func (h *DeliveryHook) OnPublish(client *mqtt.Client, pk packets.Packet) (out packets.Packet, err error) {
+	defer crash.RecoverWith(h.ID(), func() { out = pk })
+	if pk.FixedHeader.Retain {
+		return pk, nil
+	}
+	kind := deliveryKind(pk.TopicName, pk.Payload)
+	if kind != string(models.TypeTyping) && kind != string(models.TypeStatus) {
+		return pk, nil
+	}
+	user := strings.Split(pk.TopicName, "/")[1]
+
+	h.mu.Lock()
+	until, ok := h.congested[user]
+	if ok && time.Now().After(until) {
+		delete(h.congested, user)
+		ok = false
+	}
+	h.mu.Unlock()
+
+	if ok {
+		metrics.MQTTShed.WithLabelValues(kind).Inc()
+		return pk, packets.CodeSuccessIgnore
+	}
+	return pk, nil
+}
@@ -0,0 +1,42 @@
+package hooks
+
+import (
+	"bytes"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"sync/atomic"
+)
+
+// DrainHook turns new broker connections away once the instance is
+// draining before a shutdown, so clients land on another instance. MQTT 5
+// clients are told to use another server.
+type DrainHook struct {
+	mqtt.HookBase
+	draining atomic.Bool
+}
+
+func (h *DrainHook) ID() string {
+	return "drain-hook"
+}
+
+func (h *DrainHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnect,
+	}, []byte{b})
+}
+
+// Drain stops new connections, there is no way back.
+func (h *DrainHook) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *DrainHook) Draining() bool {
+	return h.draining.Load()
+}
+
+func (h *DrainHook) OnConnect(client *mqtt.Client, pk packets.Packet) error {
+	if h.Draining() {
+		return packets.ErrUseAnotherServer
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package hooks
+
+import (
+	"bytes"
+	"filachat/internal/crash"
+	"filachat/internal/metrics"
+	"filachat/internal/wire"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"strings"
+)
+
+// FormatHook sends message, typing and status payloads as CBOR to clients
+// that ask for it with the user property format=cbor on CONNECT, which
+// takes MQTT 5. Everything is published as JSON and transcoded per client
+// right before it is written, so the rest of the server knows nothing of
+// it. Payloads that fail to transcode go out as JSON.
+type FormatHook struct {
+	mqtt.HookBase
+}
+
+func (h *FormatHook) ID() string {
+	return "format-hook"
+}
+
+func (h *FormatHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPacketEncode,
+	}, []byte{b})
+}
+
+func wantsCBOR(client *mqtt.Client) bool {
+	for _, property := range client.Properties.Props.User {
+		if property.Key == "format" {
+			return property.Val == "cbor"
+		}
+	}
+	return false
+}
+
+func (h *FormatHook) OnPacketEncode(client *mqtt.Client, pk packets.Packet) (out packets.Packet) {
+	defer crash.RecoverWith(h.ID(), func() { out = pk })
+	if pk.FixedHeader.Type != packets.Publish || len(pk.Payload) == 0 || !wantsCBOR(client) {
+		return pk
+	}
+	parts := strings.Split(pk.TopicName, "/")
+	if len(parts) != 3 || parts[0] != "chat" || parts[2] != "messages" {
+		return pk
+	}
+
+	payload, err := wire.JSONToCBOR(pk.Payload)
+	if err != nil {
+		metrics.MQTTTranscodeFailed.Inc()
+		return pk
+	}
+	// the payload is shared with the other subscribers, replace it only
+	pk.Payload = payload
+	pk.Properties.ContentType = wire.ContentTypeCBOR
+	pk.Properties.PayloadFormat = 0
+	pk.Properties.PayloadFormatFlag = true
+	return pk
+}
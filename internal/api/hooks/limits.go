@@ -0,0 +1,109 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"filachat/internal/crash"
+	"filachat/internal/metrics"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"log"
+	"sync"
+	"time"
+)
+
+// LimitsHook protects the broker from clients that take more than their
+// share. A user may hold PerUser connections, further ones are disconnected
+// as soon as they are established; the user is only known once the token is
+// checked, so they cannot be refused earlier. Clients whose unacknowledged
+// publishes stay at SlowInflight or more for SlowGrace stopped reading and
+// are evicted, their session stays for when they come back.
+// The limit on all connections is the broker's MaximumClients.
+type LimitsHook struct {
+	mqtt.HookBase
+	Server       *mqtt.Server
+	PerUser      int
+	SlowInflight int
+	SlowGrace    time.Duration
+
+	mu        sync.Mutex
+	slowSince map[string]time.Time
+}
+
+func (h *LimitsHook) ID() string {
+	return "limits-hook"
+}
+
+func (h *LimitsHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnSessionEstablished,
+	}, []byte{b})
+}
+
+func (h *LimitsHook) OnSessionEstablished(client *mqtt.Client, pk packets.Packet) {
+	defer crash.Recover(h.ID())
+	if h.PerUser <= 0 || client.Net.Inline {
+		return
+	}
+	user := string(client.Properties.Username)
+	connections := 0
+	for _, other := range h.Server.Clients.GetAll() {
+		if other.ID != client.ID && !other.Closed() && string(other.Properties.Username) == user {
+			connections++
+		}
+	}
+	if connections >= h.PerUser {
+		metrics.MQTTEvicted.WithLabelValues("user_limit").Inc()
+		_ = h.Server.DisconnectClient(client, packets.ErrQuotaExceeded)
+	}
+}
+
+// Run looks for slow clients a few times per grace period.
+func (h *LimitsHook) Run(ctx context.Context) {
+	if h.SlowInflight <= 0 || h.SlowGrace <= 0 {
+		return
+	}
+	ticker := time.NewTicker(h.SlowGrace / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.evictSlow(now)
+		}
+	}
+}
+
+func (h *LimitsHook) evictSlow(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.slowSince == nil {
+		h.slowSince = make(map[string]time.Time)
+	}
+
+	backlogged := make(map[string]bool)
+	for _, client := range h.Server.Clients.GetAll() {
+		if client.Net.Inline || client.Closed() || client.State.Inflight.Len() < h.SlowInflight {
+			continue
+		}
+		backlogged[client.ID] = true
+		since, ok := h.slowSince[client.ID]
+		if !ok {
+			h.slowSince[client.ID] = now
+			continue
+		}
+		if now.Sub(since) >= h.SlowGrace {
+			log.Println("[WARN] evicting slow client", client.ID, client.State.Inflight.Len(), "inflight")
+			metrics.MQTTEvicted.WithLabelValues("slow").Inc()
+			_ = h.Server.DisconnectClient(client, packets.ErrQuotaExceeded)
+			delete(backlogged, client.ID)
+		}
+	}
+	for id := range h.slowSince {
+		if !backlogged[id] {
+			delete(h.slowSince, id)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"filachat/internal/crash"
+	"filachat/internal/metrics"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"strings"
+)
+
+// LocalDeliveryHook takes the messages clients publish to their send topic
+// and hands them to Ingest, which does what the REST endpoint does but may
+// publish to a recipient connected to this broker before the message is
+// stored. The publish itself goes no further, nobody reads send topics.
+type LocalDeliveryHook struct {
+	mqtt.HookBase
+	Ingest func(ctx context.Context, sender bson.ObjectID, payload []byte) error
+}
+
+func (h *LocalDeliveryHook) ID() string {
+	return "local-delivery-hook"
+}
+
+func (h *LocalDeliveryHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublish,
+	}, []byte{b})
+}
+
+func (h *LocalDeliveryHook) OnPublish(client *mqtt.Client, pk packets.Packet) (out packets.Packet, err error) {
+	// a message that makes ingestion panic is refused like an invalid one
+	defer crash.RecoverWith(h.ID(), func() { out, err = pk, packets.ErrRejectPacket })
+	if client.Net.Inline || !isSendTopic(strings.Split(pk.TopicName, "/")) {
+		return pk, nil
+	}
+	sender, err := bson.ObjectIDFromHex(string(client.Properties.Username))
+	if err == nil {
+		err = h.Ingest(context.Background(), sender, pk.Payload)
+	}
+	if err != nil {
+		metrics.MQTTRejected.WithLabelValues("message").Inc()
+		if client.Properties.ProtocolVersion == 5 && pk.FixedHeader.Qos > 0 {
+			return pk, packets.ErrPayloadFormatInvalid
+		}
+		return pk, packets.ErrRejectPacket
+	}
+	return pk, packets.CodeSuccessIgnore
+}
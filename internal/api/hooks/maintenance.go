@@ -0,0 +1,33 @@
+package hooks
+
+import (
+	"bytes"
+	"filachat/internal/maintenance"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// MaintenanceHook turns new broker connections away while maintenance is
+// on. Clients already connected are told and disconnected by the handler
+// that switches maintenance on.
+type MaintenanceHook struct {
+	mqtt.HookBase
+	State *maintenance.State
+}
+
+func (h *MaintenanceHook) ID() string {
+	return "maintenance-hook"
+}
+
+func (h *MaintenanceHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnect,
+	}, []byte{b})
+}
+
+func (h *MaintenanceHook) OnConnect(client *mqtt.Client, pk packets.Packet) error {
+	if h.State.Enabled() {
+		return packets.ErrServerUnavailable
+	}
+	return nil
+}
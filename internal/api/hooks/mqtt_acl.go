@@ -0,0 +1,51 @@
+package hooks
+
+import (
+	"filachat/internal/models"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"strings"
+)
+
+// OnACLCheck limits clients to the topics of their own user and the call
+// topics they take part in, plus reading public channels, presence and the
+// broadcast system topics.
+// Only call topics and the user's own send topic accept client publishes,
+// every other topic is written by the server.
+func (h *JWTHook) OnACLCheck(client *mqtt.Client, topic string, write bool) bool {
+	return TopicAllowed(string(client.Properties.Username), topic, write)
+}
+
+// TopicAllowed is the ACL above for the user with the given hex id. The
+// ACL webhook answers external brokers with it.
+func TopicAllowed(user string, topic string, write bool) bool {
+	if user == "" {
+		return false
+	}
+
+	parts := strings.Split(topic, "/")
+	if topic == models.AnnouncementsTopic || topic == models.MaintenanceTopic || len(parts) == 3 && parts[0] == "channels" && parts[2] == "posts" || len(parts) == 2 && parts[0] == "presence" {
+		return !write
+	}
+	if len(parts) < 2 || parts[1] != user && !(isCallTopic(parts) && parts[2] == user) {
+		return false
+	}
+
+	switch parts[0] {
+	case "chat":
+		if isCallTopic(parts) || isSendTopic(parts) {
+			return true
+		}
+		return !write
+	case "system":
+		return !write
+	}
+	return false
+}
+
+func isSendTopic(parts []string) bool {
+	return len(parts) == 3 && parts[0] == "chat" && parts[2] == "send"
+}
+
+func isCallTopic(parts []string) bool {
+	return len(parts) == 4 && parts[0] == "chat" && parts[3] == "call"
+}
@@ -2,18 +2,23 @@ package hooks
 import (
 
 "bytes"
-"encoding/base64"
-"encoding/hex"
+imiddleware "filachat/internal/api/middleware"
 "filachat/internal/core"
-"github.com/joho/godotenv"
+"filachat/internal/models"
 mqtt "github.com/mochi-mqtt/server/v2"
 "github.com/mochi-mqtt/server/v2/packets"
 "log"
-"os"
+"time"
 )
 
 type JWTHook struct {
 	mqtt.HookBase
+	Tokens     *core.JWTTokens
+	// Restricted turns away accounts that are not active.
+	Restricted *imiddleware.Restrictions
+	// ClientIDs verifies the client id was issued to the user by
+	// /mqtt/token.
+	ClientIDs  *core.ClientIDs
 }
 
 func (h *JWTHook) ID() string {
@@ -29,7 +34,6 @@ func (h *JWTHook) Provides(b byte) bool {
 }
 
 func (h *JWTHook) OnConnectAuthenticate(client *mqtt.Client, pk packets.Packet) bool {
-	godotenv.Load()
 	log.Println("[INFO] OnConnectAuthenticate")
 	token := string(pk.Connect.Password)
 	if token == "" {
@@ -37,27 +41,21 @@ func (h *JWTHook) OnConnectAuthenticate(client *mqtt.Client, pk packets.Packet)
 		return false
 	}
 
-	decodedToken, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
+	claims, err := h.Tokens.Open(token, core.AccessToken)
+	if err != nil || claims.IsSupport() {
 		return false
 	}
-	key, err := hex.DecodeString(os.Getenv("JWT_ACCESS_SECRET"))
-	if err != nil {
+	if user, _, err := h.ClientIDs.Verify(pk.Connect.ClientIdentifier); err != nil || user != claims.Subject {
+		log.Println("[WARN] connect refused, client id not issued to", claims.Subject.Hex())
 		return false
 	}
-
-	decryptedToken, err := core.JWTEncrypter.Decrypt(decodedToken, key)
-	if err != nil {
-		return false
-	}
-	claims, err := core.JWTFactory.ParseToken(string(decryptedToken), true)
-	if err != nil {
+	if state := h.Restricted.State(claims.Subject, time.Now()); state != models.AccountActive {
+		log.Println("[INFO] connect refused, account", state, claims.Subject.Hex())
 		return false
 	}
 
-	if err := core.JWTFactory.VerifyClaims(claims, true); err != nil {
-		return false
-	}
+	// the ACL hook identifies the client by the token subject from here on
+	client.Properties.Username = []byte(claims.Subject.Hex())
 	log.Println("[INFO] connect packet authenticated", client.ID)
 	return true
 }
\ No newline at end of file
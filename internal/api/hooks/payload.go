@@ -0,0 +1,69 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"filachat/internal/crash"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"strings"
+)
+
+// PayloadHook checks what clients publish before the broker fans it out,
+// so one client cannot hand others malformed or oversized JSON. Call
+// signals are the only client payload, see models.CallSignal; they are
+// relayed re-encoded, with the sender filled in by the broker. Messages on
+// the send topics are checked by LocalDeliveryHook instead.
+type PayloadHook struct {
+	mqtt.HookBase
+}
+
+func (h *PayloadHook) ID() string {
+	return "payload-hook"
+}
+
+func (h *PayloadHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublish,
+	}, []byte{b})
+}
+
+func (h *PayloadHook) OnPublish(client *mqtt.Client, pk packets.Packet) (out packets.Packet, err error) {
+	defer crash.RecoverWith(h.ID(), func() { out, err = pk, packets.ErrRejectPacket })
+	if client.Net.Inline {
+		return pk, nil
+	}
+	reject := func(reason string) (packets.Packet, error) {
+		metrics.MQTTRejected.WithLabelValues(reason).Inc()
+		// MQTT 5 clients get a reason code, older ones cannot be told
+		if client.Properties.ProtocolVersion == 5 && pk.FixedHeader.Qos > 0 {
+			return pk, packets.ErrPayloadFormatInvalid
+		}
+		return pk, packets.ErrRejectPacket
+	}
+
+	parts := strings.Split(pk.TopicName, "/")
+	if isSendTopic(parts) {
+		return pk, nil
+	}
+	if !isCallTopic(parts) {
+		return reject("topic")
+	}
+	sender, err := bson.ObjectIDFromHex(string(client.Properties.Username))
+	if err != nil {
+		return reject("sender")
+	}
+	signal, err := models.DecodeCallSignal(pk.Payload)
+	if err != nil {
+		return reject("payload")
+	}
+
+	signal.From = sender
+	if pk.Payload, err = json.Marshal(signal); err != nil {
+		return reject("payload")
+	}
+	return pk, nil
+}
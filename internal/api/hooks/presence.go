@@ -0,0 +1,129 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/crash"
+	database "filachat/internal/data"
+	"filachat/internal/models"
+	"filachat/internal/presence"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// PresenceHook marks users online while they hold a broker connection and
+// relays every presence change from the store to the presence topics of
+// this broker, whichever instance the change happened on. When the last
+// connection of a user closes, the time is kept with the user, so last
+// seen survives restarts of the presence store. Server is asked which
+// clients are connected, changes are published through Broker.
+type PresenceHook struct {
+	mqtt.HookBase
+	Store  presence.PresenceStore
+	DB     *database.DB
+	Server *mqtt.Server
+	Broker broker.Publisher
+	TTL    time.Duration
+}
+
+func (h *PresenceHook) ID() string {
+	return "presence-hook"
+}
+
+func (h *PresenceHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnSessionEstablished,
+		mqtt.OnDisconnect,
+	}, []byte{b})
+}
+
+func (h *PresenceHook) OnSessionEstablished(client *mqtt.Client, pk packets.Packet) {
+	defer crash.Recover(h.ID())
+	user, err := bson.ObjectIDFromHex(string(client.Properties.Username))
+	if err != nil {
+		return
+	}
+	if err := h.Store.Touch(user, h.TTL); err != nil {
+		log.Println("[WARN] presence not updated", user.Hex(), err)
+	}
+}
+
+func (h *PresenceHook) OnDisconnect(client *mqtt.Client, err error, expire bool) {
+	defer crash.Recover(h.ID())
+	user, err := bson.ObjectIDFromHex(string(client.Properties.Username))
+	if err != nil {
+		return
+	}
+	// another device of the same user keeps them online
+	for _, other := range h.Server.Clients.GetAll() {
+		if other.ID != client.ID && !other.Closed() && string(other.Properties.Username) == user.Hex() {
+			return
+		}
+	}
+	if err := h.DB.SetLastSeen(context.Background(), user, time.Now()); err != nil {
+		log.Println("[WARN] last seen not saved", user.Hex(), err)
+	}
+	// the store reports the change to Broadcast, which publishes it
+	if err := h.Store.Remove(user); err != nil {
+		log.Println("[WARN] presence not updated", user.Hex(), err)
+	}
+}
+
+// Run renews the presence of every connected user a few times per TTL, so
+// users stay online on other instances for as long as they are connected.
+func (h *PresenceHook) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		seen := make(map[string]bool)
+		for _, client := range h.Server.Clients.GetAll() {
+			name := string(client.Properties.Username)
+			if client.Net.Inline || client.Closed() || seen[name] {
+				continue
+			}
+			seen[name] = true
+			if user, err := bson.ObjectIDFromHex(name); err == nil {
+				if err := h.Store.Touch(user, h.TTL); err != nil {
+					log.Println("[WARN] presence not renewed", name, err)
+				}
+			}
+		}
+	}
+}
+
+// Broadcast publishes the changes from Store.Watch as a retained status per
+// user, so subscribers get the current one right away. The state users
+// picked is applied here, so invisible users never show up online.
+func (h *PresenceHook) Broadcast(ctx context.Context, changes <-chan models.UserStatus) {
+	for {
+		var status models.UserStatus
+		select {
+		case <-ctx.Done():
+			return
+		case status = <-changes:
+		}
+		var setting *models.StatusSetting
+		var quiet *models.QuietHours
+		if user, err := h.DB.GetUser(ctx, status.UserID); err == nil {
+			setting, quiet = user.Status, user.QuietHours
+			status = presence.Remembered(status, user.LastSeen)
+		}
+		status = presence.Paused(presence.Resolve(status, setting), quiet, time.Now())
+
+		payload, _ := json.Marshal(status)
+		if err := h.Broker.Publish(ctx, models.PresenceTopic(status.UserID), payload, true, broker.QoS(broker.ClassPresence)); err != nil {
+			log.Println("[WARN] presence not published", status.UserID.Hex(), err)
+		}
+	}
+}
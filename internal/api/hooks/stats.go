@@ -0,0 +1,27 @@
+package hooks
+
+import (
+	"bytes"
+	"filachat/internal/metrics"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// TopicStatsHook counts publishes per topic for the admin overview.
+type TopicStatsHook struct {
+	mqtt.HookBase
+}
+
+func (h *TopicStatsHook) ID() string {
+	return "topic-stats-hook"
+}
+
+func (h *TopicStatsHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnPublished,
+	}, []byte{b})
+}
+
+func (h *TopicStatsHook) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	metrics.Topics.Add(pk.TopicName)
+}
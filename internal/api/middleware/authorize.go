@@ -0,0 +1,35 @@
+package imiddleware
+
+import (
+	"context"
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"filachat/internal/policy"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+)
+
+// Authorize checks the rule the route declared in table, routes without
+// one are denied. It has to run after JWTAccessAuth. The caller, loaded
+// with users, is set as "account" for the handler.
+func Authorize(table *policy.Table, users func(ctx context.Context, id bson.ObjectID) (models.User, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Get("user").(*models.User)
+			claims, _ := c.Get("claims").(*core.Claims)
+
+			user, err := users(c.Request().Context(), auth.Id)
+			if err != nil {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: "not allowed"}
+			}
+			rule := table.Rule(c.Request().Method, c.Path())
+			if !rule(policy.Subject{User: user, Claims: claims}) {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: "not allowed"}
+			}
+
+			c.Set("account", user)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package imiddleware
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// BodyLimit is echo's body limit with the limit looked up per request, so it
+// can differ by route and follow config reloads. A limit of 0 or less lets
+// the body through, for handlers that stream it and enforce a limit of
+// their own.
+func BodyLimit(limit func(c echo.Context) int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			max := limit(c)
+			if max <= 0 {
+				return next(c)
+			}
+			req := c.Request()
+			if req.ContentLength > max {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+			req.Body = http.MaxBytesReader(c.Response(), req.Body, max)
+
+			err := next(c)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package imiddleware
+
+import (
+	"filachat/internal/redact"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"log"
+	"strings"
+)
+
+// bodyLogLimit is how much of a body is logged.
+const bodyLogLimit = 4 << 10
+
+// BodyLog logs the JSON bodies of requests and responses while LOG_BODIES
+// is set in the hot config. Bodies go through the redactor first, query
+// strings are left out since tokens ride in some of them. Bodies are held
+// in memory whole, so only requests logs accepts are buffered, and BodyLog
+// belongs after the body limit.
+func BodyLog(redactor *redact.Redactor, logs func(c echo.Context) bool) echo.MiddlewareFunc {
+	return middleware.BodyDumpWithConfig(middleware.BodyDumpConfig{
+		Skipper: func(c echo.Context) bool {
+			return !config.Current().LogBodies || !logs(c)
+		},
+		Handler: func(c echo.Context, req, res []byte) {
+			if !isJSON(c.Request().Header.Get(echo.HeaderContentType)) {
+				req = nil
+			}
+			if !isJSON(c.Response().Header().Get(echo.HeaderContentType)) {
+				res = nil
+			}
+			log.Printf("[DEBUG] %s %s %d req=%s res=%s", c.Request().Method, c.Request().URL.Path, c.Response().Status,
+				logBody(redactor, req), logBody(redactor, res))
+		},
+	})
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, echo.MIMEApplicationJSON)
+}
+
+func logBody(redactor *redact.Redactor, body []byte) string {
+	if len(body) == 0 {
+		return "-"
+	}
+	redacted := redactor.JSON(body)
+	if len(redacted) > bodyLogLimit {
+		return string(redacted[:bodyLogLimit]) + "..."
+	}
+	return string(redacted)
+}
@@ -0,0 +1,44 @@
+package imiddleware
+
+import (
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+const (
+	HeaderClientVersion    = "X-Client-Version"
+	HeaderMinClientVersion = "X-Min-Client-Version"
+	HeaderClientUpgrade    = "X-Client-Upgrade"
+)
+
+// ClientVersion applies the client version policy of the hot config.
+// Clients below the minimum get 426 Upgrade Required and the minimum in
+// X-Min-Client-Version, clients below the recommended version are served
+// with X-Client-Upgrade: recommended. Requests without a version, from
+// browsers, scripts or the broker, are let through.
+func ClientVersion() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			raw := c.Request().Header.Get(HeaderClientVersion)
+			if raw == "" {
+				return next(c)
+			}
+			cfg := config.Current()
+			version, err := models.ParseClientVersion(raw)
+			if err != nil {
+				return &echo.HTTPError{Code: http.StatusBadRequest, Message: "invalid client version"}
+			}
+
+			if minimum, err := models.ParseClientVersion(cfg.MinClientVersion); err == nil && version.Less(minimum) {
+				c.Response().Header().Set(HeaderMinClientVersion, minimum.String())
+				return &echo.HTTPError{Code: http.StatusUpgradeRequired, Message: "client upgrade required"}
+			}
+			if recommended, err := models.ParseClientVersion(cfg.RecommendedClientVersion); err == nil && version.Less(recommended) {
+				c.Response().Header().Set(HeaderClientUpgrade, "recommended")
+			}
+			return next(c)
+		}
+	}
+}
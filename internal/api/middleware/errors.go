@@ -0,0 +1,29 @@
+package imiddleware
+
+import (
+	"errors"
+	"filachat/internal/i18n"
+	"github.com/labstack/echo/v4"
+)
+
+// LocalizedErrors wraps the default error handler so error bodies carry the
+// message in the client's language next to the English one, which stays
+// stable for clients to match on:
+//
+//	{"error": "user not found", "message": "nie znaleziono użytkownika"}
+func LocalizedErrors(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			if message, ok := he.Message.(string); ok {
+				locale := i18n.Negotiate(c.Request().Header.Get("Accept-Language"))
+				err = &echo.HTTPError{
+					Code:     he.Code,
+					Message:  map[string]string{"error": message, "message": i18n.Error(locale, message)},
+					Internal: he.Internal,
+				}
+			}
+		}
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+}
@@ -0,0 +1,78 @@
+package imiddleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+)
+
+type etagWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// ETag buffers successful GET responses, tags them with a content hash and
+// answers 304 Not Modified when the client already holds the same version.
+func ETag(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return next(c)
+		}
+
+		res := c.Response()
+		original := res.Writer
+		writer := &etagWriter{ResponseWriter: original, status: http.StatusOK}
+		res.Writer = writer
+		err := next(c)
+		res.Writer = original
+		if err != nil {
+			return err
+		}
+
+		if writer.status != http.StatusOK {
+			original.WriteHeader(writer.status)
+			_, err = original.Write(writer.body.Bytes())
+			return err
+		}
+
+		sum := sha256.Sum256(writer.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		original.Header().Set("ETag", etag)
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			original.Header().Del(echo.HeaderContentType)
+			original.Header().Del(echo.HeaderContentLength)
+			original.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		original.WriteHeader(writer.status)
+		_, err = original.Write(writer.body.Bytes())
+		return err
+	}
+}
+
+func etagMatches(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
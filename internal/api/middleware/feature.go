@@ -0,0 +1,19 @@
+package imiddleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// Feature hides routes behind a switch read on every request, so features
+// turned off in the hot config disappear without a restart.
+func Feature(enabled func() bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !enabled() {
+				return &echo.HTTPError{Code: http.StatusNotFound, Message: "feature disabled"}
+			}
+			return next(c)
+		}
+	}
+}
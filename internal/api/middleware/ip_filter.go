@@ -0,0 +1,145 @@
+package imiddleware
+
+import (
+	"filachat/internal/models"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/oschwald/geoip2-golang"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IPFilter holds the allow/deny networks and blocked countries. Rules from
+// the configuration are static, rules managed by admins are swapped in at
+// runtime through SetRules.
+type IPFilter struct {
+	mu        sync.RWMutex
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	countries map[string]bool
+
+	staticAllow     []*net.IPNet
+	staticDeny      []*net.IPNet
+	staticCountries []string
+	geo             *geoip2.Reader
+}
+
+func NewIPFilter(allow []string, deny []string, countries []string, geoDatabase string) (*IPFilter, error) {
+	f := &IPFilter{staticCountries: countries}
+
+	var err error
+	if f.staticAllow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	}
+	if f.staticDeny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+	if geoDatabase != "" {
+		if f.geo, err = geoip2.Open(geoDatabase); err != nil {
+			return nil, err
+		}
+	}
+
+	f.SetRules(nil)
+	return f, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		network, err := ParseNetwork(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ParseNetwork accepts a CIDR or a bare address, which is treated as a
+// single-host network.
+func ParseNetwork(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", cidr)
+		}
+		if ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
+
+func (f *IPFilter) SetRules(rules []models.IPRule) {
+	allow := append([]*net.IPNet{}, f.staticAllow...)
+	deny := append([]*net.IPNet{}, f.staticDeny...)
+	countries := make(map[string]bool)
+	for _, country := range f.staticCountries {
+		countries[strings.ToUpper(country)] = true
+	}
+
+	for _, rule := range rules {
+		if rule.Country != "" {
+			countries[strings.ToUpper(rule.Country)] = true
+			continue
+		}
+		network, err := ParseNetwork(rule.CIDR)
+		if err != nil {
+			continue
+		}
+		if rule.Action == models.IPRuleAllow {
+			allow = append(allow, network)
+		} else {
+			deny = append(deny, network)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allow, f.deny, f.countries = allow, deny, countries
+}
+
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, network := range f.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) > 0 {
+		allowed := false
+		for _, network := range f.allow {
+			if network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if f.geo != nil && len(f.countries) > 0 {
+		if record, err := f.geo.Country(ip); err == nil && f.countries[record.Country.IsoCode] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *IPFilter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ip := net.ParseIP(c.RealIP())
+		if ip == nil || !f.Allowed(ip) {
+			return &echo.HTTPError{Code: http.StatusForbidden, Message: "access denied"}
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,39 @@
+package imiddleware
+
+import (
+	"filachat/internal/maintenance"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Maintenance answers 503 with Retry-After while maintenance is on. Admin
+// routes stay reachable, along with sign in and token refresh so an admin
+// can get a session to switch it off again; those routes check the role
+// themselves. The MQTT webhooks refuse new connections on their own.
+func Maintenance(state *maintenance.State) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			status := state.Status()
+			if !status.Enabled || maintenanceExempt(c.Path()) {
+				return next(c)
+			}
+
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(status.RetryAfter.Seconds())))
+			message := status.Message
+			if message == "" {
+				message = "down for maintenance"
+			}
+			return &echo.HTTPError{Code: http.StatusServiceUnavailable, Message: message}
+		}
+	}
+}
+
+func maintenanceExempt(path string) bool {
+	switch path {
+	case "/signin", "/refresh-token", "/metrics", "/mqtt/auth", "/mqtt/acl":
+		return true
+	}
+	return strings.HasPrefix(path, "/admin/")
+}
@@ -1,108 +1,104 @@
 package imiddleware
 
 import (
-	"encoding/base64"
-	"encoding/hex"
 	"filachat/internal/core"
 	"filachat/internal/models"
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/v2/bson"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 )
 
-func JWTRefreshAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		if !c.IsTLS() {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "connection not secured"}
+func JWTRefreshAuth(tokens *core.JWTTokens) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !c.IsTLS() {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: "connection not secured"}
+			}
+
+			var (
+				after string = ""
+				found bool   = false
+			)
+			if after, found = strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer "); after == "" || !found {
+				cookie, err := c.Cookie(RefreshCookieName)
+				if err != nil || cookie.Value == "" {
+					return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid token"}
+				}
+				after = cookie.Value
+			}
+
+			claims, err := tokens.Open(after, core.RefreshToken)
+			if err != nil {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: err.Error()}
+			}
+
+			c.Set("user", &models.User{Id: claims.Subject})
+			c.Set("claims", claims)
+			return next(c)
 		}
-
-		var (
-			after string = ""
-			found bool   = false
-		)
-		if after, found = strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer "); after == "" || !found {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid token1"}
-		}
-
-		decodedToken, err := base64.StdEncoding.DecodeString(after)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid token2"}
-		}
-
-		key, err := hex.DecodeString(os.Getenv("JWT_REFRESH_SECRET"))
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "encryption failed"}
-		}
-
-		decryptedToken, err := core.JWTEncrypter.Decrypt(decodedToken, key)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "encryption failed"}
-		}
-		claims, err := core.JWTFactory.ParseToken(string(decryptedToken), false)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: err.Error()}
-		}
-
-		if err := core.JWTFactory.VerifyClaims(claims, false); err != nil {
-			return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
-		}
-
-		userId, _ := claims.GetSubject()
-		rawUserId, err := bson.ObjectIDFromHex(userId)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: err.Error()}
-		}
-
-		c.Set("user", &models.User{Id: rawUserId})
-		return next(c)
 	}
 }
-func JWTAccessAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		if !c.IsTLS() {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "connection not secured"}
-		}
-
-		var (
-			after string = ""
-			found bool   = false
-		)
-		if after, found = strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer "); after == "" || !found {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid token"}
-		}
 
-		decodedToken, err := base64.StdEncoding.DecodeString(after)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid token"}
-		}
-
-		key, err := hex.DecodeString(os.Getenv("JWT_ACCESS_SECRET"))
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusInternalServerError, Message: "encryption failed"}
+// JWTAccessAuth takes access tokens of active accounts, a token of a
+// suspended, banned or deactivated account is refused before it expires.
+func JWTAccessAuth(tokens *core.JWTTokens, restrictions *Restrictions) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := accessClaims(c, tokens)
+			if err != nil {
+				return err
+			}
+			// a support session only looks, it never acts as the user
+			if claims.IsSupport() {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: "support token not accepted here"}
+			}
+			if state := restrictions.State(claims.Subject, time.Now()); state != models.AccountActive {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: "account " + string(state)}
+			}
+
+			c.Set("user", &models.User{Id: claims.Subject})
+			c.Set("claims", claims)
+			return next(c)
 		}
+	}
+}
 
-		decryptedToken, err := core.JWTEncrypter.Decrypt(decodedToken, key)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: "encryption failed"}
-		}
-		claims, err := core.JWTFactory.ParseToken(string(decryptedToken), true)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: err.Error()}
+// JWTSupportAuth takes the access tokens of support sessions only. The
+// handler still has to check the session was not revoked.
+func JWTSupportAuth(tokens *core.JWTTokens) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := accessClaims(c, tokens)
+			if err != nil {
+				return err
+			}
+			if !claims.IsSupport() {
+				return &echo.HTTPError{Code: http.StatusForbidden, Message: "support token required"}
+			}
+
+			c.Set("claims", claims)
+			return next(c)
 		}
+	}
+}
 
-		if err := core.JWTFactory.VerifyClaims(claims, true); err != nil {
-			return &echo.HTTPError{Code: http.StatusBadRequest, Message: err.Error()}
-		}
+func accessClaims(c echo.Context, tokens *core.JWTTokens) (*core.Claims, error) {
+	if !c.IsTLS() {
+		return nil, &echo.HTTPError{Code: http.StatusForbidden, Message: "connection not secured"}
+	}
 
-		userId, _ := claims.GetSubject()
-		rawUserId, err := bson.ObjectIDFromHex(userId)
-		if err != nil {
-			return &echo.HTTPError{Code: http.StatusForbidden, Message: err.Error()}
-		}
+	var (
+		after string = ""
+		found bool   = false
+	)
+	if after, found = strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer "); after == "" || !found {
+		return nil, &echo.HTTPError{Code: http.StatusForbidden, Message: "invalid token"}
+	}
 
-		c.Set("user", &models.User{Id: rawUserId})
-		return next(c)
+	claims, err := tokens.Open(after, core.AccessToken)
+	if err != nil {
+		return nil, &echo.HTTPError{Code: http.StatusForbidden, Message: err.Error()}
 	}
+	return claims, nil
 }
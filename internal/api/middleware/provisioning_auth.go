@@ -0,0 +1,30 @@
+package imiddleware
+
+import (
+	"crypto/subtle"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ProvisioningAuth admits identity providers holding the organization API key.
+// Provisioning stays disabled while PROVISIONING_API_KEY is unset.
+func ProvisioningAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !c.IsTLS() {
+			return &echo.HTTPError{Code: http.StatusForbidden, Message: "connection not secured"}
+		}
+
+		key := os.Getenv("PROVISIONING_API_KEY")
+		if key == "" {
+			return &echo.HTTPError{Code: http.StatusNotFound, Message: "provisioning disabled"}
+		}
+
+		after, found := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(after), []byte(key)) != 1 {
+			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "invalid api key"}
+		}
+		return next(c)
+	}
+}
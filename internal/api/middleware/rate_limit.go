@@ -0,0 +1,55 @@
+package imiddleware
+
+import (
+	"filachat/internal/models"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// UserRateLimit limits an authenticated route per user. It has to run after
+// JWTAccessAuth so the user is known, anonymous callers fall back to their IP.
+// The limits are looked up per request; when they change the limiter starts
+// over with fresh buckets.
+func UserRateLimit(limits func() (rate.Limit, int)) echo.MiddlewareFunc {
+	type limiter struct {
+		limit rate.Limit
+		burst int
+		mw    echo.MiddlewareFunc
+	}
+	var active atomic.Pointer[limiter]
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit, burst := limits()
+			current := active.Load()
+			if current == nil || current.limit != limit || current.burst != burst {
+				current = &limiter{limit: limit, burst: burst, mw: newUserRateLimiter(limit, burst)}
+				active.Store(current)
+			}
+			return current.mw(next)(c)
+		}
+	}
+}
+
+func newUserRateLimiter(limit rate.Limit, burst int) echo.MiddlewareFunc {
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      limit,
+			Burst:     burst,
+			ExpiresIn: time.Hour,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			if user, ok := c.Get("user").(*models.User); ok {
+				return user.Id.Hex(), nil
+			}
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			return &echo.HTTPError{Code: http.StatusTooManyRequests, Message: "rate limit exceeded"}
+		},
+	})
+}
@@ -0,0 +1,25 @@
+package imiddleware
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// IPExtractor decides where c.RealIP comes from, which rate limits, the IP
+// filter, sessions, audit entries and login alerts all go by. Behind the
+// trusted proxies X-Forwarded-For is read from the right, skipping the
+// proxies, so addresses a client puts in the header itself are ignored.
+// Without trusted proxies the header is not read at all.
+func IPExtractor(trusted []string) (echo.IPExtractor, error) {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	networks, err := parseNetworks(trusted)
+	if err != nil {
+		return nil, err
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, network := range networks {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
@@ -0,0 +1,42 @@
+package imiddleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPExtractorSkipsTrustedProxiesOnly(t *testing.T) {
+	extract, err := IPExtractor([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:4000"
+	// the client sent a made-up address, the proxy appended the real one
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.7, 10.0.0.5")
+	if ip := extract(req); ip != "203.0.113.7" {
+		t.Errorf("Expected the address the proxy saw, got %s", ip)
+	}
+
+	req.RemoteAddr = "198.51.100.1:4000"
+	if ip := extract(req); ip != "198.51.100.1" {
+		t.Errorf("Expected the header ignored from an untrusted peer, got %s", ip)
+	}
+}
+
+func TestIPExtractorWithoutProxiesIgnoresHeader(t *testing.T) {
+	extract, err := IPExtractor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "198.51.100.1:4000"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if ip := extract(req); ip != "198.51.100.1" {
+		t.Errorf("Expected the connection address, got %s", ip)
+	}
+
+	if _, err := IPExtractor([]string{"not a network"}); err == nil {
+		t.Error("Expected an invalid network refused")
+	}
+}
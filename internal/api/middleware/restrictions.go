@@ -0,0 +1,84 @@
+package imiddleware
+
+import (
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sync"
+	"time"
+)
+
+// Restrictions holds the accounts that are not active or are locked, so
+// access tokens and broker connections are checked without reading the
+// database. The accounts are swapped in at startup and on a timer through
+// Set, a transition made on this instance takes effect at once through
+// Update, Lock or Unlock.
+type Restrictions struct {
+	mu       sync.RWMutex
+	accounts map[bson.ObjectID]models.AccountStatus
+	locked   map[bson.ObjectID]bool
+}
+
+func NewRestrictions() *Restrictions {
+	return &Restrictions{accounts: map[bson.ObjectID]models.AccountStatus{}, locked: map[bson.ObjectID]bool{}}
+}
+
+func (r *Restrictions) Set(users []models.User) {
+	accounts := make(map[bson.ObjectID]models.AccountStatus, len(users))
+	locked := map[bson.ObjectID]bool{}
+	for _, user := range users {
+		if user.Locked {
+			locked[user.Id] = true
+		}
+		// a deleted account is out like a deactivated one until restored
+		if user.Deactivated || !user.DeletedAt.IsZero() {
+			accounts[user.Id] = models.AccountStatus{State: models.AccountDeactivated}
+		} else if user.Account != nil {
+			accounts[user.Id] = *user.Account
+		}
+	}
+	r.mu.Lock()
+	r.accounts = accounts
+	r.locked = locked
+	r.mu.Unlock()
+}
+
+// Lock turns away the tokens and connections of user from now on.
+func (r *Restrictions) Lock(user bson.ObjectID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.locked[user] = true
+}
+
+// Unlock lets user back in, unless their account is restricted otherwise.
+func (r *Restrictions) Unlock(user bson.ObjectID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.locked, user)
+}
+
+func (r *Restrictions) Update(user bson.ObjectID, status models.AccountStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if status.State == models.AccountActive {
+		delete(r.accounts, user)
+		return
+	}
+	r.accounts[user] = status
+}
+
+// State is the state of the account of user at now, AccountLocked for an
+// otherwise active account that was locked.
+func (r *Restrictions) State(user bson.ObjectID, now time.Time) models.AccountState {
+	r.mu.RLock()
+	status, ok := r.accounts[user]
+	locked := r.locked[user]
+	r.mu.RUnlock()
+	state := models.AccountActive
+	if ok {
+		state = status.StateAt(now)
+	}
+	if state == models.AccountActive && locked {
+		return models.AccountLocked
+	}
+	return state
+}
@@ -0,0 +1,49 @@
+package imiddleware
+
+import (
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestLockedAccountIsRestricted(t *testing.T) {
+	restrictions := NewRestrictions()
+	locked, banned := bson.NewObjectID(), bson.NewObjectID()
+	restrictions.Set([]models.User{
+		{Id: locked, Locked: true},
+		{Id: banned, Locked: true, Account: &models.AccountStatus{State: models.AccountBanned}},
+	})
+
+	now := time.Now()
+	if state := restrictions.State(locked, now); state != models.AccountLocked {
+		t.Errorf("Expected a locked account, got %s", state)
+	}
+	if state := restrictions.State(banned, now); state != models.AccountBanned {
+		t.Errorf("Expected the ban to win over the lock, got %s", state)
+	}
+
+	other := bson.NewObjectID()
+	restrictions.Lock(other)
+	if state := restrictions.State(other, now); state != models.AccountLocked {
+		t.Errorf("Expected Lock to take effect at once, got %s", state)
+	}
+
+	restrictions.Unlock(locked)
+	if state := restrictions.State(locked, now); state != models.AccountActive {
+		t.Errorf("Expected Unlock to take effect at once, got %s", state)
+	}
+	restrictions.Unlock(banned)
+	if state := restrictions.State(banned, now); state != models.AccountBanned {
+		t.Errorf("Expected Unlock to leave the ban, got %s", state)
+	}
+}
+
+func TestDeletedAccountIsRestricted(t *testing.T) {
+	restrictions := NewRestrictions()
+	deleted := bson.NewObjectID()
+	restrictions.Set([]models.User{{Id: deleted, DeletedAt: time.Now()}})
+	if state := restrictions.State(deleted, time.Now()); state != models.AccountDeactivated {
+		t.Errorf("Expected a deleted account to be refused, got %s", state)
+	}
+}
@@ -0,0 +1,39 @@
+package imiddleware
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Timeout gives every request a deadline looked up per request, so it can
+// differ by route and follow config reloads. Handlers pass the request
+// context on to the database, a query still running at the deadline is
+// cancelled and the request fails with 504 instead of holding on to the
+// connection. A timeout of 0 or less sets no deadline, for routes that
+// stream large bodies.
+func Timeout(timeout func(c echo.Context) time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			d := timeout(c)
+			if d <= 0 {
+				return next(c)
+			}
+			ctx, cancel := context.WithTimeout(c.Request().Context(), d)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err := next(c)
+			// handlers turn database errors into their own, the context
+			// tells whether the deadline was the cause
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Response().Committed {
+				log.Println("[WARN] request timed out after", d, c.Request().Method, c.Path())
+				return &echo.HTTPError{Code: http.StatusGatewayTimeout, Message: "request timed out", Internal: err}
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"filachat/internal/api/handlers"
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/broker"
+	"filachat/internal/core"
+	"filachat/internal/federation"
+	"filachat/internal/models"
+	"filachat/internal/policy"
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+	"net/http"
+)
+
+func exportRateLimit() (rate.Limit, int) {
+	cfg := config.Current()
+	return rate.Every(cfg.ExportRateInterval), int(cfg.ExportRateBurst)
+}
+
+func supportRateLimit() (rate.Limit, int) {
+	cfg := config.Current()
+	return rate.Every(cfg.SupportSessionRateInterval), int(cfg.SupportSessionRateBurst)
+}
+
+func keyFetchRateLimit() (rate.Limit, int) {
+	cfg := config.Current()
+	return rate.Every(cfg.KeyFetchRateInterval), int(cfg.KeyFetchRateBurst)
+}
+
+// Routes registers the API served by h. The server and the test server
+// share it, so tests run against the same routes and middleware.
+func Routes(e *echo.Echo, h *handlers.Handler) {
+	access := imiddleware.JWTAccessAuth(h.Tokens, h.Restricted)
+	refresh := imiddleware.JWTRefreshAuth(h.Tokens)
+	support := imiddleware.JWTSupportAuth(h.Tokens)
+	groups := imiddleware.Feature(func() bool { return config.Current().FeatureGroups })
+	calls := imiddleware.Feature(func() bool { return config.Current().FeatureCalls })
+	channels := imiddleware.Feature(func() bool { return config.Current().FeatureChannels })
+
+	e.GET("/capabilities", h.GetCapabilities)
+
+	federated := imiddleware.Feature(func() bool { return h.Federation != nil })
+	e.GET(federation.WellKnownPath, h.GetServerInfo, federated)
+	e.POST(federation.MessagesPath, h.ReceiveFederatedMessage, federated)
+	e.GET("/federation/v1/users/:username/keys", h.GetFederatedKeys, federated)
+
+	emailGateway := imiddleware.Feature(func() bool { return h.Config.EmailGatewayDomain != "" })
+	e.POST("/email/inbound", h.ReceiveEmail, emailGateway)
+	e.POST("/messages/:id/email-reply", access(h.ReplyToEmail), emailGateway)
+
+	e.POST("/signup", imiddleware.UserAuth(h.SignUp))
+	e.POST("/signin", imiddleware.UserAuth(h.SignIn))
+	e.POST("/signin/magic", h.RequestMagicLink)
+	e.POST("/signin/magic/redeem", h.RedeemMagicLink)
+	e.POST("/refresh-token", refresh(h.RefreshToken))
+	e.POST("/signout", h.SignOut)
+	e.POST("/passkeys/register/begin", access(h.BeginPasskeyRegistration))
+	e.POST("/passkeys/register/finish", access(h.FinishPasskeyRegistration))
+	e.POST("/passkeys/login/begin", h.BeginPasskeyLogin)
+	e.POST("/passkeys/login/finish", h.FinishPasskeyLogin)
+
+	e.GET("/users/:id/profile", access(imiddleware.ETag(h.GetProfile)))
+	e.GET("/users/by-name/:username", access(h.GetProfileByName))
+	e.POST("/mqtt/token", access(h.MQTTToken))
+	e.PUT("/me/username", access(h.ChangeUsername))
+	e.GET("/me/name-claims", access(h.ListMyNameClaims))
+	e.POST("/me/name-claims", access(h.ClaimName))
+	e.PUT("/me/public-key", access(h.SetPublicKey))
+	e.POST("/keys/bulk-fetch", access(imiddleware.UserRateLimit(keyFetchRateLimit)(h.BulkFetchKeys)))
+	e.GET("/me/sender-certificate", access(h.GetSenderCertificate))
+	e.POST("/me/two-factor/totp", access(h.BeginTOTP))
+	e.POST("/me/two-factor/totp/confirm", access(h.ConfirmTOTP))
+	e.POST("/me/two-factor/recovery-codes", access(h.RegenerateRecoveryCodes))
+	e.DELETE("/me/two-factor", access(h.DisableTwoFactor))
+	e.POST("/me/contact-token", access(h.CreateContactToken))
+	e.GET("/contacts/resolve/:token", access(h.ResolveContactToken))
+	e.POST("/me/email", access(h.RequestEmailChange))
+	e.DELETE("/me", access(h.DeleteAccount))
+	e.GET("/me/usage", access(h.GetUsage))
+	e.GET("/me/sessions", access(h.ListSessions))
+	e.POST("/me/sessions/:id/logout", access(h.EndSession))
+	e.POST("/email/confirm", h.ConfirmEmailChange)
+	e.POST("/logins/report", h.ReportLogin)
+	e.POST("/me/push-tokens", access(h.RegisterPushToken))
+	e.DELETE("/me/push-tokens/:token", access(h.DeletePushToken))
+	e.PUT("/presence/heartbeat", access(h.Heartbeat))
+	e.PUT("/me/status", access(h.SetStatus))
+	e.GET("/users/:id/status", access(h.GetStatus))
+	e.GET("/me/quiet-hours", access(h.GetQuietHours))
+	e.PUT("/me/quiet-hours", access(h.SetQuietHours))
+	e.PUT("/me/locale", access(h.SetLocale))
+	e.GET("/me/invites", access(h.GetMyInvites))
+	e.POST("/me/invites", access(h.CreateInvite))
+	e.POST("/messages", access(h.SendMessage))
+	e.POST("/messages/sealed", access(h.SendSealedMessage))
+	e.GET("/messages/unread", access(h.GetUnreadMessages))
+	e.POST("/messages/read", access(h.MarkMessagesRead))
+	e.POST("/messages/receipts", access(h.MarkDeviceReceipts))
+	e.GET("/messages/:id/device-receipts", access(h.GetDeviceReceipts))
+	e.DELETE("/messages/:id", access(h.DeleteMessage))
+	e.GET("/messages/starred", access(h.GetStarredMessages))
+	e.PUT("/messages/:id/star", access(h.StarMessage))
+	e.DELETE("/messages/:id/star", access(h.UnstarMessage))
+	e.GET("/messages/:id/receipts", access(h.GetMessageReceipts), groups)
+	e.POST("/groups", access(h.CreateGroup), groups)
+	e.GET("/groups/:id", access(h.GetGroup), groups)
+	e.GET("/groups/:id/messages", access(h.GetGroupMessages), groups)
+	e.POST("/groups/:id/receipts", access(h.MarkGroupReceipts), groups)
+	e.POST("/groups/:id/typing", access(h.SendGroupTyping), groups)
+	e.DELETE("/groups/:id/typing", access(h.StopGroupTyping), groups)
+	e.POST("/groups/:id/members", access(h.AddGroupMember), groups)
+	e.POST("/orgs", access(h.CreateOrganization))
+	e.GET("/orgs/:id", access(h.GetOrganization))
+	e.PUT("/orgs/:id/settings", access(h.SetOrgSettings))
+	e.GET("/orgs/:id/members", access(h.GetOrgMembers))
+	e.PUT("/orgs/:id/members/:userId", access(h.SetOrgMember))
+	e.DELETE("/orgs/:id/members/:userId", access(h.RemoveOrgMember))
+	e.GET("/orgs/:id/content", access(h.GetOrgContent))
+	e.POST("/attachments", access(h.UploadAttachment))
+	e.POST("/uploads", access(h.CreateUpload))
+	e.HEAD("/uploads/:id", access(h.UploadStatus))
+	e.PATCH("/uploads/:id", access(h.UploadChunk))
+	e.POST("/uploads/:id/finalize", access(h.FinalizeUpload))
+	e.DELETE("/uploads/:id", access(h.AbortUpload))
+	e.GET("/attachments/:id", access(h.DownloadAttachment))
+	e.DELETE("/attachments/:id", access(h.DeleteAttachment))
+	e.GET("/attachments/:id/thumbnail", access(h.DownloadThumbnail))
+	e.GET("/attachments/:id/url", access(h.GetMediaURL))
+	e.GET("/media/:id", h.ServeMedia)
+	e.GET("/announcements", access(h.GetAnnouncements))
+	e.POST("/channels", access(h.CreateChannel), channels)
+	e.GET("/channels/:id/posts", access(h.GetChannelPosts), channels)
+	e.POST("/channels/:id/posts", access(h.CreatePost), channels)
+	e.PUT("/attachments/:id/thumbnail", access(h.UploadThumbnail))
+	e.GET("/conversations", access(h.ListConversations))
+	e.GET("/conversations/:peerId", access(h.GetConversation))
+	e.POST("/conversations/:peerId/typing", access(h.SendTyping))
+	e.PUT("/conversations/:peerId/encryption", access(h.SetConversationEncryption))
+	e.GET("/conversations/:peerId/settings", access(h.GetConversationSettings))
+	e.PUT("/conversations/:peerId/settings", access(h.SetConversationSettings))
+	e.DELETE("/conversations/:peerId/settings", access(h.DeleteConversationSettings))
+	e.GET("/me/labels", access(h.GetLabels))
+	e.GET("/conversations/:peerId/export", access(imiddleware.UserRateLimit(exportRateLimit)(h.ExportConversation)))
+	e.POST("/imports", access(h.ImportConversation))
+	e.GET("/calls", access(h.GetCalls), calls)
+	e.POST("/calls", access(h.StartCall), calls)
+	e.POST("/calls/:id/accept", access(h.AcceptCall), calls)
+	e.POST("/calls/:id/decline", access(h.DeclineCall), calls)
+	e.POST("/calls/:id/end", access(h.EndCall), calls)
+
+	// who may call each admin route is declared with it, a route added
+	// without a rule is denied to everyone
+	adminPolicy := policy.NewTable()
+	admin := e.Group("/admin", access, imiddleware.Authorize(adminPolicy, h.DB.GetUser))
+	allow := func(method string, path string, rule policy.Rule, handler echo.HandlerFunc) {
+		adminPolicy.Set(method, "/admin"+path, rule)
+		admin.Add(method, path, handler)
+	}
+	admins := policy.All(policy.Role(models.RoleAdmin), policy.Scope(core.ScopeAdmin))
+	moderators := policy.All(policy.Role(models.RoleAdmin, models.RoleModerator), policy.Scope(core.ScopeAdmin))
+
+	allow(http.MethodGet, "/ip-rules", admins, h.ListIPRules)
+	allow(http.MethodPost, "/ip-rules", admins, h.CreateIPRule)
+	allow(http.MethodDelete, "/ip-rules/:id", admins, h.DeleteIPRule)
+	allow(http.MethodGet, "/trash", admins, h.ListTrash)
+	allow(http.MethodPost, "/users/:id/restore", admins, h.RestoreUser)
+	allow(http.MethodPost, "/messages/:id/restore", admins, h.RestoreMessage)
+	allow(http.MethodGet, "/quarantine", moderators, h.ListQuarantine)
+	allow(http.MethodPost, "/attachments/:id/release", moderators, h.ReleaseAttachment)
+	allow(http.MethodDelete, "/attachments/:id", moderators, h.PurgeAttachment)
+	allow(http.MethodGet, "/moderation", moderators, h.ListModerationQueue)
+	allow(http.MethodPost, "/moderation/:id/approve", moderators, h.ApprovePost)
+	allow(http.MethodPost, "/moderation/:id/reject", moderators, h.RejectPost)
+	allow(http.MethodGet, "/announcements", admins, h.ListAllAnnouncements)
+	allow(http.MethodPost, "/announcements", admins, h.CreateAnnouncement)
+	allow(http.MethodDelete, "/announcements/:id", admins, h.DeleteAnnouncement)
+	allow(http.MethodGet, "/maintenance", admins, h.GetMaintenance)
+	allow(http.MethodPut, "/maintenance", admins, h.SetMaintenance)
+	allow(http.MethodGet, "/broker/snapshot", admins, h.ExportBrokerSnapshot)
+	allow(http.MethodPut, "/broker/snapshot", admins, h.ImportBrokerSnapshot)
+	allow(http.MethodGet, "/spam", moderators, h.ListSpamSuspects)
+	allow(http.MethodGet, "/invites", admins, h.ListInvites)
+	allow(http.MethodPost, "/invites", admins, h.CreateBulkInvites)
+	allow(http.MethodPut, "/users/:id/spam", admins, h.SetSpamOverride)
+	allow(http.MethodGet, "/stats", admins, h.GetStats)
+	allow(http.MethodGet, "/overview", admins, h.GetOverview)
+	allow(http.MethodGet, "/client-version", admins, h.GetClientVersionPolicy)
+	allow(http.MethodPut, "/client-version", admins, h.SetClientVersionPolicy)
+	allow(http.MethodPut, "/users/:id/account", admins, h.SetAccountState)
+	allow(http.MethodPost, "/users/:id/sessions/:sessionId/logout", admins, h.EndUserSession)
+	allow(http.MethodPost, "/users/:id/support-sessions", admins, imiddleware.UserRateLimit(supportRateLimit)(h.StartSupportSession))
+	allow(http.MethodGet, "/support-sessions", admins, h.ListSupportSessions)
+	allow(http.MethodDelete, "/support-sessions/:id", admins, h.RevokeSupportSession)
+	allow(http.MethodGet, "/reserved-names", admins, h.ListReservedNames)
+	allow(http.MethodPost, "/reserved-names", admins, h.CreateReservedName)
+	allow(http.MethodDelete, "/reserved-names/:id", admins, h.DeleteReservedName)
+	allow(http.MethodGet, "/name-claims", moderators, h.ListNameClaims)
+	allow(http.MethodPost, "/name-claims/:id/approve", moderators, h.ApproveNameClaim)
+	allow(http.MethodPost, "/name-claims/:id/reject", moderators, h.RejectNameClaim)
+
+	e.GET("/support/account", support(h.GetSupportAccount))
+
+	scim := e.Group("/scim/v2")
+	scim.GET("/Users", imiddleware.ProvisioningAuth(h.ListProvisionedUsers))
+	scim.POST("/Users", imiddleware.ProvisioningAuth(h.CreateProvisionedUser))
+	scim.GET("/Users/:id", imiddleware.ProvisioningAuth(h.GetProvisionedUser))
+	scim.PATCH("/Users/:id", imiddleware.ProvisioningAuth(h.PatchProvisionedUser))
+	scim.DELETE("/Users/:id", imiddleware.ProvisioningAuth(h.DeleteProvisionedUser))
+
+	if h.Config.BrokerMode == broker.ModeBridge {
+		e.POST("/mqtt/auth", h.MQTTAuth)
+		e.POST("/mqtt/acl", h.MQTTACL)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// assetsDir holds files with a content hash in their name, they never
+// change and are cached for good.
+const assetsDir = "assets/"
+
+type static struct {
+	files  fs.FS
+	maxAge time.Duration
+}
+
+// Static serves the web client in files on every GET no API route takes.
+// Paths with no file behind them are routes of the client and get
+// index.html, unless the request does not ask for HTML, so API clients
+// still see 404 for a path that does not exist.
+func Static(e *echo.Echo, files fs.FS, maxAge time.Duration) {
+	s := &static{files: files, maxAge: maxAge}
+	e.GET("/*", s.serve)
+}
+
+func (s *static) serve(c echo.Context) error {
+	name, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	if s.servable(name) {
+		c.Response().Header().Set(echo.HeaderCacheControl, s.cacheControl(name))
+		return c.FileFS(name, s.files)
+	}
+	if !strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML) {
+		return echo.ErrNotFound
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, s.cacheControl("index.html"))
+	return c.FileFS("index.html", s.files)
+}
+
+// servable tells whether name is a regular file that is not hidden, such
+// as .gitkeep or editor leftovers.
+func (s *static) servable(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	info, err := fs.Stat(s.files, name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// cacheControl makes clients check index.html on every load, it names the
+// assets of the current build, and keep everything else for maxAge.
+func (s *static) cacheControl(name string) string {
+	switch {
+	case name == "index.html":
+		return "no-cache"
+	case strings.HasPrefix(name, assetsDir):
+		return "public, max-age=31536000, immutable"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(s.maxAge.Seconds()))
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStaticFallsBackToIndex(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":       {Data: []byte("<html>client</html>")},
+		"assets/app-1a.js": {Data: []byte("app")},
+		"robots.txt":       {Data: []byte("robots")},
+		".env":             {Data: []byte("secret")},
+	}
+	e := echo.New()
+	e.GET("/capabilities", func(c echo.Context) error { return c.String(http.StatusOK, "api") })
+	Static(e, files, time.Hour)
+
+	for _, test := range []struct {
+		path, accept string
+		status       int
+		body, cache  string
+	}{
+		{"/", "text/html", http.StatusOK, "<html>client</html>", "no-cache"},
+		{"/assets/app-1a.js", "*/*", http.StatusOK, "app", "public, max-age=31536000, immutable"},
+		{"/robots.txt", "*/*", http.StatusOK, "robots", "public, max-age=3600"},
+		{"/chats/42", "text/html,application/xhtml+xml", http.StatusOK, "<html>client</html>", "no-cache"},
+		{"/chats/42", "application/json", http.StatusNotFound, "", ""},
+		{"/.env", "application/json", http.StatusNotFound, "", ""},
+		{"/capabilities", "text/html", http.StatusOK, "api", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set(echo.HeaderAccept, test.accept)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, rec.Code)
+			continue
+		}
+		if test.body != "" && rec.Body.String() != test.body {
+			t.Errorf("%s: expected %q, got %q", test.path, test.body, rec.Body.String())
+		}
+		if got := rec.Header().Get(echo.HeaderCacheControl); test.cache != "" && got != test.cache {
+			t.Errorf("%s: expected Cache-Control %q, got %q", test.path, test.cache, got)
+		}
+	}
+}
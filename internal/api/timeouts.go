@@ -0,0 +1,53 @@
+package api
+
+import (
+	"filachat/pkg/config"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type requestTimeout func(cfg *config.Config) time.Duration
+
+func authTimeout(cfg *config.Config) time.Duration { return cfg.AuthRequestTimeout }
+
+func longTimeout(cfg *config.Config) time.Duration { return cfg.LongRequestTimeout }
+
+// untimed routes move files of any size, a deadline would cut slow
+// clients off halfway.
+func untimed(*config.Config) time.Duration { return 0 }
+
+// timeouts holds the deadline of every route that does not get
+// REQUEST_TIMEOUT, keyed by method and route path like bodyLimits.
+var timeouts = map[string]requestTimeout{
+	http.MethodPost + " /signup":                      authTimeout,
+	http.MethodPost + " /signin":                      authTimeout,
+	http.MethodPost + " /signin/magic":                authTimeout,
+	http.MethodPost + " /signin/magic/redeem":         authTimeout,
+	http.MethodPost + " /refresh-token":               authTimeout,
+	http.MethodPost + " /signout":                     authTimeout,
+	http.MethodPost + " /passkeys/login/begin":        authTimeout,
+	http.MethodPost + " /passkeys/login/finish":       authTimeout,
+	http.MethodPost + " /mqtt/auth":                   authTimeout,
+	http.MethodPost + " /mqtt/acl":                    authTimeout,
+	http.MethodGet + " /conversations/:peerId/export": longTimeout,
+	http.MethodPost + " /imports":                     longTimeout,
+	http.MethodGet + " /admin/overview":               longTimeout,
+	http.MethodGet + " /admin/stats":                  longTimeout,
+	http.MethodGet + " /admin/broker/snapshot":        longTimeout,
+	http.MethodPut + " /admin/broker/snapshot":        longTimeout,
+	http.MethodPost + " /attachments":                 untimed,
+	http.MethodGet + " /attachments/:id":              untimed,
+	http.MethodPut + " /attachments/:id/thumbnail":    untimed,
+	http.MethodPatch + " /uploads/:id":                untimed,
+	http.MethodGet + " /media/:id":                    untimed,
+}
+
+// Timeout returns the deadline of the route c matched, 0 for none.
+func Timeout(c echo.Context) time.Duration {
+	cfg := config.Current()
+	if timeout, ok := timeouts[c.Request().Method+" "+c.Path()]; ok {
+		return timeout(cfg)
+	}
+	return cfg.RequestTimeout
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"filachat/internal/core"
+	"filachat/pkg/config"
+)
+
+// App carries what used to be package singletons in core: the token factory
+// with its signing keys and secrets, and the password hasher. server.New
+// builds one and hands it to the handlers, middleware and broker hooks, so
+// tests and several instances in one process can each have their own.
+type App struct {
+	Tokens  *core.JWTTokens
+	Hashing *core.Hashing
+}
+
+// New builds the App for cfg with the signing keys from keyDir and the
+// token secrets from the environment.
+func New(cfg *config.Config, keyDir string) (*App, error) {
+	keys, err := core.LoadKeys(keyDir)
+	if err != nil {
+		return nil, err
+	}
+	access, refresh, err := core.LoadSecrets()
+	if err != nil {
+		return nil, err
+	}
+	return &App{
+		Tokens: &core.JWTTokens{
+			Issuer:         cfg.TokenIssuer,
+			TrustedIssuers: cfg.TokenTrustedIssuers,
+			Audience:       cfg.TokenAudience,
+			Keys:           keys,
+			AccessSecret:   access,
+			RefreshSecret:  refresh,
+		},
+		Hashing: core.NewHashing(),
+	}, nil
+}
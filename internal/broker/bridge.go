@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/metrics"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"time"
+)
+
+const publishTimeout = 5 * time.Second
+
+var ErrPublishTimeout = errors.New("publish not acknowledged in time")
+
+// Bridge publishes through an external broker such as EMQX or Mosquitto.
+// The broker authenticates this client like any other, through the auth
+// webhook, with the bridge credentials from the config.
+type Bridge struct {
+	client paho.Client
+}
+
+func Dial(address string, clientID string, username string, password string) (*Bridge, error) {
+	options := paho.NewClientOptions().
+		AddBroker(address).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Println("[WARN] bridge connection lost", err)
+		})
+
+	client := paho.NewClient(options)
+	token := client.Connect()
+	if !token.WaitTimeout(publishTimeout) {
+		// with connect retry on the client keeps trying in the background
+		log.Println("[WARN] bridge not connected yet to", address)
+	} else if token.Error() != nil {
+		return nil, token.Error()
+	}
+	return &Bridge{client: client}, nil
+}
+
+func (b *Bridge) Publish(ctx context.Context, topic string, payload []byte, retain bool, qos byte) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	// the external broker counts its own traffic, only ours is seen here
+	metrics.Topics.Add(topic)
+	token := b.client.Publish(topic, qos, retain, payload)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrPublishTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+func (b *Bridge) Close() {
+	b.client.Disconnect(250)
+}
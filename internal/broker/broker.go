@@ -0,0 +1,33 @@
+package broker
+
+import (
+	"context"
+	mqtt "github.com/mochi-mqtt/server/v2"
+)
+
+// Publisher is the one way events leave this service: handlers, workers and
+// the broker hooks all publish through it. Embedded implements it for the
+// mochi server in process, Bridge for an external broker. The context is
+// the one of the request or job publishing.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, payload []byte, retain bool, qos byte) error
+}
+
+// Embedded publishes through the broker running in process.
+type Embedded struct {
+	*mqtt.Server
+}
+
+// Publish has nothing to wait for in process, so the context only stops a
+// publish for a request that is gone already.
+func (e Embedded) Publish(ctx context.Context, topic string, payload []byte, retain bool, qos byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.Server.Publish(topic, payload, retain, qos)
+}
+
+const (
+	ModeEmbedded = "embedded"
+	ModeBridge   = "bridge"
+)
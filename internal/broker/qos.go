@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Class is a kind of event the service publishes. Each class is published
+// with the QoS the policy gives it: chat messages must arrive, typing and
+// retained state are replaced soon enough to be sent at most once.
+type Class string
+
+const (
+	ClassMessages      Class = "messages"
+	ClassTyping        Class = "typing"
+	ClassBadges        Class = "badges"
+	ClassPresence      Class = "presence"
+	ClassCalls         Class = "calls"
+	ClassChannels      Class = "channels"
+	ClassSystem        Class = "system"
+	ClassAnnouncements Class = "announcements"
+	ClassEvents        Class = "events"
+)
+
+func DefaultQoS() map[Class]byte {
+	return map[Class]byte{
+		ClassMessages:      1,
+		ClassTyping:        0,
+		ClassBadges:        0,
+		ClassPresence:      0,
+		ClassCalls:         1,
+		ClassChannels:      1,
+		ClassSystem:        1,
+		ClassAnnouncements: 1,
+		ClassEvents:        0,
+	}
+}
+
+// Policy is set once at startup, before anything publishes.
+var Policy = DefaultQoS()
+
+func QoS(class Class) byte {
+	return Policy[class]
+}
+
+// ParseQoS reads class=qos entries, as in MQTT_QOS=typing=0,messages=1, on
+// top of the defaults.
+func ParseQoS(entries []string) (map[Class]byte, error) {
+	policy := DefaultQoS()
+	for _, entry := range entries {
+		name, level, ok := strings.Cut(entry, "=")
+		class := Class(strings.TrimSpace(name))
+		if _, known := policy[class]; !ok || !known {
+			return nil, fmt.Errorf("unknown qos entry %q", entry)
+		}
+		switch strings.TrimSpace(level) {
+		case "0":
+			policy[class] = 0
+		case "1":
+			policy[class] = 1
+		case "2":
+			policy[class] = 2
+		default:
+			return nil, fmt.Errorf("qos of %s must be 0, 1 or 2", class)
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,22 @@
+package broker
+
+import "testing"
+
+func TestParseQoS(t *testing.T) {
+	policy, err := ParseQoS([]string{"typing=1", " messages = 2 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy[ClassTyping] != 1 || policy[ClassMessages] != 2 {
+		t.Fatalf("entries not applied: %v", policy)
+	}
+	if policy[ClassBadges] != 0 || policy[ClassSystem] != 1 {
+		t.Fatalf("defaults lost: %v", policy)
+	}
+
+	for _, entries := range [][]string{{"typing"}, {"unknown=1"}, {"typing=3"}, {"typing="}} {
+		if _, err := ParseQoS(entries); err == nil {
+			t.Errorf("%q accepted", entries)
+		}
+	}
+}
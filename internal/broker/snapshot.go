@@ -0,0 +1,132 @@
+package broker
+
+import (
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"strings"
+	"time"
+)
+
+// Snapshot is the state of the embedded broker that outlives a connection:
+// retained messages, such as the control events offline clients replay,
+// and the subscriptions of the sessions it holds. It moves the broker to
+// another host; the $SYS topics are left out, the broker keeps them.
+type Snapshot struct {
+	CreatedAt     time.Time         `json:"created_at"`
+	Retained      []RetainedMessage `json:"retained"`
+	Subscriptions []Subscription    `json:"subscriptions"`
+}
+
+type RetainedMessage struct {
+	Topic       string                 `json:"topic"`
+	Payload     []byte                 `json:"payload"`
+	QoS         byte                   `json:"qos"`
+	ContentType string                 `json:"content_type,omitempty"`
+	User        []packets.UserProperty `json:"user_properties,omitempty"`
+	Created     time.Time              `json:"created"`
+	// Expiry is when the message stops being retained, zero for never.
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+type Subscription struct {
+	ClientID          string `json:"client_id"`
+	Filter            string `json:"filter"`
+	QoS               byte   `json:"qos"`
+	NoLocal           bool   `json:"no_local,omitempty"`
+	RetainAsPublished bool   `json:"retain_as_published,omitempty"`
+	RetainHandling    byte   `json:"retain_handling,omitempty"`
+}
+
+// TakeSnapshot copies the retained messages and subscriptions of server.
+func TakeSnapshot(server *mqtt.Server) Snapshot {
+	snapshot := Snapshot{CreatedAt: time.Now(), Retained: []RetainedMessage{}, Subscriptions: []Subscription{}}
+	for _, pk := range server.Topics.Messages("#") {
+		message := RetainedMessage{
+			Topic:       pk.TopicName,
+			Payload:     pk.Payload,
+			QoS:         pk.FixedHeader.Qos,
+			ContentType: pk.Properties.ContentType,
+			User:        pk.Properties.User,
+			Created:     time.Unix(pk.Created, 0),
+		}
+		if pk.Expiry > 0 {
+			message.Expiry = time.Unix(pk.Expiry, 0)
+		}
+		snapshot.Retained = append(snapshot.Retained, message)
+	}
+	for _, client := range server.Clients.GetAll() {
+		if client.Net.Inline {
+			continue
+		}
+		for _, sub := range client.State.Subscriptions.GetAll() {
+			snapshot.Subscriptions = append(snapshot.Subscriptions, Subscription{
+				ClientID:          client.ID,
+				Filter:            sub.Filter,
+				QoS:               sub.Qos,
+				NoLocal:           sub.NoLocal,
+				RetainAsPublished: sub.RetainAsPublished,
+				RetainHandling:    sub.RetainHandling,
+			})
+		}
+	}
+	return snapshot
+}
+
+// Valid reports whether every retained message has a topic without
+// wildcards and every subscription a client and a filter, all at QoS 0 to 2.
+func (s Snapshot) Valid() bool {
+	for _, message := range s.Retained {
+		if message.Topic == "" || strings.ContainsAny(message.Topic, "+#") || message.QoS > 2 {
+			return false
+		}
+	}
+	for _, sub := range s.Subscriptions {
+		if sub.ClientID == "" || sub.Filter == "" || sub.QoS > 2 || sub.RetainHandling > 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// RestoreSnapshot adds the retained messages and subscriptions of snapshot
+// to server, replacing retained messages on the same topics. Messages that
+// expired in the meantime are skipped. Subscriptions of clients the broker
+// does not know yet are indexed under their client id and deliver once a
+// client connects with it, which the signed client ids keep stable. It
+// returns how many of each were restored.
+func RestoreSnapshot(server *mqtt.Server, snapshot Snapshot) (retained int, subscriptions int) {
+	now := time.Now()
+	for _, message := range snapshot.Retained {
+		if len(message.Payload) == 0 || (!message.Expiry.IsZero() && !message.Expiry.After(now)) {
+			continue
+		}
+		pk := packets.Packet{
+			FixedHeader: packets.FixedHeader{Type: packets.Publish, Qos: message.QoS, Retain: true},
+			TopicName:   message.Topic,
+			Payload:     message.Payload,
+			Properties:  packets.Properties{ContentType: message.ContentType, User: message.User},
+			Created:     message.Created.Unix(),
+			Origin:      mqtt.InlineClientId,
+		}
+		if !message.Expiry.IsZero() {
+			pk.Expiry = message.Expiry.Unix()
+		}
+		server.Topics.RetainMessage(pk)
+		retained++
+	}
+	for _, sub := range snapshot.Subscriptions {
+		subscription := packets.Subscription{
+			Filter:            sub.Filter,
+			Qos:               sub.QoS,
+			NoLocal:           sub.NoLocal,
+			RetainAsPublished: sub.RetainAsPublished,
+			RetainHandling:    sub.RetainHandling,
+		}
+		server.Topics.Subscribe(sub.ClientID, subscription)
+		if client, ok := server.Clients.Get(sub.ClientID); ok {
+			client.State.Subscriptions.Add(sub.Filter, subscription)
+		}
+		subscriptions++
+	}
+	return retained, subscriptions
+}
@@ -89,13 +89,17 @@ func (argon *Argon) Verify(plain []byte, encoded string) error {
 	if subtle.ConstantTimeCompare(newHash, decodedHash) == 1 {
 		return nil
 	}
-	return errors.New("invalid username or password")
+	return ErrPasswordMismatch
 }
 
-var Hashing *Argon = &Argon{
-	Memory:      32 * 1024,
-	Iterations:  1,
-	Parallelism: 1,
-	SaltLength:  32,
-	KeyLength:   64,
+// NewArgon returns the parameters new password hashes are made with. Verify
+// reads them from each hash, so changing them leaves older hashes valid.
+func NewArgon() *Argon {
+	return &Argon{
+		Memory:      32 * 1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  32,
+		KeyLength:   64,
+	}
 }
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"strings"
+)
+
+var ErrInvalidClientID = errors.New("invalid client id")
+
+// ClientIDs signs MQTT client ids, so a client cannot connect with the id
+// of another session and take over its queued messages. An id is the user
+// id and device id in hex and a base64url MAC over both, joined by dots.
+type ClientIDs struct {
+	Key []byte
+}
+
+func (c *ClientIDs) Issue(user bson.ObjectID, device bson.ObjectID) string {
+	payload := user.Hex() + "." + device.Hex()
+	return payload + "." + base64.RawURLEncoding.EncodeToString(c.mac(payload))
+}
+
+// Verify returns the user and device a client id was issued for.
+func (c *ClientIDs) Verify(id string) (bson.ObjectID, bson.ObjectID, error) {
+	payload, signature, found := cutLast(id, ".")
+	if !found {
+		return bson.NilObjectID, bson.NilObjectID, ErrInvalidClientID
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, c.mac(payload)) {
+		return bson.NilObjectID, bson.NilObjectID, ErrInvalidClientID
+	}
+
+	userHex, deviceHex, _ := strings.Cut(payload, ".")
+	user, err := bson.ObjectIDFromHex(userHex)
+	if err != nil {
+		return bson.NilObjectID, bson.NilObjectID, ErrInvalidClientID
+	}
+	device, err := bson.ObjectIDFromHex(deviceHex)
+	if err != nil {
+		return bson.NilObjectID, bson.NilObjectID, ErrInvalidClientID
+	}
+	return user, device, nil
+}
+
+func (c *ClientIDs) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte("filagram mqtt client\n"))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+)
+
+func TestClientIDRoundTrip(t *testing.T) {
+	ids := &ClientIDs{Key: []byte("secret")}
+	user, device := bson.NewObjectID(), bson.NewObjectID()
+
+	id := ids.Issue(user, device)
+	gotUser, gotDevice, err := ids.Verify(id)
+	if err != nil || gotUser != user || gotDevice != device {
+		t.Fatalf("Verify(%q) = %s, %s, %v", id, gotUser.Hex(), gotDevice.Hex(), err)
+	}
+
+	// the signature does not carry over to another user
+	forged := bson.NewObjectID().Hex() + id[len(user.Hex()):]
+	if _, _, err := ids.Verify(forged); err != ErrInvalidClientID {
+		t.Errorf("Expected ErrInvalidClientID for a forged id, got %v", err)
+	}
+	if _, _, err := (&ClientIDs{Key: []byte("other")}).Verify(id); err != ErrInvalidClientID {
+		t.Errorf("Expected ErrInvalidClientID with another key, got %v", err)
+	}
+	if _, _, err := ids.Verify("mobile-1234"); err != ErrInvalidClientID {
+		t.Errorf("Expected ErrInvalidClientID for an unsigned id, got %v", err)
+	}
+}
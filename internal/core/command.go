@@ -0,0 +1,60 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// Command is an order the server gives one session of a user, which the
+// client carries out without asking, e.g. on a device reported lost.
+type Command string
+
+const (
+	// CommandLogout: drop the tokens and cached keys of the session.
+	CommandLogout Command = "logout"
+	// CommandWipe: log out and delete every local copy of messages and
+	// attachments as well.
+	CommandWipe Command = "wipe"
+)
+
+// IssuedByCommand is the endpoint commands are issued by, see SignCommand.
+const IssuedByCommand = "command"
+
+// commandClaims is the wire form of a signed command. It has no typ, so it
+// never passes for an access token.
+type commandClaims struct {
+	jwt.RegisteredClaims
+	Command Command `json:"cmd"`
+}
+
+// SignCommand signs command for a session of user with the access key. The
+// client checks the signature with CommandKey, which it got from the
+// server over TLS, so a command cannot be forged by whoever manages to
+// publish on its topic.
+func (j *JWTTokens) SignCommand(user bson.ObjectID, session bson.ObjectID, command Command, expires time.Time) (string, error) {
+	var audience jwt.ClaimStrings
+	if j.Audience != "" {
+		audience = jwt.ClaimStrings{j.Audience}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, commandClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        session.Hex(),
+			Subject:   user.Hex(),
+			Issuer:    issuerURL(j.Issuer, IssuedByCommand),
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		Command: command,
+	})
+	return token.SignedString(j.Keys.AccessPrivateKey)
+}
+
+// CommandKey is the public key commands are signed with, nil when the
+// access key is not an Ed25519 key.
+func (j *JWTTokens) CommandKey() ed25519.PublicKey {
+	key, _ := j.Keys.AccessPublicKey.(ed25519.PublicKey)
+	return key
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestSignedCommandVerifiesWithCommandKey(t *testing.T) {
+	tokens := &JWTTokens{Issuer: "https://auth.filagram.pl", Keys: setupKeys(t)}
+	user, session := bson.NewObjectID(), bson.NewObjectID()
+
+	signed, err := tokens.SignCommand(user, session, CommandWipe, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &commandClaims{}
+	_, err = jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+		return tokens.CommandKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Command != CommandWipe || claims.Subject != user.Hex() || claims.ID != session.Hex() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := tokens.Verify(signed, AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("command accepted as access token: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// contactMACSize is the truncated HMAC length. Tokens end up in QR codes,
+// where every byte makes the code denser, and cannot be checked offline.
+const contactMACSize = 12
+
+// ContactTokens issues short signed tokens naming a user, for QR codes and
+// deep links that add a contact without typing an id. A token is the user
+// id, the expiry in unix seconds and a MAC over both, 38 characters once
+// base64url encoded.
+type ContactTokens struct {
+	Key []byte
+	TTL time.Duration
+}
+
+func (t *ContactTokens) Issue(user bson.ObjectID) (string, time.Time) {
+	expires := time.Now().Add(t.TTL).Truncate(time.Second)
+
+	raw := make([]byte, 0, len(user)+4+contactMACSize)
+	raw = append(raw, user[:]...)
+	raw = binary.BigEndian.AppendUint32(raw, uint32(expires.Unix()))
+	raw = append(raw, t.mac(raw)...)
+	return base64.RawURLEncoding.EncodeToString(raw), expires
+}
+
+// Resolve returns the user a token was issued for.
+func (t *ContactTokens) Resolve(token string) (bson.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) != len(bson.ObjectID{})+4+contactMACSize {
+		return bson.NilObjectID, ErrInvalidToken
+	}
+	payload, mac := raw[:len(raw)-contactMACSize], raw[len(raw)-contactMACSize:]
+	if !hmac.Equal(mac, t.mac(payload)) {
+		return bson.NilObjectID, ErrInvalidToken
+	}
+
+	var user bson.ObjectID
+	copy(user[:], payload)
+	expires := time.Unix(int64(binary.BigEndian.Uint32(payload[len(user):])), 0)
+	if time.Now().After(expires) {
+		return bson.NilObjectID, ErrTokenExpired
+	}
+	return user, nil
+}
+
+func (t *ContactTokens) mac(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.Key)
+	mac.Write([]byte("filagram contact\n"))
+	mac.Write(payload)
+	return mac.Sum(nil)[:contactMACSize]
+}
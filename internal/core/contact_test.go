@@ -0,0 +1,52 @@
+package core
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestContactTokenRoundTrip(t *testing.T) {
+	tokens := &ContactTokens{Key: []byte("secret"), TTL: time.Hour}
+	id := bson.NewObjectID()
+
+	token, expires := tokens.Issue(id)
+	if len(token) != 38 {
+		t.Fatalf("token %q has %d characters", token, len(token))
+	}
+	if expires.Before(time.Now()) {
+		t.Fatalf("token already expired at %v", expires)
+	}
+	resolved, err := tokens.Resolve(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != id {
+		t.Fatalf("resolved %s, want %s", resolved.Hex(), id.Hex())
+	}
+}
+
+func TestContactTokenRejected(t *testing.T) {
+	tokens := &ContactTokens{Key: []byte("secret"), TTL: time.Hour}
+	token, _ := tokens.Issue(bson.NewObjectID())
+
+	tampered := []byte(token)
+	tampered[3] ^= 1
+	other := &ContactTokens{Key: []byte("other"), TTL: time.Hour}
+	for name, check := range map[string]func() error{
+		"tampered":  func() error { _, err := tokens.Resolve(string(tampered)); return err },
+		"truncated": func() error { _, err := tokens.Resolve(token[:20]); return err },
+		"other key": func() error { _, err := other.Resolve(token); return err },
+	} {
+		if err := check(); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	expired := &ContactTokens{Key: []byte("secret"), TTL: -time.Minute}
+	token, _ = expired.Issue(bson.NewObjectID())
+	if _, err := expired.Resolve(token); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("got %v, want ErrTokenExpired", err)
+	}
+}
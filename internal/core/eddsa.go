@@ -1,6 +1,8 @@
 package core
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
@@ -15,38 +17,43 @@ type EdDSA struct {
 	RefreshPrivateKey any
 }
 
-func LoadKeys() error {
-	accessPrivateKeyPath := filepath.Join("secrets", "AccessPrivateKey.pem")
-	accessPublicKeyPath := filepath.Join("secrets", "AccessPublicKey.pem")
-	refreshPrivateKeyPath := filepath.Join("secrets", "RefreshPrivateKey.pem")
-	refreshPublicKeyPath := filepath.Join("secrets", "RefreshPublicKey.pem")
+const KeyDir = "secrets"
+
+// LoadKeys reads the key pairs GenerateKeys writes to dir.
+func LoadKeys(dir string) (*EdDSA, error) {
+	accessPrivateKeyPath := filepath.Join(dir, "AccessPrivateKey.pem")
+	accessPublicKeyPath := filepath.Join(dir, "AccessPublicKey.pem")
+	refreshPrivateKeyPath := filepath.Join(dir, "RefreshPrivateKey.pem")
+	refreshPublicKeyPath := filepath.Join(dir, "RefreshPublicKey.pem")
 
 	accessPrivateKey, err := loadKey(accessPrivateKeyPath, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	accessPublicKey, err := loadKey(accessPublicKeyPath, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	refreshPrivateKey, err := loadKey(refreshPrivateKeyPath, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	refreshPublicKey, err := loadKey(refreshPublicKeyPath, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	Ed25519Keys.AccessPublicKey = accessPublicKey
-	Ed25519Keys.AccessPrivateKey = accessPrivateKey
-	Ed25519Keys.RefreshPublicKey = refreshPublicKey
-	Ed25519Keys.RefreshPrivateKey = refreshPrivateKey
-	return nil
+	return &EdDSA{
+		AccessPublicKey:   accessPublicKey,
+		AccessPrivateKey:  accessPrivateKey,
+		RefreshPublicKey:  refreshPublicKey,
+		RefreshPrivateKey: refreshPrivateKey,
+	}, nil
 }
+
 func loadKey(path string, private bool) (any, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
@@ -80,4 +87,41 @@ func loadKey(path string, private bool) (any, error) {
 	}
 }
 
-var Ed25519Keys *EdDSA = &EdDSA{}
+var ErrKeysExist = errors.New("signing keys already exist")
+
+// GenerateKeys writes fresh access and refresh key pairs in the layout
+// LoadKeys reads. Existing keys are only replaced with overwrite set.
+func GenerateKeys(dir string, overwrite bool) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for _, name := range []string{"Access", "Refresh"} {
+		privatePath := filepath.Join(dir, name+"PrivateKey.pem")
+		if _, err := os.Stat(privatePath); err == nil && !overwrite {
+			return ErrKeysExist
+		}
+
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return err
+		}
+		publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+
+		privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+		if err := os.WriteFile(privatePath, privatePEM, 0o600); err != nil {
+			return err
+		}
+		publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+		if err := os.WriteFile(filepath.Join(dir, name+"PublicKey.pem"), publicPEM, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"io"
 )
 
@@ -39,7 +40,8 @@ func (encryption *JWTEncryption) Decrypt(ciphertext []byte, key []byte) ([]byte,
 	}
 
 	nonceSize := aesgcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
 	return aesgcm.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
 }
-
-var JWTEncrypter *JWTEncryption
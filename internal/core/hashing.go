@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrPasswordMismatch is returned by Verify for a wrong password.
+var ErrPasswordMismatch = errors.New("invalid username or password")
+
+// Algorithm hashes and verifies passwords in one encoded format, told
+// apart from the others by its prefix.
+type Algorithm interface {
+	Recognizes(encoded string) bool
+	Hash(plain []byte) (string, error)
+	Verify(plain []byte, encoded string) error
+}
+
+// Hashing writes every new password hash with Primary and verifies hashes
+// of any of its algorithms, so users imported from other systems with
+// bcrypt or scrypt hashes can sign in without resetting their password.
+// Their hash is replaced with a Primary one on the next sign-in, see
+// NeedsRehash.
+type Hashing struct {
+	Primary Algorithm
+	Legacy  []Algorithm
+}
+
+// NewHashing hashes with Argon2id and accepts bcrypt and scrypt hashes.
+func NewHashing() *Hashing {
+	return &Hashing{
+		Primary: NewArgon(),
+		Legacy:  []Algorithm{Bcrypt{}, Scrypt{}},
+	}
+}
+
+func (h *Hashing) Hash(plain []byte) (string, error) {
+	return h.Primary.Hash(plain)
+}
+
+func (h *Hashing) Verify(plain []byte, encoded string) error {
+	if h.Primary.Recognizes(encoded) {
+		return h.Primary.Verify(plain, encoded)
+	}
+	for _, algorithm := range h.Legacy {
+		if algorithm.Recognizes(encoded) {
+			return algorithm.Verify(plain, encoded)
+		}
+	}
+	return errors.New("unknown hash format")
+}
+
+// NeedsRehash tells whether encoded was made by a legacy algorithm and
+// should be replaced once the password is known again.
+func (h *Hashing) NeedsRehash(encoded string) bool {
+	return !h.Primary.Recognizes(encoded)
+}
+
+func (argon *Argon) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
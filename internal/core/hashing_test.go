@@ -0,0 +1,66 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+	"testing"
+)
+
+func TestHashingVerifiesLegacyHashes(t *testing.T) {
+	hashing := NewHashing()
+	password := []byte("correct horse battery staple")
+
+	bcryptHash, err := Bcrypt{Cost: 4}.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scryptHash, err := Scrypt{LogN: 10, R: 8, P: 1, SaltLength: 16, KeyLength: 32}.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argonHash, err := hashing.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []string{argonHash, bcryptHash, scryptHash} {
+		if err := hashing.Verify(password, hash); err != nil {
+			t.Errorf("Expected %s to verify, got %v", hash[:8], err)
+		}
+		if err := hashing.Verify([]byte("wrong"), hash); !errors.Is(err, ErrPasswordMismatch) {
+			t.Errorf("Expected a mismatch for %s, got %v", hash[:8], err)
+		}
+	}
+
+	if hashing.NeedsRehash(argonHash) {
+		t.Error("Expected an Argon2id hash to be kept")
+	}
+	if !hashing.NeedsRehash(bcryptHash) || !hashing.NeedsRehash(scryptHash) {
+		t.Error("Expected legacy hashes to be replaced")
+	}
+	if err := hashing.Verify(password, "$md5$whatever"); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+}
+
+func TestScryptReadsPasslibHashes(t *testing.T) {
+	// this salt encodes with +, which passlib writes as .
+	salt := []byte{0xfb, 0xef, 0xbe, 0xfb, 0xef, 0xbe, 0x01, 0x02, 0x03}
+	key, err := scrypt.Key([]byte("password"), salt, 1<<4, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapted := func(b []byte) string {
+		return strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(b), "+", ".")
+	}
+	encoded := "$scrypt$ln=4,r=8,p=1$" + adapted(salt) + "$" + adapted(key)
+	if !strings.Contains(encoded, ".") {
+		t.Fatal("Expected the salt to need adapted base64")
+	}
+
+	if err := (Scrypt{}).Verify([]byte("password"), encoded); err != nil {
+		t.Errorf("Expected the passlib hash to verify, got %v", err)
+	}
+}
@@ -1,14 +1,32 @@
 package core
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"strings"
 	"time"
 )
 
-type JWTTokens struct{}
+type JWTTokens struct {
+	// Issuer is the base URL tokens are issued under, the endpoint that
+	// issued a token is appended to it.
+	Issuer string
+	// TrustedIssuers are further base URLs accepted on verification, e.g.
+	// the previous domain while a deployment moves.
+	TrustedIssuers []string
+	// Audience is written into new tokens and, when set, required of every
+	// verified token.
+	Audience string
+
+	// Keys sign and verify the tokens, AccessSecret and RefreshSecret
+	// encrypt them for clients, see Seal.
+	Keys          *EdDSA
+	AccessSecret  []byte
+	RefreshSecret []byte
+}
 
 func If[T any](cond bool, vtrue, vfalse T) T {
 	if cond {
@@ -17,50 +35,214 @@ func If[T any](cond bool, vtrue, vfalse T) T {
 	return vfalse
 }
 
-func (j *JWTTokens) NewToken(id bson.ObjectID, iss string, access bool) (string, error) {
-	rawToken := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
-		"sub": id,
-		"iss": iss,
-		"exp": If(access, time.Now().Add(time.Hour*2).Unix(), time.Now().Add(time.Hour*24*7).Unix()),
-		"iat": time.Now().Unix(),
-		"typ": If(access, "access_token", "refresh_token"),
+type TokenType string
+
+const (
+	AccessToken  TokenType = "access_token"
+	RefreshToken TokenType = "refresh_token"
+)
+
+// The endpoints tokens are issued by, see JWTTokens.Issuer.
+const (
+	IssuedBySignIn  = "signin"
+	IssuedByRefresh = "refresh-token"
+	IssuedBySupport = "support"
+)
+
+// ScopeSupport is the only scope of support session tokens, which an admin
+// uses to look at the account of a user, see NewSupportToken.
+const ScopeSupport = "support"
+
+// ScopeAdmin is needed for the admin routes, tokens issued for narrower
+// scopes cannot manage the server even for admins.
+const ScopeAdmin = "admin"
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims are the verified claims of a token, see JWTTokens.Verify.
+type Claims struct {
+	Subject   bson.ObjectID
+	Issuer    string
+	Type      TokenType
+	Scope     []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Session is the sign-in a refresh token belongs to. Tokens issued
+	// before sessions were tracked have none.
+	Session bson.ObjectID
+}
+
+// HasScope reports whether the token was issued for scope. Tokens without
+// any scope are full session tokens and carry every scope.
+func (c *Claims) HasScope(scope string) bool {
+	if len(c.Scope) == 0 {
+		return true
+	}
+	for _, s := range c.Scope {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSupport reports whether the token belongs to a support session. Such
+// tokens are good for the support routes only, never for acting as the
+// user.
+func (c *Claims) IsSupport() bool {
+	return len(c.Scope) == 1 && c.Scope[0] == ScopeSupport
+}
+
+// tokenClaims is the wire form of Claims.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Type  TokenType `json:"typ"`
+	Scope string    `json:"scope,omitempty"`
+}
+
+func issuerURL(base, issuedBy string) string {
+	return strings.TrimSuffix(base, "/") + "/" + issuedBy
+}
+
+func (j *JWTTokens) NewToken(id bson.ObjectID, issuedBy string, typ TokenType, scope ...string) (string, error) {
+	now := time.Now()
+	return j.sign(id, bson.NilObjectID, issuedBy, typ, now, now.Add(If(typ == AccessToken, time.Hour*2, time.Hour*24*7)), scope)
+}
+
+// NewSessionToken issues the refresh token of a sign-in session. It lives
+// as long as the session may at most, the session record decides whether
+// it is still good.
+func (j *JWTTokens) NewSessionToken(id bson.ObjectID, session bson.ObjectID, expires time.Time) (string, error) {
+	return j.sign(id, session, IssuedBySignIn, RefreshToken, time.Now(), expires, nil)
+}
+
+// NewSupportToken issues the access token of a support session on the
+// account of id. It carries the session id, so revoking the session ends
+// it before it expires.
+func (j *JWTTokens) NewSupportToken(id bson.ObjectID, session bson.ObjectID, expires time.Time) (string, error) {
+	return j.sign(id, session, IssuedBySupport, AccessToken, time.Now(), expires, []string{ScopeSupport})
+}
+
+func (j *JWTTokens) sign(id bson.ObjectID, session bson.ObjectID, issuedBy string, typ TokenType, now time.Time, expires time.Time, scope []string) (string, error) {
+	var audience jwt.ClaimStrings
+	if j.Audience != "" {
+		audience = jwt.ClaimStrings{j.Audience}
+	}
+	var jti string
+	if !session.IsZero() {
+		jti = session.Hex()
+	}
+	rawToken := jwt.NewWithClaims(jwt.SigningMethodEdDSA, tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   id.Hex(),
+			Issuer:    issuerURL(j.Issuer, issuedBy),
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Type:  typ,
+		Scope: strings.Join(scope, " "),
 	})
 
-	return rawToken.SignedString(If(access, Ed25519Keys.AccessPrivateKey, Ed25519Keys.RefreshPrivateKey))
+	return rawToken.SignedString(If(typ == AccessToken, j.Keys.AccessPrivateKey, j.Keys.RefreshPrivateKey))
+}
+
+// trustedIssuer reports whether iss is one of the configured issuers, by an
+// endpoint allowed to issue tokens of typ.
+func (j *JWTTokens) trustedIssuer(iss string, typ TokenType) bool {
+	issuedBy := []string{IssuedBySignIn}
+	if typ == AccessToken {
+		issuedBy = append(issuedBy, IssuedByRefresh, IssuedBySupport)
+	}
+	for _, base := range append([]string{j.Issuer}, j.TrustedIssuers...) {
+		for _, by := range issuedBy {
+			if iss == issuerURL(base, by) {
+				return true
+			}
+		}
+	}
+	return false
 }
-func (j *JWTTokens) ParseToken(token string, access bool) (*jwt.MapClaims, error) {
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+
+// Verify checks the signature and claims of a token of the given type and
+// returns its claims. Access tokens may come from a sign in, a refresh or a
+// support session, refresh tokens only from a sign in.
+func (j *JWTTokens) Verify(token string, typ TokenType) (*Claims, error) {
+	access := typ == AccessToken
+	options := []jwt.ParserOption{jwt.WithExpirationRequired(), jwt.WithIssuedAt()}
+	if j.Audience != "" {
+		options = append(options, jwt.WithAudience(j.Audience))
+	}
+	parsed := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(token, parsed, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return If(access, Ed25519Keys.AccessPublicKey, Ed25519Keys.RefreshPublicKey), nil
-	})
+		return If(access, j.Keys.AccessPublicKey, j.Keys.RefreshPublicKey), nil
+	}, options...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
 	if err != nil {
-		return nil, err
+		return nil, ErrInvalidToken
 	}
-	var (
-		claims jwt.MapClaims
-		ok     bool
-	)
-	if claims, ok = parsedToken.Claims.(jwt.MapClaims); !ok || !parsedToken.Valid {
-		return nil, errors.New("invalid token")
+
+	if parsed.Type != typ {
+		return nil, ErrInvalidToken
 	}
-	return &claims, nil
-}
-func (j *JWTTokens) VerifyClaims(claims *jwt.MapClaims, access bool) error {
-	if iss, err := claims.GetIssuer(); err != nil || If(access, iss != "https://auth.filagram.pl/refresh-token" && iss != "https://auth.filagram.pl/signin", iss != "https://auth.filagram.pl/signin") {
-		return errors.New("invalid token")
+	if !j.trustedIssuer(parsed.Issuer, typ) {
+		return nil, ErrInvalidToken
 	}
-	if iat, err := claims.GetIssuedAt(); err != nil || (iat.Unix() >= time.Now().Unix()) {
-		return errors.New("invalid token")
+	if parsed.IssuedAt == nil {
+		return nil, ErrInvalidToken
 	}
-	if exp, err := claims.GetExpirationTime(); err != nil || exp.Unix() < time.Now().Unix() {
-		return errors.New("token expired")
+	subject, err := bson.ObjectIDFromHex(parsed.Subject)
+	if err != nil {
+		return nil, ErrInvalidToken
 	}
-	if _, err := claims.GetSubject(); err != nil {
-		return errors.New("invalid token")
+	var session bson.ObjectID
+	if parsed.ID != "" {
+		if session, err = bson.ObjectIDFromHex(parsed.ID); err != nil {
+			return nil, ErrInvalidToken
+		}
 	}
-	return nil
+
+	return &Claims{
+		Subject:   subject,
+		Session:   session,
+		Issuer:    parsed.Issuer,
+		Type:      parsed.Type,
+		Scope:     strings.Fields(parsed.Scope),
+		IssuedAt:  parsed.IssuedAt.Time,
+		ExpiresAt: parsed.ExpiresAt.Time,
+	}, nil
 }
 
-var JWTFactory = &JWTTokens{}
+// Seal is what sign in hands to clients: the token encrypted with the secret
+// for its type, base64 encoded.
+func (j *JWTTokens) Seal(token string, typ TokenType) (string, error) {
+	var encryption JWTEncryption
+	encrypted, err := encryption.Encrypt([]byte(token), If(typ == AccessToken, j.AccessSecret, j.RefreshSecret))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// Open reverses Seal and verifies the token.
+func (j *JWTTokens) Open(token string, typ TokenType) (*Claims, error) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var encryption JWTEncryption
+	decrypted, err := encryption.Decrypt(decoded, If(typ == AccessToken, j.AccessSecret, j.RefreshSecret))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return j.Verify(string(decrypted), typ)
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func setupKeys(t *testing.T) *EdDSA {
+	t.Helper()
+	dir := t.TempDir()
+	if err := GenerateKeys(dir, false); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestVerifyReturnsTypedClaims(t *testing.T) {
+	tokens := &JWTTokens{Issuer: "https://auth.filagram.pl", Keys: setupKeys(t)}
+	id := bson.NewObjectID()
+
+	token, err := tokens.NewToken(id, IssuedBySignIn, AccessToken, "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.Verify(token, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != id || claims.Type != AccessToken || claims.Issuer != "https://auth.filagram.pl/signin" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if !claims.HasScope("media") || claims.HasScope("admin") {
+		t.Fatalf("scope = %v", claims.Scope)
+	}
+}
+
+func TestVerifyRejectsWrongTypeAndIssuer(t *testing.T) {
+	tokens := &JWTTokens{Issuer: "https://auth.filagram.pl", Keys: setupKeys(t)}
+	id := bson.NewObjectID()
+
+	refresh, err := tokens.NewToken(id, IssuedBySignIn, RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(refresh, AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("refresh token accepted as access token: %v", err)
+	}
+
+	// a refresh only ever mints access tokens
+	refreshed, err := tokens.NewToken(id, IssuedByRefresh, RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(refreshed, RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("refresh token from a refresh accepted: %v", err)
+	}
+}
+
+func TestSupportTokenCarriesSession(t *testing.T) {
+	tokens := &JWTTokens{Issuer: "https://auth.filagram.pl", Keys: setupKeys(t)}
+	id, session := bson.NewObjectID(), bson.NewObjectID()
+
+	token, err := tokens.NewSupportToken(id, session, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.Verify(token, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claims.IsSupport() || claims.Session != session || claims.Subject != id {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	full, err := tokens.NewToken(id, IssuedBySignIn, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := tokens.Verify(full, AccessToken); err != nil || claims.IsSupport() {
+		t.Fatalf("sign-in token taken for a support token: %+v (%v)", claims, err)
+	}
+}
+
+func TestVerifyConfiguredIssuerAndAudience(t *testing.T) {
+	keys := setupKeys(t)
+	id := bson.NewObjectID()
+
+	old := &JWTTokens{Issuer: "https://auth.old.example", Keys: keys}
+	token, err := old.NewToken(id, IssuedBySignIn, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := &JWTTokens{Issuer: "https://auth.example.org/", Keys: keys}
+	if _, err := tokens.Verify(token, AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token from an unknown issuer accepted: %v", err)
+	}
+	tokens.TrustedIssuers = []string{"https://auth.old.example"}
+	if _, err := tokens.Verify(token, AccessToken); err != nil {
+		t.Fatalf("token from a trusted issuer rejected: %v", err)
+	}
+
+	tokens.Audience = "chat.example.org"
+	if _, err := tokens.Verify(token, AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token without the audience accepted: %v", err)
+	}
+	token, err = tokens.NewToken(id, IssuedByRefresh, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.Verify(token, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "https://auth.example.org/refresh-token" {
+		t.Fatalf("issuer = %q", claims.Issuer)
+	}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	tokens := &JWTTokens{Issuer: "https://auth.filagram.pl", Keys: setupKeys(t), AccessSecret: make([]byte, 32), RefreshSecret: make([]byte, 32)}
+	tokens.RefreshSecret[0] = 1
+	id := bson.NewObjectID()
+
+	token, err := tokens.NewToken(id, IssuedBySignIn, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := tokens.Seal(token, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.Open(sealed, AccessToken)
+	if err != nil || claims.Subject != id {
+		t.Fatalf("Open = %+v, %v", claims, err)
+	}
+	// each type is sealed with its own secret
+	if _, err := tokens.Open(sealed, RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("access token opened as refresh token: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+)
+
+// Bcrypt verifies the $2a$, $2b$ and $2y$ hashes of most web frameworks.
+type Bcrypt struct {
+	Cost int
+}
+
+func (Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) Hash(plain []byte) (string, error) {
+	cost := b.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	hash, err := bcrypt.GenerateFromPassword(plain, cost)
+	return string(hash), err
+}
+
+func (Bcrypt) Verify(plain []byte, encoded string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), plain)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// Scrypt verifies hashes in the modular crypt format of passlib,
+//
+//	$scrypt$ln=16,r=8,p=1$<salt>$<hash>
+//
+// with N = 2^ln and salt and hash in base64 without padding, either
+// standard or with . for + as passlib writes it.
+type Scrypt struct {
+	LogN       int
+	R, P       int
+	SaltLength int
+	KeyLength  int
+}
+
+func (Scrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (s Scrypt) Hash(plain []byte) (string, error) {
+	salt, err := generateSalt(uint32(s.SaltLength))
+	if err != nil {
+		return "", err
+	}
+	hash, err := scrypt.Key(plain, salt, 1<<s.LogN, s.R, s.P, s.KeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", s.LogN, s.R, s.P,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+func (Scrypt) Verify(plain []byte, encoded string) error {
+	vals := strings.Split(encoded, "$")
+	if len(vals) != 5 {
+		return errors.New("invalid hash format")
+	}
+	var logN, r, p int
+	if _, err := fmt.Sscanf(vals[2], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return err
+	}
+	if logN < 1 || logN > 20 {
+		return errors.New("invalid scrypt cost")
+	}
+	salt, err := decodeAdaptedBase64(vals[3])
+	if err != nil {
+		return err
+	}
+	hash, err := decodeAdaptedBase64(vals[4])
+	if err != nil {
+		return err
+	}
+
+	newHash, err := scrypt.Key(plain, salt, 1<<logN, r, p, len(hash))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(newHash, hash) == 1 {
+		return nil
+	}
+	return ErrPasswordMismatch
+}
+
+func decodeAdaptedBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(strings.TrimRight(s, "="), ".", "+"))
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+)
+
+// TokenSecrets are the env entries holding the hex encoded AES-256 keys the
+// issued tokens are encrypted with, see JWTEncryption.
+var TokenSecrets = []string{"JWT_ACCESS_SECRET", "JWT_REFRESH_SECRET"}
+
+// NewSecret returns a random AES-256 key, hex encoded as TokenSecrets expects.
+func NewSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// LoadSecrets decodes the TokenSecrets from the environment, check them
+// with MissingSecrets first.
+func LoadSecrets() (access []byte, refresh []byte, err error) {
+	if access, err = hex.DecodeString(os.Getenv(TokenSecrets[0])); err != nil {
+		return nil, nil, err
+	}
+	if refresh, err = hex.DecodeString(os.Getenv(TokenSecrets[1])); err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// MissingSecrets lists the TokenSecrets that are unset or not a valid key.
+func MissingSecrets() []string {
+	var missing []string
+	for _, name := range TokenSecrets {
+		key, err := hex.DecodeString(os.Getenv(name))
+		if err != nil || len(key) != 32 {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
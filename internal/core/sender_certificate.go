@@ -0,0 +1,40 @@
+package core
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// IssuedBySenderCertificate is the endpoint sender certificates are issued
+// by, see SignSenderCertificate.
+const IssuedBySenderCertificate = "sender-certificate"
+
+// senderCertificateClaims is the wire form of a sender certificate. Like a
+// command it has no typ and never passes for an access token.
+type senderCertificateClaims struct {
+	jwt.RegisteredClaims
+	PublicKey []byte `json:"pk"`
+}
+
+// SignSenderCertificate vouches that publicKey belongs to user. A sender
+// puts the certificate inside a sealed message, so the server relays it
+// without learning who sent it and the recipient checks it with
+// CommandKey before trusting the sender named in it.
+func (j *JWTTokens) SignSenderCertificate(user bson.ObjectID, publicKey []byte, expires time.Time) (string, error) {
+	var audience jwt.ClaimStrings
+	if j.Audience != "" {
+		audience = jwt.ClaimStrings{j.Audience}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, senderCertificateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.Hex(),
+			Issuer:    issuerURL(j.Issuer, IssuedBySenderCertificate),
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		PublicKey: publicKey,
+	})
+	return token.SignedString(j.Keys.AccessPrivateKey)
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestSenderCertificateVerifiesWithCommandKey(t *testing.T) {
+	tokens := &JWTTokens{Issuer: "https://auth.filagram.pl", Keys: setupKeys(t)}
+	user := bson.NewObjectID()
+
+	signed, err := tokens.SignSenderCertificate(user, []byte("public key"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &senderCertificateClaims{}
+	_, err = jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+		return tokens.CommandKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != user.Hex() || string(claims.PublicKey) != "public key" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := tokens.Verify(signed, AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("sender certificate accepted as access token: %v", err)
+	}
+}
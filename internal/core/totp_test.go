@@ -0,0 +1,53 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors, base32 encoded.
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC(t *testing.T) {
+	// RFC 6238 appendix B lists 8 digits, we use the last 6 of them
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != want {
+			t.Errorf("at %d got %s, want %s", unix, code, want)
+		}
+	}
+}
+
+func TestVerifyTOTPAllowsSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(rfcSecret, TOTPStep(now))
+
+	for _, at := range []time.Time{now, now.Add(-totpPeriod * time.Second), now.Add(totpPeriod * time.Second)} {
+		step, ok := VerifyTOTP(rfcSecret, code, at)
+		if !ok || step != TOTPStep(now) {
+			t.Errorf("code %s refused at %v", code, at)
+		}
+	}
+	if _, ok := VerifyTOTP(rfcSecret, code, now.Add(2*totpPeriod*time.Second)); ok {
+		t.Error("code accepted two steps late")
+	}
+	if _, ok := VerifyTOTP(rfcSecret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Filagram", "alice", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Filagram:alice?") || !strings.Contains(uri, "secret=ABC") {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}
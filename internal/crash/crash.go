@@ -0,0 +1,93 @@
+// Package crash keeps a panic in a background worker or a broker hook from
+// taking the process down. Recovered panics are logged with their stack,
+// counted and, when a Reporter is set, sent on to e.g. Sentry.
+package crash
+
+import (
+	"filachat/internal/metrics"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Report is what is known about one recovered panic.
+type Report struct {
+	// Where names the worker or hook that panicked.
+	Where string
+	Value any
+	Stack []byte
+	Time  time.Time
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("panic in %s: %v\n%s", r.Where, r.Value, r.Stack)
+}
+
+// Reporter sends crash reports out of the process. Report must not block
+// for long, it runs on the goroutine that panicked.
+type Reporter interface {
+	Report(report Report)
+}
+
+// Sink is where reports go besides the log, nil keeps them in the log.
+// It is set once at startup.
+var Sink Reporter
+
+// Restart bounds the wait before a worker that panicked is started again.
+// The wait doubles with every panic in a row, up to Max.
+var Restart = struct{ Min, Max time.Duration }{Min: time.Second, Max: time.Minute}
+
+// Capture reports a panic recovered elsewhere, e.g. by the Recover
+// middleware of echo.
+func Capture(where string, value any, stack []byte) {
+	r := Report{Where: where, Value: value, Stack: stack, Time: time.Now()}
+	metrics.PanicsRecovered.WithLabelValues(where).Inc()
+	log.Println("[ERROR]", r)
+	if Sink != nil {
+		Sink.Report(r)
+	}
+}
+
+// Recover reports a panic of the function deferring it and lets the
+// function return its zero values, e.g.
+//
+//	defer crash.Recover("presence-hook")
+//
+// It has to be deferred directly, recover does nothing otherwise.
+func Recover(where string) {
+	if value := recover(); value != nil {
+		Capture(where, value, debug.Stack())
+	}
+}
+
+// RecoverWith is Recover for functions that need to answer something else
+// than their zero values after a panic, fallback runs once it was
+// reported and may set named results.
+func RecoverWith(where string, fallback func()) {
+	if value := recover(); value != nil {
+		Capture(where, value, debug.Stack())
+		fallback()
+	}
+}
+
+// Go runs a worker on a goroutine of its own. When run panics the panic is
+// reported and run is started again after a backoff; when it returns the
+// worker is done.
+func Go(where string, run func()) {
+	go func() {
+		wait := Restart.Min
+		for !safely(where, run) {
+			time.Sleep(wait)
+			wait = min(wait*2, Restart.Max)
+			log.Println("[INFO] restarting", where)
+		}
+	}()
+}
+
+// safely tells whether run returned without panicking.
+func safely(where string, run func()) (ok bool) {
+	defer Recover(where)
+	run()
+	return true
+}
@@ -0,0 +1,76 @@
+package crash
+
+import (
+	"errors"
+	"filachat/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collector struct {
+	mu      sync.Mutex
+	reports []Report
+}
+
+func (c *collector) Report(report Report) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reports = append(c.reports, report)
+}
+
+func TestGoRestartsAfterPanic(t *testing.T) {
+	sink := &collector{}
+	Sink = sink
+	Restart.Min, Restart.Max = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { Sink = nil })
+
+	before := testutil.ToFloat64(metrics.PanicsRecovered.WithLabelValues("test-worker"))
+	runs := 0
+	done := make(chan struct{})
+	Go("test-worker", func() {
+		runs++
+		if runs < 3 {
+			panic(errors.New("boom"))
+		}
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker was not restarted")
+	}
+	if got := testutil.ToFloat64(metrics.PanicsRecovered.WithLabelValues("test-worker")) - before; got != 2 {
+		t.Errorf("Expected 2 panics counted, got %v", got)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.reports) != 2 || sink.reports[0].Where != "test-worker" || len(sink.reports[0].Stack) == 0 {
+		t.Errorf("Expected 2 reports with a stack, got %+v", sink.reports)
+	}
+}
+
+func TestRecoverWithSetsResults(t *testing.T) {
+	answer := func() (result string) {
+		defer RecoverWith("test-hook", func() { result = "fallback" })
+		panic("boom")
+	}
+	if got := answer(); got != "fallback" {
+		t.Errorf("Expected the fallback result, got %q", got)
+	}
+}
+
+func TestNewSentry(t *testing.T) {
+	sentry, err := NewSentry("https://public@sentry.example.com/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sentry.endpoint != "https://sentry.example.com/api/42/store/" || sentry.key != "public" {
+		t.Errorf("Unexpected endpoint %q and key %q", sentry.endpoint, sentry.key)
+	}
+	if _, err := NewSentry("https://sentry.example.com/42"); err == nil {
+		t.Error("Expected a DSN without a key to be refused")
+	}
+}
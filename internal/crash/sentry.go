@@ -0,0 +1,95 @@
+package crash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sentry sends reports to the store endpoint of a Sentry project, or any
+// server speaking its protocol. Reports are sent in the background, one
+// that cannot be sent is only logged.
+type Sentry struct {
+	endpoint string
+	key      string
+	Release  string
+	Client   *http.Client
+}
+
+// NewSentry reads a DSN of the form https://key@host/project.
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("sentry DSN needs a key and a project")
+	}
+	endpoint := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/api/" + project + "/store/"}
+	return &Sentry{
+		endpoint: endpoint.String(),
+		key:      u.User.Username(),
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type sentryEvent struct {
+	EventId   string            `json:"event_id"`
+	Timestamp string            `json:"timestamp"`
+	Level     string            `json:"level"`
+	Platform  string            `json:"platform"`
+	Logger    string            `json:"logger"`
+	Release   string            `json:"release,omitempty"`
+	Message   string            `json:"message"`
+	Tags      map[string]string `json:"tags"`
+	Extra     map[string]string `json:"extra"`
+}
+
+func (s *Sentry) Report(report Report) {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	event := sentryEvent{
+		EventId:   hex.EncodeToString(id),
+		Timestamp: report.Time.UTC().Format(time.RFC3339),
+		Level:     "error",
+		Platform:  "go",
+		Logger:    "crash",
+		Release:   s.Release,
+		Message:   fmt.Sprintf("panic in %s: %v", report.Where, report.Value),
+		Tags:      map[string]string{"where": report.Where},
+		Extra:     map[string]string{"stack": string(report.Stack)},
+	}
+	go s.send(event)
+}
+
+func (s *Sentry) send(event sentryEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println("[WARN] crash report not encoded", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		log.Println("[WARN] crash report not sent", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=filagram/1.0, sentry_key=%s", s.key))
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		log.Println("[WARN] crash report not sent", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Println("[WARN] crash report refused with", resp.StatusCode)
+	}
+}
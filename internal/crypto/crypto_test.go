@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring := NewKeyring(&LocalKMS{MasterKey: make([]byte, 32)})
+	wrapped, err := keyring.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := keyring.SealString(wrapped, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == "hello" {
+		t.Fatal("content not sealed")
+	}
+
+	// a fresh keyring has to unwrap the key through the provider
+	fresh := NewKeyring(keyring.Provider)
+	text, err := fresh.OpenString(wrapped, sealed)
+	if err != nil || text != "hello" {
+		t.Fatalf("opened %q, %v", text, err)
+	}
+}
+
+func TestVaultTransit(t *testing.T) {
+	key := make([]byte, 32)
+	key[0] = 1
+	plaintext := base64.StdEncoding.EncodeToString(key)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/chat":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": plaintext, "ciphertext": "vault:v1:abc"}})
+		case "/v1/transit/decrypt/chat":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": plaintext}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	vault := &VaultTransit{Address: server.URL, Token: "token", Key: "chat", Client: server.Client()}
+	got, wrapped, err := vault.GenerateDataKey()
+	if err != nil || string(wrapped) != "vault:v1:abc" || got[0] != 1 {
+		t.Fatalf("data key %x %q %v", got, wrapped, err)
+	}
+	unwrapped, err := vault.Unwrap(wrapped)
+	if err != nil || unwrapped[0] != 1 {
+		t.Fatalf("unwrapped %x %v", unwrapped, err)
+	}
+}
+
+func TestFieldCipher(t *testing.T) {
+	fields, err := NewFieldCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := fields.Encrypt("alice@filagram.pl")
+	if err != nil || !IsSealed(sealed) {
+		t.Fatalf("sealed %q, %v", sealed, err)
+	}
+	if again, _ := fields.Encrypt(sealed); again != sealed {
+		t.Fatal("sealed value sealed twice")
+	}
+	if plain, err := fields.Decrypt(sealed); err != nil || plain != "alice@filagram.pl" {
+		t.Fatalf("decrypted %q, %v", plain, err)
+	}
+	// values from before encryption was on read as they are
+	if plain, _ := fields.Decrypt("bob@filagram.pl"); plain != "bob@filagram.pl" {
+		t.Fatalf("plain value changed to %q", plain)
+	}
+
+	if fields.Index(" Alice@Filagram.pl") != fields.Index("alice@filagram.pl") {
+		t.Fatal("index depends on case")
+	}
+	if fields.Index("alice@filagram.pl") == fields.Index("bob@filagram.pl") {
+		t.Fatal("index collides")
+	}
+}
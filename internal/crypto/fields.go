@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const sealedPrefix = "enc:v1:"
+
+// FieldCipher encrypts single fields of stored documents. Sealed values
+// carry a prefix, so documents written before encryption was switched on
+// still read fine until they are migrated. Fields that are looked up by
+// value get a keyed hash next to them, see Index.
+type FieldCipher struct {
+	key   []byte
+	index []byte
+}
+
+// NewFieldCipher derives separate encryption and index keys from master.
+func NewFieldCipher(master []byte) (*FieldCipher, error) {
+	key, err := hkdf.Key(sha256.New, master, nil, "filagram field encryption", 32)
+	if err != nil {
+		return nil, err
+	}
+	index, err := hkdf.Key(sha256.New, master, nil, "filagram field index", 32)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldCipher{key: key, index: index}, nil
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Encrypt seals value. Empty and already sealed values are returned as is.
+func (f *FieldCipher) Encrypt(value string) (string, error) {
+	if value == "" || IsSealed(value) {
+		return value, nil
+	}
+	sealed, err := Seal(f.key, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a sealed value. Plain values are returned as is.
+func (f *FieldCipher) Decrypt(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	plain, err := Open(f.key, raw)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Index returns a deterministic keyed hash of value, case and surrounding
+// space ignored, to find documents by a sealed field.
+func (f *FieldCipher) Index(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, f.index)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"sync"
+)
+
+// Keyring caches unwrapped data keys, so the KMS is asked once per key and
+// process rather than once per message.
+type Keyring struct {
+	Provider KeyProvider
+
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func NewKeyring(provider KeyProvider) *Keyring {
+	return &Keyring{Provider: provider, keys: make(map[string][]byte)}
+}
+
+func (k *Keyring) NewDataKey() ([]byte, error) {
+	key, wrapped, err := k.Provider.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.keys[string(wrapped)] = key
+	k.mu.Unlock()
+	return wrapped, nil
+}
+
+func (k *Keyring) key(wrapped []byte) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.keys[string(wrapped)]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := k.Provider.Unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.keys[string(wrapped)] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+// SealString encrypts text under the data key wrapped as given and returns
+// it base64 encoded, to be stored where the plain text was.
+func (k *Keyring) SealString(wrapped []byte, text string) (string, error) {
+	key, err := k.key(wrapped)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := Seal(key, []byte(text))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) OpenString(wrapped []byte, sealed string) (string, error) {
+	key, err := k.key(wrapped)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	text, err := Open(key, raw)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
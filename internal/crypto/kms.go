@@ -0,0 +1,160 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filachat/pkg/config"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// KeyProvider hands out data keys for envelope encryption. The key that
+// wraps them never leaves the provider; only wrapped data keys are stored
+// next to the data they protect.
+type KeyProvider interface {
+	GenerateDataKey() (key []byte, wrapped []byte, err error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// LocalKMS wraps data keys with a master key held in process, for
+// deployments without a KMS.
+type LocalKMS struct {
+	MasterKey []byte
+}
+
+func (k *LocalKMS) GenerateDataKey() ([]byte, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := Seal(k.MasterKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+func (k *LocalKMS) Unwrap(wrapped []byte) ([]byte, error) {
+	return Open(k.MasterKey, wrapped)
+}
+
+// VaultTransit gets data keys from the transit secrets engine of HashiCorp
+// Vault or OpenBao.
+type VaultTransit struct {
+	Address string
+	Token   string
+	Key     string
+	Client  *http.Client
+}
+
+func (v *VaultTransit) call(path string, body any, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, v.Address+"/v1/transit/"+path+"/"+v.Key, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("vault returned %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (v *VaultTransit) GenerateDataKey() ([]byte, []byte, error) {
+	var out struct {
+		Data struct {
+			Plaintext  string `json:"plaintext"`
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := v.call("datakey/plaintext", map[string]any{"bits": 256}, &out); err != nil {
+		return nil, nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(out.Data.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, []byte(out.Data.Ciphertext), nil
+}
+
+func (v *VaultTransit) Unwrap(wrapped []byte) ([]byte, error) {
+	var out struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &out); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.Data.Plaintext)
+}
+
+// NewKeyProvider picks Vault when an address is configured and a local
+// master key otherwise. Without either, nil is returned and server side
+// encryption stays unavailable.
+func NewKeyProvider(cfg *config.Config) (KeyProvider, error) {
+	if cfg.KMSVaultAddress != "" {
+		return &VaultTransit{
+			Address: cfg.KMSVaultAddress,
+			Token:   cfg.KMSVaultToken,
+			Key:     cfg.KMSVaultKey,
+			Client:  &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	}
+	if cfg.KMSMasterKey == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(cfg.KMSMasterKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("KMS_MASTER_KEY must be a 32 byte hex key")
+	}
+	return &LocalKMS{MasterKey: key}, nil
+}
+
+// Seal encrypts with AES-256-GCM, prefixing the random nonce.
+func Seal(key []byte, plaintext []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aesgcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func Open(key []byte, ciphertext []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aesgcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aesgcm.NonceSize()], ciphertext[aesgcm.NonceSize():]
+	return aesgcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
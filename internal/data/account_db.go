@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// GetRestrictedAccounts lists the users that are deleted, not active at now
+// or locked, with only their id and state loaded.
+func (DB *DB) GetRestrictedAccounts(ctx context.Context, now time.Time) ([]models.User, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"deleted_at": bson.M{"$exists": true}},
+			bson.M{"deactivated": true},
+			bson.M{"locked": true},
+			bson.M{"account.state": bson.M{"$in": bson.A{models.AccountBanned, models.AccountDeactivated}}},
+			bson.M{"account.state": models.AccountSuspended, "$or": bson.A{
+				bson.M{"account.until": bson.M{"$exists": false}},
+				bson.M{"account.until": bson.M{"$gt": now}},
+			}},
+		},
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "deactivated": 1, "locked": 1, "account": 1, "deleted_at": 1})
+	cursor, err := DB.Db.Collection("users").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	return users, cursor.All(ctx, &users)
+}
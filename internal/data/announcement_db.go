@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+func (DB *DB) SaveAnnouncement(ctx context.Context, announcement *models.Announcement) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("announcements").InsertOne(ctx, *announcement)
+	return err
+}
+
+func (DB *DB) DeleteAnnouncement(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("announcements").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetActiveAnnouncements returns what is live at the given time, newest
+// first. Announcements without an expiry stay until deleted.
+func (DB *DB) GetActiveAnnouncements(ctx context.Context, at time.Time) ([]models.Announcement, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"publish_at": bson.M{"$lte": at},
+		"$or": []bson.M{
+			{"expires_at": bson.M{"$exists": false}},
+			{"expires_at": bson.M{"$gt": at}},
+		},
+	}
+	cursor, err := DB.Db.Collection("announcements").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "publish_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	announcements := []models.Announcement{}
+	return announcements, cursor.All(ctx, &announcements)
+}
+
+// GetAnnouncements lists every announcement including scheduled and expired
+// ones, for admins.
+func (DB *DB) GetAnnouncements(ctx context.Context) ([]models.Announcement, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("announcements").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "publish_at", Value: -1}}).SetLimit(200))
+	if err != nil {
+		return nil, err
+	}
+	var announcements []models.Announcement
+	return announcements, cursor.All(ctx, &announcements)
+}
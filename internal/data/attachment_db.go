@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ReserveStorage adds size to the user's usage unless that would go over the
+// quota. The check and the increment are one update so parallel uploads
+// cannot overshoot together.
+func (DB *DB) ReserveStorage(ctx context.Context, userId bson.ObjectID, size int64, quota int64) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": userId, "$or": []bson.M{
+		{"storage_used": bson.M{"$exists": false}},
+		{"storage_used": bson.M{"$lte": quota - size}},
+	}}
+	result, err := DB.Db.Collection("users").UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"storage_used": size}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (DB *DB) ReleaseStorage(ctx context.Context, userId bson.ObjectID, size int64) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("users").UpdateByID(ctx, userId, bson.M{"$inc": bson.M{"storage_used": -size}})
+	return err
+}
+
+func (DB *DB) SaveAttachment(ctx context.Context, attachment *models.Attachment) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("attachments").InsertOne(ctx, *attachment)
+	return err
+}
+
+func (DB *DB) GetAttachment(ctx context.Context, id bson.ObjectID) (models.Attachment, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var attachment models.Attachment
+	if err := DB.Db.Collection("attachments").FindOne(ctx, bson.M{"_id": id}).Decode(&attachment); err != nil {
+		return models.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+func (DB *DB) DeleteAttachment(ctx context.Context, id bson.ObjectID, ownerId bson.ObjectID) (models.Attachment, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var attachment models.Attachment
+	err := DB.Db.Collection("attachments").FindOneAndDelete(ctx, bson.M{"_id": id, "owner_id": ownerId}).Decode(&attachment)
+	return attachment, err
+}
+
+func (DB *DB) GetQuarantinedAttachments(ctx context.Context, page pagination.Page) ([]models.Attachment, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{
+		{"quarantined": true},
+		page.Filter("created_at"),
+	}}
+	cursor, err := DB.Db.Collection("attachments").Find(ctx, filter, page.FindOptions("created_at"))
+	if err != nil {
+		return nil, err
+	}
+	var attachments []models.Attachment
+	return attachments, cursor.All(ctx, &attachments)
+}
+
+func (DB *DB) ReleaseAttachment(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("attachments").UpdateOne(ctx,
+		bson.M{"_id": id, "quarantined": true},
+		bson.M{"$unset": bson.M{"quarantined": "", "signature": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// PurgeAttachment removes an attachment regardless of its owner, for admins.
+func (DB *DB) PurgeAttachment(ctx context.Context, id bson.ObjectID) (models.Attachment, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var attachment models.Attachment
+	err := DB.Db.Collection("attachments").FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&attachment)
+	return attachment, err
+}
+
+// SetThumbnail attaches a client supplied thumbnail, only once per attachment.
+func (DB *DB) SetThumbnail(ctx context.Context, id bson.ObjectID, ownerId bson.ObjectID, thumbnail models.Thumbnail) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "owner_id": ownerId, "thumbnail": bson.M{"$exists": false}}
+	result, err := DB.Db.Collection("attachments").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"thumbnail": thumbnail}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+var ErrNonceUsed = errors.New("media link already used")
+
+// ConsumeMediaNonce burns the nonce of a single-use media link. The nonce is
+// the document id, so a second use fails on the unique index every
+// collection has.
+func (DB *DB) ConsumeMediaNonce(ctx context.Context, nonce string, expires time.Time) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("media_nonces").InsertOne(ctx, bson.M{"_id": nonce, "expires_at": expires})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrNonceUsed
+	}
+	return err
+}
+
+func (DB *DB) PurgeExpiredNonces(ctx context.Context) error {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("media_nonces").DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": time.Now()}})
+	return err
+}
@@ -0,0 +1,14 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+)
+
+func (DB *DB) SaveAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("audit_logs").InsertOne(ctx, *entry)
+	return err
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (DB *DB) SaveCall(ctx context.Context, call *models.Call) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("calls").InsertOne(ctx, *call)
+	return err
+}
+
+func (DB *DB) GetCall(ctx context.Context, id bson.ObjectID) (models.Call, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var call models.Call
+	if err := DB.Db.Collection("calls").FindOne(ctx, bson.M{"_id": id}).Decode(&call); err != nil {
+		return models.Call{}, err
+	}
+	return call, nil
+}
+
+// TransitionCall moves a call to a new status only while it is still in one
+// of the expected states, so concurrent answers and timeouts cannot both win.
+func (DB *DB) TransitionCall(ctx context.Context, id bson.ObjectID, from []models.CallStatus, to models.CallStatus, fields bson.M) (models.Call, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	set := bson.M{"status": to}
+	for key, value := range fields {
+		set[key] = value
+	}
+
+	var call models.Call
+	err := DB.Db.Collection("calls").FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&call)
+	if err != nil {
+		return models.Call{}, err
+	}
+	return call, nil
+}
+
+func (DB *DB) GetCalls(ctx context.Context, userId bson.ObjectID, page pagination.Page) ([]models.Call, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{
+		{"$or": []bson.M{{"caller_id": userId}, {"callee_id": userId}}},
+		page.Filter("created_at"),
+	}}
+	result, err := DB.Db.Collection("calls").Find(ctx, filter, page.FindOptions("created_at"))
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []models.Call
+	if err := result.All(ctx, &calls); err != nil {
+		return nil, err
+	}
+	return calls, nil
+}
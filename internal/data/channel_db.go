@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (DB *DB) SaveChannel(ctx context.Context, channel *models.Channel) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("channels").InsertOne(ctx, *channel)
+	return err
+}
+
+func (DB *DB) GetChannel(ctx context.Context, id bson.ObjectID) (models.Channel, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var channel models.Channel
+	if err := DB.Db.Collection("channels").FindOne(ctx, bson.M{"_id": id}).Decode(&channel); err != nil {
+		return models.Channel{}, err
+	}
+	return channel, nil
+}
+
+func (DB *DB) SavePost(ctx context.Context, post *models.ChannelPost) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("channel_posts").InsertOne(ctx, *post)
+	return err
+}
+
+// GetPosts lists what readers of a channel see, flagged posts included
+// since flagging does not hold them back.
+func (DB *DB) GetPosts(ctx context.Context, channelId bson.ObjectID, page pagination.Page) ([]models.ChannelPost, error) {
+	filter := bson.M{"channel_id": channelId, "status": bson.M{"$in": []models.PostStatus{models.PostPublished, models.PostFlagged}}}
+	return DB.findPosts(ctx, filter, page)
+}
+
+func (DB *DB) GetReviewQueue(ctx context.Context, page pagination.Page) ([]models.ChannelPost, error) {
+	filter := bson.M{"status": bson.M{"$in": []models.PostStatus{models.PostHeld, models.PostFlagged}}}
+	return DB.findPosts(ctx, filter, page)
+}
+
+func (DB *DB) findPosts(ctx context.Context, filter bson.M, page pagination.Page) ([]models.ChannelPost, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("channel_posts").Find(ctx, bson.M{"$and": []bson.M{filter, page.Filter("timestamp")}}, page.FindOptions("timestamp"))
+	if err != nil {
+		return nil, err
+	}
+	var posts []models.ChannelPost
+	return posts, cursor.All(ctx, &posts)
+}
+
+// ReviewPost settles a queued post and returns it as it was before, so the
+// caller knows whether it still has to be published.
+func (DB *DB) ReviewPost(ctx context.Context, id bson.ObjectID, status models.PostStatus, reviewer bson.ObjectID) (models.ChannelPost, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "status": bson.M{"$in": []models.PostStatus{models.PostHeld, models.PostFlagged}}}
+	update := bson.M{"$set": bson.M{"status": status, "reviewed_by": reviewer}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+
+	var post models.ChannelPost
+	if err := DB.Db.Collection("channel_posts").FindOneAndUpdate(ctx, filter, update, opts).Decode(&post); err != nil {
+		return models.ChannelPost{}, err
+	}
+	return post, nil
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (DB *DB) GetConversation(ctx context.Context, id string) (models.Conversation, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var conversation models.Conversation
+	err := DB.Db.Collection("conversations").FindOne(ctx, bson.M{"_id": id}).Decode(&conversation)
+	return conversation, err
+}
+
+func (DB *DB) SetConversationEncryption(ctx context.Context, conversation *models.Conversation) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": conversation.Id},
+		bson.M{
+			"$set": bson.M{
+				"encryption": conversation.Encryption,
+				"updated_by": conversation.UpdatedBy,
+				"updated_at": conversation.UpdatedAt,
+			},
+			"$setOnInsert": bson.M{"participants": conversation.Participants},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// EnsureDataKey stores the wrapped data key of a conversation unless it has
+// one already, and returns the key that is stored. A conversation keeps its
+// first key for good, every message sealed under it depends on it.
+func (DB *DB) EnsureDataKey(ctx context.Context, conversation *models.Conversation, wrapped []byte) ([]byte, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": conversation.Id, "data_key": bson.M{"$exists": false}},
+		bson.M{
+			"$set": bson.M{"data_key": wrapped},
+			"$setOnInsert": bson.M{
+				"participants": conversation.Participants,
+				"encryption":   conversation.Encryption,
+			},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	// the upsert collides with a conversation that already has its key
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	var stored models.Conversation
+	opts := options.FindOne().SetProjection(bson.M{"data_key": 1})
+	if err := DB.Db.Collection("conversations").FindOne(ctx, bson.M{"_id": conversation.Id}, opts).Decode(&stored); err != nil {
+		return nil, err
+	}
+	return stored.DataKey, nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SaveConversationSettings replaces the settings a user keeps about a peer.
+func (DB *DB) SaveConversationSettings(ctx context.Context, settings *models.ConversationSettings) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("conversation_settings").UpdateOne(ctx,
+		bson.M{"user_id": settings.UserId, "peer_id": settings.PeerId},
+		bson.M{
+			"$set":         bson.M{"labels": settings.Labels, "note": settings.Note, "updated_at": settings.UpdatedAt},
+			"$setOnInsert": bson.M{"_id": settings.Id},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func (DB *DB) GetConversationSettings(ctx context.Context, user bson.ObjectID, peer bson.ObjectID) (models.ConversationSettings, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var settings models.ConversationSettings
+	err := DB.Db.Collection("conversation_settings").FindOne(ctx, bson.M{"user_id": user, "peer_id": peer}).Decode(&settings)
+	return settings, err
+}
+
+// FindConversationSettings lists the settings of a user, only those with
+// label unless it is empty.
+func (DB *DB) FindConversationSettings(ctx context.Context, user bson.ObjectID, label string) ([]models.ConversationSettings, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"user_id": user}
+	if label != "" {
+		filter["labels"] = label
+	}
+	cursor, err := DB.Db.Collection("conversation_settings").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var settings []models.ConversationSettings
+	return settings, cursor.All(ctx, &settings)
+}
+
+// GetLabels lists the labels a user has put on any conversation.
+func (DB *DB) GetLabels(ctx context.Context, user bson.ObjectID) ([]string, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var labels []string
+	err := DB.Db.Collection("conversation_settings").Distinct(ctx, "labels", bson.M{"user_id": user}).Decode(&labels)
+	return labels, err
+}
+
+func (DB *DB) DeleteConversationSettings(ctx context.Context, user bson.ObjectID, peer bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("conversation_settings").DeleteOne(ctx, bson.M{"user_id": user, "peer_id": peer})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package database_test
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/testserver"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestConversationSettingsByLabel(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	user, colleague, sister := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+
+	for peer, labels := range map[bson.ObjectID][]string{colleague: {"work"}, sister: {"family", "work"}} {
+		settings := models.ConversationSettings{Id: bson.NewObjectID(), UserId: user, PeerId: peer, Labels: labels, UpdatedAt: time.Now()}
+		if err := db.SaveConversationSettings(ctx, &settings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// saving again replaces the labels
+	settings := models.ConversationSettings{Id: bson.NewObjectID(), UserId: user, PeerId: sister, Labels: []string{"family"}, UpdatedAt: time.Now()}
+	if err := db.SaveConversationSettings(ctx, &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	work, err := db.FindConversationSettings(ctx, user, "work")
+	if err != nil || len(work) != 1 || work[0].PeerId != colleague {
+		t.Fatalf("Expected only the colleague labelled work, got %+v (%v)", work, err)
+	}
+	labels, err := db.GetLabels(ctx, user)
+	if err != nil || len(labels) != 2 {
+		t.Errorf("Expected 2 labels in use, got %q (%v)", labels, err)
+	}
+}
@@ -2,27 +2,175 @@ package database
 
 import (
 	"context"
-	"github.com/joho/godotenv"
+	"filachat/internal/metrics"
+	"filachat/pkg/config"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
-	"os"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"log"
 	"time"
 )
 
-func Connect()  (*mongo.Client, error) {
-	err := godotenv.Load()
-	if err != nil { return nil, err }
+// Connect opens the client with the pool, retry and read preference
+// settings of cfg and pings the server until it answers, backing off
+// between attempts so the API can start alongside a database that is still
+// coming up.
+func Connect(ctx context.Context, cfg *config.Config) (*mongo.Client, error) {
+	readPreference, err := readPref(cfg.DBReadPreference)
+	if err != nil {
+		return nil, err
+	}
 
 	clientOptions := options.Client()
-	clientOptions.ApplyURI(os.Getenv("DATABASE_URL"))
+	clientOptions.ApplyURI(cfg.DatabaseURL)
 	clientOptions.SetTimeout(10 * time.Second)
+	clientOptions.SetMaxPoolSize(uint64(cfg.DBMaxPoolSize))
+	clientOptions.SetMinPoolSize(uint64(cfg.DBMinPoolSize))
+	clientOptions.SetServerSelectionTimeout(cfg.DBServerSelectionTimeout)
+	clientOptions.SetRetryWrites(cfg.DBRetryWrites)
+	clientOptions.SetRetryReads(cfg.DBRetryWrites)
+	clientOptions.SetReadPreference(readPreference)
+	clientOptions.SetPoolMonitor(poolMonitor())
 
 	client, err := mongo.Connect(clientOptions)
-	if err != nil { return &mongo.Client{}, err }
+	if err != nil {
+		return nil, err
+	}
 
 	// test the connection with database
-	if err := client.Ping(context.Background(), nil); err != nil { return &mongo.Client{}, err }
+	backoff := cfg.DBConnectBackoff
+	for attempt := int64(1); ; attempt++ {
+		err = client.Ping(ctx, nil)
+		if err == nil {
+			return client, nil
+		}
+		if attempt >= cfg.DBConnectAttempts {
+			break
+		}
+		log.Printf("[WARN] database not reachable (attempt %d of %d), retrying in %s: %v", attempt, cfg.DBConnectAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			err = ctx.Err()
+			_ = client.Disconnect(context.Background())
+			return nil, err
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+	_ = client.Disconnect(context.Background())
+	return nil, fmt.Errorf("database not reachable after %d attempts: %w", cfg.DBConnectAttempts, err)
+}
+
+// SupportsTransactions reports whether the server behind client is a
+// replica set member or a mongos, standalone servers have no transactions.
+func SupportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		log.Println("[WARN] server topology unknown, transactions off:", err)
+		return false
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid"
+}
+
+// UseTransactions is cfg.DBTransactions unless the server has none.
+func UseTransactions(ctx context.Context, client *mongo.Client, cfg *config.Config) bool {
+	if !cfg.DBTransactions {
+		return false
+	}
+	if !SupportsTransactions(ctx, client) {
+		log.Println("[WARN] database is a standalone server, writes across collections run without transactions")
+		return false
+	}
+	return true
+}
+
+func readPref(mode string) (*readpref.ReadPref, error) {
+	switch mode {
+	case "", "primary":
+		return readpref.Primary(), nil
+	case "primaryPreferred":
+		return readpref.PrimaryPreferred(), nil
+	case "secondary":
+		return readpref.Secondary(), nil
+	case "secondaryPreferred":
+		return readpref.SecondaryPreferred(), nil
+	case "nearest":
+		return readpref.Nearest(), nil
+	}
+	return nil, fmt.Errorf("unknown read preference %q", mode)
+}
+
+// poolMonitor feeds the pool metrics from the driver's connection events.
+func poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			switch evt.Type {
+			case event.ConnectionCreated:
+				metrics.MongoConnections.WithLabelValues("open").Inc()
+			case event.ConnectionClosed:
+				metrics.MongoConnections.WithLabelValues("open").Dec()
+			case event.ConnectionCheckedOut:
+				metrics.MongoConnections.WithLabelValues("in_use").Inc()
+				metrics.MongoCheckoutWait.Observe(evt.Duration.Seconds())
+			case event.ConnectionCheckedIn:
+				metrics.MongoConnections.WithLabelValues("in_use").Dec()
+			case event.ConnectionCheckOutFailed:
+				metrics.MongoCheckoutFailed.WithLabelValues(evt.Reason).Inc()
+			}
+		},
+	}
+}
 
-	return client, nil
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultBatchTimeout = time.Minute
+)
+
+func (DB *DB) query(ctx context.Context) (context.Context, context.CancelFunc) {
+	if DB.Timeout > 0 {
+		return context.WithTimeout(ctx, DB.Timeout)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
 }
 
+func (DB *DB) batch(ctx context.Context) (context.Context, context.CancelFunc) {
+	if DB.BatchTimeout > 0 {
+		return context.WithTimeout(ctx, DB.BatchTimeout)
+	}
+	return context.WithTimeout(ctx, defaultBatchTimeout)
+}
+
+// transaction runs fn in a transaction, which the driver retries as a whole
+// on transient errors and on commits of unknown outcome, so fn must not
+// keep state across calls. Without Transactions, e.g. against a standalone
+// server, fn runs on its own.
+func (DB *DB) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !DB.Transactions {
+		return fn(ctx)
+	}
+	session, err := DB.Db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// Ping checks the database answers within the query timeout.
+func (DB *DB) Ping(ctx context.Context) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	return DB.Db.Client().Ping(ctx, nil)
+}
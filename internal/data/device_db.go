@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SaveDevice registers a device or, for one already registered, replaces
+// its name and signed prekey. The identity key of a device never changes,
+// a new identity is a new device.
+func (DB *DB) SaveDevice(ctx context.Context, device *models.Device) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("devices").UpdateOne(ctx,
+		bson.M{"_id": device.Id, "user_id": device.UserId},
+		bson.M{
+			"$set": bson.M{"name": device.Name, "signed_prekey": device.SignedPreKey, "updated_at": device.UpdatedAt},
+			"$setOnInsert": bson.M{
+				"identity_key": device.IdentityKey,
+				"created_at":   device.CreatedAt,
+			},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// the id is taken by a device of someone else
+		return mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func (DB *DB) GetDevice(ctx context.Context, id bson.ObjectID, user bson.ObjectID) (models.Device, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var device models.Device
+	err := DB.Db.Collection("devices").FindOne(ctx, bson.M{"_id": id, "user_id": user}).Decode(&device)
+	return device, err
+}
+
+func (DB *DB) GetDevices(ctx context.Context, user bson.ObjectID) ([]models.Device, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("devices").Find(ctx, bson.M{"user_id": user}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var devices []models.Device
+	return devices, cursor.All(ctx, &devices)
+}
+
+// GetUsersDevices returns the devices of several users at once, oldest
+// first.
+func (DB *DB) GetUsersDevices(ctx context.Context, users []bson.ObjectID) ([]models.Device, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("devices").Find(ctx, bson.M{"user_id": bson.M{"$in": users}}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var devices []models.Device
+	return devices, cursor.All(ctx, &devices)
+}
+
+// PublicKeys maps user ids to their public keys, users without one are
+// left out.
+func (DB *DB) PublicKeys(ctx context.Context, ids []bson.ObjectID) (map[bson.ObjectID][]byte, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"public_key": 1})
+	cursor, err := DB.Db.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	keys := make(map[bson.ObjectID][]byte, len(users))
+	for _, user := range users {
+		if len(user.PublicKey) > 0 {
+			keys[user.Id] = user.PublicKey
+		}
+	}
+	return keys, nil
+}
+
+// DeleteDevice removes a device with the prekeys it has left.
+func (DB *DB) DeleteDevice(ctx context.Context, id bson.ObjectID, user bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	return DB.transaction(ctx, func(ctx context.Context) error {
+		result, err := DB.Db.Collection("devices").DeleteOne(ctx, bson.M{"_id": id, "user_id": user})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		_, err = DB.Db.Collection("prekeys").DeleteMany(ctx, bson.M{"device_id": id})
+		return err
+	})
+}
+
+// AddPreKeys stores a batch of one-time prekeys. A key id the device
+// already uploaded is skipped, so a client may resend a batch.
+func (DB *DB) AddPreKeys(ctx context.Context, keys []models.PreKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	documents := make([]any, 0, len(keys))
+	for _, key := range keys {
+		documents = append(documents, key)
+	}
+	_, err := DB.Db.Collection("prekeys").InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// TakePreKey hands out the oldest prekey of a device and deletes it, so no
+// two peers get the same one. mongo.ErrNoDocuments means the device ran
+// out and the peer has to do with the signed prekey.
+func (DB *DB) TakePreKey(ctx context.Context, device bson.ObjectID) (models.PreKey, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var key models.PreKey
+	opts := options.FindOneAndDelete().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "key_id", Value: 1}})
+	err := DB.Db.Collection("prekeys").FindOneAndDelete(ctx, bson.M{"device_id": device}, opts).Decode(&key)
+	return key, err
+}
+
+// CountPreKeys tells a device how many prekeys it has left, for it to
+// upload more when running low.
+func (DB *DB) CountPreKeys(ctx context.Context, device bson.ObjectID) (int64, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	return DB.Db.Collection("prekeys").CountDocuments(ctx, bson.M{"device_id": device})
+}
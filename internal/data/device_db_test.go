@@ -0,0 +1,184 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"filachat/pkg/testserver"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"testing"
+	"time"
+)
+
+func TestDeviceKeepsIdentityKey(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	user := bson.NewObjectID()
+
+	device := models.Device{
+		Id:           bson.NewObjectID(),
+		UserId:       user,
+		Name:         "phone",
+		IdentityKey:  []byte("identity"),
+		SignedPreKey: models.SignedPreKey{KeyId: 1, PublicKey: []byte("signed 1"), Signature: []byte("sig 1")},
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := db.SaveDevice(ctx, &device); err != nil {
+		t.Fatal(err)
+	}
+	device.IdentityKey = []byte("other identity")
+	device.SignedPreKey = models.SignedPreKey{KeyId: 2, PublicKey: []byte("signed 2"), Signature: []byte("sig 2")}
+	if err := db.SaveDevice(ctx, &device); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := db.GetDevice(ctx, device.Id, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved.IdentityKey) != "identity" || saved.SignedPreKey.KeyId != 2 {
+		t.Errorf("Expected the first identity key and the second signed prekey, got %+v", saved)
+	}
+
+	device.UserId = bson.NewObjectID()
+	if err := db.SaveDevice(ctx, &device); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments saving a device of someone else, got %v", err)
+	}
+}
+
+func TestPreKeysAreTakenOnce(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	user, device := bson.NewObjectID(), bson.NewObjectID()
+
+	var keys []models.PreKey
+	for id := uint32(1); id <= 3; id++ {
+		keys = append(keys, models.PreKey{Id: bson.NewObjectID(), UserId: user, DeviceId: device, KeyId: id, PublicKey: []byte{byte(id)}, CreatedAt: time.Now()})
+	}
+	if err := db.AddPreKeys(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	// resending a batch does not duplicate keys
+	if err := db.AddPreKeys(ctx, keys[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := db.CountPreKeys(ctx, device); err != nil || count != 3 {
+		t.Fatalf("Expected 3 prekeys, got %d (%v)", count, err)
+	}
+
+	taken := map[uint32]bool{}
+	for range keys {
+		key, err := db.TakePreKey(ctx, device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken[key.KeyId] {
+			t.Errorf("Expected prekey %d to be handed out once", key.KeyId)
+		}
+		taken[key.KeyId] = true
+	}
+	if _, err := db.TakePreKey(ctx, device); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments once prekeys ran out, got %v", err)
+	}
+}
+
+func TestDeleteDeviceRemovesPreKeys(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	user := bson.NewObjectID()
+
+	device := models.Device{Id: bson.NewObjectID(), UserId: user, IdentityKey: []byte("identity"), CreatedAt: time.Now()}
+	if err := db.SaveDevice(ctx, &device); err != nil {
+		t.Fatal(err)
+	}
+	key := models.PreKey{Id: bson.NewObjectID(), UserId: user, DeviceId: device.Id, KeyId: 1, CreatedAt: time.Now()}
+	if err := db.AddPreKeys(ctx, []models.PreKey{key}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteDevice(ctx, device.Id, bson.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments deleting a device of someone else, got %v", err)
+	}
+	if err := db.DeleteDevice(ctx, device.Id, user); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := db.CountPreKeys(ctx, device.Id); count != 0 {
+		t.Errorf("Expected the prekeys to go with the device, %d left", count)
+	}
+}
+
+func TestWebhookDisabledAfterFailures(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	owner := bson.NewObjectID()
+
+	webhook := models.Webhook{Id: bson.NewObjectID(), OwnerId: owner, URL: "https://example.com/hook", Events: []string{"message"}, CreatedAt: time.Now()}
+	if err := db.SaveWebhook(ctx, &webhook); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := db.RecordWebhookDelivery(ctx, webhook.Id, false, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	webhooks, err := db.WebhooksFor(ctx, owner, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 0 {
+		t.Errorf("Expected the failing webhook to be disabled, got %+v", webhooks)
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	owner, member := bson.NewObjectID(), bson.NewObjectID()
+
+	group := models.Group{Id: bson.NewObjectID(), Name: "team", OwnerId: owner, Members: []bson.ObjectID{owner}, CreatedAt: time.Now()}
+	if err := db.SaveGroup(ctx, &group); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddGroupMember(ctx, group.Id, member); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := db.GetUserGroups(ctx, member, pagination.Page{Limit: 10})
+	if err != nil || len(groups) != 1 || groups[0].Id != group.Id {
+		t.Fatalf("Expected the member to see the group, got %+v (%v)", groups, err)
+	}
+
+	if err := db.RemoveGroupMember(ctx, group.Id, member); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveGroupMember(ctx, group.Id, member); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments removing a user not in the group, got %v", err)
+	}
+}
+
+func TestDeleteSessionsKeepsCurrent(t *testing.T) {
+	db := testserver.NewTestServer(t).DB
+	ctx := context.Background()
+	user := bson.NewObjectID()
+
+	var ids []bson.ObjectID
+	for range 3 {
+		session := models.Session{Id: bson.NewObjectID(), UserId: user, CreatedAt: time.Now(), LastUsedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := db.SaveSession(ctx, &session); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, session.Id)
+	}
+
+	deleted, err := db.DeleteSessions(ctx, user, ids[0])
+	if err != nil || deleted != 2 {
+		t.Fatalf("Expected 2 sessions ended, got %d (%v)", deleted, err)
+	}
+	sessions, err := db.GetSessions(ctx, user)
+	if err != nil || len(sessions) != 1 || sessions[0].Id != ids[0] {
+		t.Errorf("Expected only the current session left, got %+v (%v)", sessions, err)
+	}
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// SaveEmailChange stores a pending change, replacing any earlier request of
+// the same user so only the newest link stays valid.
+func (DB *DB) SaveEmailChange(ctx context.Context, change *models.EmailChange) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	if _, err := DB.Db.Collection("email_changes").DeleteMany(ctx, bson.M{"user_id": change.UserId}); err != nil {
+		return err
+	}
+	sealed := *change
+	var err error
+	if sealed.NewEmail, err = DB.seal(change.NewEmail); err != nil {
+		return err
+	}
+	_, err = DB.Db.Collection("email_changes").InsertOne(ctx, sealed)
+	return err
+}
+
+func (DB *DB) TakeEmailChange(ctx context.Context, tokenHash string) (models.EmailChange, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"token_hash": tokenHash, "expires_at": bson.M{"$gt": time.Now()}}
+	var change models.EmailChange
+	if err := DB.Db.Collection("email_changes").FindOneAndDelete(ctx, filter).Decode(&change); err != nil {
+		return models.EmailChange{}, err
+	}
+	change.NewEmail = DB.open(change.NewEmail)
+	return change, nil
+}
+
+// SaveMagicLink stores a sign-in link, replacing any earlier one of the same
+// user so only the newest link works.
+func (DB *DB) SaveMagicLink(ctx context.Context, link *models.MagicLink) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	if _, err := DB.Db.Collection("magic_links").DeleteMany(ctx, bson.M{"user_id": link.UserId}); err != nil {
+		return err
+	}
+	_, err := DB.Db.Collection("magic_links").InsertOne(ctx, link)
+	return err
+}
+
+// TakeMagicLink redeems a link once, and only from the device it was asked
+// for on.
+func (DB *DB) TakeMagicLink(ctx context.Context, tokenHash string, deviceHash string) (models.MagicLink, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"token_hash": tokenHash, "device_hash": deviceHash, "expires_at": bson.M{"$gt": time.Now()}}
+	var link models.MagicLink
+	err := DB.Db.Collection("magic_links").FindOneAndDelete(ctx, filter).Decode(&link)
+	return link, err
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// GetOrCreateRemoteUser returns the local stand-in of a user of another
+// server, creating it on first contact. Messages to and from remote users
+// are stored against it like for any other user.
+func (DB *DB) GetOrCreateRemoteUser(ctx context.Context, address string, host string) (models.User, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var user models.User
+	err := DB.Db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"username": address, "host": host, "deleted_at": notDeleted},
+		bson.M{"$setOnInsert": bson.M{"_id": bson.NewObjectID()}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&user)
+	return user, err
+}
+
+// SetRemoteUserKey keeps the public key a remote server last reported for
+// one of its users.
+func (DB *DB) SetRemoteUserKey(ctx context.Context, id bson.ObjectID, key []byte) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id, "host": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"public_key": key}})
+	return err
+}
+
+// RecordInbound notes a message received from another server and reports
+// false when it was received before, as servers retry deliveries they are
+// unsure about. Ids are remembered until expires.
+func (DB *DB) RecordInbound(ctx context.Context, origin string, id bson.ObjectID, expires time.Time) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("federation_inbox").InsertOne(ctx, bson.M{"_id": origin + "/" + id.Hex(), "expires_at": expires})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ForgetInbound undoes RecordInbound for a message that could not be
+// delivered, so the retry of the remote server is taken.
+func (DB *DB) ForgetInbound(ctx context.Context, origin string, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("federation_inbox").DeleteOne(ctx, bson.M{"_id": origin + "/" + id.Hex()})
+	return err
+}
+
+func (DB *DB) EnqueueOutbox(ctx context.Context, entry *models.OutboxEntry) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("federation_outbox").InsertOne(ctx, entry)
+	return err
+}
+
+// ClaimOutbox takes the entry most overdue and hides it from other
+// instances for lease. mongo.ErrNoDocuments means nothing is due.
+func (DB *DB) ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration) (models.OutboxEntry, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var entry models.OutboxEntry
+	err := DB.Db.Collection("federation_outbox").FindOneAndUpdate(ctx,
+		bson.M{"next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"next_attempt_at": 1}),
+	).Decode(&entry)
+	return entry, err
+}
+
+func (DB *DB) RescheduleOutbox(ctx context.Context, id bson.ObjectID, attempts int, next time.Time, lastError string) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("federation_outbox").UpdateByID(ctx, id, bson.M{"$set": bson.M{
+		"attempts":        attempts,
+		"next_attempt_at": next,
+		"last_error":      lastError,
+	}})
+	return err
+}
+
+func (DB *DB) DeleteOutbox(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("federation_outbox").DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
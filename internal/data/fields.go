@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/crypto"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"time"
+)
+
+// Sensitive fields are sealed with DB.Fields on the way in and opened on the
+// way out, so callers only ever see plain values. Without Fields everything
+// is stored in the clear, and values sealed earlier cannot be read.
+
+func (DB *DB) seal(value string) (string, error) {
+	if DB.Fields == nil {
+		return value, nil
+	}
+	return DB.Fields.Encrypt(value)
+}
+
+func (DB *DB) open(value string) string {
+	if DB.Fields == nil || !crypto.IsSealed(value) {
+		return value
+	}
+	plain, err := DB.Fields.Decrypt(value)
+	if err != nil {
+		log.Println("[WARN] field not decrypted", err)
+		return ""
+	}
+	return plain
+}
+
+// emailFilter matches a user by email, sealed or not yet migrated.
+func (DB *DB) emailFilter(email string) bson.M {
+	if DB.Fields == nil {
+		return bson.M{"email": email}
+	}
+	return bson.M{"$or": []bson.M{{"email": email}, {"email_hash": DB.Fields.Index(email)}}}
+}
+
+func (DB *DB) sealUser(user *models.User) error {
+	if DB.Fields == nil || user.Email == "" {
+		return nil
+	}
+	user.EmailHash = DB.Fields.Index(user.Email)
+	var err error
+	user.Email, err = DB.Fields.Encrypt(user.Email)
+	return err
+}
+
+func (DB *DB) openUser(user *models.User) {
+	user.Email = DB.open(user.Email)
+	user.TOTPSecret = DB.open(user.TOTPSecret)
+	if user.LastSeenSealed != "" {
+		if lastSeen, err := time.Parse(time.RFC3339Nano, DB.open(user.LastSeenSealed)); err == nil {
+			user.LastSeen = lastSeen
+		}
+	}
+}
+
+// maxLastSeenAttempts bounds how often setSealedLastSeen starts over after
+// another instance wrote last seen between its read and its write.
+const maxLastSeenAttempts = 3
+
+// setSealedLastSeen stores last seen sealed in last_seen_sealed. $max cannot
+// compare sealed values, so the newer time is picked here and only written
+// over the value that was read.
+func (DB *DB) setSealedLastSeen(ctx context.Context, id bson.ObjectID, at time.Time) error {
+	sealed, err := DB.Fields.Encrypt(at.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+	for range maxLastSeenAttempts {
+		var user models.User
+		opts := options.FindOne().SetProjection(bson.M{"last_seen": 1, "last_seen_sealed": 1})
+		if err := DB.Db.Collection("users").FindOne(ctx, bson.M{"_id": id}, opts).Decode(&user); err != nil {
+			return err
+		}
+		DB.openUser(&user)
+		if !at.After(user.LastSeen) {
+			return nil
+		}
+		filter := bson.M{"_id": id, "last_seen_sealed": user.LastSeenSealed}
+		if user.LastSeenSealed == "" {
+			filter["last_seen_sealed"] = bson.M{"$exists": false}
+		}
+		update := bson.M{"$set": bson.M{"last_seen_sealed": sealed}, "$unset": bson.M{"last_seen": ""}}
+		result, err := DB.Db.Collection("users").UpdateOne(ctx, filter, update)
+		if err != nil || result.MatchedCount > 0 {
+			return err
+		}
+	}
+	// the instances that won wrote times just as recent
+	return nil
+}
+
+// sealFields seals the sensitive entries of a $set document.
+func (DB *DB) sealFields(fields bson.M) (bson.M, error) {
+	email, ok := fields["email"].(string)
+	if DB.Fields == nil || !ok {
+		return fields, nil
+	}
+	sealed := bson.M{}
+	for key, value := range fields {
+		sealed[key] = value
+	}
+	var err error
+	if sealed["email"], err = DB.Fields.Encrypt(email); err != nil {
+		return nil, err
+	}
+	sealed["email_hash"] = DB.Fields.Index(email)
+	return sealed, nil
+}
+
+// EncryptFields seals the sensitive fields still stored in the clear and
+// returns how many documents it changed per collection, last seen times
+// are counted as users.last_seen. It is safe to run again, sealed values
+// are skipped.
+func (DB *DB) EncryptFields(ctx context.Context) (map[string]int64, error) {
+	if DB.Fields == nil {
+		return nil, errors.New("field encryption not configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	changed := make(map[string]int64)
+	for collection, field := range map[string]string{
+		"users":         "email",
+		"email_changes": "new_email",
+		"logins":        "ip",
+	} {
+		filter := bson.M{field: bson.M{"$type": "string", "$ne": "", "$not": bson.M{"$regex": "^enc:v1:"}}}
+		cursor, err := DB.Db.Collection(collection).Find(ctx, filter)
+		if err != nil {
+			return changed, err
+		}
+		for cursor.Next(ctx) {
+			var doc bson.M
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return changed, err
+			}
+			value, _ := doc[field].(string)
+			sealed, err := DB.Fields.Encrypt(value)
+			if err != nil {
+				cursor.Close(ctx)
+				return changed, err
+			}
+			set := bson.M{field: sealed}
+			if collection == "users" {
+				set["email_hash"] = DB.Fields.Index(value)
+			}
+			// only replace the value that was read, a concurrent write wins
+			if _, err := DB.Db.Collection(collection).UpdateOne(ctx, bson.M{"_id": doc["_id"], field: value}, bson.M{"$set": set}); err != nil {
+				cursor.Close(ctx)
+				return changed, err
+			}
+			changed[collection]++
+		}
+		if err := cursor.Err(); err != nil {
+			cursor.Close(ctx)
+			return changed, err
+		}
+		cursor.Close(ctx)
+	}
+
+	count, err := DB.encryptLastSeen(ctx)
+	if count > 0 {
+		changed["users.last_seen"] = count
+	}
+	return changed, err
+}
+
+// encryptLastSeen moves last seen times still stored as dates into
+// last_seen_sealed.
+func (DB *DB) encryptLastSeen(ctx context.Context) (int64, error) {
+	filter := bson.M{"last_seen": bson.M{"$type": "date"}, "last_seen_sealed": bson.M{"$exists": false}}
+	opts := options.Find().SetProjection(bson.M{"last_seen": 1})
+	cursor, err := DB.Db.Collection("users").Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var changed int64
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return changed, err
+		}
+		sealed, err := DB.Fields.Encrypt(user.LastSeen.UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			return changed, err
+		}
+		// a concurrent SetLastSeen seals a newer time and wins
+		update := bson.M{"$set": bson.M{"last_seen_sealed": sealed}, "$unset": bson.M{"last_seen": ""}}
+		result, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": user.Id, "last_seen": user.LastSeen, "last_seen_sealed": bson.M{"$exists": false}}, update)
+		if err != nil {
+			return changed, err
+		}
+		changed += result.ModifiedCount
+	}
+	return changed, cursor.Err()
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+func (DB *DB) SaveGroup(ctx context.Context, group *models.Group) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("groups").InsertOne(ctx, *group)
+	return err
+}
+
+func (DB *DB) GetGroup(ctx context.Context, id bson.ObjectID) (models.Group, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var group models.Group
+	if err := DB.Db.Collection("groups").FindOne(ctx, bson.M{"_id": id}).Decode(&group); err != nil {
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+func (DB *DB) AddGroupMember(ctx context.Context, id bson.ObjectID, user bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("groups").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$addToSet": bson.M{"members": user}})
+	return err
+}
+
+// RemoveGroupMember takes a user out of a group, mongo.ErrNoDocuments means
+// they were not in it.
+func (DB *DB) RemoveGroupMember(ctx context.Context, id bson.ObjectID, user bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("groups").UpdateOne(ctx, bson.M{"_id": id, "members": user}, bson.M{"$pull": bson.M{"members": user}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetUserGroups lists the groups a user is a member of, newest first.
+func (DB *DB) GetUserGroups(ctx context.Context, user bson.ObjectID, page pagination.Page) ([]models.Group, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{{"members": user}, page.Filter("created_at")}}
+	cursor, err := DB.Db.Collection("groups").Find(ctx, filter, page.FindOptions("created_at"))
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.Group
+	return groups, cursor.All(ctx, &groups)
+}
+
+func (DB *DB) GetGroupMessages(ctx context.Context, groupId bson.ObjectID, page pagination.Page) ([]models.Message, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{
+		{"group_id": groupId, "deleted_at": notDeleted},
+		page.Filter("timestamp"),
+	}}
+	cursor, err := DB.Db.Collection("messages").Find(ctx, filter, page.FindOptions("timestamp"))
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.Message
+	return messages, cursor.All(ctx, &messages)
+}
+
+// receiptProjection is what handling a receipt needs of a message: its id
+// and the receive time for latency metrics.
+var receiptProjection = bson.M{"_id": 1, "received_at": 1, "via": 1}
+
+// GroupReceiptMessages narrows ids down to the messages of a group that
+// user did not send, the ones they may report receipts for. Only the
+// fields of receiptProjection are loaded.
+func (DB *DB) GroupReceiptMessages(ctx context.Context, groupId bson.ObjectID, user bson.ObjectID, ids []bson.ObjectID) ([]models.Message, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": bson.M{"$in": ids}, "group_id": groupId, "sender_id": bson.M{"$ne": user}}
+	cursor, err := DB.Db.Collection("messages").Find(ctx, filter, options.Find().SetProjection(receiptProjection))
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.Message
+	return messages, cursor.All(ctx, &messages)
+}
+
+// UnreadReceiptMessages narrows ids down to the direct messages to user
+// not read yet, loading the fields of receiptProjection.
+func (DB *DB) UnreadReceiptMessages(ctx context.Context, user bson.ObjectID, ids []bson.ObjectID) ([]models.Message, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": bson.M{"$in": ids}, "recipient_id": user, "read": bson.M{"$ne": true}}
+	cursor, err := DB.Db.Collection("messages").Find(ctx, filter, options.Find().SetProjection(receiptProjection))
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.Message
+	return messages, cursor.All(ctx, &messages)
+}
+
+// MarkReceipts records that user got or read the given messages, one
+// document per member and message, in a single bulk write. Timestamps only
+// ever move back, so repeated reports keep the first time, and reading a
+// message implies it was delivered.
+func (DB *DB) MarkReceipts(ctx context.Context, user bson.ObjectID, ids []bson.ObjectID, status models.StatusType, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	times := bson.M{"delivered_at": at}
+	if status == models.StatusRead {
+		times["read_at"] = at
+	}
+	writes := make([]mongo.WriteModel, len(ids))
+	for i, id := range ids {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"message_id": id, "user_id": user}).
+			SetUpdate(bson.M{"$min": times}).
+			SetUpsert(true)
+	}
+	_, err := DB.Db.Collection("message_receipts").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// GetReceipts pages through the members who reached status on a message,
+// latest first.
+func (DB *DB) GetReceipts(ctx context.Context, messageId bson.ObjectID, status models.StatusType, page pagination.Page) ([]models.Receipt, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	field := receiptField(status)
+	filter := bson.M{"$and": []bson.M{
+		{"message_id": messageId, field: bson.M{"$exists": true}},
+		page.Filter(field),
+	}}
+	cursor, err := DB.Db.Collection("message_receipts").Find(ctx, filter, page.FindOptions(field))
+	if err != nil {
+		return nil, err
+	}
+	var receipts []models.Receipt
+	return receipts, cursor.All(ctx, &receipts)
+}
+
+func (DB *DB) CountReceipts(ctx context.Context, messageId bson.ObjectID) (delivered int64, read int64, err error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	receipts := DB.Db.Collection("message_receipts")
+	if delivered, err = receipts.CountDocuments(ctx, bson.M{"message_id": messageId, "delivered_at": bson.M{"$exists": true}}); err != nil {
+		return 0, 0, err
+	}
+	read, err = receipts.CountDocuments(ctx, bson.M{"message_id": messageId, "read_at": bson.M{"$exists": true}})
+	return delivered, read, err
+}
+
+func receiptField(status models.StatusType) string {
+	if status == models.StatusRead {
+		return "read_at"
+	}
+	return "delivered_at"
+}
+
+// UnreadCounts counts the direct messages user has not read, and the group
+// messages of their groups they have no read receipt for.
+func (DB *DB) UnreadCounts(ctx context.Context, user bson.ObjectID) (direct int64, groups int64, err error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	messages := DB.Db.Collection("messages")
+	direct, err = messages.CountDocuments(ctx, bson.M{"recipient_id": user, "read": bson.M{"$ne": true}, "deleted_at": notDeleted})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cursor, err := DB.Db.Collection("groups").Find(ctx, bson.M{"members": user}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, 0, err
+	}
+	var memberOf []struct {
+		Id bson.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &memberOf); err != nil || len(memberOf) == 0 {
+		return direct, 0, err
+	}
+	ids := make([]bson.ObjectID, len(memberOf))
+	for i, group := range memberOf {
+		ids[i] = group.Id
+	}
+
+	total, err := messages.CountDocuments(ctx, bson.M{"group_id": bson.M{"$in": ids}, "sender_id": bson.M{"$ne": user}, "deleted_at": notDeleted})
+	if err != nil {
+		return 0, 0, err
+	}
+	read, err := DB.Db.Collection("message_receipts").CountDocuments(ctx, bson.M{"user_id": user, "read_at": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, 0, err
+	}
+	return direct, max(total-read, 0), nil
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// importBatch is how many messages go to the database at once.
+const importBatch = 1000
+
+// ImportMessages stores imported messages and returns how many were new.
+// Messages imported before, known by their import key, are skipped, so an
+// interrupted import can be run again.
+func (DB *DB) ImportMessages(ctx context.Context, messages []models.Message) (int, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	imported := 0
+	for start := 0; start < len(messages); start += importBatch {
+		batch := messages[start:min(start+importBatch, len(messages))]
+		documents := make([]any, 0, len(batch))
+		for _, message := range batch {
+			documents = append(documents, message)
+		}
+		result, err := DB.Db.Collection("messages").InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+		if result != nil {
+			imported += len(result.InsertedIDs)
+		}
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
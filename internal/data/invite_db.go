@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+func (DB *DB) SaveInvites(ctx context.Context, invites []models.Invite) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("invites").InsertMany(ctx, invites)
+	return err
+}
+
+// CountInvites counts the invites a user handed out themselves, bulk codes
+// from admins do not take from their allowance.
+func (DB *DB) CountInvites(ctx context.Context, createdBy bson.ObjectID) (int64, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	return DB.Db.Collection("invites").CountDocuments(ctx, bson.M{"created_by": createdBy, "bulk": bson.M{"$ne": true}})
+}
+
+func (DB *DB) GetInvites(ctx context.Context, filter bson.M, page pagination.Page) ([]models.Invite, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("invites").Find(ctx, bson.M{"$and": []bson.M{filter, page.Filter("created_at")}}, page.FindOptions("created_at"))
+	if err != nil {
+		return nil, err
+	}
+	var invites []models.Invite
+	return invites, cursor.All(ctx, &invites)
+}
+
+// TakeInvite marks an unused, unexpired invite as used by user. Only one
+// signup can win a code.
+func (DB *DB) TakeInvite(ctx context.Context, codeHash string, user bson.ObjectID) (models.Invite, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"code_hash": codeHash, "used_by": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": time.Now()}}
+	update := bson.M{"$set": bson.M{"used_by": user, "used_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var invite models.Invite
+	err := DB.Db.Collection("invites").FindOneAndUpdate(ctx, filter, update, opts).Decode(&invite)
+	return invite, err
+}
+
+// ReleaseInvite hands a taken invite back when the signup failed after all.
+func (DB *DB) ReleaseInvite(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("invites").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"used_by": "", "used_at": ""}})
+	return err
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (DB *DB) GetIPRules(ctx context.Context) ([]models.IPRule, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("ip_rules").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []models.IPRule
+	if err := result.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (DB *DB) SaveIPRule(ctx context.Context, rule *models.IPRule) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("ip_rules").InsertOne(ctx, *rule)
+	return err
+}
+
+func (DB *DB) DeleteIPRule(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("ip_rules").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"time"
+)
+
+// EnsureWrappedKey returns the wrapped key stored under name, storing the
+// one generate returns when there is none yet. When two instances race the
+// first write wins and both get its key.
+func (DB *DB) EnsureWrappedKey(ctx context.Context, name string, generate func() ([]byte, error)) ([]byte, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var stored struct {
+		Wrapped []byte `bson:"wrapped"`
+	}
+	err := DB.Db.Collection("keys").FindOne(ctx, bson.M{"_id": name}).Decode(&stored)
+	if err == nil {
+		return stored.Wrapped, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	wrapped, err := generate()
+	if err != nil {
+		return nil, err
+	}
+	_, err = DB.Db.Collection("keys").InsertOne(ctx, bson.M{"_id": name, "wrapped": wrapped, "created_at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		if err := DB.Db.Collection("keys").FindOne(ctx, bson.M{"_id": name}).Decode(&stored); err != nil {
+			return nil, err
+		}
+		return stored.Wrapped, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return wrapped, nil
+}
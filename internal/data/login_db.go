@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// IsNewDevice reports whether the user has signed in before but never from
+// this network and user agent. The very first login is not considered new.
+func (DB *DB) IsNewDevice(ctx context.Context, userId bson.ObjectID, network string, userAgent string) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	total, err := DB.Db.Collection("logins").CountDocuments(ctx, bson.M{"user_id": userId})
+	if err != nil || total == 0 {
+		return false, err
+	}
+
+	known, err := DB.Db.Collection("logins").CountDocuments(ctx, bson.M{
+		"user_id":    userId,
+		"network":    network,
+		"user_agent": userAgent,
+	})
+	if err != nil {
+		return false, err
+	}
+	return known == 0, nil
+}
+
+func (DB *DB) SaveLogin(ctx context.Context, login *models.Login) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	sealed := *login
+	var err error
+	if sealed.IP, err = DB.seal(login.IP); err != nil {
+		return err
+	}
+	_, err = DB.Db.Collection("logins").InsertOne(ctx, sealed)
+	return err
+}
+
+func (DB *DB) TakeLoginReport(ctx context.Context, reportHash string) (models.Login, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var login models.Login
+	err := DB.Db.Collection("logins").FindOneAndUpdate(ctx,
+		bson.M{"report_hash": reportHash},
+		bson.M{"$unset": bson.M{"report_hash": ""}},
+	).Decode(&login)
+	if err != nil {
+		return models.Login{}, err
+	}
+	login.IP = DB.open(login.IP)
+	return login, nil
+}
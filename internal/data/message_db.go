@@ -2,73 +2,191 @@ package database
 
 import (
 	"context"
+	"errors"
 	"filachat/internal/models"
+	"filachat/pkg/pagination"
 	"go.mongodb.org/mongo-driver/v2/bson"
-	"sync"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 "time"
 )
 
-// TODO: Store Online Users in the map :!
-
-type MessageDB struct {
-	userStatus map[bson.ObjectID]bool
-	userStatusLock sync.RWMutex
+func (DB *DB) SaveMessage(ctx context.Context, message *models.Message) error {
+	ctx, cancel := DB.query(ctx)
+    defer cancel()
 
-	lastSeen map[bson.ObjectID]time.Time
-	lastSeenLock sync.RWMutex
+	_, err := DB.Db.Collection("messages").InsertOne(ctx, *message)
+	if err != nil { return err }
+	return nil
 }
+func (DB *DB) GetMessage(ctx context.Context, id bson.ObjectID) (models.Message, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
 
-func NewMessageDB() *MessageDB {
-	return &MessageDB{
-		userStatus: make(map[bson.ObjectID]bool),
-		lastSeen:   make(map[bson.ObjectID]time.Time),
-	}
+	var message models.Message
+	err := DB.Db.Collection("messages").FindOne(ctx, bson.M{"_id": id, "deleted_at": notDeleted}).Decode(&message)
+	return message, err
 }
+func (DB *DB) ReadMessage(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
 
-func (db *MessageDB) UserOline(userID bson.ObjectID) bool {
-	db.userStatusLock.RLock()
-	defer db.userStatusLock.RUnlock()
+	_, err := DB.Db.Collection("messages").UpdateOne(ctx, bson.D{{"_id", id}, {"deleted_at", notDeleted}}, bson.D{{"$set", bson.D{{"read", true}}}})
+	return err
+}
+// MarkRead flags messages addressed to user as read and returns how many
+// were unread before.
+func (DB *DB) MarkRead(ctx context.Context, user bson.ObjectID, ids []bson.ObjectID) (int64, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
 
-	online, exists := db.userStatus[userID]
-	return exists && online
+	filter := bson.M{"_id": bson.M{"$in": ids}, "recipient_id": user, "read": bson.M{"$ne": true}}
+	result, err := DB.Db.Collection("messages").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read": true}})
+	if err != nil { return 0, err }
+	return result.ModifiedCount, nil
 }
+func (DB *DB) GetUnreadMessages(ctx context.Context, id bson.ObjectID, page pagination.Page) ([]models.Message, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{
+		{"recipient_id": id, "read": bson.M{"$ne": true}, "deleted_at": notDeleted},
+		page.Filter("timestamp"),
+	}}
+	result, err := DB.Db.Collection("messages").Find(ctx, filter, page.FindOptions("timestamp"))
+	if err != nil { return models.NilMessages, err }
+
+	var messages []models.Message
+	if err := result.All(ctx, &messages); err != nil { return models.NilMessages, err }
 
-func (db *MessageDB) ToggleUserActivity(userID bson.ObjectID) {
-	db.userStatusLock.Lock()
-	defer db.userStatusLock.Unlock()
+	return messages, nil
+}
+// StreamConversation walks the messages between two users oldest first.
+// HasConversation reports whether either user ever wrote to the other.
+func (DB *DB) HasConversation(ctx context.Context, a bson.ObjectID, b bson.ObjectID) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
 
-	if db.userStatus[userID] {
-		delete(db.userStatus, userID)
-	} else {
-		db.userStatus[userID] = true
+	filter := bson.M{"$or": []bson.M{
+		{"sender_id": a, "recipient_id": b},
+		{"sender_id": b, "recipient_id": a},
+	}}
+	err := DB.Db.Collection("messages").FindOne(ctx, filter).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
 	}
+	return err == nil, err
 }
 
-func (DB *DB) SaveMessage(message *models.Message) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
+func (DB *DB) StreamConversation(ctx context.Context, userId bson.ObjectID, peerId bson.ObjectID, fn func(models.Message) error) error {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
 
-	_, err := DB.Db.Collection("messages").InsertOne(ctx, *message)
+	filter := bson.M{
+		"$or": []bson.M{
+			{"sender_id": userId, "recipient_id": peerId},
+			{"sender_id": peerId, "recipient_id": userId},
+		},
+		"deleted_at": notDeleted,
+	}
+	opts := options.Find().SetSort(bson.D{{"timestamp", 1}, {"_id", 1}})
+	cursor, err := DB.Db.Collection("messages").Find(ctx, filter, opts)
 	if err != nil { return err }
-	return nil
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message models.Message
+		if err := cursor.Decode(&message); err != nil { return err }
+		if err := fn(message); err != nil { return err }
+	}
+	return cursor.Err()
 }
-func (DB *DB) ReadMessage(id bson.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+// CountMessagesBefore and DeleteMessagesBefore back the age based retention rule.
+func (DB *DB) CountMessagesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := DB.batch(ctx)
 	defer cancel()
 
-	_, err := DB.Db.Collection("messages").UpdateByID(ctx, id, bson.D{{"read", true}})
-	return err
+	return DB.Db.Collection("messages").CountDocuments(ctx, bson.M{"timestamp": bson.M{"$lt": cutoff}})
 }
-func (DB *DB) GetUnreadMessages(id bson.ObjectID) ([]models.Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (DB *DB) DeleteMessagesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := DB.batch(ctx)
 	defer cancel()
 
-	filter := bson.D{{"recipient_id", id}}
-	result, err := DB.Db.Collection("messages").Find(ctx, filter)
-	if err != nil { return models.NilMessages, err }
+	result, err := DB.Db.Collection("messages").DeleteMany(ctx, bson.M{"timestamp": bson.M{"$lt": cutoff}})
+	if err != nil { return 0, err }
+	return result.DeletedCount, nil
+}
 
-	var messages []models.Message
-	if err := result.All(ctx, &messages); err != nil { return models.NilMessages, err }
+// OverflowingMessages returns, per conversation holding more than max
+// messages, the ids of everything past the newest max.
+func (DB *DB) OverflowingMessages(ctx context.Context, max int64) ([]bson.ObjectID, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
 
-	return messages, nil
-}
\ No newline at end of file
+	pipeline := []bson.M{
+		{"$group": bson.M{
+			"_id": bson.M{
+				"a": bson.M{"$min": []string{"$sender_id", "$recipient_id"}},
+				"b": bson.M{"$max": []string{"$sender_id", "$recipient_id"}},
+			},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$match": bson.M{"count": bson.M{"$gt": max}}},
+	}
+	result, err := DB.Db.Collection("messages").Aggregate(ctx, pipeline)
+	if err != nil { return nil, err }
+
+	var conversations []struct {
+		Id struct {
+			A bson.ObjectID `bson:"a"`
+			B bson.ObjectID `bson:"b"`
+		} `bson:"_id"`
+	}
+	if err := result.All(ctx, &conversations); err != nil { return nil, err }
+
+	var ids []bson.ObjectID
+	for _, conversation := range conversations {
+		filter := bson.M{"$or": []bson.M{
+			{"sender_id": conversation.Id.A, "recipient_id": conversation.Id.B},
+			{"sender_id": conversation.Id.B, "recipient_id": conversation.Id.A},
+		}}
+		opts := options.Find().
+			SetSort(bson.D{{"timestamp", -1}, {"_id", -1}}).
+			SetSkip(max).
+			SetProjection(bson.M{"_id": 1})
+		cursor, err := DB.Db.Collection("messages").Find(ctx, filter, opts)
+		if err != nil { return nil, err }
+
+		var overflow []struct {
+			Id bson.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &overflow); err != nil { return nil, err }
+		for _, message := range overflow {
+			ids = append(ids, message.Id)
+		}
+	}
+	return ids, nil
+}
+func (DB *DB) DeleteMessages(ctx context.Context, ids []bson.ObjectID) (int64, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("messages").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil { return 0, err }
+	return result.DeletedCount, nil
+}
+// SetMessageError records why a stored message was not delivered.
+func (DB *DB) SetMessageError(ctx context.Context, id bson.ObjectID, messageError models.MessageError) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("messages").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"error": messageError}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// indexes backs the queries the handlers and jobs run. Creating an index
+// that already exists with the same options is a no-op, so Migrate can run
+// on every deploy.
+var indexes = map[string][]mongo.IndexModel{
+	"users": {
+		// unique among users not deleted, who all lack deleted_at; deleted
+		// users differ in when they were deleted
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "deleted_at", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "email", Value: 1}}},
+		{Keys: bson.D{{Key: "email_hash", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "deactivated", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "account.state", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"messages": {
+		{Keys: bson.D{{Key: "recipient_id", Value: 1}, {Key: "read", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "sender_id", Value: 1}, {Key: "recipient_id", Value: 1}, {Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "timestamp", Value: 1}}},
+		{Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "timestamp", Value: -1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "import.key", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	},
+	"conversation_summaries": {
+		{Keys: bson.D{{Key: "participants", Value: 1}, {Key: "updated_at", Value: -1}, {Key: "last_message_id", Value: -1}}},
+	},
+	"groups": {
+		{Keys: bson.D{{Key: "members", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "org_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"channels": {
+		{Keys: bson.D{{Key: "org_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	},
+	"org_members": {
+		{Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "joined_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"message_receipts": {
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "delivered_at", Value: -1}}},
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "read_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"device_receipts": {
+		{Keys: bson.D{{Key: "message_id", Value: 1}, {Key: "device_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"calls": {
+		{Keys: bson.D{{Key: "caller_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "callee_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"attachments": {
+		{Keys: bson.D{{Key: "owner_id", Value: 1}}},
+	},
+	"uploads": {
+		{Keys: bson.D{{Key: "updated_at", Value: 1}}},
+	},
+	"channel_posts": {
+		{Keys: bson.D{{Key: "channel_id", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "timestamp", Value: -1}}},
+	},
+	"invites": {
+		{Keys: bson.D{{Key: "code_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_by", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"logins": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"push_tokens": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"sessions": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_used_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"conversation_settings": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "peer_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "labels", Value: 1}}},
+	},
+	"stars": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "message_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"support_sessions": {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"reserved_names": {
+		{Keys: bson.D{{Key: "skeleton", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"name_claims": {
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "reserved_id", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": "pending"})},
+	},
+	"magic_links": {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"devices": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	},
+	"prekeys": {
+		{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "key_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "key_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"webhooks": {
+		{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "events", Value: 1}}},
+	},
+	"federation_outbox": {
+		{Keys: bson.D{{Key: "next_attempt_at", Value: 1}}},
+	},
+	"federation_inbox": {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	"media_nonces": {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+}
+
+func (DB *DB) Migrate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	for collection, models := range indexes {
+		if _, err := DB.Db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// SaveOrganization stores a new organization together with its owner as
+// the first member.
+func (DB *DB) SaveOrganization(ctx context.Context, org *models.Organization) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	owner := models.OrgMember{
+		Id:       bson.NewObjectID(),
+		OrgId:    org.Id,
+		UserId:   org.OwnerId,
+		Role:     models.OrgRoleOwner,
+		JoinedAt: org.CreatedAt,
+	}
+	return DB.transaction(ctx, func(ctx context.Context) error {
+		if _, err := DB.Db.Collection("organizations").InsertOne(ctx, *org); err != nil {
+			return err
+		}
+		_, err := DB.Db.Collection("org_members").InsertOne(ctx, owner)
+		return err
+	})
+}
+
+func (DB *DB) GetOrganization(ctx context.Context, id bson.ObjectID) (models.Organization, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var org models.Organization
+	err := DB.Db.Collection("organizations").FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	return org, err
+}
+
+func (DB *DB) SetOrgSettings(ctx context.Context, id bson.ObjectID, settings models.OrgSettings) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("organizations").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"settings": settings}})
+	return err
+}
+
+func (DB *DB) GetOrgMember(ctx context.Context, orgId bson.ObjectID, userId bson.ObjectID) (models.OrgMember, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var member models.OrgMember
+	err := DB.Db.Collection("org_members").FindOne(ctx, bson.M{"org_id": orgId, "user_id": userId}).Decode(&member)
+	return member, err
+}
+
+func (DB *DB) GetOrgMembers(ctx context.Context, orgId bson.ObjectID, page pagination.Page) ([]models.OrgMember, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{{"org_id": orgId}, page.Filter("joined_at")}}
+	cursor, err := DB.Db.Collection("org_members").Find(ctx, filter, page.FindOptions("joined_at"))
+	if err != nil {
+		return nil, err
+	}
+	var members []models.OrgMember
+	return members, cursor.All(ctx, &members)
+}
+
+// SetOrgMember adds a user to an organization or changes their role.
+func (DB *DB) SetOrgMember(ctx context.Context, member *models.OrgMember) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("org_members").UpdateOne(ctx,
+		bson.M{"org_id": member.OrgId, "user_id": member.UserId},
+		bson.M{
+			"$set":         bson.M{"role": member.Role},
+			"$setOnInsert": bson.M{"_id": member.Id, "joined_at": member.JoinedAt},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// RemoveOrgMember takes a user out of an organization and out of the groups
+// it owns.
+func (DB *DB) RemoveOrgMember(ctx context.Context, orgId bson.ObjectID, userId bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	if _, err := DB.Db.Collection("org_members").DeleteOne(ctx, bson.M{"org_id": orgId, "user_id": userId}); err != nil {
+		return err
+	}
+	_, err := DB.Db.Collection("groups").UpdateMany(ctx, bson.M{"org_id": orgId}, bson.M{"$pull": bson.M{"members": userId}})
+	return err
+}
+
+// Usernames maps user ids to their usernames for listings.
+func (DB *DB) Usernames(ctx context.Context, ids []bson.ObjectID) (map[bson.ObjectID]string, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"username": 1})
+	cursor, err := DB.Db.Collection("users").Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	names := make(map[bson.ObjectID]string, len(users))
+	for _, user := range users {
+		names[user.Id] = user.Username
+	}
+	return names, nil
+}
+
+func (DB *DB) GetOrgGroups(ctx context.Context, orgId bson.ObjectID, member bson.ObjectID) ([]models.Group, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("groups").Find(ctx, bson.M{"org_id": orgId, "members": member})
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.Group
+	return groups, cursor.All(ctx, &groups)
+}
+
+func (DB *DB) GetOrgChannels(ctx context.Context, orgId bson.ObjectID) ([]models.Channel, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("channels").Find(ctx, bson.M{"org_id": orgId})
+	if err != nil {
+		return nil, err
+	}
+	var channels []models.Channel
+	return channels, cursor.All(ctx, &channels)
+}
+
+// OrgsWithRetention lists the organizations that set a retention of their
+// own, for the retention janitor.
+func (DB *DB) OrgsWithRetention(ctx context.Context) ([]models.Organization, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("organizations").Find(ctx, bson.M{"settings.retention_days": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	var orgs []models.Organization
+	return orgs, cursor.All(ctx, &orgs)
+}
+
+// orgContentFilters selects the group messages and channel posts owned by
+// an organization.
+func (DB *DB) orgContentFilters(ctx context.Context, orgId bson.ObjectID, cutoff time.Time) (bson.M, bson.M, error) {
+	ids := func(collection string) ([]bson.ObjectID, error) {
+		cursor, err := DB.Db.Collection(collection).Find(ctx, bson.M{"org_id": orgId}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var docs []struct {
+			Id bson.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+		ids := make([]bson.ObjectID, len(docs))
+		for i, doc := range docs {
+			ids[i] = doc.Id
+		}
+		return ids, nil
+	}
+	groups, err := ids("groups")
+	if err != nil {
+		return nil, nil, err
+	}
+	channels, err := ids("channels")
+	if err != nil {
+		return nil, nil, err
+	}
+	messages := bson.M{"group_id": bson.M{"$in": groups}, "timestamp": bson.M{"$lt": cutoff}}
+	posts := bson.M{"channel_id": bson.M{"$in": channels}, "timestamp": bson.M{"$lt": cutoff}}
+	return messages, posts, nil
+}
+
+func (DB *DB) CountOrgContentBefore(ctx context.Context, orgId bson.ObjectID, cutoff time.Time) (int64, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	messages, posts, err := DB.orgContentFilters(ctx, orgId, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	count, err := DB.Db.Collection("messages").CountDocuments(ctx, messages)
+	if err != nil {
+		return 0, err
+	}
+	postCount, err := DB.Db.Collection("channel_posts").CountDocuments(ctx, posts)
+	return count + postCount, err
+}
+
+func (DB *DB) DeleteOrgContentBefore(ctx context.Context, orgId bson.ObjectID, cutoff time.Time) (int64, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	messages, posts, err := DB.orgContentFilters(ctx, orgId, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	result, err := DB.Db.Collection("messages").DeleteMany(ctx, messages)
+	if err != nil {
+		return 0, err
+	}
+	postResult, err := DB.Db.Collection("channel_posts").DeleteMany(ctx, posts)
+	if err != nil {
+		return result.DeletedCount, err
+	}
+	return result.DeletedCount + postResult.DeletedCount, nil
+}
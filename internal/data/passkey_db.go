@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+func (DB *DB) SavePasskeySession(ctx context.Context, session *models.PasskeySession) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("passkey_sessions").InsertOne(ctx, *session)
+	return err
+}
+
+// TakePasskeySession returns a pending ceremony and removes it, so every
+// challenge can be answered at most once.
+func (DB *DB) TakePasskeySession(ctx context.Context, id bson.ObjectID, sessionType models.PasskeySessionType) (models.PasskeySession, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "type": sessionType, "expires_at": bson.M{"$gt": time.Now()}}
+	var session models.PasskeySession
+	if err := DB.Db.Collection("passkey_sessions").FindOneAndDelete(ctx, filter).Decode(&session); err != nil {
+		return models.PasskeySession{}, err
+	}
+	return session, nil
+}
+
+func (DB *DB) GetPasskeyCredentials(ctx context.Context, userId bson.ObjectID) ([]models.PasskeyCredential, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("passkey_credentials").Find(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials []models.PasskeyCredential
+	if err := result.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (DB *DB) SavePasskeyCredential(ctx context.Context, credential *models.PasskeyCredential) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("passkey_credentials").InsertOne(ctx, *credential)
+	return err
+}
+
+func (DB *DB) UpdatePasskeyCredential(ctx context.Context, userId bson.ObjectID, credential *webauthn.Credential) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"user_id": userId, "credential.id": credential.ID}
+	_, err := DB.Db.Collection("passkey_credentials").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"credential": *credential}})
+	return err
+}
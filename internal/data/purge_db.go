@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// userOwned lists the collections whose documents belong to one user through
+// a user_id field.
+var userOwned = []string{
+	"username_history",
+	"email_changes",
+	"magic_links",
+	"sessions",
+	"logins",
+	"passkey_credentials",
+	"passkey_sessions",
+	"push_tokens",
+	"message_receipts",
+	"device_receipts",
+	"org_members",
+	"devices",
+	"prekeys",
+	"conversation_settings",
+	"stars",
+	"name_claims",
+}
+
+// PurgeUser hard deletes a user and everything tied to them, bypassing the
+// trash. The audit log is kept. The attachments and uploads are returned so
+// the caller can delete their blobs. The documents go in one transaction,
+// a failure leaves the user as they were.
+func (DB *DB) PurgeUser(ctx context.Context, id bson.ObjectID) ([]models.Attachment, []models.Upload, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	var (
+		attachments []models.Attachment
+		uploads     []models.Upload
+	)
+	err := DB.transaction(ctx, func(ctx context.Context) error {
+		attachments, uploads = nil, nil
+		cursor, err := DB.Db.Collection("attachments").Find(ctx, bson.M{"owner_id": id})
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(ctx, &attachments); err != nil {
+			return err
+		}
+		cursor, err = DB.Db.Collection("uploads").Find(ctx, bson.M{"owner_id": id})
+		if err != nil {
+			return err
+		}
+		if err := cursor.All(ctx, &uploads); err != nil {
+			return err
+		}
+		return DB.purgeUser(ctx, id)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return attachments, uploads, nil
+}
+
+func (DB *DB) purgeUser(ctx context.Context, id bson.ObjectID) error {
+
+	deletes := map[string]bson.M{
+		"users":                  {"_id": id},
+		"messages":               {"$or": []bson.M{{"sender_id": id}, {"recipient_id": id}}},
+		"calls":                  {"$or": []bson.M{{"caller_id": id}, {"callee_id": id}}},
+		"attachments":            {"owner_id": id},
+		"uploads":                {"owner_id": id},
+		"channel_posts":          {"author_id": id},
+		"conversations":          {"participants": id},
+		"conversation_summaries": {"participants": id},
+		"webhooks":               {"owner_id": id},
+	}
+	for _, collection := range userOwned {
+		deletes[collection] = bson.M{"user_id": id}
+	}
+	for collection, filter := range deletes {
+		if _, err := DB.Db.Collection(collection).DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+	}
+	_, err := DB.Db.Collection("groups").UpdateMany(ctx, bson.M{"members": id}, bson.M{"$pull": bson.M{"members": id}})
+	return err
+}
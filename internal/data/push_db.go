@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// SavePushToken registers a device token. A token moving to another account
+// is reassigned, so a shared device never receives the previous user's pushes.
+func (DB *DB) SavePushToken(ctx context.Context, token *models.PushToken) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("push_tokens").UpdateOne(ctx,
+		bson.M{"token": token.Token},
+		bson.M{
+			"$set":         bson.M{"user_id": token.UserId, "platform": token.Platform, "updated_at": token.UpdatedAt},
+			"$setOnInsert": bson.M{"_id": token.Id},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func (DB *DB) DeletePushToken(ctx context.Context, userId bson.ObjectID, token string) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("push_tokens").DeleteOne(ctx, bson.M{"user_id": userId, "token": token})
+	return err
+}
+
+func (DB *DB) GetPushTokens(ctx context.Context, userId bson.ObjectID) ([]models.PushToken, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("push_tokens").Find(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []models.PushToken
+	if err := result.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DoNotDisturb reports whether the user asked not to be notified right now,
+// by picking the do not disturb state or through their quiet hours.
+func (DB *DB) DoNotDisturb(ctx context.Context, userId bson.ObjectID) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"status": 1, "quiet_hours": 1})
+	if err := DB.Db.Collection("users").FindOne(ctx, bson.M{"_id": userId}, opts).Decode(&user); err != nil {
+		return false, err
+	}
+	if user.Status != nil && user.Status.State == models.PresenceDoNotDisturb {
+		return true, nil
+	}
+	if user.QuietHours != nil {
+		_, quiet := user.QuietHours.Until(time.Now())
+		return quiet, nil
+	}
+	return false, nil
+}
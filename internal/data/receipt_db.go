@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// DirectReceiptMessages narrows ids down to the direct messages to user,
+// loading their sender besides the fields of receiptProjection.
+func (DB *DB) DirectReceiptMessages(ctx context.Context, user bson.ObjectID, ids []bson.ObjectID) ([]models.Message, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": bson.M{"$in": ids}, "recipient_id": user, "group_id": bson.M{"$exists": false}, "deleted_at": notDeleted}
+	projection := bson.M{"sender_id": 1}
+	for field, value := range receiptProjection {
+		projection[field] = value
+	}
+	cursor, err := DB.Db.Collection("messages").Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.Message
+	return messages, cursor.All(ctx, &messages)
+}
+
+// MarkDeviceReceipts records that a device of user got or read the given
+// messages and moves the receipts of user along, see MarkReceipts. It
+// returns the messages user reached status on with this report, the ones
+// no other device of theirs got to first. Two devices reporting at the
+// same moment may both be first.
+func (DB *DB) MarkDeviceReceipts(ctx context.Context, user bson.ObjectID, device bson.ObjectID, ids []bson.ObjectID, status models.StatusType, at time.Time) ([]bson.ObjectID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	field := receiptField(status)
+	cursor, err := DB.Db.Collection("message_receipts").Find(ctx,
+		bson.M{"message_id": bson.M{"$in": ids}, "user_id": user, field: bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"message_id": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var reached []models.Receipt
+	if err := cursor.All(ctx, &reached); err != nil {
+		return nil, err
+	}
+
+	times := bson.M{"delivered_at": at}
+	if status == models.StatusRead {
+		times["read_at"] = at
+	}
+	writes := make([]mongo.WriteModel, len(ids))
+	for i, id := range ids {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"message_id": id, "device_id": device}).
+			SetUpdate(bson.M{"$min": times, "$setOnInsert": bson.M{"user_id": user}}).
+			SetUpsert(true)
+	}
+	if _, err := DB.Db.Collection("device_receipts").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return nil, err
+	}
+	if err := DB.MarkReceipts(ctx, user, ids, status, at); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[bson.ObjectID]bool, len(reached))
+	for _, receipt := range reached {
+		seen[receipt.MessageId] = true
+	}
+	var first []bson.ObjectID
+	for _, id := range ids {
+		if !seen[id] {
+			first = append(first, id)
+		}
+	}
+	return first, nil
+}
+
+// GetDeviceReceipts lists the receipts of every device a message reached,
+// in the order they got it.
+func (DB *DB) GetDeviceReceipts(ctx context.Context, messageId bson.ObjectID) ([]models.DeviceReceipt, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("device_receipts").Find(ctx, bson.M{"message_id": messageId}, options.Find().SetSort(bson.M{"delivered_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var receipts []models.DeviceReceipt
+	return receipts, cursor.All(ctx, &receipts)
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// SaveReservedName reserves a name. A name whose skeleton is reserved
+// already is refused with a duplicate key error.
+func (DB *DB) SaveReservedName(ctx context.Context, reserved *models.ReservedName) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("reserved_names").InsertOne(ctx, reserved)
+	return err
+}
+
+func (DB *DB) GetReservedNames(ctx context.Context) ([]models.ReservedName, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("reserved_names").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var names []models.ReservedName
+	return names, cursor.All(ctx, &names)
+}
+
+// GetReservedName finds the reservation a name falls under by its skeleton.
+func (DB *DB) GetReservedName(ctx context.Context, skeleton string) (models.ReservedName, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var reserved models.ReservedName
+	err := DB.Db.Collection("reserved_names").FindOne(ctx, bson.M{"skeleton": skeleton}).Decode(&reserved)
+	return reserved, err
+}
+
+// NameReserved reports whether a name is reserved for anyone but except,
+// who may have been granted it.
+func (DB *DB) NameReserved(ctx context.Context, skeleton string, except bson.ObjectID) (bool, error) {
+	reserved, err := DB.GetReservedName(ctx, skeleton)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return except.IsZero() || reserved.GrantedTo != except, nil
+}
+
+func (DB *DB) DeleteReservedName(ctx context.Context, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("reserved_names").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// SaveNameClaim files a claim. A user has one pending claim per reserved
+// name, a second one is refused with a duplicate key error.
+func (DB *DB) SaveNameClaim(ctx context.Context, claim *models.NameClaim) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("name_claims").InsertOne(ctx, claim)
+	return err
+}
+
+func (DB *DB) GetNameClaim(ctx context.Context, id bson.ObjectID) (models.NameClaim, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var claim models.NameClaim
+	err := DB.Db.Collection("name_claims").FindOne(ctx, bson.M{"_id": id}).Decode(&claim)
+	return claim, err
+}
+
+// GetNameClaims lists the claims in a status, oldest first.
+func (DB *DB) GetNameClaims(ctx context.Context, status models.ClaimStatus) ([]models.NameClaim, error) {
+	return DB.findNameClaims(ctx, bson.M{"status": status}, 1)
+}
+
+// GetUserNameClaims lists the claims of a user, newest first.
+func (DB *DB) GetUserNameClaims(ctx context.Context, user bson.ObjectID) ([]models.NameClaim, error) {
+	return DB.findNameClaims(ctx, bson.M{"user_id": user}, -1)
+}
+
+func (DB *DB) findNameClaims(ctx context.Context, filter bson.M, order int) ([]models.NameClaim, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("name_claims").Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": order}))
+	if err != nil {
+		return nil, err
+	}
+	var claims []models.NameClaim
+	return claims, cursor.All(ctx, &claims)
+}
+
+// ReviewNameClaim settles a pending claim. Approving it grants the reserved
+// name to the claimant, renaming them is up to the caller.
+// mongo.ErrNoDocuments means the claim is not pending.
+func (DB *DB) ReviewNameClaim(ctx context.Context, claim models.NameClaim, status models.ClaimStatus, reviewer bson.ObjectID, now time.Time) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	return DB.transaction(ctx, func(ctx context.Context) error {
+		result, err := DB.Db.Collection("name_claims").UpdateOne(ctx,
+			bson.M{"_id": claim.Id, "status": models.ClaimPending},
+			bson.M{"$set": bson.M{"status": status, "reviewed_by": reviewer, "reviewed_at": now}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		if status != models.ClaimApproved {
+			return nil
+		}
+		result, err = DB.Db.Collection("reserved_names").UpdateByID(ctx, claim.ReservedId, bson.M{"$set": bson.M{"granted_to": claim.UserId}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			// the reservation was lifted meanwhile
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
+}
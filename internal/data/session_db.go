@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (DB *DB) SaveSession(ctx context.Context, session *models.Session) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("sessions").InsertOne(ctx, session)
+	return err
+}
+
+func (DB *DB) GetSession(ctx context.Context, id bson.ObjectID, user bson.ObjectID) (models.Session, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var session models.Session
+	err := DB.Db.Collection("sessions").FindOne(ctx, bson.M{"_id": id, "user_id": user}).Decode(&session)
+	return session, err
+}
+
+func (DB *DB) TouchSession(ctx context.Context, session *models.Session) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("sessions").UpdateOne(ctx, bson.M{"_id": session.Id},
+		bson.M{"$set": bson.M{"last_used_at": session.LastUsedAt, "expires_at": session.ExpiresAt}})
+	return err
+}
+
+// GetSessions lists the sessions of a user, most recently used first.
+func (DB *DB) GetSessions(ctx context.Context, user bson.ObjectID) ([]models.Session, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"last_used_at": -1})
+	cursor, err := DB.Db.Collection("sessions").Find(ctx, bson.M{"user_id": user}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []models.Session
+	return sessions, cursor.All(ctx, &sessions)
+}
+
+// DeleteSessions ends every session of a user but except, which may be
+// zero to end them all.
+func (DB *DB) DeleteSessions(ctx context.Context, user bson.ObjectID, except bson.ObjectID) (int64, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"user_id": user}
+	if !except.IsZero() {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+	result, err := DB.Db.Collection("sessions").DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (DB *DB) DeleteSession(ctx context.Context, id bson.ObjectID, user bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("sessions").DeleteOne(ctx, bson.M{"_id": id, "user_id": user})
+	return err
+}
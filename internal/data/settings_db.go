@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// GetSettings reads the runtime overrides from the settings document, keyed
+// like the environment variables:
+//
+//	{"_id": "runtime", "values": {"BODY_LIMIT": "2M"}}
+func (DB *DB) GetSettings(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var doc struct {
+		Values map[string]string `bson:"values"`
+	}
+	err := DB.Db.Collection("settings").FindOne(ctx, bson.M{"_id": "runtime"}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return doc.Values, err
+}
+
+// SetSettings stores runtime overrides. Every instance applies them on its
+// next config reload. Values are kept even when empty, an empty override
+// still wins over the config file and the environment.
+func (DB *DB) SetSettings(ctx context.Context, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	set := bson.M{}
+	for key, value := range values {
+		set["values."+key] = value
+	}
+	_, err := DB.Db.Collection("settings").UpdateOne(ctx, bson.M{"_id": "runtime"}, bson.M{"$set": set}, options.UpdateOne().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// StarMessage adds a message to the stars of a user. Starring it again
+// keeps the first star.
+func (DB *DB) StarMessage(ctx context.Context, star *models.Star) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("stars").UpdateOne(ctx,
+		bson.M{"user_id": star.UserId, "message_id": star.MessageId},
+		bson.M{"$setOnInsert": bson.M{"_id": star.Id, "created_at": star.CreatedAt}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func (DB *DB) UnstarMessage(ctx context.Context, user bson.ObjectID, message bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("stars").DeleteOne(ctx, bson.M{"user_id": user, "message_id": message})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetStars pages through the stars of a user, most recently starred first,
+// with their messages. Stars of messages deleted since are left without.
+func (DB *DB) GetStars(ctx context.Context, user bson.ObjectID, page pagination.Page) ([]models.Star, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{{"user_id": user}, page.Filter("created_at")}}
+	cursor, err := DB.Db.Collection("stars").Find(ctx, filter, page.FindOptions("created_at"))
+	if err != nil {
+		return nil, err
+	}
+	var stars []models.Star
+	if err := cursor.All(ctx, &stars); err != nil {
+		return nil, err
+	}
+	if len(stars) == 0 {
+		return stars, nil
+	}
+
+	ids := make([]bson.ObjectID, 0, len(stars))
+	for _, star := range stars {
+		ids = append(ids, star.MessageId)
+	}
+	cursor, err = DB.Db.Collection("messages").Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": notDeleted})
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	byId := make(map[bson.ObjectID]*models.Message, len(messages))
+	for i := range messages {
+		byId[messages[i].Id] = &messages[i]
+	}
+	for i := range stars {
+		stars[i].Message = byId[stars[i].MessageId]
+	}
+	return stars, nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// CountActivity counts the messages sent in [from, to) and the distinct
+// users who sent them.
+func (DB *DB) CountActivity(ctx context.Context, from time.Time, to time.Time) (users int64, messages int64, err error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	window := bson.M{"timestamp": bson.M{"$gte": from, "$lt": to}}
+	if messages, err = DB.Db.Collection("messages").CountDocuments(ctx, window); err != nil {
+		return 0, 0, err
+	}
+
+	cursor, err := DB.Db.Collection("messages").Aggregate(ctx, []bson.M{
+		{"$match": window},
+		{"$group": bson.M{"_id": "$sender_id"}},
+		{"$count": "users"},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var counted []struct {
+		Users int64 `bson:"users"`
+	}
+	if err := cursor.All(ctx, &counted); err != nil {
+		return 0, 0, err
+	}
+	if len(counted) > 0 {
+		users = counted[0].Users
+	}
+	return users, messages, nil
+}
+
+// SaveDailyStats records the counts of a day. The peak only ever grows,
+// a restarted instance sampling fewer connections does not lower it.
+func (DB *DB) SaveDailyStats(ctx context.Context, stats *models.DailyStats) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("stats").UpdateOne(ctx,
+		bson.M{"_id": stats.Day},
+		bson.M{
+			"$set": bson.M{"active_users": stats.ActiveUsers, "messages": stats.Messages, "updated_at": stats.UpdatedAt},
+			"$max": bson.M{"peak_connections": stats.PeakConnections},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// GetDailyStats lists the days from since on, oldest first.
+func (DB *DB) GetDailyStats(ctx context.Context, since time.Time) ([]models.DailyStats, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := DB.Db.Collection("stats").Find(ctx, bson.M{"_id": bson.M{"$gte": since}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var stats []models.DailyStats
+	return stats, cursor.All(ctx, &stats)
+}
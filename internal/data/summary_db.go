@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// RecordMessage moves a direct message to the top of its conversation and
+// counts it as unread for the recipient, unless it is a note to self.
+func (DB *DB) RecordMessage(ctx context.Context, message *models.Message) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"last_message_id": message.Id,
+			"updated_at":      message.Timestamp,
+		},
+		"$setOnInsert": bson.M{"participants": []bson.ObjectID{message.SenderId, message.RecipientId}},
+	}
+	if !message.ToSelf() {
+		update["$inc"] = bson.M{"unread." + message.RecipientId.Hex(): 1}
+	}
+	_, err := DB.Db.Collection("conversation_summaries").UpdateOne(ctx,
+		bson.M{"_id": models.ConversationId(message.SenderId, message.RecipientId)},
+		update,
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// RecountUnread sets the unread count of user again in the conversations
+// the given messages belong to, after they were read. Counting rather than
+// subtracting repairs counts that drifted.
+func (DB *DB) RecountUnread(ctx context.Context, user bson.ObjectID, ids []bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	messages := DB.Db.Collection("messages")
+	var senders []bson.ObjectID
+	err := messages.Distinct(ctx, "sender_id", bson.M{"_id": bson.M{"$in": ids}, "recipient_id": user}).Decode(&senders)
+	if err != nil {
+		return err
+	}
+	for _, sender := range senders {
+		unread, err := messages.CountDocuments(ctx, bson.M{"sender_id": sender, "recipient_id": user, "read": bson.M{"$ne": true}, "deleted_at": notDeleted})
+		if err != nil {
+			return err
+		}
+		_, err = DB.Db.Collection("conversation_summaries").UpdateOne(ctx,
+			bson.M{"_id": models.ConversationId(sender, user)},
+			bson.M{"$set": bson.M{"unread." + user.Hex(): unread}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetConversationSummaries lists the conversations of a user, most recent
+// first, only those in ids unless it is nil.
+func (DB *DB) GetConversationSummaries(ctx context.Context, user bson.ObjectID, ids []string, page pagination.Page) ([]models.ConversationSummary, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	// summaries are keyed by conversation, so pages break ties on the last
+	// message id instead of _id
+	filter := bson.M{"participants": user}
+	if ids != nil {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	if page.After != nil {
+		filter["$or"] = []bson.M{
+			{"updated_at": bson.M{"$lt": page.After.Time}},
+			{"updated_at": page.After.Time, "last_message_id": bson.M{"$lt": page.After.ID}},
+		}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"updated_at", -1}, {"last_message_id", -1}}).
+		SetLimit(page.Limit + 1)
+	cursor, err := DB.Db.Collection("conversation_summaries").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var summaries []models.ConversationSummary
+	err = cursor.All(ctx, &summaries)
+	return summaries, err
+}
+
+// RebuildConversationSummaries replaces every summary with one computed
+// from the messages, for existing data and for summaries that drifted.
+func (DB *DB) RebuildConversationSummaries(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	return DB.rebuildSummaries(ctx, bson.M{})
+}
+
+// RebuildConversationSummary recomputes the summary of the conversation of
+// a and b, after messages were added out of order.
+func (DB *DB) RebuildConversationSummary(ctx context.Context, a, b bson.ObjectID) error {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	return DB.rebuildSummaries(ctx, bson.M{"$or": bson.A{
+		bson.M{"sender_id": a, "recipient_id": b},
+		bson.M{"sender_id": b, "recipient_id": a},
+	}})
+}
+
+// rebuildSummaries recomputes the summaries of the direct conversations
+// the messages matching match belong to.
+func (DB *DB) rebuildSummaries(ctx context.Context, match bson.M) error {
+	match["group_id"] = bson.M{"$exists": false}
+	match["deleted_at"] = notDeleted
+
+	unreadFor := func(participant string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{
+			bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$recipient_id", participant}},
+				bson.M{"$ne": bson.A{"$read", true}},
+			}}, 1, 0,
+		}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$sort", bson.M{"timestamp": 1}}},
+		{{"$set", bson.M{
+			"a": bson.M{"$min": bson.A{"$sender_id", "$recipient_id"}},
+			"b": bson.M{"$max": bson.A{"$sender_id", "$recipient_id"}},
+		}}},
+		{{"$group", bson.M{
+			"_id":             bson.M{"$concat": bson.A{bson.M{"$toString": "$a"}, ":", bson.M{"$toString": "$b"}}},
+			"a":               bson.M{"$first": "$a"},
+			"b":               bson.M{"$first": "$b"},
+			"last_message_id": bson.M{"$last": "$_id"},
+			"updated_at":      bson.M{"$last": "$timestamp"},
+			"unread_a":        unreadFor("$a"),
+			"unread_b":        unreadFor("$b"),
+		}}},
+		{{"$project", bson.M{
+			"participants":    bson.A{"$a", "$b"},
+			"last_message_id": 1,
+			"updated_at":      1,
+			"unread": bson.M{"$arrayToObject": bson.A{bson.A{
+				bson.M{"k": bson.M{"$toString": "$a"}, "v": "$unread_a"},
+				bson.M{"k": bson.M{"$toString": "$b"}, "v": "$unread_b"},
+			}}},
+		}}},
+		{{"$merge", bson.M{"into": "conversation_summaries", "whenMatched": "replace"}}},
+	}
+	cursor, err := DB.Db.Collection("messages").Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+func (DB *DB) SaveSupportSession(ctx context.Context, session *models.SupportSession) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("support_sessions").InsertOne(ctx, session)
+	return err
+}
+
+func (DB *DB) GetSupportSession(ctx context.Context, id bson.ObjectID) (models.SupportSession, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var session models.SupportSession
+	err := DB.Db.Collection("support_sessions").FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	return session, err
+}
+
+// GetActiveSupportSessions lists the support sessions neither revoked nor
+// expired at now, newest first.
+func (DB *DB) GetActiveSupportSessions(ctx context.Context, now time.Time) ([]models.SupportSession, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"revoked_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": now}}
+	cursor, err := DB.Db.Collection("support_sessions").Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	var sessions []models.SupportSession
+	return sessions, cursor.All(ctx, &sessions)
+}
+
+// RevokeSupportSession ends a support session early. mongo.ErrNoDocuments
+// means there is no such session or it was revoked already.
+func (DB *DB) RevokeSupportSession(ctx context.Context, id bson.ObjectID, by bson.ObjectID, now time.Time) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("support_sessions").UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now, "revoked_by": by}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"filachat/pkg/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"time"
+)
+
+// Users and messages are soft deleted: deleted_at is set and every read of
+// the repositories skips the document until the reaper removes it for good.
+var notDeleted = bson.M{"$exists": false}
+
+func (DB *DB) SoftDeleteUser(ctx context.Context, id bson.ObjectID) error {
+	return DB.softDelete(ctx, "users", bson.M{"_id": id})
+}
+
+// SoftDeleteMessage only lets the sender delete their message.
+func (DB *DB) SoftDeleteMessage(ctx context.Context, id bson.ObjectID, sender bson.ObjectID) error {
+	return DB.softDelete(ctx, "messages", bson.M{"_id": id, "sender_id": sender})
+}
+
+func (DB *DB) softDelete(ctx context.Context, collection string, filter bson.M) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter["deleted_at"] = notDeleted
+	result, err := DB.Db.Collection(collection).UpdateOne(ctx, filter, bson.M{"$set": bson.M{"deleted_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (DB *DB) RestoreUser(ctx context.Context, id bson.ObjectID) error {
+	return DB.restore(ctx, "users", id)
+}
+
+func (DB *DB) RestoreMessage(ctx context.Context, id bson.ObjectID) error {
+	return DB.restore(ctx, "messages", id)
+}
+
+func (DB *DB) restore(ctx context.Context, collection string, id bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}}
+	result, err := DB.Db.Collection(collection).UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"deleted_at": ""}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (DB *DB) GetDeletedUsers(ctx context.Context, page pagination.Page) ([]models.User, error) {
+	var users []models.User
+	return users, DB.findDeleted(ctx, "users", page, &users)
+}
+
+func (DB *DB) GetDeletedMessages(ctx context.Context, page pagination.Page) ([]models.Message, error) {
+	var messages []models.Message
+	return messages, DB.findDeleted(ctx, "messages", page, &messages)
+}
+
+func (DB *DB) findDeleted(ctx context.Context, collection string, page pagination.Page, results any) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"$and": []bson.M{
+		{"deleted_at": bson.M{"$exists": true}},
+		page.Filter("deleted_at"),
+	}}
+	cursor, err := DB.Db.Collection(collection).Find(ctx, filter, page.FindOptions("deleted_at"))
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+// userOwned are the collections whose documents belong to one user by
+// user_id and go when the user is purged.
+var userOwned = []string{"sessions", "devices", "prekeys", "push_tokens"}
+
+// PurgeDeleted hard deletes everything that sat in the trash since before
+// the cutoff. Messages, sessions, devices, prekeys and push tokens of purged
+// users go with them.
+func (DB *DB) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, int64, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	expired := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+	cursor, err := DB.Db.Collection("users").Find(ctx, expired)
+	if err != nil {
+		return 0, 0, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, 0, err
+	}
+
+	ids := make([]bson.ObjectID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+
+	// a user goes together with what they own, so a failure half way
+	// leaves nothing of a purged user behind
+	var purgedUsers int64
+	if len(ids) > 0 {
+		err := DB.transaction(ctx, func(ctx context.Context) error {
+			result, err := DB.Db.Collection("users").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+			if err != nil {
+				return err
+			}
+			purgedUsers = result.DeletedCount
+
+			_, err = DB.Db.Collection("messages").DeleteMany(ctx, bson.M{"$or": []bson.M{
+				{"sender_id": bson.M{"$in": ids}},
+				{"recipient_id": bson.M{"$in": ids}},
+			}})
+			if err != nil {
+				return err
+			}
+			for _, collection := range userOwned {
+				if _, err := DB.Db.Collection(collection).DeleteMany(ctx, bson.M{"user_id": bson.M{"$in": ids}}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	result, err := DB.Db.Collection("messages").DeleteMany(ctx, expired)
+	if err != nil {
+		return purgedUsers, 0, err
+	}
+	return purgedUsers, result.DeletedCount, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// Two factor state lives on the user: the TOTP secret, sealed like other
+// sensitive fields, the last time step a code was accepted for, and the
+// hashes of the recovery codes not used yet, and the wrong codes sent in a
+// row.
+
+// SetTOTPSecret starts an enrollment. Two factor stays off until a code for
+// the new secret is confirmed, see EnableTwoFactor.
+func (DB *DB) SetTOTPSecret(ctx context.Context, user bson.ObjectID, secret string) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	sealed, err := DB.seal(secret)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": user, "two_factor": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp_secret": sealed}, "$unset": bson.M{"totp_step": "", "recovery_codes": ""}})
+	return err
+}
+
+func (DB *DB) EnableTwoFactor(ctx context.Context, user bson.ObjectID, recoveryHashes []string) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": user},
+		bson.M{"$set": bson.M{"two_factor": true, "recovery_codes": recoveryHashes}})
+	return err
+}
+
+func (DB *DB) DisableTwoFactor(ctx context.Context, user bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": user},
+		bson.M{"$unset": bson.M{"two_factor": "", "totp_secret": "", "totp_step": "", "recovery_codes": ""}})
+	return err
+}
+
+// UseTOTPStep records the step a code was accepted for and reports false
+// when that step or a later one was used already, so a code seen over
+// someone's shoulder cannot be replayed.
+func (DB *DB) UseTOTPStep(ctx context.Context, user bson.ObjectID, step int64) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"_id": user, "$or": []bson.M{{"totp_step": bson.M{"$lt": step}}, {"totp_step": bson.M{"$exists": false}}}}
+	result, err := DB.Db.Collection("users").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_step": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (DB *DB) SetRecoveryCodes(ctx context.Context, user bson.ObjectID, hashes []string) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": user, "two_factor": true},
+		bson.M{"$set": bson.M{"recovery_codes": hashes}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UseRecoveryCode removes a code in one step, so it works once even when
+// sent twice at the same time, and returns how many are left.
+// mongo.ErrNoDocuments means the code is not, or no longer, valid.
+func (DB *DB) UseRecoveryCode(ctx context.Context, user bson.ObjectID, hash string) (int, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"recovery_codes": 1})
+	var left struct {
+		RecoveryCodes []string `bson:"recovery_codes"`
+	}
+	err := DB.Db.Collection("users").FindOneAndUpdate(ctx,
+		bson.M{"_id": user, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}}, opts).Decode(&left)
+	return len(left.RecoveryCodes), err
+}
+
+// FailTwoFactor counts a wrong code. The max-th in a row locks two factor
+// until now plus lockout and starts the count again, FailTwoFactor then
+// reports true.
+func (DB *DB) FailTwoFactor(ctx context.Context, user bson.ObjectID, max int64, lockout time.Duration) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"two_factor_failures": 1})
+	var failures struct {
+		Count int64 `bson:"two_factor_failures"`
+	}
+	err := DB.Db.Collection("users").FindOneAndUpdate(ctx, bson.M{"_id": user},
+		bson.M{"$inc": bson.M{"two_factor_failures": 1}}, opts).Decode(&failures)
+	if err != nil || failures.Count < max {
+		return false, err
+	}
+	_, err = DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": user},
+		bson.M{"$set": bson.M{"two_factor_locked_until": time.Now().Add(lockout)}, "$unset": bson.M{"two_factor_failures": ""}})
+	return err == nil, err
+}
+
+// ResetTwoFactorFailures forgets the wrong codes before a right one.
+func (DB *DB) ResetTwoFactorFailures(ctx context.Context, user bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": user},
+		bson.M{"$unset": bson.M{"two_factor_failures": ""}})
+	return err
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+func (DB *DB) SaveUpload(ctx context.Context, upload *models.Upload) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("uploads").InsertOne(ctx, *upload)
+	return err
+}
+
+func (DB *DB) GetUpload(ctx context.Context, id bson.ObjectID, ownerId bson.ObjectID) (models.Upload, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	var upload models.Upload
+	if err := DB.Db.Collection("uploads").FindOne(ctx, bson.M{"_id": id, "owner_id": ownerId}).Decode(&upload); err != nil {
+		return models.Upload{}, err
+	}
+	return upload, nil
+}
+
+// ClaimUpload marks an upload busy while one chunk is written, provided the
+// client's offset matches. A lease rather than a flag, so a crashed request
+// cannot block the upload forever.
+func (DB *DB) ClaimUpload(ctx context.Context, id bson.ObjectID, ownerId bson.ObjectID, offset int64, lease time.Duration) (models.Upload, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"_id": id, "owner_id": ownerId, "offset": offset, "$or": []bson.M{
+		{"busy_until": bson.M{"$exists": false}},
+		{"busy_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{"busy_until": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var upload models.Upload
+	if err := DB.Db.Collection("uploads").FindOneAndUpdate(ctx, filter, update, opts).Decode(&upload); err != nil {
+		return models.Upload{}, err
+	}
+	return upload, nil
+}
+
+func (DB *DB) AdvanceUpload(ctx context.Context, id bson.ObjectID, offset int64) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("uploads").UpdateByID(ctx, id, bson.M{
+		"$set":   bson.M{"offset": offset, "updated_at": time.Now()},
+		"$unset": bson.M{"busy_until": ""},
+	})
+	return err
+}
+
+func (DB *DB) DeleteUpload(ctx context.Context, id bson.ObjectID, ownerId bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("uploads").DeleteOne(ctx, bson.M{"_id": id, "owner_id": ownerId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// TakeStaleUploads removes uploads without progress since the cutoff and
+// returns them so their partial blobs can be deleted.
+func (DB *DB) TakeStaleUploads(ctx context.Context, cutoff time.Time) ([]models.Upload, error) {
+	ctx, cancel := DB.batch(ctx)
+	defer cancel()
+
+	filter := bson.M{"updated_at": bson.M{"$lt": cutoff}}
+	cursor, err := DB.Db.Collection("uploads").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var uploads []models.Upload
+	if err := cursor.All(ctx, &uploads); err != nil {
+		return nil, err
+	}
+
+	// delete one by one with the cutoff repeated, an upload resumed since the
+	// find must keep its blob
+	var taken []models.Upload
+	for _, upload := range uploads {
+		result, err := DB.Db.Collection("uploads").DeleteOne(ctx, bson.M{"_id": upload.Id, "updated_at": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return taken, err
+		}
+		if result.DeletedCount == 1 {
+			taken = append(taken, upload)
+		}
+	}
+	return taken, nil
+}
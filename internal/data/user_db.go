@@ -3,45 +3,72 @@ package database
 import (
 	"context"
 	"errors"
+	"filachat/internal/crypto"
 	"filachat/internal/models"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"time"
 )
 
 type DB struct {
-	Db *mongo.Database
+	Db     *mongo.Database
+	Fields *crypto.FieldCipher
+
+	// Timeout bounds a single query, BatchTimeout work over many documents
+	// such as purges. Both apply within the deadline of the context passed
+	// in, zero means the defaults.
+	Timeout      time.Duration
+	BatchTimeout time.Duration
+
+	// Transactions makes writes spanning several collections atomic. It
+	// needs a replica set or sharded cluster.
+	Transactions bool
 }
 
-func (DB *DB) GetUser(id bson.ObjectID) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (DB *DB) GetUser(ctx context.Context, id bson.ObjectID) (models.User, error) {
+	ctx, cancel := DB.query(ctx)
 	defer cancel()
 
-	result := DB.Db.Collection("users").FindOne(ctx, bson.D{{"_id", id}})
+	result := DB.Db.Collection("users").FindOne(ctx, bson.D{{"_id", id}, {"deleted_at", notDeleted}})
 	if err := result.Err(); err != nil { return models.NilUser, err }
 
 	var user models.User
 	if err := result.Decode(&user); err != nil { return models.NilUser, err }
+	DB.openUser(&user)
 
 	return user, nil
 }
-func (DB *DB) GetUserByName(username string) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (DB *DB) GetUserByName(ctx context.Context, username string) (models.User, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result := DB.Db.Collection("users").FindOne(ctx, bson.D{{"username", username}, {"deleted_at", notDeleted}})
+	if err := result.Err(); err != nil { return models.NilUser, err }
+	var user models.User
+	if err := result.Decode(&user); err != nil { return models.NilUser, err }
+	DB.openUser(&user)
+	return user, nil
+}
+func (DB *DB) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := DB.query(ctx)
 	defer cancel()
 
-	result := DB.Db.Collection("users").FindOne(ctx, bson.D{{"username", username}})
+	filter := bson.M{"$and": []bson.M{DB.emailFilter(email), {"deleted_at": notDeleted}}}
+	result := DB.Db.Collection("users").FindOne(ctx, filter)
 	if err := result.Err(); err != nil { return models.NilUser, err }
 	var user models.User
 	if err := result.Decode(&user); err != nil { return models.NilUser, err }
+	DB.openUser(&user)
 	return user, nil
 }
-func (DB *DB) Exists(username string, email string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (DB *DB) Exists(ctx context.Context, username string, email string) (bool, error) {
+	ctx, cancel := DB.query(ctx)
     defer cancel()
 
     filter := bson.M{
         "$or": []bson.M{
-            {"email": email},
+            DB.emailFilter(email),
             {"username": username},
         },
     }
@@ -56,14 +83,66 @@ func (DB *DB) Exists(username string, email string) (bool, error) {
 	}
 	return true, nil
 }
-func (DB *DB) NewUser(id bson.ObjectID, username string, email string, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (DB *DB) NewUser(ctx context.Context, id bson.ObjectID, username string, email string, password string) error {
+	ctx, cancel := DB.query(ctx)
 	defer cancel()
 
 	user := models.User{Id: id, Username: username, Email: email, Password: password}
+	if err := DB.sealUser(&user); err != nil { return err }
 
 	_, err := DB.Db.Collection("users").InsertOne(ctx, user)
 	if err != nil { return err }
 
 	return nil
+}
+func (DB *DB) ListUsers(ctx context.Context, filter bson.M, skip int64, limit int64) ([]models.User, int64, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter = bson.M{"$and": []bson.M{filter, {"deleted_at": notDeleted}}}
+	total, err := DB.Db.Collection("users").CountDocuments(ctx, filter)
+	if err != nil { return nil, 0, err }
+
+	opts := options.Find().SetSort(bson.D{{"_id", 1}}).SetSkip(skip).SetLimit(limit)
+	result, err := DB.Db.Collection("users").Find(ctx, filter, opts)
+	if err != nil { return nil, 0, err }
+
+	var users []models.User
+	if err := result.All(ctx, &users); err != nil { return nil, 0, err }
+	for i := range users {
+		DB.openUser(&users[i])
+	}
+	return users, total, nil
+}
+func (DB *DB) UpdateUser(ctx context.Context, id bson.ObjectID, fields bson.M) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	fields, err := DB.sealFields(fields)
+	if err != nil { return err }
+	result, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": id, "deleted_at": notDeleted}, bson.M{"$set": fields})
+	if err != nil { return err }
+	if result.MatchedCount == 0 { return mongo.ErrNoDocuments }
+	return nil
+}
+// SetLastSeen never moves last seen back, disconnects reported late by
+// another instance leave the newer time in place.
+func (DB *DB) SetLastSeen(ctx context.Context, id bson.ObjectID, at time.Time) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	if DB.Fields != nil {
+		return DB.setSealedLastSeen(ctx, id, at)
+	}
+	_, err := DB.Db.Collection("users").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$max": bson.M{"last_seen": at}})
+	return err
+}
+func (DB *DB) InsertUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	sealed := *user
+	if err := DB.sealUser(&sealed); err != nil { return err }
+	_, err := DB.Db.Collection("users").InsertOne(ctx, sealed)
+	return err
 }
\ No newline at end of file
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"time"
+)
+
+// UsernameHeld reports whether a previous owner still holds the name during
+// its grace period. The previous owner may always reclaim it.
+func (DB *DB) UsernameHeld(ctx context.Context, username string, except bson.ObjectID) (bool, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"username":    username,
+		"user_id":     bson.M{"$ne": except},
+		"released_at": bson.M{"$gt": time.Now()},
+	}
+	err := DB.Db.Collection("username_history").FindOne(ctx, filter).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (DB *DB) ChangeUsername(ctx context.Context, id bson.ObjectID, previous string, username string, hold time.Duration) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	now := time.Now()
+	history := models.UsernameHistory{
+		Id:         bson.NewObjectID(),
+		UserId:     id,
+		Username:   previous,
+		ChangedAt:  now,
+		ReleasedAt: now.Add(hold),
+	}
+	return DB.transaction(ctx, func(ctx context.Context) error {
+		if _, err := DB.Db.Collection("username_history").InsertOne(ctx, history); err != nil {
+			return err
+		}
+		_, err := DB.Db.Collection("users").UpdateByID(ctx, id, bson.M{"$set": bson.M{
+			"username":            username,
+			"username_changed_at": now,
+		}})
+		return err
+	})
+}
+
+// ResolveFormerUsername finds the current owner of a name that was given up
+// within the hold period.
+func (DB *DB) ResolveFormerUsername(ctx context.Context, username string) (models.User, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"username": username, "released_at": bson.M{"$gt": time.Now()}}
+	var history models.UsernameHistory
+	if err := DB.Db.Collection("username_history").FindOne(ctx, filter).Decode(&history); err != nil {
+		return models.NilUser, err
+	}
+	return DB.GetUser(ctx, history.UserId)
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (DB *DB) SaveWebhook(ctx context.Context, webhook *models.Webhook) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	_, err := DB.Db.Collection("webhooks").InsertOne(ctx, webhook)
+	return err
+}
+
+func (DB *DB) GetWebhooks(ctx context.Context, owner bson.ObjectID) ([]models.Webhook, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	cursor, err := DB.Db.Collection("webhooks").Find(ctx, bson.M{"owner_id": owner})
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []models.Webhook
+	return webhooks, cursor.All(ctx, &webhooks)
+}
+
+// WebhooksFor lists the enabled webhooks of owner subscribed to event.
+func (DB *DB) WebhooksFor(ctx context.Context, owner bson.ObjectID, event string) ([]models.Webhook, error) {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	filter := bson.M{"owner_id": owner, "events": event, "disabled": bson.M{"$ne": true}}
+	cursor, err := DB.Db.Collection("webhooks").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []models.Webhook
+	return webhooks, cursor.All(ctx, &webhooks)
+}
+
+func (DB *DB) DeleteWebhook(ctx context.Context, id bson.ObjectID, owner bson.ObjectID) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	result, err := DB.Db.Collection("webhooks").DeleteOne(ctx, bson.M{"_id": id, "owner_id": owner})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// RecordWebhookDelivery resets the failure count of a webhook after a
+// successful delivery or counts a failure, disabling the webhook once it
+// failed maxFailures times in a row.
+func (DB *DB) RecordWebhookDelivery(ctx context.Context, id bson.ObjectID, ok bool, maxFailures int) error {
+	ctx, cancel := DB.query(ctx)
+	defer cancel()
+
+	collection := DB.Db.Collection("webhooks")
+	if ok {
+		_, err := collection.UpdateByID(ctx, id, bson.M{"$unset": bson.M{"failures": ""}})
+		return err
+	}
+	if _, err := collection.UpdateByID(ctx, id, bson.M{"$inc": bson.M{"failures": 1}}); err != nil {
+		return err
+	}
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id, "failures": bson.M{"$gte": maxFailures}},
+		bson.M{"$set": bson.M{"disabled": true}})
+	return err
+}
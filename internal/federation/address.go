@@ -0,0 +1,47 @@
+package federation
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// Address names a user of any server as username@host. Usernames of this
+// server never contain @, so an address is never mistaken for one.
+type Address struct {
+	User string
+	Host string
+}
+
+func ParseAddress(raw string) (Address, error) {
+	user, host, ok := strings.Cut(raw, "@")
+	host = strings.ToLower(host)
+	if !ok || user == "" || !ValidHost(host) || strings.Contains(user, "/") {
+		return Address{}, errors.New("invalid address")
+	}
+	return Address{User: user, Host: host}, nil
+}
+
+// ValidHost reports whether host is a lowercase domain name a server can be
+// reached at. IP literals, ports and single label names are refused, the
+// host ends up in URLs this server fetches.
+func ValidHost(host string) bool {
+	if len(host) > 253 || !strings.Contains(host, ".") || net.ParseIP(host) != nil {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (a Address) String() string {
+	return a.User + "@" + a.Host
+}
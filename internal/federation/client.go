@@ -0,0 +1,88 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"filachat/internal/models"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	MessagesPath = "/federation/v1/messages"
+	KeysPath     = "/federation/v1/users/%s/keys"
+)
+
+// ErrRejected is a request another server refused for good, retrying it
+// makes no difference.
+var ErrRejected = errors.New("rejected by remote server")
+
+// Client makes signed requests to other servers over HTTPS.
+type Client struct {
+	Signer *Signer
+	HTTP   *http.Client
+}
+
+func (c *Client) Deliver(ctx context.Context, host string, message models.FederatedMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	res, err := c.do(ctx, http.MethodPost, host, MessagesPath, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+	return statusError(res)
+}
+
+// FetchKeys asks the server of a remote user for their public keys, which
+// also tells whether the user exists.
+func (c *Client) FetchKeys(ctx context.Context, address Address) (models.RemoteKeys, error) {
+	var keys models.RemoteKeys
+	res, err := c.do(ctx, http.MethodGet, address.Host, fmt.Sprintf(KeysPath, url.PathEscape(address.User)), nil)
+	if err != nil {
+		return keys, err
+	}
+	defer res.Body.Close()
+	if err := statusError(res); err != nil {
+		return keys, err
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&keys); err != nil {
+		return keys, err
+	}
+	if keys.Address != address.String() {
+		return keys, fmt.Errorf("keys of %s: answered for %q", address, keys.Address)
+	}
+	return keys, nil
+}
+
+func (c *Client) do(ctx context.Context, method, host, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "https://"+host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.Signer.Sign(req, body)
+	return c.HTTP.Do(req)
+}
+
+// statusError maps a response to nil, ErrRejected, or an error worth a
+// retry.
+func statusError(res *http.Response) error {
+	switch {
+	case res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests, res.StatusCode >= 500:
+		return fmt.Errorf("remote server: status %d", res.StatusCode)
+	default:
+		return fmt.Errorf("%w: status %d", ErrRejected, res.StatusCode)
+	}
+}
@@ -0,0 +1,68 @@
+// Package federation lets independently hosted servers exchange direct
+// messages. Users of another server are addressed as username@host and
+// stand in locally as users with a Host. Servers sign their requests to
+// each other with a key published at WellKnownPath, deliver messages
+// through a retrying Outbox and fetch the keys of remote users for senders
+// to encrypt to them.
+package federation
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Federation is what the API needs to talk to other servers.
+type Federation struct {
+	Domain   string
+	Signer   *Signer
+	Verifier *Verifier
+	Client   *Client
+	Outbox   *Outbox
+}
+
+func New(domain string, key ed25519.PrivateKey, allowed []string, store OutboxStore, interval time.Duration, maxAttempts int) *Federation {
+	httpClient := &http.Client{Timeout: 30 * time.Second, Transport: publicTransport()}
+	signer := &Signer{Domain: domain, Key: key}
+	client := &Client{Signer: signer, HTTP: httpClient}
+	return &Federation{
+		Domain:   domain,
+		Signer:   signer,
+		Verifier: &Verifier{Domain: domain, Keys: &KeyCache{HTTP: httpClient}, Allowed: allowed},
+		Client:   client,
+		Outbox:   NewOutbox(store, client, interval, maxAttempts),
+	}
+}
+
+var errPrivateAddress = errors.New("federation: refusing a private address")
+
+// publicTransport only connects to public addresses. Hosts come from other
+// servers and users, one resolving to loopback or the internal network
+// would have this server fetch from there.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := addr.Addr().Unmap(); !publicAddress(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+func publicAddress(ip netip.Addr) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
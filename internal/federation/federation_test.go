@@ -0,0 +1,109 @@
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+type staticKeys map[string]ed25519.PublicKey
+
+func (k staticKeys) ServerKey(ctx context.Context, host string) (ed25519.PublicKey, error) {
+	if key, ok := k[host]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown server")
+}
+
+func TestParseAddress(t *testing.T) {
+	address, err := ParseAddress("alice@Chat.Example.com")
+	if err != nil || address != (Address{User: "alice", Host: "chat.example.com"}) {
+		t.Errorf("Expected alice at chat.example.com, got %+v (%v)", address, err)
+	}
+	for _, raw := range []string{"alice", "@example.com", "alice@", "a@b@c", "alice@example.com/x", "a/b@example.com", "alice@127.0.0.1", "alice@example.com:8443", "alice@localhost", "alice@[::1]"} {
+		if _, err := ParseAddress(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+// signedRequest builds a request from a.example to b.example as it arrives
+// at the receiving server.
+func signedRequest(t *testing.T, key ed25519.PrivateKey, body string) *http.Request {
+	t.Helper()
+	out := httptest.NewRequest(http.MethodPost, "https://b.example"+MessagesPath, strings.NewReader(body))
+	(&Signer{Domain: "a.example", Key: key}).Sign(out, []byte(body))
+
+	in := httptest.NewRequest(http.MethodPost, MessagesPath, strings.NewReader(body))
+	in.Header = out.Header.Clone()
+	return in
+}
+
+func TestVerifySignedRequest(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &Verifier{Domain: "b.example", Keys: staticKeys{"a.example": public}}
+	ctx := context.Background()
+
+	req := signedRequest(t, private, `{"content":"hi"}`)
+	if origin, err := verifier.Verify(ctx, req, []byte(`{"content":"hi"}`)); err != nil || origin != "a.example" {
+		t.Errorf("Expected a valid request from a.example, got %q (%v)", origin, err)
+	}
+	if _, err := verifier.Verify(ctx, req, []byte(`{"content":"bye"}`)); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected a changed body to be refused, got %v", err)
+	}
+
+	// a request signed for b.example is no good at c.example
+	other := &Verifier{Domain: "c.example", Keys: verifier.Keys}
+	if _, err := other.Verify(ctx, req, []byte(`{"content":"hi"}`)); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected a request for another server to be refused, got %v", err)
+	}
+
+	stale := signedRequest(t, private, "")
+	stale.Header.Set(HeaderDate, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	if _, err := verifier.Verify(ctx, stale, nil); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected an old request to be refused, got %v", err)
+	}
+
+	for _, origin := range []string{"127.0.0.1", "a.example:8443", "localhost", "169.254.169.254"} {
+		spoofed := signedRequest(t, private, `{"content":"hi"}`)
+		spoofed.Header.Set(HeaderOrigin, origin)
+		if _, err := verifier.Verify(ctx, spoofed, []byte(`{"content":"hi"}`)); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("Expected origin %q to be refused before fetching its key, got %v", origin, err)
+		}
+	}
+
+	allowList := &Verifier{Domain: "b.example", Keys: verifier.Keys, Allowed: []string{"d.example"}}
+	if _, err := allowList.Verify(ctx, req, []byte(`{"content":"hi"}`)); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected a server off the allow list to be refused, got %v", err)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	for _, raw := range []string{"127.0.0.1", "10.0.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "::1", "fd00::1", "fe80::1"} {
+		if publicAddress(netip.MustParseAddr(raw)) {
+			t.Errorf("Expected %s to be refused", raw)
+		}
+	}
+	if !publicAddress(netip.MustParseAddr("93.184.216.34")) {
+		t.Error("Expected a public address to be allowed")
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	if backoff(1) != 30*time.Second || backoff(2) != time.Minute {
+		t.Errorf("Expected 30s then 1m, got %v and %v", backoff(1), backoff(2))
+	}
+	if backoff(100) != maxBackoff {
+		t.Errorf("Expected the backoff to stop at %v, got %v", maxBackoff, backoff(100))
+	}
+}
@@ -0,0 +1,132 @@
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// KeyFile is the name of the server signing key in the key directory.
+const KeyFile = "FederationPrivateKey.pem"
+
+// WellKnownPath is where a server publishes its signing key, see
+// ServerInfo.
+const WellKnownPath = "/.well-known/filagram/server"
+
+// ServerInfo is what a server publishes at WellKnownPath.
+type ServerInfo struct {
+	Domain    string `json:"domain"`
+	PublicKey []byte `json:"public_key"`
+}
+
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("invalid PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("federation key is not ed25519")
+	}
+	return private, nil
+}
+
+// GenerateKey writes a new signing key to path unless one is there. A
+// server keeps its key, peers cache it.
+func GenerateKey(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// KeySource resolves the signing key of a server.
+type KeySource interface {
+	ServerKey(ctx context.Context, host string) (ed25519.PublicKey, error)
+}
+
+const serverKeyTTL = time.Hour
+
+type cachedKey struct {
+	key     ed25519.PublicKey
+	fetched time.Time
+}
+
+// KeyCache fetches server keys from their well-known URL and keeps them for
+// an hour.
+type KeyCache struct {
+	HTTP *http.Client
+
+	mu   sync.Mutex
+	keys map[string]cachedKey
+}
+
+func (k *KeyCache) ServerKey(ctx context.Context, host string) (ed25519.PublicKey, error) {
+	if !ValidHost(host) {
+		return nil, fmt.Errorf("server key of %s: invalid host", host)
+	}
+	k.mu.Lock()
+	cached, ok := k.keys[host]
+	k.mu.Unlock()
+	if ok && time.Since(cached.fetched) < serverKeyTTL {
+		return cached.key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+WellKnownPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := k.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server key of %s: status %d", host, res.StatusCode)
+	}
+	var info ServerInfo
+	if err := json.NewDecoder(io.LimitReader(res.Body, 4<<10)).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.Domain != host || len(info.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("server key of %s: invalid key", host)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[string]cachedKey)
+	}
+	k.keys[host] = cachedKey{key: info.PublicKey, fetched: time.Now()}
+	return info.PublicKey, nil
+}
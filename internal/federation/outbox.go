@@ -0,0 +1,126 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"log"
+	"time"
+)
+
+// OutboxStore keeps messages for other servers until they are delivered.
+type OutboxStore interface {
+	EnqueueOutbox(ctx context.Context, entry *models.OutboxEntry) error
+	ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration) (models.OutboxEntry, error)
+	RescheduleOutbox(ctx context.Context, id bson.ObjectID, attempts int, next time.Time, lastError string) error
+	DeleteOutbox(ctx context.Context, id bson.ObjectID) error
+}
+
+const (
+	// deliveryLease is how long a claimed entry is hidden from the other
+	// instances while one delivers it.
+	deliveryLease = time.Minute
+	maxBackoff    = 6 * time.Hour
+)
+
+// Outbox delivers messages to other servers, retrying with exponential
+// backoff while the remote server is unreachable. Entries live in the
+// database so they survive restarts and any instance may deliver them. A
+// message is dropped after MaxAttempts or when the remote server rejects it,
+// Dropped is then told if set.
+type Outbox struct {
+	Store       OutboxStore
+	Client      *Client
+	Interval    time.Duration
+	MaxAttempts int
+	Dropped     func(ctx context.Context, message models.FederatedMessage)
+
+	wake chan struct{}
+}
+
+func NewOutbox(store OutboxStore, client *Client, interval time.Duration, maxAttempts int) *Outbox {
+	return &Outbox{Store: store, Client: client, Interval: interval, MaxAttempts: maxAttempts, wake: make(chan struct{}, 1)}
+}
+
+// Send queues a message for host and has the worker try it right away.
+func (o *Outbox) Send(ctx context.Context, host string, message models.FederatedMessage) error {
+	now := time.Now()
+	entry := models.OutboxEntry{Id: bson.NewObjectID(), Host: host, Message: message, NextAttemptAt: now, CreatedAt: now}
+	if err := o.Store.EnqueueOutbox(ctx, &entry); err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		o.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// RunOnce delivers every entry that is due.
+func (o *Outbox) RunOnce(ctx context.Context) {
+	for {
+		entry, err := o.Store.ClaimOutbox(ctx, time.Now(), deliveryLease)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			log.Println("[WARN] federation outbox not read", err)
+			return
+		}
+		o.attempt(ctx, entry)
+	}
+}
+
+func (o *Outbox) attempt(ctx context.Context, entry models.OutboxEntry) {
+	deliverCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	err := o.Client.Deliver(deliverCtx, entry.Host, entry.Message)
+	cancel()
+
+	entry.Attempts++
+	switch {
+	case err == nil:
+		metrics.FederationDeliveries.WithLabelValues("delivered").Inc()
+	case errors.Is(err, ErrRejected), entry.Attempts >= o.MaxAttempts:
+		metrics.FederationDeliveries.WithLabelValues("dropped").Inc()
+		log.Println("[WARN] federated message dropped", entry.Message.Id.Hex(), entry.Host, err)
+		if o.Dropped != nil {
+			o.Dropped(ctx, entry.Message)
+		}
+	default:
+		metrics.FederationDeliveries.WithLabelValues("retried").Inc()
+		if err := o.Store.RescheduleOutbox(ctx, entry.Id, entry.Attempts, time.Now().Add(backoff(entry.Attempts)), err.Error()); err != nil {
+			log.Println("[WARN] federated message not rescheduled", entry.Message.Id.Hex(), err)
+		}
+		return
+	}
+	if err := o.Store.DeleteOutbox(ctx, entry.Id); err != nil {
+		log.Println("[WARN] federation outbox entry not deleted", entry.Id.Hex(), err)
+	}
+}
+
+// backoff doubles from 30 seconds per attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
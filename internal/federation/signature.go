@@ -0,0 +1,85 @@
+package federation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderOrigin    = "X-Filagram-Origin"
+	HeaderDate      = "X-Filagram-Date"
+	HeaderSignature = "X-Filagram-Signature"
+)
+
+// maxClockSkew bounds the age of a signed request, a captured one cannot
+// be replayed later.
+const maxClockSkew = 5 * time.Minute
+
+var ErrUnauthorized = errors.New("invalid server signature")
+
+// signingString covers the request line, both servers, the date and the
+// body, so a request is only valid for the server it was sent to.
+func signingString(method, uri, destination, origin, date string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(strings.Join([]string{method, uri, destination, origin, date, hex.EncodeToString(sum[:])}, "\n"))
+}
+
+// Signer signs requests to other servers as Domain.
+type Signer struct {
+	Domain string
+	Key    ed25519.PrivateKey
+}
+
+func (s *Signer) Sign(req *http.Request, body []byte) {
+	date := time.Now().UTC().Format(http.TimeFormat)
+	signature := ed25519.Sign(s.Key, signingString(req.Method, req.URL.RequestURI(), req.URL.Host, s.Domain, date, body))
+	req.Header.Set(HeaderOrigin, s.Domain)
+	req.Header.Set(HeaderDate, date)
+	req.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(signature))
+}
+
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.Key.Public().(ed25519.PublicKey)
+}
+
+// Verifier checks requests from other servers are signed by the key their
+// origin publishes. With Allowed set, only those servers are accepted.
+type Verifier struct {
+	Domain  string
+	Keys    KeySource
+	Allowed []string
+}
+
+// Verify returns the server a request came from.
+func (v *Verifier) Verify(ctx context.Context, req *http.Request, body []byte) (string, error) {
+	origin := strings.ToLower(req.Header.Get(HeaderOrigin))
+	date := req.Header.Get(HeaderDate)
+	signature, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderSignature))
+	if !ValidHost(origin) || origin == v.Domain || err != nil {
+		return "", ErrUnauthorized
+	}
+	if len(v.Allowed) > 0 && !slices.Contains(v.Allowed, origin) {
+		return "", ErrUnauthorized
+	}
+	signed, err := http.ParseTime(date)
+	if err != nil || time.Since(signed).Abs() > maxClockSkew {
+		return "", ErrUnauthorized
+	}
+
+	key, err := v.Keys.ServerKey(ctx, origin)
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(key, signingString(req.Method, req.URL.RequestURI(), v.Domain, origin, date, body), signature) {
+		return "", ErrUnauthorized
+	}
+	return origin, nil
+}
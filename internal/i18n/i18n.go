@@ -0,0 +1,124 @@
+// Package i18n translates the strings the server generates itself: API
+// errors, emails, system messages and push notifications. Catalogs are JSON
+// files embedded from locales/, one per language.
+//
+// Strings are looked up by key, errors by their English message, so
+// handlers keep returning plain English and only the edges translate.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type catalog struct {
+	Strings map[string]string `json:"strings"`
+	Errors  map[string]string `json:"errors"`
+}
+
+//go:embed locales/*.json
+var files embed.FS
+
+var catalogs = load()
+
+// Default is the locale used when nothing better is known, and the one
+// every lookup falls back to.
+var Default = "en"
+
+func load() map[string]catalog {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]catalog, len(entries))
+	for _, entry := range entries {
+		raw, err := files.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var c catalog
+		if err := json.Unmarshal(raw, &c); err != nil {
+			panic("i18n: " + entry.Name() + ": " + err.Error())
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = c
+	}
+	return catalogs
+}
+
+// Locales lists the locales with a catalog.
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return locales
+}
+
+func Supported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// T returns the string for key in locale with {name} placeholders filled
+// from params. Missing translations fall back to the default locale and
+// then to the key itself.
+func T(locale string, key string, params map[string]string) string {
+	text, ok := catalogs[locale].Strings[key]
+	if !ok {
+		if text, ok = catalogs[Default].Strings[key]; !ok {
+			text = key
+		}
+	}
+	if len(params) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Error translates an API error message, returning it unchanged when the
+// locale has no translation.
+func Error(locale string, message string) string {
+	if text, ok := catalogs[locale].Errors[message]; ok {
+		return text
+	}
+	return message
+}
+
+// Negotiate picks the supported locale the client prefers most from an
+// Accept-Language header, matching on the primary language only.
+func Negotiate(header string) string {
+	type choice struct {
+		locale string
+		q      float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if q > 0 && Supported(primary) {
+			choices = append(choices, choice{primary, q})
+		}
+	}
+	if len(choices) == 0 {
+		return Default
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].locale
+}
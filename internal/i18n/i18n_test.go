@@ -0,0 +1,49 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                          "en",
+		"pl-PL,pl;q=0.9,en;q=0.8":   "pl",
+		"de-DE,en;q=0.5,pl;q=0.7":   "pl",
+		"fr, de":                    "en",
+		"pl;q=0, en-US":             "en",
+		"EN-gb;q=0.8, pl;q=garbage": "en",
+	}
+	for header, want := range cases {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestTranslateFallsBack(t *testing.T) {
+	if got := T("pl", "push.missed_call_from", map[string]string{"username": "ala"}); got != "Nieodebrane połączenie od ala" {
+		t.Errorf("Unexpected translation %q", got)
+	}
+	if got := T("xx", "push.missed_call", nil); got != "Missed call" {
+		t.Errorf("Expected default locale fallback, got %q", got)
+	}
+	if got := T("pl", "no.such.key", nil); got != "no.such.key" {
+		t.Errorf("Expected key fallback, got %q", got)
+	}
+	if got := Error("pl", "user not found"); got != "nie znaleziono użytkownika" {
+		t.Errorf("Unexpected error translation %q", got)
+	}
+	if got := Error("pl", "invalid member 1"); got != "invalid member 1" {
+		t.Errorf("Expected untranslated error unchanged, got %q", got)
+	}
+}
+
+// Every locale must cover the strings of the default one, an error message
+// missing from a catalog is fine since it falls back to English.
+func TestCatalogsComplete(t *testing.T) {
+	for _, locale := range Locales() {
+		for key := range catalogs["en"].Strings {
+			if _, ok := catalogs[locale].Strings[key]; !ok {
+				t.Errorf("Locale %s misses %s", locale, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package importer reads chat exports of other apps, so users can bring a
+// conversation's history along. Exports are parsed into Export, the same
+// for every source, and Build turns one into messages between two users.
+package importer
+
+import (
+	"errors"
+	"filachat/internal/models"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	SourceTelegram = "telegram"
+	SourceWhatsApp = "whatsapp"
+)
+
+// Export is a conversation as an app exported it, oldest message first.
+type Export struct {
+	Source   string
+	Messages []Message
+}
+
+type Message struct {
+	ExternalId string
+	// Author is the display name, AuthorId the id in the source app when
+	// the export has one.
+	Author   string
+	AuthorId string
+	Time     time.Time
+	Text     string
+	Media    *models.ImportedMedia
+}
+
+// Parse reads an export of source.
+func Parse(source string, r io.Reader) (Export, error) {
+	switch source {
+	case SourceTelegram:
+		return parseTelegram(r)
+	case SourceWhatsApp:
+		return parseWhatsApp(r)
+	}
+	return Export{}, fmt.Errorf("unknown source %q", source)
+}
+
+// Authors lists the distinct authors of an export in order of appearance.
+func (e Export) Authors() []string {
+	var authors []string
+	seen := map[string]bool{}
+	for _, message := range e.Messages {
+		if !seen[message.Author] {
+			seen[message.Author] = true
+			authors = append(authors, message.Author)
+		}
+	}
+	return authors
+}
+
+// Build turns an export of a direct conversation into messages between
+// owner, who appears in it as self (name or id in the source app), and
+// peer. Timestamps are kept, apart from messages sharing one being spread
+// a millisecond apart so their order survives.
+func Build(export Export, owner, peer bson.ObjectID, self string) ([]models.Message, error) {
+	authors := export.Authors()
+	if len(authors) > 2 {
+		return nil, errors.New("only direct conversations can be imported")
+	}
+	isSelf := func(message Message) bool {
+		return strings.EqualFold(message.Author, self) || (message.AuthorId != "" && message.AuthorId == self)
+	}
+	if len(authors) == 2 && !slices.ContainsFunc(export.Messages, isSelf) {
+		return nil, fmt.Errorf("%q is none of the authors %s", self, strings.Join(authors, ", "))
+	}
+	conversation := models.ConversationId(owner, peer)
+
+	messages := make([]models.Message, 0, len(export.Messages))
+	var previous time.Time
+	for i, imported := range export.Messages {
+		sender, recipient := peer, owner
+		if isSelf(imported) {
+			sender, recipient = owner, peer
+		}
+		timestamp := imported.Time
+		if !timestamp.After(previous) && i > 0 {
+			timestamp = previous.Add(time.Millisecond)
+		}
+		previous = timestamp
+
+		externalId := imported.ExternalId
+		if externalId == "" {
+			externalId = fmt.Sprint(i)
+		}
+		messages = append(messages, models.Message{
+			Id:          bson.NewObjectID(),
+			SenderId:    sender,
+			RecipientId: recipient,
+			Type:        models.TypeMessage,
+			Content:     imported.Text,
+			Read:        true,
+			Timestamp:   timestamp,
+			Import: &models.ImportInfo{
+				Source:     export.Source,
+				ExternalId: imported.ExternalId,
+				Author:     imported.Author,
+				Media:      imported.Media,
+				ImportedBy: owner,
+				Key:        conversation + "/" + export.Source + "/" + externalId,
+			},
+		})
+	}
+	return messages, nil
+}
@@ -0,0 +1,79 @@
+package importer
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"strings"
+	"testing"
+)
+
+const telegramSample = `{
+  "name": "Ola", "type": "personal_chat", "id": 42,
+  "messages": [
+    {"id": 1, "type": "message", "date": "2024-01-01T12:00:00", "date_unixtime": "1704110400", "from": "Ala", "from_id": "user1", "text": "hej"},
+    {"id": 2, "type": "service", "date": "2024-01-01T12:00:00", "date_unixtime": "1704110400", "actor": "Ala", "action": "phone_call", "text": ""},
+    {"id": 3, "type": "message", "date": "2024-01-01T12:00:00", "date_unixtime": "1704110400", "from": "Ola", "from_id": "user2", "text": ["see ", {"type": "link", "text": "filagram.pl"}]},
+    {"id": 4, "type": "message", "date": "2024-01-01T12:01:00", "date_unixtime": "1704110460", "from": "Ola", "from_id": "user2", "text": "", "photo": "photos/photo_1.jpg"}
+  ]
+}`
+
+func TestParseTelegram(t *testing.T) {
+	export, err := Parse(SourceTelegram, strings.NewReader(telegramSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Messages) != 3 {
+		t.Fatalf("Expected 3 messages without the service one, got %+v", export.Messages)
+	}
+	if export.Messages[1].Text != "see filagram.pl" {
+		t.Errorf("Expected formatted text to be flattened, got %q", export.Messages[1].Text)
+	}
+	if media := export.Messages[2].Media; media == nil || media.Kind != "photo" || media.FileName != "photo_1.jpg" {
+		t.Errorf("Expected the photo to be kept as media, got %+v", media)
+	}
+}
+
+func TestParseWhatsApp(t *testing.T) {
+	sample := `[
+	  {"date": "2024-01-01T12:00:00Z", "author": null, "message": "Messages are end-to-end encrypted."},
+	  {"date": "2024-01-01T12:00:00Z", "author": "Ala", "message": "hej"},
+	  {"date": "2024-01-01T12:02:00Z", "author": "Ola", "message": "PTT-20240101-WA0001.opus (file attached)", "attachment": {"fileName": "PTT-20240101-WA0001.opus"}}
+	]`
+	export, err := Parse(SourceWhatsApp, strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Messages) != 2 {
+		t.Fatalf("Expected 2 messages without the notice, got %+v", export.Messages)
+	}
+	if media := export.Messages[1].Media; media == nil || media.Kind != "voice_message" || export.Messages[1].Text != "" {
+		t.Errorf("Expected a voice message without text, got %+v", export.Messages[1])
+	}
+}
+
+func TestBuildKeepsOrder(t *testing.T) {
+	export, err := Parse(SourceTelegram, strings.NewReader(telegramSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, peer := bson.NewObjectID(), bson.NewObjectID()
+
+	messages, err := Build(export, owner, peer, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages[0].SenderId != owner || messages[1].SenderId != peer {
+		t.Errorf("Expected the first message from the owner and the second from the peer")
+	}
+	for i := 1; i < len(messages); i++ {
+		if !messages[i].Timestamp.After(messages[i-1].Timestamp) {
+			t.Errorf("Expected message %d after message %d, got %v and %v", i, i-1, messages[i].Timestamp, messages[i-1].Timestamp)
+		}
+	}
+	if !messages[0].Read || messages[0].Import == nil || messages[0].Import.ImportedBy != owner {
+		t.Errorf("Expected imported messages marked read and imported by the owner, got %+v", messages[0])
+	}
+
+	if _, err := Build(export, owner, peer, "Ela"); err == nil {
+		t.Error("Expected an error when self is not an author")
+	}
+}
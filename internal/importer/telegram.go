@@ -0,0 +1,108 @@
+package importer
+
+import (
+	"encoding/json"
+	"filachat/internal/models"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramExport is the result.json of a chat exported by Telegram
+// Desktop in machine-readable format.
+type telegramExport struct {
+	Type     string            `json:"type"`
+	Messages []telegramMessage `json:"messages"`
+}
+
+type telegramMessage struct {
+	Id           int64           `json:"id"`
+	Type         string          `json:"type"`
+	DateUnixtime string          `json:"date_unixtime"`
+	Date         string          `json:"date"`
+	From         string          `json:"from"`
+	FromId       string          `json:"from_id"`
+	Text         json.RawMessage `json:"text"`
+	Photo        string          `json:"photo"`
+	File         string          `json:"file"`
+	MediaType    string          `json:"media_type"`
+}
+
+func parseTelegram(r io.Reader) (Export, error) {
+	var raw telegramExport
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return Export{}, err
+	}
+
+	export := Export{Source: SourceTelegram}
+	for _, message := range raw.Messages {
+		// service messages are joins, pins, calls and the like
+		if message.Type != "message" {
+			continue
+		}
+		imported := Message{
+			ExternalId: strconv.FormatInt(message.Id, 10),
+			Author:     message.From,
+			AuthorId:   message.FromId,
+			Time:       telegramTime(message),
+			Text:       telegramText(message.Text),
+			Media:      telegramMedia(message),
+		}
+		if imported.Text == "" && imported.Media == nil {
+			continue
+		}
+		export.Messages = append(export.Messages, imported)
+	}
+	return export, nil
+}
+
+// telegramTime prefers the unix time, the plain date is local time of the
+// exporting machine.
+func telegramTime(message telegramMessage) time.Time {
+	if seconds, err := strconv.ParseInt(message.DateUnixtime, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC()
+	}
+	t, _ := time.Parse("2006-01-02T15:04:05", message.Date)
+	return t
+}
+
+// telegramText flattens text, which is a string or, with formatting or
+// links, a list of strings and entities.
+func telegramText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(raw, &parts) != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(part, &text) == nil {
+			b.WriteString(text)
+		} else if json.Unmarshal(part, &entity) == nil {
+			b.WriteString(entity.Text)
+		}
+	}
+	return b.String()
+}
+
+func telegramMedia(message telegramMessage) *models.ImportedMedia {
+	switch {
+	case message.Photo != "":
+		return &models.ImportedMedia{Kind: "photo", FileName: path.Base(message.Photo)}
+	case message.File != "":
+		kind := message.MediaType
+		if kind == "" {
+			kind = "file"
+		}
+		return &models.ImportedMedia{Kind: kind, FileName: path.Base(message.File)}
+	}
+	return nil
+}
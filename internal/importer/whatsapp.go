@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"encoding/json"
+	"filachat/internal/models"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// WhatsApp exports chats as text only. What is read here is the JSON the
+// common converters, such as whatsapp-chat-parser, make of that text: a
+// list of messages with the attachment file name where there was one.
+type whatsAppMessage struct {
+	Date       time.Time `json:"date"`
+	Author     *string   `json:"author"`
+	Message    string    `json:"message"`
+	Attachment *struct {
+		FileName string `json:"fileName"`
+	} `json:"attachment"`
+}
+
+// whatsAppKinds maps the parts of attachment file names that tell their
+// kind, IMG-20240101-WA0001.jpg on Android and 00000012-PHOTO-2024-01-01.jpg
+// on iOS.
+var whatsAppKinds = map[string]string{
+	"IMG": "photo", "VID": "video", "PTT": "voice_message", "AUD": "audio_file", "STK": "sticker",
+	"PHOTO": "photo", "VIDEO": "video", "AUDIO": "voice_message", "STICKER": "sticker",
+}
+
+func whatsAppKind(name string) string {
+	parts := strings.SplitN(name, "-", 3)
+	for _, part := range parts[:min(2, len(parts))] {
+		if kind, ok := whatsAppKinds[part]; ok {
+			return kind
+		}
+	}
+	return "file"
+}
+
+func parseWhatsApp(r io.Reader) (Export, error) {
+	var raw []whatsAppMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return Export{}, err
+	}
+
+	export := Export{Source: SourceWhatsApp}
+	for _, message := range raw {
+		// messages without author are notices such as the encryption one
+		if message.Author == nil {
+			continue
+		}
+		imported := Message{Author: *message.Author, Time: message.Date.UTC(), Text: message.Message}
+		if message.Attachment != nil {
+			name := path.Base(message.Attachment.FileName)
+			imported.Media = &models.ImportedMedia{Kind: whatsAppKind(name), FileName: name}
+			// the text of an attachment is its file name
+			imported.Text = ""
+		}
+		if imported.Text == "" && imported.Media == nil {
+			continue
+		}
+		export.Messages = append(export.Messages, imported)
+	}
+	return export, nil
+}
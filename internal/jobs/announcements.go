@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	database "filachat/internal/data"
+	"filachat/internal/models"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Announcer keeps the retained message on the announcements topic in line
+// with the announcements that are live right now. It rechecks every Interval
+// so scheduled announcements appear and expired ones disappear on their own,
+// and Refresh is called after admins change something.
+type Announcer struct {
+	DB       *database.DB
+	Broker   broker.Publisher
+	Interval time.Duration
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (a *Announcer) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		a.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Announcer) Refresh(ctx context.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	active, err := a.DB.GetActiveAnnouncements(ctx, time.Now())
+	if err != nil {
+		log.Println("[WARN] announcements not loaded", err)
+		return
+	}
+	ids := make([]string, len(active))
+	for i, announcement := range active {
+		ids[i] = announcement.Id.Hex()
+	}
+	if a.published != nil && slices.Equal(ids, a.published) {
+		return
+	}
+
+	// an empty retained payload clears the topic once nothing is active
+	var payload []byte
+	if len(active) > 0 {
+		payload, _ = json.Marshal(active)
+	}
+	if err := a.Broker.Publish(ctx, models.AnnouncementsTopic, payload, true, broker.QoS(broker.ClassAnnouncements)); err != nil {
+		log.Println("[WARN] announcements not published", err)
+		return
+	}
+	a.published = ids
+}
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"context"
+	database "filachat/internal/data"
+	"filachat/internal/metrics"
+	"log"
+	"time"
+)
+
+// Reaper hard deletes soft-deleted users and messages once their grace
+// window has passed.
+type Reaper struct {
+	DB       *database.DB
+	Grace    time.Duration
+	Interval time.Duration
+}
+
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reaper) RunOnce(ctx context.Context) {
+	users, messages, err := r.DB.PurgeDeleted(ctx, time.Now().Add(-r.Grace))
+	if err != nil {
+		log.Println("[WARN] trash purge failed", err)
+	}
+	metrics.ReaperPurged.WithLabelValues("users").Add(float64(users))
+	metrics.ReaperPurged.WithLabelValues("messages").Add(float64(messages))
+	if users > 0 || messages > 0 {
+		log.Printf("[INFO] trash purged %d users and %d messages", users, messages)
+	}
+}
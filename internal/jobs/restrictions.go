@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"context"
+	imiddleware "filachat/internal/api/middleware"
+	database "filachat/internal/data"
+	"log"
+	"time"
+)
+
+// RestrictionsLoader reloads the accounts that are not active every
+// Interval, so transitions made on other instances and suspensions that
+// ran out take effect here too.
+type RestrictionsLoader struct {
+	DB         *database.DB
+	Restricted *imiddleware.Restrictions
+	Interval   time.Duration
+}
+
+func (l *RestrictionsLoader) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := l.Load(ctx); err != nil {
+			log.Println("[WARN] account restrictions not loaded", err)
+		}
+	}
+}
+
+func (l *RestrictionsLoader) Load(ctx context.Context) error {
+	users, err := l.DB.GetRestrictedAccounts(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	l.Restricted.Set(users)
+	return nil
+}
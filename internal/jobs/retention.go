@@ -0,0 +1,155 @@
+package jobs
+
+import (
+	"context"
+	database "filachat/internal/data"
+	"filachat/internal/metrics"
+	"log"
+	"sync"
+	"time"
+)
+
+const retentionBatchSize = 1000
+
+// RetentionJanitor enforces the operator's retention policy: messages older
+// than MaxAge and messages beyond the newest MaxPerConversation of a
+// conversation are purged. A zero value disables the rule. Organizations
+// may keep the content they own for a shorter time on top. In dry-run mode
+// the janitor only reports what it would delete.
+type RetentionJanitor struct {
+	DB                 *database.DB
+	MaxAge             time.Duration
+	MaxPerConversation int64
+	Interval           time.Duration
+	DryRun             bool
+
+	// mu is held for a whole run so a policy change never applies halfway
+	mu sync.Mutex
+}
+
+func (j *RetentionJanitor) Enabled() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.MaxAge > 0 || j.MaxPerConversation > 0
+}
+
+// Reconfigure swaps the policy, it applies from the next run on.
+func (j *RetentionJanitor) Reconfigure(maxAge time.Duration, maxPerConversation int64, dryRun bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.MaxAge, j.MaxPerConversation, j.DryRun = maxAge, maxPerConversation, dryRun
+}
+
+func (j *RetentionJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *RetentionJanitor) RunOnce(ctx context.Context) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.MaxAge > 0 {
+		if err := j.purgeByAge(ctx); err != nil {
+			log.Println("[WARN] retention by age failed", err)
+		}
+	}
+	if j.MaxPerConversation > 0 {
+		if err := j.purgeByCount(ctx); err != nil {
+			log.Println("[WARN] retention by count failed", err)
+		}
+	}
+	if err := j.purgeOrganizations(ctx); err != nil {
+		log.Println("[WARN] organization retention failed", err)
+	}
+}
+
+func (j *RetentionJanitor) purgeByAge(ctx context.Context) error {
+	cutoff := time.Now().Add(-j.MaxAge)
+	count, err := j.DB.CountMessagesBefore(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	metrics.RetentionCandidates.WithLabelValues("max_age").Set(float64(count))
+	if j.DryRun {
+		log.Printf("[INFO] retention dry run: %d messages older than %s", count, cutoff.Format(time.RFC3339))
+		return nil
+	}
+
+	deleted, err := j.DB.DeleteMessagesBefore(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	metrics.RetentionPurged.WithLabelValues("max_age").Add(float64(deleted))
+	log.Printf("[INFO] retention purged %d messages older than %s", deleted, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+func (j *RetentionJanitor) purgeByCount(ctx context.Context) error {
+	ids, err := j.DB.OverflowingMessages(ctx, j.MaxPerConversation)
+	if err != nil {
+		return err
+	}
+	metrics.RetentionCandidates.WithLabelValues("max_per_conversation").Set(float64(len(ids)))
+	if j.DryRun {
+		log.Printf("[INFO] retention dry run: %d messages over the per-conversation cap", len(ids))
+		return nil
+	}
+
+	var total int64
+	for start := 0; start < len(ids); start += retentionBatchSize {
+		end := min(start+retentionBatchSize, len(ids))
+		deleted, err := j.DB.DeleteMessages(ctx, ids[start:end])
+		if err != nil {
+			return err
+		}
+		total += deleted
+	}
+	metrics.RetentionPurged.WithLabelValues("max_per_conversation").Add(float64(total))
+	log.Printf("[INFO] retention purged %d messages over the per-conversation cap", total)
+	return nil
+}
+
+func (j *RetentionJanitor) purgeOrganizations(ctx context.Context) error {
+	orgs, err := j.DB.OrgsWithRetention(ctx)
+	if err != nil {
+		return err
+	}
+
+	var candidates, total int64
+	for _, org := range orgs {
+		cutoff := time.Now().AddDate(0, 0, -org.Settings.RetentionDays)
+		if j.DryRun {
+			count, err := j.DB.CountOrgContentBefore(ctx, org.Id, cutoff)
+			if err != nil {
+				return err
+			}
+			candidates += count
+			log.Printf("[INFO] retention dry run: %d items of organization %s older than %s", count, org.Id.Hex(), cutoff.Format(time.RFC3339))
+			continue
+		}
+		deleted, err := j.DB.DeleteOrgContentBefore(ctx, org.Id, cutoff)
+		if err != nil {
+			return err
+		}
+		total += deleted
+	}
+	if j.DryRun {
+		metrics.RetentionCandidates.WithLabelValues("organization").Set(float64(candidates))
+		return nil
+	}
+	metrics.RetentionPurged.WithLabelValues("organization").Add(float64(total))
+	if total > 0 {
+		log.Printf("[INFO] retention purged %d items under organization policies", total)
+	}
+	return nil
+}
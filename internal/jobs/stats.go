@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"context"
+	database "filachat/internal/data"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// StatsAggregator keeps the activity of the current day in the stats
+// collection and the gauges: distinct senders, messages and the peak of
+// concurrent broker connections. Connections is sampled every Interval,
+// and is nil when the broker runs outside the process.
+type StatsAggregator struct {
+	DB          *database.DB
+	Connections func() int64
+	Interval    time.Duration
+
+	mu   sync.Mutex
+	day  time.Time
+	peak int64
+}
+
+func (s *StatsAggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *StatsAggregator) RunOnce(ctx context.Context) {
+	now := time.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+
+	s.mu.Lock()
+	if !day.Equal(s.day) {
+		if !s.day.IsZero() {
+			// close the previous day with its final counts
+			s.aggregate(ctx, s.day, s.peak, now)
+		}
+		s.day, s.peak = day, 0
+	}
+	if s.Connections != nil {
+		s.peak = max(s.peak, s.Connections())
+	}
+	peak := s.peak
+	s.mu.Unlock()
+
+	stats := s.aggregate(ctx, day, peak, now)
+	metrics.ActiveUsersToday.Set(float64(stats.ActiveUsers))
+	metrics.MessagesToday.Set(float64(stats.Messages))
+	metrics.PeakConnectionsToday.Set(float64(stats.PeakConnections))
+}
+
+func (s *StatsAggregator) aggregate(ctx context.Context, day time.Time, peak int64, now time.Time) models.DailyStats {
+	stats := models.DailyStats{Day: day, PeakConnections: peak, UpdatedAt: now}
+	var err error
+	stats.ActiveUsers, stats.Messages, err = s.DB.CountActivity(ctx, day, day.Add(24*time.Hour))
+	if err != nil {
+		log.Println("[WARN] activity count failed", err)
+		return stats
+	}
+	if err := s.DB.SaveDailyStats(ctx, &stats); err != nil {
+		log.Println("[WARN] stats not saved", err)
+	}
+	return stats
+}
@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"context"
+	database "filachat/internal/data"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+)
+
+type summaryUpdate struct {
+	message *models.Message
+	reader  bson.ObjectID
+	read    []bson.ObjectID
+}
+
+// SummaryProjector keeps the conversation summaries current in the
+// background, in the order messages were sent and read. Updates are dropped
+// when the queue is full; the next read of the conversation recounts its
+// unread messages, and the rebuild-summaries command repairs the rest.
+type SummaryProjector struct {
+	DB    *database.DB
+	queue chan summaryUpdate
+}
+
+func NewSummaryProjector(db *database.DB, size int) *SummaryProjector {
+	return &SummaryProjector{DB: db, queue: make(chan summaryUpdate, size)}
+}
+
+// Message records a stored direct message, group messages are skipped.
+func (p *SummaryProjector) Message(message models.Message) {
+	if !message.GroupId.IsZero() {
+		return
+	}
+	p.enqueue(summaryUpdate{message: &message})
+}
+
+// Read recounts the unread messages of reader after they read ids.
+func (p *SummaryProjector) Read(reader bson.ObjectID, ids []bson.ObjectID) {
+	p.enqueue(summaryUpdate{reader: reader, read: ids})
+}
+
+func (p *SummaryProjector) enqueue(update summaryUpdate) {
+	select {
+	case p.queue <- update:
+	default:
+		log.Println("[WARN] summary queue full, update dropped")
+	}
+}
+
+// Len is the number of updates waiting to be applied.
+func (p *SummaryProjector) Len() int {
+	return len(p.queue)
+}
+
+// Run applies queued updates until ctx is done.
+func (p *SummaryProjector) Run(ctx context.Context) {
+	for {
+		var update summaryUpdate
+		select {
+		case <-ctx.Done():
+			return
+		case update = <-p.queue:
+		}
+		if update.message != nil {
+			if err := p.DB.RecordMessage(ctx, update.message); err != nil {
+				log.Println("[WARN] conversation summary not updated", update.message.Id.Hex(), err)
+			}
+			continue
+		}
+		if err := p.DB.RecountUnread(ctx, update.reader, update.read); err != nil {
+			log.Println("[WARN] unread counts not updated", update.reader.Hex(), err)
+		}
+	}
+}
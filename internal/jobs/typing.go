@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TypingAggregator sums up who is typing in each group. Members report
+// with Typing every few seconds while they type; every Interval each group
+// that changed gets one models.GroupTyping event on the message topic of
+// every member, so a busy group costs its members one event per Interval
+// rather than one per typist and report. A member drops out TTL after
+// their last report, or right away with Stopped.
+type TypingAggregator struct {
+	Broker   broker.Publisher
+	Interval time.Duration
+	TTL      time.Duration
+
+	mu     sync.Mutex
+	groups map[bson.ObjectID]*typingGroup
+}
+
+type typingGroup struct {
+	members []bson.ObjectID
+	typists map[bson.ObjectID]*typist
+	changed bool
+}
+
+type typist struct {
+	name  string
+	since time.Time
+	until time.Time
+}
+
+// Typing records that user, a member of group, is typing.
+func (a *TypingAggregator) Typing(group models.Group, user bson.ObjectID, name string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.groups == nil {
+		a.groups = make(map[bson.ObjectID]*typingGroup)
+	}
+	g, ok := a.groups[group.Id]
+	if !ok {
+		g = &typingGroup{typists: make(map[bson.ObjectID]*typist)}
+		a.groups[group.Id] = g
+	}
+	g.members = group.Members
+	if t, ok := g.typists[user]; ok {
+		t.until = now.Add(a.TTL)
+		return
+	}
+	g.typists[user] = &typist{name: name, since: now, until: now.Add(a.TTL)}
+	g.changed = true
+}
+
+// Stopped records that user stopped typing in group before their report ran
+// out, e.g. because they sent the message.
+func (a *TypingAggregator) Stopped(group bson.ObjectID, user bson.ObjectID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if g, ok := a.groups[group]; ok {
+		if _, ok := g.typists[user]; ok {
+			delete(g.typists, user)
+			g.changed = true
+		}
+	}
+}
+
+func (a *TypingAggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.Flush(ctx, now)
+		}
+	}
+}
+
+type typingSummary struct {
+	members []bson.ObjectID
+	event   models.GroupTyping
+}
+
+// Flush drops the typists whose reports ran out and publishes the summary
+// of every group that changed since the last flush.
+func (a *TypingAggregator) Flush(ctx context.Context, now time.Time) {
+	var summaries []typingSummary
+	a.mu.Lock()
+	for id, g := range a.groups {
+		for user, t := range g.typists {
+			if !now.Before(t.until) {
+				delete(g.typists, user)
+				g.changed = true
+			}
+		}
+		if len(g.typists) == 0 {
+			delete(a.groups, id)
+		}
+		if !g.changed {
+			continue
+		}
+		g.changed = false
+		summaries = append(summaries, typingSummary{members: g.members, event: g.summary(id, now)})
+	}
+	a.mu.Unlock()
+
+	for _, summary := range summaries {
+		payload, _ := json.Marshal(summary.event)
+		for _, member := range summary.members {
+			if err := a.Broker.Publish(ctx, models.MessageTopic(member), payload, false, broker.QoS(broker.ClassTyping)); err != nil {
+				log.Println("[WARN] group typing not published", summary.event.GroupId.Hex(), member.Hex(), err)
+			}
+		}
+	}
+}
+
+func (g *typingGroup) summary(id bson.ObjectID, now time.Time) models.GroupTyping {
+	users := make([]bson.ObjectID, 0, len(g.typists))
+	for user := range g.typists {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return g.typists[users[i]].since.Before(g.typists[users[j]].since)
+	})
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = g.typists[user].name
+	}
+	return models.GroupTyping{
+		Type:      models.TypeTyping,
+		GroupId:   id,
+		Users:     users,
+		Names:     names,
+		Count:     len(users),
+		Timestamp: now,
+	}
+}
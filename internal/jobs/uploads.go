@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"context"
+	database "filachat/internal/data"
+	"filachat/internal/storage"
+	"log"
+	"time"
+)
+
+// UploadCollector drops resumable uploads that saw no chunk for longer than
+// Expiry, together with the bytes they already stored. It also forgets the
+// nonces of single-use media links once the links expired.
+type UploadCollector struct {
+	DB       *database.DB
+	Storage  storage.BlobStore
+	Expiry   time.Duration
+	Interval time.Duration
+}
+
+func (u *UploadCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(u.Interval)
+	defer ticker.Stop()
+
+	for {
+		u.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (u *UploadCollector) RunOnce(ctx context.Context) {
+	uploads, err := u.DB.TakeStaleUploads(ctx, time.Now().Add(-u.Expiry))
+	if err != nil {
+		log.Println("[WARN] stale upload collection failed", err)
+	}
+	for _, upload := range uploads {
+		if err := u.Storage.Delete(storage.PartialKey(upload.Id.Hex())); err != nil {
+			log.Println("[WARN] partial upload not deleted", upload.Id.Hex(), err)
+		}
+	}
+	if len(uploads) > 0 {
+		log.Printf("[INFO] collected %d stale uploads", len(uploads))
+	}
+
+	if err := u.DB.PurgeExpiredNonces(ctx); err != nil {
+		log.Println("[WARN] media nonce purge failed", err)
+	}
+}
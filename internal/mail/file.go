@@ -0,0 +1,24 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileSender writes every message to Dir as an .eml file instead of sending
+// it, for development: mail clients open them as they would arrive.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(message Message) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(message.To)
+	name := time.Now().Format("20060102-150405.000000") + "-" + recipient + ".eml"
+	return os.WriteFile(filepath.Join(s.Dir, name), compose(s.From, message), 0o644)
+}
@@ -0,0 +1,137 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"filachat/pkg/config"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// ReplyTo and InReplyTo are set on replies to email received through
+	// the gateway, so the answer of the recipient comes back to chat and
+	// their mail client threads the conversation.
+	ReplyTo   string
+	InReplyTo string
+}
+
+type Sender interface {
+	Send(message Message) error
+}
+
+type SMTPSender struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (s *SMTPSender) Send(message Message) error {
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{message.To}, compose(s.From, message))
+}
+
+// LogSender is used when no mail provider is configured, so setups without
+// one can follow verification links from the log.
+type LogSender struct{}
+
+func (s *LogSender) Send(message Message) error {
+	log.Printf("[INFO] mail to %s: %s\n%s", message.To, message.Subject, message.Text)
+	return nil
+}
+
+// compose renders a message as MIME, with the text and, when there is
+// one, the HTML part as alternatives.
+func compose(from string, message Message) []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", from)
+	fmt.Fprintf(&body, "To: %s\r\n", message.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if message.ReplyTo != "" {
+		fmt.Fprintf(&body, "Reply-To: %s\r\n", message.ReplyTo)
+	}
+	if message.InReplyTo != "" {
+		fmt.Fprintf(&body, "In-Reply-To: %s\r\nReferences: %s\r\n", message.InReplyTo, message.InReplyTo)
+	}
+	body.WriteString("MIME-Version: 1.0\r\n")
+
+	if message.HTML == "" {
+		body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		body.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuoted(&body, message.Text)
+		return body.Bytes()
+	}
+
+	raw := make([]byte, 12)
+	rand.Read(raw)
+	boundary := "filagram-" + hex.EncodeToString(raw)
+	fmt.Fprintf(&body, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", message.Text},
+		{"text/html", message.HTML},
+	} {
+		fmt.Fprintf(&body, "--%s\r\n", boundary)
+		fmt.Fprintf(&body, "Content-Type: %s; charset=UTF-8\r\n", part.contentType)
+		body.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuoted(&body, part.content)
+		body.WriteString("\r\n")
+	}
+	fmt.Fprintf(&body, "--%s--\r\n", boundary)
+	return body.Bytes()
+}
+
+func writeQuoted(body *bytes.Buffer, content string) {
+	writer := quotedprintable.NewWriter(body)
+	writer.Write([]byte(content))
+	writer.Close()
+}
+
+// NewSender picks the provider named by MAIL_PROVIDER. Without one, SMTP is
+// used when an address is configured, development setups write mail to
+// disk and everything else only logs it.
+func NewSender(cfg *config.Config) Sender {
+	provider := cfg.MailProvider
+	if provider == "" {
+		switch {
+		case cfg.SMTPAddr != "":
+			provider = "smtp"
+		case cfg.Environment == "development":
+			provider = "file"
+		}
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch provider {
+	case "smtp":
+		sender := &SMTPSender{Addr: cfg.SMTPAddr, From: cfg.MailFrom}
+		if cfg.SMTPUsername != "" {
+			host, _, _ := net.SplitHostPort(cfg.SMTPAddr)
+			sender.Auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+		}
+		return sender
+	case "sendgrid":
+		return &SendGridSender{APIKey: cfg.SendGridAPIKey, From: cfg.MailFrom, Client: client}
+	case "ses":
+		return &SESSender{
+			Region:          cfg.SESRegion,
+			AccessKeyID:     cfg.SESAccessKeyID,
+			SecretAccessKey: cfg.SESSecretAccessKey,
+			From:            cfg.MailFrom,
+			Client:          client,
+		}
+	case "file":
+		return &FileSender{Dir: cfg.MailDir, From: cfg.MailFrom}
+	}
+	return &LogSender{}
+}
@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var untranslated = regexp.MustCompile(`mail\.[a-z_]+(\.[a-z_]+)?`)
+
+func TestRenderAllTemplates(t *testing.T) {
+	params := map[string]string{"username": "ala", "email": "ala@example.com", "link": "https://filagram.pl/x?a=1&b=2", "hours": "1", "minutes": "10",
+		"ip": "192.0.2.1", "user_agent": "<script>", "time": "now"}
+	for _, locale := range []string{"en", "pl"} {
+		for _, name := range Templates {
+			message, err := Render(name, locale, params)
+			if err != nil {
+				t.Fatalf("Render %s/%s failed: %v", locale, name, err)
+			}
+			for _, part := range []string{message.Subject, message.Text, message.HTML} {
+				if part == "" || untranslated.MatchString(part) {
+					t.Errorf("Untranslated key in %s/%s: %q", locale, name, part)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderEscapesHTMLOnly(t *testing.T) {
+	message, err := Render("login_alert", "en", map[string]string{"username": "ala", "user_agent": "<script>", "link": "https://filagram.pl/r?t=1&u=2"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if strings.Contains(message.HTML, "<script>") {
+		t.Error("Expected user agent to be escaped in HTML")
+	}
+	if !strings.Contains(message.Text, "<script>") || !strings.Contains(message.Text, "https://filagram.pl/r?t=1&u=2") {
+		t.Error("Expected text part to keep values verbatim")
+	}
+}
+
+func TestFileSenderWritesMIME(t *testing.T) {
+	dir := t.TempDir()
+	sender := &FileSender{Dir: dir, From: "no-reply@development.filagram.pl"}
+	if err := sender.Send(Message{To: "ala@example.com", Subject: "Zażółć", Text: "text", HTML: "<p>html</p>"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one .eml file, got %d", len(files))
+	}
+	raw, _ := os.ReadFile(files[0])
+	eml := string(raw)
+	for _, want := range []string{"Subject: =?utf-8?q?", "multipart/alternative", "text/plain", "text/html"} {
+		if !strings.Contains(eml, want) {
+			t.Errorf("Expected %q in message", want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SendGridSender sends through the SendGrid v3 mail API.
+type SendGridSender struct {
+	APIKey string
+	From   string
+	Client *http.Client
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (s *SendGridSender) Send(message Message) error {
+	content := []sendGridContent{{"text/plain", message.Text}}
+	if message.HTML != "" {
+		content = append(content, sendGridContent{"text/html", message.HTML})
+	}
+	body := map[string]any{
+		"personalizations": []map[string]any{{"to": []map[string]string{{"email": message.To}}}},
+		"from":             map[string]string{"email": s.From},
+		"subject":          message.Subject,
+		"content":          content,
+	}
+	if message.ReplyTo != "" {
+		body["reply_to"] = map[string]string{"email": message.ReplyTo}
+	}
+	if message.InReplyTo != "" {
+		body["headers"] = map[string]string{"In-Reply-To": message.InReplyTo, "References": message.InReplyTo}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"filachat/internal/i18n"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email has a text and an HTML template in templates/, wrapped in the
+// shared layout. Wording comes from the i18n catalogs under
+// mail.{name}.*, the templates only arrange it:
+//
+//	{{t .Locale "mail.verification.intro" .Params}}
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Templates lists the emails the server sends.
+var Templates = []string{"verification", "email_changed", "login_alert", "reset", "magic_link"}
+
+type templateData struct {
+	Locale string
+	Params map[string]string
+}
+
+var (
+	htmlTemplates = map[string]*htmltemplate.Template{}
+	textTemplates = map[string]*texttemplate.Template{}
+)
+
+func init() {
+	funcs := map[string]any{"t": i18n.T}
+	for _, name := range Templates {
+		htmlTemplates[name] = htmltemplate.Must(htmltemplate.New("layout.html").Funcs(funcs).
+			ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html"))
+		textTemplates[name] = texttemplate.Must(texttemplate.New("layout.txt").Funcs(funcs).
+			ParseFS(templateFiles, "templates/layout.txt", "templates/"+name+".txt"))
+	}
+}
+
+// Render builds the email name in locale, leaving the recipient to the
+// caller. Params fill the placeholders of the catalog strings.
+func Render(name string, locale string, params map[string]string) (Message, error) {
+	data := templateData{Locale: locale, Params: params}
+
+	var html, text bytes.Buffer
+	if err := htmlTemplates[name].Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	if err := textTemplates[name].Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Subject: i18n.T(locale, "mail."+name+".subject", params),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,58 @@
+package maintenance
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sync"
+	"time"
+)
+
+// State is the runtime maintenance flag shared by the HTTP middleware and
+// the broker hook.
+type State struct {
+	mu     sync.RWMutex
+	status Status
+}
+
+type Status struct {
+	Enabled    bool          `json:"enabled"`
+	Message    string        `json:"message,omitempty"`
+	RetryAfter time.Duration `json:"-"`
+	Since      time.Time     `json:"since,omitempty"`
+	// Notified are the users told on their control topic, to be told
+	// again when maintenance ends.
+	Notified []bson.ObjectID `json:"-"`
+}
+
+func (s *State) Enable(message string, retryAfter time.Duration) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = Status{Enabled: true, Message: message, RetryAfter: retryAfter, Since: time.Now()}
+	return s.status
+}
+
+// Notify records users told about the current maintenance.
+func (s *State) Notify(users []bson.ObjectID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Notified = append(s.status.Notified, users...)
+}
+
+func (s *State) Disable() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = Status{}
+}
+
+func (s *State) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.status
+}
+
+func (s *State) Enabled() bool {
+	return s.Status().Enabled
+}
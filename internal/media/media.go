@@ -0,0 +1,94 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+)
+
+const (
+	ThumbnailSide = 320
+	// MaxPixels bounds the decoded size so a tiny compressed file cannot
+	// expand into gigabytes of memory.
+	MaxPixels = 40_000_000
+)
+
+var ErrTooLarge = errors.New("image dimensions too large")
+
+type Preview struct {
+	Width     int
+	Height    int
+	Thumbnail []byte
+	Blurhash  string
+}
+
+// IsImage reports whether previews can be generated for a content type.
+func IsImage(contentType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// NewPreview decodes an image and renders a JPEG thumbnail that fits into
+// ThumbnailSide pixels together with the blurhash of that thumbnail.
+func NewPreview(r io.ReadSeeker) (Preview, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return Preview{}, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return Preview{}, ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return Preview{}, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	width, height := fit(config.Width, config.Height, ThumbnailSide)
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		return Preview{}, err
+	}
+	hash, err := blurhash.Encode(4, 3, thumb)
+	if err != nil {
+		return Preview{}, err
+	}
+	return Preview{Width: config.Width, Height: config.Height, Thumbnail: buf.Bytes(), Blurhash: hash}, nil
+}
+
+func fit(width, height, side int) (int, int) {
+	if width <= side && height <= side {
+		return max(width, 1), max(height, 1)
+	}
+	if width >= height {
+		return side, max(height*side/width, 1)
+	}
+	return max(width*side/height, 1), side
+}
+
+// ValidBlurhash checks a client supplied blurhash. Hashes from end-to-end
+// encrypted uploads cannot be verified against the image, only their shape.
+func ValidBlurhash(hash string) bool {
+	if len(hash) > 128 {
+		return false
+	}
+	_, _, err := blurhash.Components(hash)
+	return err == nil
+}
@@ -0,0 +1,58 @@
+package media
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseURL(t *testing.T, raw string) (string, url.Values) {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(u.Path, "/media/"), u.Query()
+}
+
+func TestSignedURLRoundTrip(t *testing.T) {
+	signer := &URLSigner{Key: []byte("secret"), TTL: time.Minute}
+	grant, err := signer.Issue("abc", "thumbnail", "user", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, query := parseURL(t, signer.URL(grant))
+	verified, err := signer.Verify(id, query)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if verified.Variant != "thumbnail" || verified.UserId != "user" || verified.Nonce != grant.Nonce {
+		t.Fatalf("unexpected grant %+v", verified)
+	}
+}
+
+func TestSignedURLTampered(t *testing.T) {
+	signer := &URLSigner{Key: []byte("secret"), TTL: time.Minute}
+	grant, _ := signer.Issue("abc", "", "user", false)
+	id, query := parseURL(t, signer.URL(grant))
+
+	if _, err := signer.Verify("other", query); err != ErrBadSignature {
+		t.Fatalf("other attachment: got %v", err)
+	}
+	query.Set("u", "mallory")
+	if _, err := signer.Verify(id, query); err != ErrBadSignature {
+		t.Fatalf("other user: got %v", err)
+	}
+}
+
+func TestSignedURLExpired(t *testing.T) {
+	signer := &URLSigner{Key: []byte("secret"), TTL: -time.Minute}
+	grant, _ := signer.Issue("abc", "", "user", false)
+	id, query := parseURL(t, signer.URL(grant))
+
+	if _, err := signer.Verify(id, query); err != ErrURLExpired {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+var (
+	RetentionPurged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "retention_purged_messages_total",
+		Help:      "Messages removed by the retention janitor, by rule.",
+	}, []string{"rule"})
+	RetentionCandidates = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "filagram",
+		Name:      "retention_candidate_messages",
+		Help:      "Messages matched by a retention rule in the last run, purged or not.",
+	}, []string{"rule"})
+	ReaperPurged = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "trash_purged_total",
+		Help:      "Soft-deleted documents removed after the grace window, by kind.",
+	}, []string{"kind"})
+	SpamActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "spam_actions_total",
+		Help:      "Messages throttled or shadow limited by the spam heuristics, by action.",
+	}, []string{"action"})
+	MQTTDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "mqtt_dropped_total",
+		Help:      "Publishes dropped because the outbound queue of a client was full, by kind.",
+	}, []string{"kind"})
+	MQTTShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "mqtt_shed_total",
+		Help:      "Low priority publishes skipped for users whose queue overflowed recently, by kind.",
+	}, []string{"kind"})
+	MQTTRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "mqtt_rejected_total",
+		Help:      "Client publishes refused before fan-out, by reason.",
+	}, []string{"reason"})
+	MQTTEvicted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "mqtt_evicted_total",
+		Help:      "Clients disconnected by the broker, by reason: over the per-user limit or too slow.",
+	}, []string{"reason"})
+	MQTTTranscodeFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "mqtt_transcode_failed_total",
+		Help:      "Payloads sent as JSON to clients that asked for CBOR because they did not transcode.",
+	})
+	DeliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "filagram",
+		Name:      "message_delivery_seconds",
+		Help:      "Time from accepting a message to publishing it to the recipient, by path: stored first, or local and stored afterwards.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1},
+	}, []string{"path"})
+	MessageLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "filagram",
+		Name:      "message_latency_seconds",
+		Help:      "Time from the server receiving a message to a stage of its delivery: persisted, published, or delivered as reported by a recipient's receipt; by path: rest or mqtt.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 3600},
+	}, []string{"stage", "path"})
+	SpamScore = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "filagram",
+		Name:      "spam_sender_score",
+		Help:      "Spam score of senders at the time they sent a message.",
+		Buckets:   []float64{0.25, 0.5, 0.75, 1, 1.5, 2, 3, 5},
+	})
+	ActiveUsersToday = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "filagram",
+		Name:      "active_users_today",
+		Help:      "Distinct users who sent a message since midnight UTC.",
+	})
+	MessagesToday = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "filagram",
+		Name:      "messages_today",
+		Help:      "Messages sent since midnight UTC.",
+	})
+	PeakConnectionsToday = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "filagram",
+		Name:      "mqtt_peak_connections_today",
+		Help:      "Most concurrent broker connections sampled since midnight UTC.",
+	})
+	MongoConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "filagram",
+		Name:      "mongo_pool_connections",
+		Help:      "Connections in the MongoDB pool, open in total and in use by a query.",
+	}, []string{"state"})
+	MongoCheckoutWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "filagram",
+		Name:      "mongo_pool_checkout_seconds",
+		Help:      "Time a query waited for a connection from the MongoDB pool.",
+		Buckets:   []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+	MongoCheckoutFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "mongo_pool_checkout_failed_total",
+		Help:      "Queries that got no connection from the MongoDB pool, by reason such as timeout or poolClosed.",
+	}, []string{"reason"})
+	FederationDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "federation_deliveries_total",
+		Help:      "Attempts to deliver messages to other servers, by outcome: delivered, retried or dropped.",
+	}, []string{"outcome"})
+	PanicsRecovered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "filagram",
+		Name:      "panics_recovered_total",
+		Help:      "Panics recovered in workers, broker hooks and request handlers, by where they happened.",
+	}, []string{"where"})
+)
+
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
+
+// Requests counts requests and server errors into RecentRequests and
+// RecentErrors.
+func Requests() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				var httpErr *echo.HTTPError
+				if errors.As(err, &httpErr) {
+					status = httpErr.Code
+				}
+			}
+			RecentRequests.Add()
+			if status >= http.StatusInternalServerError {
+				RecentErrors.Add()
+			}
+			return err
+		}
+	}
+}
+
+// ObserveMessageStage records how long after it was received a message
+// reached stage. Messages the server made itself have no receive time and
+// are skipped.
+func ObserveMessageStage(stage, path string, received time.Time) {
+	if received.IsZero() || path == "" {
+		return
+	}
+	MessageLatency.WithLabelValues(stage, path).Observe(time.Since(received).Seconds())
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// StartedAt is when the process started, for the uptime on the admin
+// overview.
+var StartedAt = time.Now()
+
+// The admin overview shows what happened in the last few minutes, which
+// Prometheus counters alone cannot tell without a Prometheus server.
+const recentMinutes = 5
+
+// Recent counts events over the last few minutes in one minute buckets.
+type Recent struct {
+	mu      sync.Mutex
+	counts  [recentMinutes]int64
+	minutes [recentMinutes]int64
+}
+
+func (r *Recent) Add() {
+	r.addAt(time.Now())
+}
+
+func (r *Recent) addAt(now time.Time) {
+	minute := now.Unix() / 60
+	slot := minute % recentMinutes
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.minutes[slot] != minute {
+		r.minutes[slot], r.counts[slot] = minute, 0
+	}
+	r.counts[slot]++
+}
+
+// Total is the number of events in the last recentMinutes minutes, the
+// current one included.
+func (r *Recent) Total() int64 {
+	return r.totalAt(time.Now())
+}
+
+func (r *Recent) totalAt(now time.Time) int64 {
+	minute := now.Unix() / 60
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total int64
+	for slot := range r.counts {
+		if minute-r.minutes[slot] < recentMinutes {
+			total += r.counts[slot]
+		}
+	}
+	return total
+}
+
+// maxTrackedTopics bounds the memory of TopicCounter, topics first seen
+// after that within a minute are not counted.
+const maxTrackedTopics = 10000
+
+type TopicCount struct {
+	Topic    string `json:"topic"`
+	Messages int64  `json:"messages"`
+}
+
+// TopicCounter counts publishes per topic over the current and the
+// previous minute.
+type TopicCounter struct {
+	mu       sync.Mutex
+	minute   int64
+	current  map[string]int64
+	previous map[string]int64
+}
+
+func (t *TopicCounter) Add(topic string) {
+	t.addAt(topic, time.Now())
+}
+
+func (t *TopicCounter) addAt(topic string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rotate(now)
+	if _, ok := t.current[topic]; ok || len(t.current) < maxTrackedTopics {
+		t.current[topic]++
+	}
+}
+
+func (t *TopicCounter) rotate(now time.Time) {
+	minute := now.Unix() / 60
+	if minute == t.minute && t.current != nil {
+		return
+	}
+	if minute == t.minute+1 {
+		t.previous = t.current
+	} else {
+		t.previous = nil
+	}
+	t.minute, t.current = minute, make(map[string]int64)
+}
+
+// Top returns the n busiest topics of the last one to two minutes.
+func (t *TopicCounter) Top(n int) []TopicCount {
+	return t.topAt(n, time.Now())
+}
+
+func (t *TopicCounter) topAt(n int, now time.Time) []TopicCount {
+	t.mu.Lock()
+	t.rotate(now)
+	merged := make(map[string]int64, len(t.current)+len(t.previous))
+	for topic, count := range t.previous {
+		merged[topic] += count
+	}
+	for topic, count := range t.current {
+		merged[topic] += count
+	}
+	t.mu.Unlock()
+
+	top := make([]TopicCount, 0, len(merged))
+	for topic, count := range merged {
+		top = append(top, TopicCount{Topic: topic, Messages: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Messages != top[j].Messages {
+			return top[i].Messages > top[j].Messages
+		}
+		return top[i].Topic < top[j].Topic
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+var (
+	// RecentRequests and RecentErrors count HTTP requests and those
+	// answered with a 5xx status, see Requests.
+	RecentRequests Recent
+	RecentErrors   Recent
+	// Topics counts publishes through the broker.
+	Topics TopicCounter
+)
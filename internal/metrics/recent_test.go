@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentForgetsOldMinutes(t *testing.T) {
+	var recent Recent
+	start := time.Unix(1_700_000_000, 0)
+
+	recent.addAt(start)
+	recent.addAt(start.Add(time.Minute))
+	recent.addAt(start.Add(2 * time.Minute))
+	if total := recent.totalAt(start.Add(2 * time.Minute)); total != 3 {
+		t.Errorf("Expected 3 events, got %d", total)
+	}
+	if total := recent.totalAt(start.Add((recentMinutes + 1) * time.Minute)); total != 1 {
+		t.Errorf("Expected only the last event left, got %d", total)
+	}
+	// a slot reused after a full turn starts from zero
+	recent.addAt(start.Add(recentMinutes * time.Minute))
+	if total := recent.totalAt(start.Add(recentMinutes * time.Minute)); total != 3 {
+		t.Errorf("Expected 3 events, got %d", total)
+	}
+}
+
+func TestTopicCounterTop(t *testing.T) {
+	var topics TopicCounter
+	start := time.Unix(1_700_000_000, 0).Truncate(time.Minute)
+
+	for range 3 {
+		topics.addAt("chat/a/messages", start)
+	}
+	topics.addAt("chat/b/messages", start)
+	topics.addAt("chat/b/messages", start.Add(time.Minute))
+
+	top := topics.topAt(1, start.Add(time.Minute))
+	if len(top) != 1 || top[0].Topic != "chat/a/messages" || top[0].Messages != 3 {
+		t.Errorf("Expected chat/a/messages with 3 publishes, got %+v", top)
+	}
+	if top := topics.topAt(5, start.Add(3*time.Minute)); len(top) != 0 {
+		t.Errorf("Expected old minutes to be forgotten, got %+v", top)
+	}
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// AccountState is what an account may do. Only active accounts sign in,
+// use their tokens or connect to the broker.
+type AccountState string
+
+const (
+	AccountActive AccountState = "active"
+	// AccountSuspended is temporary, until AccountStatus.Until if set.
+	AccountSuspended   AccountState = "suspended"
+	AccountBanned      AccountState = "banned"
+	AccountDeactivated AccountState = "deactivated"
+	// AccountLocked is not set by admins, it is the state of an account
+	// locked after its owner reported a login, see User.Locked.
+	AccountLocked AccountState = "locked"
+)
+
+// accountTransitions lists the states each state may move to. A banned or
+// deactivated account has to be made active again before anything else.
+var accountTransitions = map[AccountState][]AccountState{
+	AccountActive:      {AccountSuspended, AccountBanned, AccountDeactivated},
+	AccountSuspended:   {AccountActive, AccountSuspended, AccountBanned, AccountDeactivated},
+	AccountBanned:      {AccountActive},
+	AccountDeactivated: {AccountActive},
+}
+
+func (s AccountState) Valid() bool {
+	_, ok := accountTransitions[s]
+	return ok
+}
+
+// CanBecome tells whether an admin may move an account from s to next.
+// Suspending a suspended account again changes its reason or expiry.
+func (s AccountState) CanBecome(next AccountState) bool {
+	for _, state := range accountTransitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountStatus is the state an admin last put an account in.
+type AccountStatus struct {
+	State     AccountState  `json:"state" bson:"state"`
+	Reason    string        `json:"reason,omitempty" bson:"reason,omitempty"`
+	Until     time.Time     `json:"until,omitempty" bson:"until,omitempty"`
+	ChangedBy bson.ObjectID `json:"changed_by,omitempty" bson:"changed_by,omitempty"`
+	ChangedAt time.Time     `json:"changed_at" bson:"changed_at"`
+}
+
+// StateAt is the state in effect at now: a suspension past its Until is
+// over without anyone lifting it.
+func (s AccountStatus) StateAt(now time.Time) AccountState {
+	if s.State == "" {
+		return AccountActive
+	}
+	if s.State == AccountSuspended && !s.Until.IsZero() && !now.Before(s.Until) {
+		return AccountActive
+	}
+	return s.State
+}
+
+// State is the state of the account at now. Deactivated, which
+// provisioning sets, counts as the deactivated state.
+func (u User) State(now time.Time) AccountState {
+	if u.Deactivated {
+		return AccountDeactivated
+	}
+	if u.Account == nil {
+		return AccountActive
+	}
+	return u.Account.StateAt(now)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountTransitions(t *testing.T) {
+	for _, tc := range []struct {
+		from, to AccountState
+		allowed  bool
+	}{
+		{AccountActive, AccountSuspended, true},
+		{AccountSuspended, AccountSuspended, true},
+		{AccountSuspended, AccountBanned, true},
+		{AccountBanned, AccountSuspended, false},
+		{AccountBanned, AccountActive, true},
+		{AccountDeactivated, AccountBanned, false},
+		{AccountActive, AccountActive, false},
+	} {
+		if got := tc.from.CanBecome(tc.to); got != tc.allowed {
+			t.Errorf("%s -> %s allowed = %v, want %v", tc.from, tc.to, got, tc.allowed)
+		}
+	}
+}
+
+func TestSuspensionEnds(t *testing.T) {
+	now := time.Now()
+	user := User{Account: &AccountStatus{State: AccountSuspended, Until: now.Add(time.Hour)}}
+
+	if state := user.State(now); state != AccountSuspended {
+		t.Errorf("state = %s, want suspended", state)
+	}
+	if state := user.State(now.Add(time.Hour)); state != AccountActive {
+		t.Errorf("state = %s after the suspension, want active", state)
+	}
+	user.Deactivated = true
+	if state := user.State(now); state != AccountDeactivated {
+		t.Errorf("state = %s, want deactivated", state)
+	}
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// ControlType is the kind of a ControlEvent.
+type ControlType string
+
+const (
+	// ControlMaintenance: the server goes into maintenance, Message and
+	// RetryAfter as on MaintenanceTopic. Connections are dropped shortly.
+	ControlMaintenance ControlType = "maintenance"
+	// ControlMaintenanceEnd: maintenance is over, clients may reconnect.
+	ControlMaintenanceEnd ControlType = "maintenance_end"
+	// ControlLogout: SessionId ended, or every session of the user when it
+	// is absent. Clients of an ended session drop their tokens.
+	ControlLogout ControlType = "logout"
+	// ControlWipe: as ControlLogout, and the client deletes what it keeps
+	// of messages and attachments too.
+	ControlWipe ControlType = "wipe"
+	// ControlPolicy: a policy clients enforce changed, Details holds the
+	// new values, e.g. minimum and recommended client versions.
+	ControlPolicy ControlType = "policy"
+	// ControlReconnect: the instance is shutting down, clients reconnect to
+	// Details["server"], or the address they know when it is absent. Sent
+	// without retaining, only to clients connected at the time.
+	ControlReconnect ControlType = "reconnect"
+)
+
+// ControlEvent is a server lifecycle event on the control topic of a user,
+// system/{userId}/control, which every client must subscribe to. The topic
+// retains the latest event so clients that were offline replay it on
+// subscribe; clients remember the Id of the last event they acted on to
+// skip the replay of one they already handled. As JSON:
+//
+//	{
+//	  "id":          "<hex>",
+//	  "type":        "maintenance" | "maintenance_end" | "logout" | "wipe" | "policy" | "reconnect",
+//	  "timestamp":   "<RFC 3339>",
+//	  "reason":      "<machine-readable cause>",           optional
+//	  "message":     "<text for the user>",                optional
+//	  "retry_after": <seconds>,                            maintenance only
+//	  "session_id":  "<hex>",                              logout and wipe only
+//	  "command":     "<JWT>",                              logout and wipe only
+//	  "details":     {"<key>": "<value>"}                  optional
+//	}
+//
+// Clients ignore types they do not know. A logout or wipe sent to a single
+// session comes on its own topic, see SessionControlTopic, with a command
+// signed by the server, see core.SignCommand; clients carry it out only if
+// the signature holds and the command names their session.
+type ControlEvent struct {
+	Id         bson.ObjectID     `json:"id"`
+	Type       ControlType       `json:"type"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Reason     string            `json:"reason,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	RetryAfter int               `json:"retry_after,omitempty"`
+	SessionId  bson.ObjectID     `json:"session_id,omitempty"`
+	Command    string            `json:"command,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+func ControlTopic(user bson.ObjectID) string {
+	return SystemTopic(user, "control")
+}
+
+// SessionControlTopic carries the control events for one session of a
+// user, which clients learn the id of from sign-in.
+func SessionControlTopic(user bson.ObjectID, session bson.ObjectID) string {
+	return SystemTopic(user, "sessions/"+session.Hex()+"/control")
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// DebugEvent tells a client in debug mode what became of its requests, on
+// chat/{userId}/events. It carries ids, counts and reasons only, never
+// content or peers, so it is safe to paste into a bug report.
+type DebugEvent struct {
+	Type      DebugEventType `json:"type"`
+	MessageId bson.ObjectID  `json:"message_id,omitempty"`
+	Status    StatusType     `json:"status,omitempty"`
+	Count     int            `json:"count,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Trace     string         `json:"trace,omitempty"`
+	At        time.Time      `json:"at"`
+}
+
+type DebugEventType string
+
+const (
+	DebugMessageAccepted DebugEventType = "message_accepted"
+	DebugMessageRejected DebugEventType = "message_rejected"
+	DebugReceiptRecorded DebugEventType = "receipt_recorded"
+	DebugRateLimited     DebugEventType = "rate_limited"
+)
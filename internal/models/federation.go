@@ -0,0 +1,37 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// FederatedMessage is a direct message as servers exchange it. Users are
+// addressed as username@host, the content stays encrypted end to end.
+type FederatedMessage struct {
+	Id               bson.ObjectID `json:"id" bson:"id"`
+	From             string        `json:"from" bson:"from"`
+	To               string        `json:"to" bson:"to"`
+	Content          string        `json:"content" bson:"content"`
+	AesSecret        string        `json:"aes_secret,omitempty" bson:"aes_secret,omitempty"`
+	SharedSecretSalt []byte        `json:"shared_secret_salt,omitempty" bson:"shared_secret_salt,omitempty"`
+	Timestamp        time.Time     `json:"timestamp" bson:"timestamp"`
+}
+
+// OutboxEntry is a message waiting for delivery to another server.
+type OutboxEntry struct {
+	Id            bson.ObjectID    `bson:"_id"`
+	Host          string           `bson:"host"`
+	Message       FederatedMessage `bson:"message"`
+	Attempts      int              `bson:"attempts"`
+	NextAttemptAt time.Time        `bson:"next_attempt_at"`
+	LastError     string           `bson:"last_error,omitempty"`
+	CreatedAt     time.Time        `bson:"created_at"`
+}
+
+// RemoteKeys is what a server tells another about one of its users for
+// the sender to encrypt to them.
+type RemoteKeys struct {
+	Address   string   `json:"address"`
+	PublicKey []byte   `json:"public_key,omitempty"`
+	Devices   []Device `json:"devices,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "go.mongodb.org/mongo-driver/v2/bson"
+
+// ImportInfo tells where an imported message came from. Both sides of an
+// imported conversation appear as senders, ImportedBy is who uploaded it.
+type ImportInfo struct {
+	Source     string         `json:"source" bson:"source"`
+	ExternalId string         `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	Author     string         `json:"author,omitempty" bson:"author,omitempty"`
+	Media      *ImportedMedia `json:"media,omitempty" bson:"media,omitempty"`
+	ImportedBy bson.ObjectID  `json:"imported_by" bson:"imported_by"`
+	// Key makes importing the same export again a no-op.
+	Key string `json:"-" bson:"key"`
+}
+
+// ImportedMedia names a file the export referred to. Exports do not carry
+// the files themselves, only the kind and name are kept.
+type ImportedMedia struct {
+	Kind     string `json:"kind" bson:"kind"`
+	FileName string `json:"file_name,omitempty" bson:"file_name,omitempty"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// Device is one client install of a user with the public half of its end
+// to end encryption keys. Private keys never leave the device.
+type Device struct {
+	Id           bson.ObjectID `json:"id" bson:"_id"`
+	UserId       bson.ObjectID `json:"user_id" bson:"user_id"`
+	Name         string        `json:"name,omitempty" bson:"name,omitempty"`
+	IdentityKey  []byte        `json:"identity_key" bson:"identity_key"`
+	SignedPreKey SignedPreKey  `json:"signed_prekey" bson:"signed_prekey"`
+	CreatedAt    time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// SignedPreKey is the medium-term key of a device, signed with its
+// identity key and rotated by the client.
+type SignedPreKey struct {
+	KeyId     uint32 `json:"key_id" bson:"key_id"`
+	PublicKey []byte `json:"public_key" bson:"public_key"`
+	Signature []byte `json:"signature" bson:"signature"`
+}
+
+// PreKey is a one-time key a device uploads in batches. Each is handed out
+// to a single peer starting a session and deleted.
+type PreKey struct {
+	Id        bson.ObjectID `json:"-" bson:"_id"`
+	UserId    bson.ObjectID `json:"-" bson:"user_id"`
+	DeviceId  bson.ObjectID `json:"-" bson:"device_id"`
+	KeyId     uint32        `json:"key_id" bson:"key_id"`
+	PublicKey []byte        `json:"public_key" bson:"public_key"`
+	CreatedAt time.Time     `json:"-" bson:"created_at"`
+}
+
+// Webhook is an endpoint a user or organization registered to be told
+// about events, e.g. new messages to a bot account. Deliveries are signed
+// with Secret.
+type Webhook struct {
+	Id        bson.ObjectID `json:"id" bson:"_id"`
+	OwnerId   bson.ObjectID `json:"owner_id" bson:"owner_id"`
+	OrgId     bson.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+	URL       string        `json:"url" bson:"url"`
+	Events    []string      `json:"events" bson:"events"`
+	Secret    string        `json:"-" bson:"secret"`
+	Disabled  bool          `json:"disabled,omitempty" bson:"disabled,omitempty"`
+	Failures  int           `json:"failures,omitempty" bson:"failures,omitempty"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// KeyBundle is what a peer needs to start sessions with a user: their
+// public key and, per device, the identity key, signed prekey and one
+// one-time prekey while the device has any left.
+type KeyBundle struct {
+	UserId    bson.ObjectID  `json:"user_id"`
+	PublicKey []byte         `json:"public_key,omitempty"`
+	Devices   []DeviceBundle `json:"devices"`
+}
+
+type DeviceBundle struct {
+	DeviceId     bson.ObjectID `json:"device_id"`
+	IdentityKey  []byte        `json:"identity_key"`
+	SignedPreKey SignedPreKey  `json:"signed_prekey"`
+	PreKey       *PreKey       `json:"prekey,omitempty"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ConversationSettings are what a user keeps for themselves about a
+// conversation and its peer: labels such as "work" or "family" and a note.
+// Peers never see them.
+type ConversationSettings struct {
+	Id        bson.ObjectID `json:"-" bson:"_id"`
+	UserId    bson.ObjectID `json:"-" bson:"user_id"`
+	PeerId    bson.ObjectID `json:"peer_id" bson:"peer_id"`
+	Labels    []string      `json:"labels" bson:"labels"`
+	Note      string        `json:"note,omitempty" bson:"note,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+const (
+	maxLabels      = 20
+	maxLabelLength = 32
+	maxNoteLength  = 1000
+)
+
+// NormalizeLabel lowercases and trims a label so "Work " and "work" are
+// the same one.
+func NormalizeLabel(label string) string {
+	return strings.ToLower(strings.TrimSpace(label))
+}
+
+// Normalize cleans up the labels, dropping duplicates, and checks the
+// settings are within limits.
+func (s *ConversationSettings) Normalize() error {
+	labels := make([]string, 0, len(s.Labels))
+	for _, label := range s.Labels {
+		label = NormalizeLabel(label)
+		if label == "" || utf8.RuneCountInString(label) > maxLabelLength {
+			return errors.New("invalid label")
+		}
+		if !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) > maxLabels {
+		return errors.New("too many labels")
+	}
+	slices.Sort(labels)
+	s.Labels = labels
+
+	s.Note = strings.TrimSpace(s.Note)
+	if utf8.RuneCountInString(s.Note) > maxNoteLength {
+		return errors.New("note too long")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConversationSettingsNormalize(t *testing.T) {
+	settings := ConversationSettings{Labels: []string{" Work", "family", "work "}, Note: "  met at the conference  "}
+	if err := settings.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(settings.Labels, []string{"family", "work"}) {
+		t.Errorf("Expected deduplicated, sorted labels, got %q", settings.Labels)
+	}
+	if settings.Note != "met at the conference" {
+		t.Errorf("Expected a trimmed note, got %q", settings.Note)
+	}
+}
+
+func TestConversationSettingsLimits(t *testing.T) {
+	for _, settings := range []ConversationSettings{
+		{Labels: []string{"  "}},
+		{Labels: []string{strings.Repeat("a", maxLabelLength+1)}},
+		{Note: strings.Repeat("a", maxNoteLength+1)},
+	} {
+		if err := settings.Normalize(); err == nil {
+			t.Errorf("Expected %+v to be refused", settings)
+		}
+	}
+
+	var labels []string
+	for i := range maxLabels + 1 {
+		labels = append(labels, strings.Repeat("a", i+1))
+	}
+	settings := ConversationSettings{Labels: labels}
+	if err := settings.Normalize(); err == nil {
+		t.Error("Expected too many labels to be refused")
+	}
+}
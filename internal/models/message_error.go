@@ -0,0 +1,45 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// MessageErrorReason says why a message did not reach its recipient.
+type MessageErrorReason string
+
+const (
+	// ErrorInvalid: the message was malformed, e.g. without content.
+	ErrorInvalid MessageErrorReason = "invalid"
+	// ErrorRecipientNotFound: the recipient does not exist or deleted
+	// their account, or the group does not or the sender left it.
+	ErrorRecipientNotFound MessageErrorReason = "recipient_not_found"
+	// ErrorRecipientUnavailable: the account of the recipient is banned or
+	// deactivated.
+	ErrorRecipientUnavailable MessageErrorReason = "recipient_unavailable"
+	// ErrorRateLimited: the sender sent more messages than allowed.
+	ErrorRateLimited MessageErrorReason = "rate_limited"
+	// ErrorNotStored: the server failed to store the message, sending it
+	// again may work.
+	ErrorNotStored MessageErrorReason = "not_stored"
+	// ErrorRemoteUndeliverable: the server of the recipient refused the
+	// message or stayed unreachable.
+	ErrorRemoteUndeliverable MessageErrorReason = "remote_undeliverable"
+)
+
+// MessageErrorType is the type of a MessageError event.
+const MessageErrorType = "message_error"
+
+// MessageError tells a sender on system/{userId}/delivery that a message
+// was refused or could not be delivered. A message refused before it was
+// stored has no id, clients match the event to it by recipient and order;
+// a stored message that could not be delivered carries the error too,
+// so its history shows it as not delivered.
+type MessageError struct {
+	Type        string             `json:"type" bson:"-"`
+	MessageId   bson.ObjectID      `json:"message_id,omitempty" bson:"-"`
+	RecipientId bson.ObjectID      `json:"recipient_id,omitempty" bson:"-"`
+	GroupId     bson.ObjectID      `json:"group_id,omitempty" bson:"-"`
+	Reason      MessageErrorReason `json:"reason" bson:"reason"`
+	Timestamp   time.Time          `json:"timestamp" bson:"timestamp"`
+}
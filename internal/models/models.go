@@ -1,7 +1,10 @@
 package models
 
 import (
+	"github.com/go-webauthn/webauthn/webauthn"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -9,28 +12,175 @@ const (
 	TypeMessage MessageType = "message"
 	TypeTyping  MessageType = "typing"
 	TypeStatus  MessageType = "status"
+	TypeSystem  MessageType = "system"
+	TypeSealed  MessageType = "sealed"
+	SystemUserJoined  SystemEventKind = "user_joined"
+	SystemNameChanged SystemEventKind = "name_changed"
+	SystemMissedCall  SystemEventKind = "missed_call"
+	SystemEncryptionChanged SystemEventKind = "encryption_changed"
+	SystemEmail SystemEventKind = "email"
 	StatusRead StatusType = "read"
 	StatusDelivered StatusType = "delivered"
+	ViaREST = "rest"
+	ViaMQTT = "mqtt"
+	PasskeyRegistration PasskeySessionType = "registration"
+	PasskeyLogin        PasskeySessionType = "login"
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	IPRuleAllow IPRuleAction = "allow"
+	IPRuleDeny  IPRuleAction = "deny"
+	CallAudio CallMedia = "audio"
+	CallVideo CallMedia = "video"
+	CallRinging  CallStatus = "ringing"
+	CallAccepted CallStatus = "accepted"
+	CallDeclined CallStatus = "declined"
+	CallCanceled CallStatus = "canceled"
+	CallMissed   CallStatus = "missed"
+	CallEnded    CallStatus = "ended"
+	PlatformIOS     Platform = "ios"
+	PlatformAndroid Platform = "android"
+	PostPublished PostStatus = "published"
+	PostFlagged   PostStatus = "flagged"
+	PostHeld      PostStatus = "held"
+	PostRejected  PostStatus = "rejected"
+	PresenceOnline       PresenceState = "online"
+	PresenceAway         PresenceState = "away"
+	PresenceDoNotDisturb PresenceState = "dnd"
+	PresenceInvisible    PresenceState = "invisible"
+	PresenceOffline      PresenceState = "offline"
+	EncryptionE2E    EncryptionMode = "e2e"
+	EncryptionServer EncryptionMode = "server"
+	OrgRoleOwner  OrgRole = "owner"
+	OrgRoleAdmin  OrgRole = "admin"
+	OrgRoleMember OrgRole = "member"
 )
 
 type (
 	User struct {
 		Id           bson.ObjectID `json:"id" bson:"_id"`
 		Username     string        `json:"username,omitempty" bson:"username,omitempty"`
-		Email        string        `json:"email,omitempty" bson:"email,omitempty"`
-		Password     string        `json:"password,omitempty" bson:"password,omitempty"`
-		AccessToken  string        `json:"access_token,omitempty" bson:"-"`
-		RefreshToken string        `json:"refresh_token,omitempty" bson:"-"`
+		// Host is the server of a remote user, whose Username is their
+		// full address. Remote users cannot sign in here.
+		Host         string        `json:"host,omitempty" bson:"host,omitempty"`
+		Email        string        `json:"email,omitempty" bson:"email,omitempty" log:"redact"`
+		EmailHash    string        `json:"-" bson:"email_hash,omitempty"`
+		Password     string        `json:"password,omitempty" bson:"password,omitempty" log:"redact"`
+		ExternalId   string        `json:"-" bson:"external_id,omitempty"`
+		Deactivated  bool          `json:"deactivated,omitempty" bson:"deactivated,omitempty"`
+		Locked       bool          `json:"locked,omitempty" bson:"locked,omitempty"`
+		Account      *AccountStatus `json:"-" bson:"account,omitempty"`
+		Role         Role          `json:"role,omitempty" bson:"role,omitempty"`
+		DeletedAt    time.Time     `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+		StorageUsed  int64         `json:"-" bson:"storage_used,omitempty"`
+		SpamOverride string        `json:"-" bson:"spam_override,omitempty"`
+		Status       *StatusSetting `json:"-" bson:"status,omitempty"`
+		QuietHours   *QuietHours   `json:"-" bson:"quiet_hours,omitempty"`
+		LastSeen     time.Time     `json:"-" bson:"last_seen,omitempty"`
+		LastSeenSealed string      `json:"-" bson:"last_seen_sealed,omitempty"`
+		Locale       string        `json:"locale,omitempty" bson:"locale,omitempty"`
+		PublicKey    []byte        `json:"public_key,omitempty" bson:"public_key,omitempty"`
+		InvitedBy    bson.ObjectID `json:"-" bson:"invited_by,omitempty"`
+		InviteCode   string        `json:"invite_code,omitempty" bson:"-" log:"redact"`
+		TwoFactor    bool          `json:"two_factor,omitempty" bson:"two_factor,omitempty"`
+		TOTPSecret   string        `json:"-" bson:"totp_secret,omitempty"`
+		TwoFactorFailures    int64     `json:"-" bson:"two_factor_failures,omitempty"`
+		TwoFactorLockedUntil time.Time `json:"-" bson:"two_factor_locked_until,omitempty"`
+		TOTPCode     string        `json:"totp_code,omitempty" bson:"-" log:"redact"`
+		RecoveryCode string        `json:"recovery_code,omitempty" bson:"-" log:"redact"`
+		UsernameChangedAt time.Time `json:"-" bson:"username_changed_at,omitempty"`
+		AccessToken  string        `json:"access_token,omitempty" bson:"-" log:"redact"`
+		RefreshToken string        `json:"refresh_token,omitempty" bson:"-" log:"redact"`
+		// SessionId is handed out at sign-in, for the client to follow its
+		// session control topic.
+		SessionId    bson.ObjectID `json:"session_id,omitempty" bson:"-"`
 	}
 	Message struct {
 		Id          bson.ObjectID `json:"id" bson:"_id"`
 		SenderId    bson.ObjectID `json:"sender_id" bson:"sender_id"`
 		RecipientId bson.ObjectID `json:"recipient_id" bson:"recipient_id"`
+		GroupId     bson.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+		Type        MessageType   `json:"type,omitempty" bson:"type,omitempty"`
+		System      *SystemEvent  `json:"system,omitempty" bson:"system,omitempty"`
 		Content     string        `json:"content,omitempty" bson:"content,omitempty"`
+		// Padding fills the content up to one of the PADDING_BUCKETS sizes,
+		// clients ignore it.
+		Padding     string        `json:"padding,omitempty" bson:"padding,omitempty"`
 		AesSecret   string        `json:"aes_secret,omitempty" bson:"aes_secret,omitempty"`
+		// Trace is the W3C trace context of the request that sent it, for
+		// the broker only.
+		Trace       string        `json:"-" bson:"-"`
 		SharedSecretSalt []byte   `json:"shared_secret_salt,omitempty" bson:"shared_secret_salt,omitempty"`
 		Read        bool          `json:"read,omitempty" bson:"read,omitempty"`
+		Sealed      bool          `json:"-" bson:"sealed,omitempty"`
+		// Import marks a message brought in from another app, its content
+		// is the plain text of the export.
+		Import      *ImportInfo   `json:"import,omitempty" bson:"import,omitempty"`
 		Timestamp   time.Time     `json:"timestamp,omitempty" bson:"timestamp,omitempty"`
+		// ReceivedAt is when the server got the message from its sender
+		// and Via how, ViaREST or ViaMQTT; both only feed latency metrics.
+		ReceivedAt  time.Time     `json:"-" bson:"received_at,omitempty"`
+		Via         string        `json:"-" bson:"via,omitempty"`
+		// Error is why the message was not delivered, see MessageError.
+		Error       *MessageError `json:"error,omitempty" bson:"error,omitempty"`
+		DeletedAt   time.Time     `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	}
+	Conversation struct {
+		Id           string          `json:"id" bson:"_id"`
+		Participants []bson.ObjectID `json:"participants" bson:"participants"`
+		Encryption   EncryptionMode  `json:"encryption" bson:"encryption"`
+		DataKey      []byte          `json:"-" bson:"data_key,omitempty"`
+		UpdatedBy    bson.ObjectID   `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+		UpdatedAt    time.Time       `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	}
+	Group struct {
+		Id        bson.ObjectID   `json:"id" bson:"_id"`
+		Name      string          `json:"name" bson:"name"`
+		OwnerId   bson.ObjectID   `json:"owner_id" bson:"owner_id"`
+		OrgId     bson.ObjectID   `json:"org_id,omitempty" bson:"org_id,omitempty"`
+		Members   []bson.ObjectID `json:"members" bson:"members"`
+		CreatedAt time.Time       `json:"created_at" bson:"created_at"`
+	}
+	Organization struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		Name      string        `json:"name" bson:"name"`
+		OwnerId   bson.ObjectID `json:"owner_id" bson:"owner_id"`
+		Settings  OrgSettings   `json:"settings" bson:"settings"`
+		CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	}
+	OrgSettings struct {
+		RetentionDays  int      `json:"retention_days,omitempty" bson:"retention_days,omitempty"`
+		AllowedDomains []string `json:"allowed_domains,omitempty" bson:"allowed_domains,omitempty"`
+	}
+	OrgMember struct {
+		Id       bson.ObjectID `json:"-" bson:"_id"`
+		OrgId    bson.ObjectID `json:"org_id" bson:"org_id"`
+		UserId   bson.ObjectID `json:"user_id" bson:"user_id"`
+		Username string        `json:"username,omitempty" bson:"-"`
+		Role     OrgRole       `json:"role" bson:"role"`
+		JoinedAt time.Time     `json:"joined_at" bson:"joined_at"`
+	}
+	Receipt struct {
+		Id          bson.ObjectID `json:"-" bson:"_id"`
+		MessageId   bson.ObjectID `json:"-" bson:"message_id"`
+		UserId      bson.ObjectID `json:"user_id" bson:"user_id"`
+		DeliveredAt time.Time     `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+		ReadAt      time.Time     `json:"read_at,omitempty" bson:"read_at,omitempty"`
+	}
+	Badges struct {
+		Unread    int64     `json:"unread"`
+		Direct    int64     `json:"direct"`
+		Groups    int64     `json:"groups"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	ReceiptCounts struct {
+		Members   int   `json:"members"`
+		Delivered int64 `json:"delivered"`
+		Read      int64 `json:"read"`
+	}
+	SystemEvent struct {
+		Kind   SystemEventKind   `json:"kind" bson:"kind"`
+		Params map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+		Text   string            `json:"text,omitempty" bson:"text,omitempty"`
 	}
 	TypingNotification struct {
     	Sender    bson.ObjectID   `json:"sender"`
@@ -39,6 +189,7 @@ type (
     	Timestamp time.Time `json:"timestamp"`
     }
 	StatusUpdate struct {
+    	Type      MessageType      `json:"type"`
     	Sender    bson.ObjectID    `json:"sender"`
     	Receiver  bson.ObjectID     `json:"receiver"`
     	MessageID bson.ObjectID    `json:"message_id"`
@@ -50,11 +201,294 @@ type (
     	IsOnline  bool      `json:"is_online"`
     	LastSeen  time.Time `json:"last_seen"`
     	Timestamp time.Time `json:"timestamp"`
+		State     PresenceState `json:"state,omitempty"`
+		Text      string        `json:"text,omitempty"`
+		Emoji     string        `json:"emoji,omitempty"`
+		NotificationsPausedUntil time.Time `json:"notifications_paused_until,omitempty"`
     }
+	StatusSetting struct {
+		State     PresenceState `json:"state" bson:"state"`
+		Text      string        `json:"text,omitempty" bson:"text,omitempty"`
+		Emoji     string        `json:"emoji,omitempty" bson:"emoji,omitempty"`
+		UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+	}
+	Heartbeat struct {
+		Interval  int       `json:"interval"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	PasskeyCredential struct {
+		Id         bson.ObjectID       `json:"id" bson:"_id"`
+		UserId     bson.ObjectID       `json:"user_id" bson:"user_id"`
+		Credential webauthn.Credential `json:"-" bson:"credential"`
+		CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+	}
+	UsernameHistory struct {
+		Id         bson.ObjectID `json:"id" bson:"_id"`
+		UserId     bson.ObjectID `json:"user_id" bson:"user_id"`
+		Username   string        `json:"username" bson:"username"`
+		ChangedAt  time.Time     `json:"changed_at" bson:"changed_at"`
+		ReleasedAt time.Time     `json:"released_at" bson:"released_at"`
+	}
+	Invite struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		Code      string        `json:"code,omitempty" bson:"-"`
+		CodeHash  string        `json:"-" bson:"code_hash"`
+		CreatedBy bson.ObjectID `json:"created_by" bson:"created_by"`
+		Bulk      bool          `json:"bulk,omitempty" bson:"bulk,omitempty"`
+		UsedBy    bson.ObjectID `json:"used_by,omitempty" bson:"used_by,omitempty"`
+		UsedAt    time.Time     `json:"used_at,omitempty" bson:"used_at,omitempty"`
+		ExpiresAt time.Time     `json:"expires_at" bson:"expires_at"`
+		CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	}
+	EmailChange struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		UserId    bson.ObjectID `json:"user_id" bson:"user_id"`
+		NewEmail  string        `json:"new_email" bson:"new_email" log:"redact"`
+		TokenHash string        `json:"-" bson:"token_hash"`
+		ExpiresAt time.Time     `json:"expires_at" bson:"expires_at"`
+	}
+	MagicLink struct {
+		Id         bson.ObjectID `json:"id" bson:"_id"`
+		UserId     bson.ObjectID `json:"user_id" bson:"user_id"`
+		TokenHash  string        `json:"-" bson:"token_hash"`
+		DeviceHash string        `json:"-" bson:"device_hash"`
+		ExpiresAt  time.Time     `json:"expires_at" bson:"expires_at"`
+	}
+	Login struct {
+		Id         bson.ObjectID `json:"id" bson:"_id"`
+		UserId     bson.ObjectID `json:"user_id" bson:"user_id"`
+		IP         string        `json:"ip" bson:"ip"`
+		Network    string        `json:"-" bson:"network"`
+		UserAgent  string        `json:"user_agent" bson:"user_agent"`
+		ReportHash string        `json:"-" bson:"report_hash,omitempty"`
+		Timestamp  time.Time     `json:"timestamp" bson:"timestamp"`
+	}
+	LoginAlert struct {
+		Type      string    `json:"type"`
+		LoginId   string    `json:"login_id"`
+		IP        string    `json:"ip"`
+		UserAgent string    `json:"user_agent"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	// DeliveryFailure tells a sender on system/{userId}/delivery that a
+	// message was stored but could not be pushed to the recipient live.
+	// The recipient still gets it on their next sync.
+	DeliveryFailure struct {
+		MessageId   bson.ObjectID `json:"message_id"`
+		RecipientId bson.ObjectID `json:"recipient_id"`
+		Reason      string        `json:"reason"`
+		Timestamp   time.Time     `json:"timestamp"`
+	}
+	IPRule struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		Action    IPRuleAction  `json:"action" bson:"action"`
+		CIDR      string        `json:"cidr,omitempty" bson:"cidr,omitempty"`
+		Country   string        `json:"country,omitempty" bson:"country,omitempty"`
+		Note      string        `json:"note,omitempty" bson:"note,omitempty"`
+		CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	}
+	Call struct {
+		Id         bson.ObjectID `json:"id" bson:"_id"`
+		CallerId   bson.ObjectID `json:"caller_id" bson:"caller_id"`
+		CalleeId   bson.ObjectID `json:"callee_id" bson:"callee_id"`
+		Media      CallMedia     `json:"media" bson:"media"`
+		Status     CallStatus    `json:"status" bson:"status"`
+		Topic      string        `json:"topic" bson:"topic"`
+		CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+		AnsweredAt time.Time     `json:"answered_at,omitempty" bson:"answered_at,omitempty"`
+		EndedAt    time.Time     `json:"ended_at,omitempty" bson:"ended_at,omitempty"`
+		Duration   int64         `json:"duration,omitempty" bson:"duration,omitempty"`
+	}
+	CallEvent struct {
+		Type      string        `json:"type"`
+		Call      Call          `json:"call"`
+		Timestamp time.Time     `json:"timestamp"`
+	}
+	PushToken struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		UserId    bson.ObjectID `json:"user_id" bson:"user_id"`
+		Token     string        `json:"token" bson:"token" log:"redact"`
+		Platform  Platform      `json:"platform" bson:"platform"`
+		UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+	}
+	AuditEntry struct {
+		Id        bson.ObjectID     `json:"id" bson:"_id"`
+		ActorId   bson.ObjectID     `json:"actor_id" bson:"actor_id"`
+		Action    string            `json:"action" bson:"action"`
+		TargetId  bson.ObjectID     `json:"target_id,omitempty" bson:"target_id,omitempty"`
+		IP        string            `json:"ip,omitempty" bson:"ip,omitempty"`
+		Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+		Timestamp time.Time         `json:"timestamp" bson:"timestamp"`
+	}
+	Attachment struct {
+		Id          bson.ObjectID `json:"id" bson:"_id"`
+		OwnerId     bson.ObjectID `json:"owner_id" bson:"owner_id"`
+		RecipientId bson.ObjectID `json:"recipient_id,omitempty" bson:"recipient_id,omitempty"`
+		ContentType string        `json:"content_type" bson:"content_type"`
+		Size        int64         `json:"size" bson:"size"`
+		StorageKey  string        `json:"-" bson:"storage_key"`
+		Quarantined bool          `json:"quarantined,omitempty" bson:"quarantined,omitempty"`
+		Signature   string        `json:"signature,omitempty" bson:"signature,omitempty"`
+		Encrypted   bool          `json:"encrypted,omitempty" bson:"encrypted,omitempty"`
+		Width       int           `json:"width,omitempty" bson:"width,omitempty"`
+		Height      int           `json:"height,omitempty" bson:"height,omitempty"`
+		Blurhash    string        `json:"blurhash,omitempty" bson:"blurhash,omitempty"`
+		Thumbnail   *Thumbnail    `json:"thumbnail,omitempty" bson:"thumbnail,omitempty"`
+		CreatedAt   time.Time     `json:"created_at" bson:"created_at"`
+	}
+	Upload struct {
+		Id          bson.ObjectID `json:"id" bson:"_id"`
+		OwnerId     bson.ObjectID `json:"owner_id" bson:"owner_id"`
+		RecipientId bson.ObjectID `json:"recipient_id,omitempty" bson:"recipient_id,omitempty"`
+		ContentType string        `json:"content_type" bson:"content_type"`
+		Length      int64         `json:"length" bson:"length"`
+		Offset      int64         `json:"offset" bson:"offset"`
+		Encrypted   bool          `json:"encrypted,omitempty" bson:"encrypted,omitempty"`
+		Width       int           `json:"width,omitempty" bson:"width,omitempty"`
+		Height      int           `json:"height,omitempty" bson:"height,omitempty"`
+		Blurhash    string        `json:"blurhash,omitempty" bson:"blurhash,omitempty"`
+		BusyUntil   time.Time     `json:"-" bson:"busy_until,omitempty"`
+		CreatedAt   time.Time     `json:"created_at" bson:"created_at"`
+		UpdatedAt   time.Time     `json:"updated_at" bson:"updated_at"`
+	}
+	Channel struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		Name      string        `json:"name" bson:"name"`
+		OwnerId   bson.ObjectID `json:"owner_id" bson:"owner_id"`
+		OrgId     bson.ObjectID `json:"org_id,omitempty" bson:"org_id,omitempty"`
+		CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	}
+	ChannelPost struct {
+		Id         bson.ObjectID `json:"id" bson:"_id"`
+		ChannelId  bson.ObjectID `json:"channel_id" bson:"channel_id"`
+		AuthorId   bson.ObjectID `json:"author_id" bson:"author_id"`
+		Content    string        `json:"content" bson:"content"`
+		Status     PostStatus    `json:"status" bson:"status"`
+		Reasons    []string      `json:"reasons,omitempty" bson:"reasons,omitempty"`
+		ReviewedBy bson.ObjectID `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+		Timestamp  time.Time     `json:"timestamp" bson:"timestamp"`
+	}
+	Announcement struct {
+		Id        bson.ObjectID `json:"id" bson:"_id"`
+		AuthorId  bson.ObjectID `json:"-" bson:"author_id"`
+		Title     string        `json:"title" bson:"title"`
+		Body      string        `json:"body" bson:"body"`
+		PublishAt time.Time     `json:"publish_at" bson:"publish_at"`
+		ExpiresAt time.Time     `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+		CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	}
+	Thumbnail struct {
+		ContentType string `json:"content_type" bson:"content_type"`
+		Size        int64  `json:"size" bson:"size"`
+		StorageKey  string `json:"-" bson:"storage_key"`
+	}
+	StorageUsage struct {
+		Used  int64 `json:"used"`
+		Quota int64 `json:"quota"`
+	}
+	PasskeySession struct {
+		Id        bson.ObjectID        `json:"id" bson:"_id"`
+		UserId    bson.ObjectID        `json:"user_id" bson:"user_id"`
+		Type      PasskeySessionType   `json:"type" bson:"type"`
+		Data      webauthn.SessionData `json:"-" bson:"data"`
+		ExpiresAt time.Time            `json:"expires_at" bson:"expires_at"`
+	}
 	MessageType string
+	SystemEventKind string
 	StatusType string
+	PasskeySessionType string
+	Role string
+	IPRuleAction string
+	CallMedia string
+	CallStatus string
+	Platform string
+	PostStatus string
+	PresenceState string
+	EncryptionMode string
+	OrgRole string
 )
 
+// Valid reports whether t is one of the known message types.
+func (t MessageType) Valid() bool {
+	switch t {
+	case TypeMessage, TypeTyping, TypeStatus, TypeSystem, TypeSealed:
+		return true
+	}
+	return false
+}
+
+// Valid reports whether s is a state users may pick, offline only ever
+// follows from the connection.
+func (s PresenceState) Valid() bool {
+	switch s {
+	case PresenceOnline, PresenceAway, PresenceDoNotDisturb, PresenceInvisible:
+		return true
+	}
+	return false
+}
+
+func (t StatusType) Valid() bool {
+	return t == StatusRead || t == StatusDelivered
+}
+
+// IsMember reports whether user belongs to the group.
+func (g Group) IsMember(user bson.ObjectID) bool {
+	return slices.Contains(g.Members, user)
+}
+
+func (r OrgRole) Valid() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin || r == OrgRoleMember
+}
+
+// CanAdmin reports whether the role may manage the organization, its
+// members and what it owns.
+func (r OrgRole) CanAdmin() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin
+}
+
+// AllowsEmail reports whether an address may join under the allowed
+// domains, an empty list allows every address.
+func (s OrgSettings) AllowsEmail(email string) bool {
+	if len(s.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range s.AllowedDomains {
+		if domain == strings.ToLower(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvelopeVersions are the formats of end-to-end encrypted content clients
+// of this server agree on. The server relays the envelope as it is and only
+// advertises them, see GET /capabilities.
+var EnvelopeVersions = []int{1}
+
+func (m EncryptionMode) Valid() bool {
+	return m == EncryptionE2E || m == EncryptionServer
+}
+
+// ConversationId names the conversation between two users the same way
+// from either side.
+func ConversationId(a bson.ObjectID, b bson.ObjectID) string {
+	if a.Hex() > b.Hex() {
+		a, b = b, a
+	}
+	return a.Hex() + ":" + b.Hex()
+}
+
+// ToSelf reports whether a message is a note the sender wrote to
+// themselves, in their saved messages.
+func (m Message) ToSelf() bool {
+	return m.GroupId.IsZero() && m.SenderId == m.RecipientId
+}
+
 var (
 	NilUser     = User{}
 	NilMessage  = Message{}
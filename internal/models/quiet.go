@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// QuietHours are recurring ranges of local time in which a user gets no
+// push notifications. A range may cross midnight, it then belongs to the
+// day it starts on. Ranges without days apply every day.
+type QuietHours struct {
+	Enabled   bool         `json:"enabled" bson:"enabled"`
+	Timezone  string       `json:"timezone" bson:"timezone"`
+	Ranges    []QuietRange `json:"ranges" bson:"ranges"`
+	ShowPeers bool         `json:"show_peers" bson:"show_peers"`
+	UpdatedAt time.Time    `json:"updated_at" bson:"updated_at"`
+}
+
+type QuietRange struct {
+	Start string         `json:"start" bson:"start"`
+	End   string         `json:"end" bson:"end"`
+	Days  []time.Weekday `json:"days,omitempty" bson:"days,omitempty"`
+}
+
+const maxQuietRanges = 14
+
+func (q QuietHours) Validate() error {
+	if _, err := time.LoadLocation(q.Timezone); err != nil || q.Timezone == "" {
+		return errors.New("unknown timezone")
+	}
+	if len(q.Ranges) > maxQuietRanges {
+		return errors.New("too many ranges")
+	}
+	for _, r := range q.Ranges {
+		start, err := clock(r.Start)
+		if err != nil {
+			return err
+		}
+		end, err := clock(r.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return errors.New("empty range")
+		}
+		for _, day := range r.Days {
+			if day < time.Sunday || day > time.Saturday {
+				return errors.New("invalid day")
+			}
+		}
+	}
+	return nil
+}
+
+// Until reports whether now falls in one of the ranges and, if so, when
+// the quiet hours end.
+func (q QuietHours) Until(now time.Time) (time.Time, bool) {
+	if !q.Enabled {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+
+	var until time.Time
+	for _, r := range q.Ranges {
+		start, err := clock(r.Start)
+		if err != nil {
+			continue
+		}
+		end, err := clock(r.End)
+		if err != nil {
+			continue
+		}
+		// a range that started yesterday may still be running
+		for _, offset := range []int{0, -1} {
+			day := now.AddDate(0, 0, offset)
+			if len(r.Days) > 0 && !slices.Contains(r.Days, day.Weekday()) {
+				continue
+			}
+			from := time.Date(day.Year(), day.Month(), day.Day(), 0, start, 0, 0, loc)
+			to := time.Date(day.Year(), day.Month(), day.Day(), 0, end, 0, 0, loc)
+			if end <= start {
+				to = to.AddDate(0, 0, 1)
+			}
+			if !now.Before(from) && now.Before(to) && to.After(until) {
+				until = to
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// clock parses a "15:04" time of day into minutes after midnight.
+func clock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New("invalid time " + value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursAcrossMidnight(t *testing.T) {
+	warsaw, _ := time.LoadLocation("Europe/Warsaw")
+	quiet := QuietHours{
+		Enabled:  true,
+		Timezone: "Europe/Warsaw",
+		Ranges:   []QuietRange{{Start: "22:00", End: "07:00", Days: []time.Weekday{time.Friday}}},
+	}
+	if err := quiet.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	// Saturday 03:00 still belongs to the range starting on Friday
+	until, ok := quiet.Until(time.Date(2026, 10, 17, 3, 0, 0, 0, warsaw))
+	if !ok || !until.Equal(time.Date(2026, 10, 17, 7, 0, 0, 0, warsaw)) {
+		t.Errorf("Expected quiet until 07:00, got %v %v", until, ok)
+	}
+	if _, ok := quiet.Until(time.Date(2026, 10, 17, 23, 0, 0, 0, warsaw)); ok {
+		t.Error("Expected Saturday night not to be quiet")
+	}
+	if _, ok := quiet.Until(time.Date(2026, 10, 16, 21, 59, 0, 0, warsaw)); ok {
+		t.Error("Expected Friday before 22:00 not to be quiet")
+	}
+}
+
+func TestQuietHoursInUserTimezone(t *testing.T) {
+	quiet := QuietHours{Enabled: true, Timezone: "America/New_York", Ranges: []QuietRange{{Start: "09:00", End: "17:00"}}}
+
+	// 15:00 UTC is 11:00 in New York
+	if _, ok := quiet.Until(time.Date(2026, 10, 15, 15, 0, 0, 0, time.UTC)); !ok {
+		t.Error("Expected quiet hours in New York time")
+	}
+	quiet.Enabled = false
+	if _, ok := quiet.Until(time.Date(2026, 10, 15, 15, 0, 0, 0, time.UTC)); ok {
+		t.Error("Expected disabled quiet hours never to apply")
+	}
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	invalid := []QuietHours{
+		{Timezone: "Mars/Olympus", Ranges: []QuietRange{{Start: "22:00", End: "07:00"}}},
+		{Timezone: "UTC", Ranges: []QuietRange{{Start: "25:00", End: "07:00"}}},
+		{Timezone: "UTC", Ranges: []QuietRange{{Start: "07:00", End: "07:00"}}},
+		{Timezone: "UTC", Ranges: []QuietRange{{Start: "22:00", End: "07:00", Days: []time.Weekday{7}}}},
+	}
+	for _, quiet := range invalid {
+		if err := quiet.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", quiet)
+		}
+	}
+}
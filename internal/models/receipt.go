@@ -0,0 +1,19 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// DeviceReceipt is how far a direct message got on one device of its
+// recipient. The recipient's Receipt, and Message.Read, follow the first
+// device to get a message and the first to read it; only the sender sees
+// the receipts of single devices.
+type DeviceReceipt struct {
+	Id          bson.ObjectID `json:"-" bson:"_id"`
+	MessageId   bson.ObjectID `json:"message_id" bson:"message_id"`
+	UserId      bson.ObjectID `json:"user_id" bson:"user_id"`
+	DeviceId    bson.ObjectID `json:"device_id" bson:"device_id"`
+	DeliveredAt time.Time     `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+	ReadAt      time.Time     `json:"read_at,omitempty" bson:"read_at,omitempty"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// ReservedName is a handle nobody may sign up with or change to, like a
+// brand or the name of staff. Lookalike spellings are reserved with it. A
+// user gets a reserved name by claiming it, see NameClaim.
+type ReservedName struct {
+	Id   bson.ObjectID `json:"id" bson:"_id"`
+	Name string        `json:"name" bson:"name"`
+	// Skeleton is the name with lookalike letters folded, see
+	// moderation.Skeleton. It is what usernames are matched against.
+	Skeleton  string        `json:"-" bson:"skeleton"`
+	Reason    string        `json:"reason,omitempty" bson:"reason,omitempty"`
+	GrantedTo bson.ObjectID `json:"granted_to,omitempty" bson:"granted_to,omitempty"`
+	CreatedBy bson.ObjectID `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+}
+
+type ClaimStatus string
+
+const (
+	ClaimPending  ClaimStatus = "pending"
+	ClaimApproved ClaimStatus = "approved"
+	ClaimRejected ClaimStatus = "rejected"
+)
+
+// NameClaim is a request of a user for a reserved name, e.g. by the owner
+// of the brand. Approving it grants the name and renames the user.
+type NameClaim struct {
+	Id         bson.ObjectID `json:"id" bson:"_id"`
+	UserId     bson.ObjectID `json:"user_id" bson:"user_id"`
+	ReservedId bson.ObjectID `json:"reserved_id" bson:"reserved_id"`
+	Username   string        `json:"username" bson:"username"`
+	Reason     string        `json:"reason" bson:"reason"`
+	Status     ClaimStatus   `json:"status" bson:"status"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	ReviewedBy bson.ObjectID `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt time.Time     `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+}
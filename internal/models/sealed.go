@@ -0,0 +1,20 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// SealedMessage is a message in sealed sender mode. Its content is an
+// envelope encrypted to the recipient holding the message and a sender
+// certificate, so only the recipient learns who sent it. It is published
+// on the recipient's SealedTopic and stored as a Message of TypeSealed
+// without a sender.
+type SealedMessage struct {
+	Id          bson.ObjectID `json:"id"`
+	Type        MessageType   `json:"type"`
+	RecipientId bson.ObjectID `json:"recipient_id"`
+	Content     string        `json:"content"`
+	Padding     string        `json:"padding,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+}
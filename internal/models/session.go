@@ -0,0 +1,76 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// Session is one sign-in, kept alive by refreshing its tokens. See
+// config.Hot for how long sessions last.
+type Session struct {
+	Id         bson.ObjectID `json:"id" bson:"_id"`
+	UserId     bson.ObjectID `json:"user_id" bson:"user_id"`
+	IP         string        `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	LastUsedAt time.Time     `json:"last_used_at" bson:"last_used_at"`
+	ExpiresAt  time.Time     `json:"expires_at" bson:"expires_at"`
+}
+
+// SessionPolicy is how long sessions last, see config.Hot.
+type SessionPolicy struct {
+	TTL         time.Duration
+	Sliding     bool
+	MaxAge      time.Duration
+	IdleTimeout time.Duration
+}
+
+// Limit is the latest a session started at created can last, whatever
+// happens in between.
+func (p SessionPolicy) Limit(created time.Time) time.Time {
+	if p.Sliding && p.MaxAge > p.TTL {
+		return created.Add(p.MaxAge)
+	}
+	return created.Add(p.TTL)
+}
+
+func (s Session) Active(now time.Time, policy SessionPolicy) bool {
+	if !now.Before(s.ExpiresAt) {
+		return false
+	}
+	return policy.IdleTimeout <= 0 || now.Sub(s.LastUsedAt) < policy.IdleTimeout
+}
+
+// Touch records a use of the session and, when sliding, extends it.
+func (s *Session) Touch(now time.Time, policy SessionPolicy) {
+	s.LastUsedAt = now
+	if !policy.Sliding {
+		return
+	}
+	expires := now.Add(policy.TTL)
+	if limit := policy.Limit(s.CreatedAt); expires.After(limit) {
+		expires = limit
+	}
+	if expires.After(s.ExpiresAt) {
+		s.ExpiresAt = expires
+	}
+}
+
+// SupportSession lets an admin look at the account of a user, e.g. to help
+// them with a support ticket. It only ever shows metadata, never messages.
+type SupportSession struct {
+	Id        bson.ObjectID `json:"id" bson:"_id"`
+	AdminId   bson.ObjectID `json:"admin_id" bson:"admin_id"`
+	UserId    bson.ObjectID `json:"user_id" bson:"user_id"`
+	Reason    string        `json:"reason" bson:"reason"`
+	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time     `json:"expires_at" bson:"expires_at"`
+	RevokedAt time.Time     `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	RevokedBy bson.ObjectID `json:"revoked_by,omitempty" bson:"revoked_by,omitempty"`
+	// Token is the sealed support token, handed out once when starting.
+	Token string `json:"token,omitempty" bson:"-"`
+}
+
+func (s SupportSession) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
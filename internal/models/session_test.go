@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionFixedExpiry(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	policy := SessionPolicy{TTL: 7 * 24 * time.Hour, MaxAge: 30 * 24 * time.Hour}
+	session := Session{CreatedAt: start, LastUsedAt: start, ExpiresAt: start.Add(policy.TTL)}
+
+	later := start.Add(6 * 24 * time.Hour)
+	session.Touch(later, policy)
+	if session.ExpiresAt != start.Add(policy.TTL) {
+		t.Fatalf("fixed session extended to %v", session.ExpiresAt)
+	}
+	if !session.Active(later, policy) || session.Active(start.Add(policy.TTL), policy) {
+		t.Fatal("fixed session not ending after its TTL")
+	}
+	if policy.Limit(start) != start.Add(policy.TTL) {
+		t.Fatalf("limit %v, want TTL without sliding", policy.Limit(start))
+	}
+}
+
+func TestSessionSlidesUpToMaxAge(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	policy := SessionPolicy{TTL: 7 * 24 * time.Hour, Sliding: true, MaxAge: 10 * 24 * time.Hour}
+	session := Session{CreatedAt: start, LastUsedAt: start, ExpiresAt: start.Add(policy.TTL)}
+
+	session.Touch(start.Add(2*24*time.Hour), policy)
+	if want := start.Add(9 * 24 * time.Hour); session.ExpiresAt != want {
+		t.Fatalf("expires %v, want %v", session.ExpiresAt, want)
+	}
+	session.Touch(start.Add(6*24*time.Hour), policy)
+	if want := start.Add(policy.MaxAge); session.ExpiresAt != want {
+		t.Fatalf("expires %v, want capped at %v", session.ExpiresAt, want)
+	}
+	if session.Active(start.Add(policy.MaxAge), policy) {
+		t.Fatal("session active past its max age")
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	policy := SessionPolicy{TTL: 7 * 24 * time.Hour, IdleTimeout: 24 * time.Hour}
+	session := Session{CreatedAt: start, LastUsedAt: start, ExpiresAt: start.Add(policy.TTL)}
+
+	if !session.Active(start.Add(23*time.Hour), policy) {
+		t.Fatal("session ended before idle timeout")
+	}
+	if session.Active(start.Add(25*time.Hour), policy) {
+		t.Fatal("idle session still active")
+	}
+	session.Touch(start.Add(23*time.Hour), policy)
+	if !session.Active(start.Add(25*time.Hour), policy) {
+		t.Fatal("used session timed out")
+	}
+}
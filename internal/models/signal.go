@@ -0,0 +1,85 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"io"
+)
+
+// CallSignal is what clients publish on a call topic to negotiate WebRTC,
+// the only payload the broker accepts from clients. The broker checks it
+// before relaying and fills in From itself.
+type CallSignal struct {
+	Type      SignalType    `json:"type"`
+	CallId    bson.ObjectID `json:"call_id"`
+	From      bson.ObjectID `json:"from,omitempty"`
+	SDP       string        `json:"sdp,omitempty"`
+	Candidate *ICECandidate `json:"candidate,omitempty"`
+}
+
+type ICECandidate struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdp_mid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdp_mline_index,omitempty"`
+	UsernameFragment string  `json:"username_fragment,omitempty"`
+}
+
+type SignalType string
+
+const (
+	SignalOffer     SignalType = "offer"
+	SignalAnswer    SignalType = "answer"
+	SignalCandidate SignalType = "candidate"
+	SignalHangup    SignalType = "hangup"
+)
+
+const (
+	// MaxSignalSize bounds a whole payload, SDP of video calls with many
+	// codecs stays well below.
+	MaxSignalSize = 64 << 10
+	maxCandidate  = 1024
+)
+
+// DecodeCallSignal strictly decodes and checks a signal: one JSON object,
+// no unknown fields, no trailing data.
+func DecodeCallSignal(payload []byte) (CallSignal, error) {
+	if len(payload) > MaxSignalSize {
+		return CallSignal{}, errors.New("signal too large")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	var signal CallSignal
+	if err := decoder.Decode(&signal); err != nil {
+		return CallSignal{}, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return CallSignal{}, errors.New("trailing data after signal")
+	}
+	return signal, signal.Validate()
+}
+
+func (s CallSignal) Validate() error {
+	if s.CallId.IsZero() {
+		return errors.New("missing call id")
+	}
+	switch s.Type {
+	case SignalOffer, SignalAnswer:
+		if s.SDP == "" || s.Candidate != nil {
+			return errors.New("offer and answer carry sdp only")
+		}
+	case SignalCandidate:
+		if s.Candidate == nil || s.SDP != "" || len(s.Candidate.Candidate) > maxCandidate {
+			return errors.New("candidate signals carry one candidate only")
+		}
+	case SignalHangup:
+		if s.SDP != "" || s.Candidate != nil {
+			return errors.New("hangup carries no payload")
+		}
+	default:
+		return errors.New("unknown signal type")
+	}
+	return nil
+}
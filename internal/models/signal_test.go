@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+const callId = `"call_id":"65f1a2b3c4d5e6f708192a3b"`
+
+func TestDecodeCallSignalAccepts(t *testing.T) {
+	for _, payload := range []string{
+		`{"type":"offer",` + callId + `,"sdp":"v=0"}`,
+		`{"type":"candidate",` + callId + `,"candidate":{"candidate":"candidate:1 1 udp 1 192.0.2.1 5000 typ host","sdp_mid":"0","sdp_mline_index":0}}`,
+		`{"type":"hangup",` + callId + `}`,
+	} {
+		if _, err := DecodeCallSignal([]byte(payload)); err != nil {
+			t.Errorf("%s refused: %v", payload, err)
+		}
+	}
+}
+
+func TestDecodeCallSignalRejects(t *testing.T) {
+	for name, payload := range map[string]string{
+		"unknown field": `{"type":"offer",` + callId + `,"sdp":"v=0","admin":true}`,
+		"trailing data": `{"type":"hangup",` + callId + `}{"type":"hangup"}`,
+		"not an object": `["offer"]`,
+		"unknown type":  `{"type":"typing",` + callId + `}`,
+		"missing call":  `{"type":"offer","sdp":"v=0"}`,
+		"empty offer":   `{"type":"offer",` + callId + `}`,
+		"mixed payload": `{"type":"hangup",` + callId + `,"sdp":"v=0"}`,
+		"too large":     `{"type":"offer",` + callId + `,"sdp":"` + strings.Repeat("a", MaxSignalSize) + `"}`,
+		"truncated":     `{"type":"offer",`,
+	} {
+		if _, err := DecodeCallSignal([]byte(payload)); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// Star bookmarks a message for one user. Stars are private, the other
+// participants never learn about them.
+type Star struct {
+	Id        bson.ObjectID `json:"-" bson:"_id"`
+	UserId    bson.ObjectID `json:"-" bson:"user_id"`
+	MessageId bson.ObjectID `json:"message_id" bson:"message_id"`
+	CreatedAt time.Time     `json:"starred_at" bson:"created_at"`
+	Message   *Message      `json:"message,omitempty" bson:"-"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DailyStats is the activity of one UTC day. It holds counts only, never
+// who sent what.
+type DailyStats struct {
+	Day             time.Time `json:"day" bson:"_id"`
+	ActiveUsers     int64     `json:"active_users" bson:"active_users"`
+	Messages        int64     `json:"messages" bson:"messages"`
+	PeakConnections int64     `json:"peak_connections" bson:"peak_connections"`
+	UpdatedAt       time.Time `json:"updated_at" bson:"updated_at"`
+}
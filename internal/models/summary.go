@@ -0,0 +1,26 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// ConversationSummary is the read model behind the conversation list: one
+// document per direct conversation, kept current from new messages and read
+// receipts, so listing conversations does not scan messages. Unread is
+// keyed by the hex id of each participant; a user only ever sees their own
+// count, as UnreadCount.
+type ConversationSummary struct {
+	Id            string           `json:"id" bson:"_id"`
+	Participants  []bson.ObjectID  `json:"participants" bson:"participants"`
+	LastMessageId bson.ObjectID    `json:"last_message_id" bson:"last_message_id"`
+	Unread        map[string]int64 `json:"-" bson:"unread"`
+	UnreadCount   int64            `json:"unread" bson:"-"`
+	UpdatedAt     time.Time        `json:"updated_at" bson:"updated_at"`
+	// Labels are those the user put on the conversation, see
+	// ConversationSettings.
+	Labels []string `json:"labels,omitempty" bson:"-"`
+	// Self marks the saved messages of the user, the conversation they
+	// have with themselves.
+	Self bool `json:"self,omitempty" bson:"-"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import "go.mongodb.org/mongo-driver/v2/bson"
+
+// Topic layout shared by the REST handlers, which publish, and the broker
+// ACL, which decides who may subscribe:
+//
+//	chat/{userId}/...        events addressed to one user
+//	chat/{userId}/badges     retained unread counts of one user
+//	chat/{a}/{b}/call        call signaling between two users, a < b, see
+//	                         CallSignal
+//	chat/{userId}/send       messages one user sends over MQTT instead of
+//	                         REST; with the call topics the only topics
+//	                         clients publish to, see hooks.LocalDeliveryHook
+//	chat/{userId}/events     what became of the user's requests, for
+//	                         clients in debug mode, see DebugEvent
+//	chat/{userId}/sealed     messages whose sender only the recipient
+//	                         knows, see SealedMessage
+//	system/{userId}/...      server notices for one user
+//	system/{userId}/delivery messages of the user that were not pushed live,
+//	                         or not delivered at all, see MessageError
+//	system/{userId}/sent     messages of the user accepted from chat/.../send
+//	system/{userId}/control  retained lifecycle events every client must
+//	                         follow, see ControlEvent
+//	system/{userId}/sessions/{sessionId}/control
+//	                         retained logout and wipe commands for one
+//	                         session
+//	system/announcements     retained list of active announcements
+//	system/maintenance       retained maintenance notice
+//	channels/{channelId}/posts  public channel posts, readable by everyone
+//	presence/{userId}        retained online status, readable by everyone
+//
+// Payloads are JSON. Clients may get chat/{userId}/messages as CBOR
+// instead, see hooks.FormatHook.
+
+const (
+	AnnouncementsTopic = "system/announcements"
+	MaintenanceTopic   = "system/maintenance"
+)
+
+func MessageTopic(recipient bson.ObjectID) string {
+	return "chat/" + recipient.Hex() + "/messages"
+}
+
+func SendTopic(sender bson.ObjectID) string {
+	return "chat/" + sender.Hex() + "/send"
+}
+
+func SealedTopic(recipient bson.ObjectID) string {
+	return "chat/" + recipient.Hex() + "/sealed"
+}
+
+func EventsTopic(user bson.ObjectID) string {
+	return "chat/" + user.Hex() + "/events"
+}
+
+func BadgesTopic(user bson.ObjectID) string {
+	return "chat/" + user.Hex() + "/badges"
+}
+
+func CallsTopic(user bson.ObjectID) string {
+	return "chat/" + user.Hex() + "/calls"
+}
+
+// CallTopic orders the participants so both sides derive the same topic.
+func CallTopic(a bson.ObjectID, b bson.ObjectID) string {
+	if a.Hex() > b.Hex() {
+		a, b = b, a
+	}
+	return "chat/" + a.Hex() + "/" + b.Hex() + "/call"
+}
+
+func SystemTopic(user bson.ObjectID, name string) string {
+	return "system/" + user.Hex() + "/" + name
+}
+
+func ChannelTopic(channel bson.ObjectID) string {
+	return "channels/" + channel.Hex() + "/posts"
+}
+
+func PresenceTopic(user bson.ObjectID) string {
+	return "presence/" + user.Hex()
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// GroupTyping sums up who is typing in a group, published on the message
+// topic of every member instead of the typing events of each typist, see
+// jobs.TypingAggregator. Users and Names are in the order members started
+// typing; an event with a Count of 0 means everyone stopped. Members find
+// themselves in Users and leave themselves out.
+type GroupTyping struct {
+	Type      MessageType     `json:"type"`
+	GroupId   bson.ObjectID   `json:"group_id"`
+	Users     []bson.ObjectID `json:"users"`
+	Names     []string        `json:"names"`
+	Count     int             `json:"count"`
+	Timestamp time.Time       `json:"timestamp"`
+}
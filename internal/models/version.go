@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ClientVersion is the major.minor.patch version clients send in the
+// X-Client-Version header. A leading v and anything after a - or + are
+// ignored, 2.1 reads as 2.1.0.
+type ClientVersion [3]int
+
+func ParseClientVersion(raw string) (ClientVersion, error) {
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "v")
+	if i := strings.IndexAny(raw, "-+"); i >= 0 {
+		raw = raw[:i]
+	}
+	parts := strings.Split(raw, ".")
+	if raw == "" || len(parts) > 3 {
+		return ClientVersion{}, errors.New("invalid version")
+	}
+
+	var version ClientVersion
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return ClientVersion{}, errors.New("invalid version")
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+func (v ClientVersion) Less(other ClientVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+func (v ClientVersion) String() string {
+	return strconv.Itoa(v[0]) + "." + strconv.Itoa(v[1]) + "." + strconv.Itoa(v[2])
+}
@@ -0,0 +1,32 @@
+package models
+
+import "testing"
+
+func TestParseClientVersion(t *testing.T) {
+	for raw, want := range map[string]ClientVersion{
+		"1.2.3":        {1, 2, 3},
+		"v2.1":         {2, 1, 0},
+		"3":            {3, 0, 0},
+		"1.4.0-beta.2": {1, 4, 0},
+		"1.4.0+build7": {1, 4, 0},
+	} {
+		got, err := ParseClientVersion(raw)
+		if err != nil || got != want {
+			t.Errorf("Expected %q to parse as %v, got %v (%v)", raw, want, got, err)
+		}
+	}
+	for _, raw := range []string{"", "1.2.3.4", "1.x", "-1.0", "1..2"} {
+		if _, err := ParseClientVersion(raw); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+}
+
+func TestClientVersionLess(t *testing.T) {
+	if !(ClientVersion{1, 9, 9}).Less(ClientVersion{1, 10, 0}) {
+		t.Error("Expected 1.9.9 < 1.10.0")
+	}
+	if (ClientVersion{2, 0, 0}).Less(ClientVersion{2, 0, 0}) {
+		t.Error("Expected a version not to be less than itself")
+	}
+}
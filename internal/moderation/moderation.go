@@ -0,0 +1,232 @@
+package moderation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"filachat/pkg/config"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Verdict is ordered by severity, a pipeline returns the worst one any
+// filter reached.
+type Verdict int
+
+const (
+	Allow Verdict = iota
+	// Flag publishes but puts the post in the review queue.
+	Flag
+	// Hold keeps the post back until an admin approves it.
+	Hold
+	Reject
+)
+
+func ParseVerdict(s string) (Verdict, error) {
+	switch s {
+	case "allow":
+		return Allow, nil
+	case "flag":
+		return Flag, nil
+	case "hold":
+		return Hold, nil
+	case "reject":
+		return Reject, nil
+	}
+	return Allow, fmt.Errorf("unknown moderation verdict %q", s)
+}
+
+type Decision struct {
+	Verdict Verdict
+	Reasons []string
+}
+
+type Filter interface {
+	Check(ctx context.Context, text string) (Decision, error)
+}
+
+type Pipeline struct {
+	Filters []Filter
+}
+
+// Evaluate runs every filter. A failing filter fails the evaluation, posts
+// are not published unchecked.
+func (p *Pipeline) Evaluate(ctx context.Context, text string) (Decision, error) {
+	var result Decision
+	for _, filter := range p.Filters {
+		decision, err := filter.Check(ctx, text)
+		if err != nil {
+			return Decision{}, err
+		}
+		if decision.Verdict > result.Verdict {
+			result.Verdict = decision.Verdict
+		}
+		result.Reasons = append(result.Reasons, decision.Reasons...)
+	}
+	return result, nil
+}
+
+// KeywordFilter matches whole words, case insensitive.
+type KeywordFilter struct {
+	Words   map[string]bool
+	Verdict Verdict
+}
+
+func NewKeywordFilter(words []string, verdict Verdict) *KeywordFilter {
+	filter := &KeywordFilter{Words: make(map[string]bool, len(words)), Verdict: verdict}
+	for _, word := range words {
+		filter.Words[strings.ToLower(word)] = true
+	}
+	return filter
+}
+
+func (f *KeywordFilter) Check(ctx context.Context, text string) (Decision, error) {
+	var decision Decision
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r == '\'' || r == '-' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9' || r > 127)
+	})
+	for _, word := range words {
+		if f.Words[word] {
+			decision.Verdict = f.Verdict
+			decision.Reasons = append(decision.Reasons, "keyword:"+word)
+		}
+	}
+	return decision, nil
+}
+
+type RegexFilter struct {
+	Rules   []*regexp.Regexp
+	Verdict Verdict
+}
+
+// LoadRegexFilter reads one pattern per line, blank lines and lines starting
+// with # are skipped.
+func LoadRegexFilter(path string, verdict Verdict) (*RegexFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &RegexFilter{Verdict: verdict}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := regexp.Compile(line)
+		if err != nil {
+			return nil, err
+		}
+		filter.Rules = append(filter.Rules, rule)
+	}
+	return filter, scanner.Err()
+}
+
+func (f *RegexFilter) Check(ctx context.Context, text string) (Decision, error) {
+	var decision Decision
+	for _, rule := range f.Rules {
+		if rule.MatchString(text) {
+			decision.Verdict = f.Verdict
+			decision.Reasons = append(decision.Reasons, "pattern:"+rule.String())
+		}
+	}
+	return decision, nil
+}
+
+// ClassifierFilter asks an external service for a score between 0 and 1 and
+// maps it onto a verdict with the thresholds.
+type ClassifierFilter struct {
+	URL      string
+	Client   *http.Client
+	FlagAt   float64
+	HoldAt   float64
+	RejectAt float64
+}
+
+func NewClassifierFilter(url string) *ClassifierFilter {
+	return &ClassifierFilter{
+		URL:      url,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+		FlagAt:   0.5,
+		HoldAt:   0.8,
+		RejectAt: 0.95,
+	}
+}
+
+func (f *ClassifierFilter) Check(ctx context.Context, text string) (Decision, error) {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return Decision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(payload))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return Decision{}, fmt.Errorf("classifier returned %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score float64 `json:"score"`
+		Label string  `json:"label"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Decision{}, err
+	}
+
+	var decision Decision
+	switch {
+	case result.Score >= f.RejectAt:
+		decision.Verdict = Reject
+	case result.Score >= f.HoldAt:
+		decision.Verdict = Hold
+	case result.Score >= f.FlagAt:
+		decision.Verdict = Flag
+	default:
+		return decision, nil
+	}
+	decision.Reasons = []string{fmt.Sprintf("classifier:%s:%.2f", result.Label, result.Score)}
+	return decision, nil
+}
+
+// NewPipeline assembles the filters that are configured, an empty pipeline
+// allows everything.
+func NewPipeline(cfg *config.Config) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+
+	if len(cfg.ModerationKeywords) > 0 {
+		verdict, err := ParseVerdict(cfg.ModerationKeywordAction)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Filters = append(pipeline.Filters, NewKeywordFilter(cfg.ModerationKeywords, verdict))
+	}
+	if cfg.ModerationPatternsFile != "" {
+		verdict, err := ParseVerdict(cfg.ModerationPatternAction)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := LoadRegexFilter(cfg.ModerationPatternsFile, verdict)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Filters = append(pipeline.Filters, filter)
+	}
+	if cfg.ModerationClassifierURL != "" {
+		pipeline.Filters = append(pipeline.Filters, NewClassifierFilter(cfg.ModerationClassifierURL))
+	}
+	return pipeline, nil
+}
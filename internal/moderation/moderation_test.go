@@ -0,0 +1,58 @@
+package moderation
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPipelineTakesWorstVerdict(t *testing.T) {
+	pipeline := &Pipeline{Filters: []Filter{
+		NewKeywordFilter([]string{"casino"}, Flag),
+		NewKeywordFilter([]string{"scam"}, Hold),
+	}}
+
+	decision, err := pipeline.Evaluate(context.Background(), "Best CASINO, no scam!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Verdict != Hold {
+		t.Fatalf("verdict = %d, want Hold", decision.Verdict)
+	}
+	if len(decision.Reasons) != 2 {
+		t.Fatalf("reasons = %v", decision.Reasons)
+	}
+}
+
+func TestKeywordFilterMatchesWholeWords(t *testing.T) {
+	filter := NewKeywordFilter([]string{"ass"}, Reject)
+
+	decision, _ := filter.Check(context.Background(), "a classic assessment")
+	if decision.Verdict != Allow {
+		t.Fatalf("verdict = %d, want Allow", decision.Verdict)
+	}
+}
+
+func TestNameFilterCatchesLookalikes(t *testing.T) {
+	filter := NewNameFilter([]string{"admin"}, false)
+
+	for _, name := range []string{"Admin", "4dm1n", "the_admin", "аdmіn", "ａｄｍｉｎ"} {
+		if err := filter.Check(name); err == nil {
+			t.Errorf("%q allowed, want it blocked", name)
+		}
+	}
+	for _, name := range []string{"adam", "borys", "борис", "ωμεγα"} {
+		if err := filter.Check(name); err != nil {
+			t.Errorf("%q blocked: %v", name, err)
+		}
+	}
+}
+
+func TestNameFilterRefusesMixedScripts(t *testing.T) {
+	// a latin name with a cyrillic о
+	if err := NewNameFilter(nil, false).Check("bоrys"); err != ErrNameMixedScripts {
+		t.Fatalf("err = %v, want ErrNameMixedScripts", err)
+	}
+	if err := NewNameFilter(nil, true).Check("bоrys"); err != nil {
+		t.Fatalf("err = %v with mixed scripts allowed", err)
+	}
+}
@@ -0,0 +1,128 @@
+package moderation
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"filachat/pkg/config"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrNameBlocked      = errors.New("username not allowed")
+	ErrNameMixedScripts = errors.New("username mixes scripts")
+)
+
+// confusables folds letters of other scripts and digits that pass for latin
+// letters onto the latin letter. i and l fold together, they are told apart
+// by the font only.
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'l', 'ї': 'l',
+	'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's',
+	'т': 't', 'у': 'y', 'ԝ': 'w', 'х': 'x',
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+	// digits and symbols
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '@': 'a', '$': 's',
+	'!': 'l', '|': 'l', 'i': 'l',
+}
+
+// confusableScripts are the scripts with letters that look alike. A name
+// may use any one of them, names mixing two are how latin names get
+// impersonated.
+var confusableScripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Armenian, unicode.Cherokee}
+
+// Skeleton is what a name looks like: NFKC normalized, lower case, with
+// confusable letters folded and separators dropped. Names with the same
+// skeleton are hard to tell apart.
+func Skeleton(name string) string {
+	var skeleton strings.Builder
+	for _, r := range strings.ToLower(norm.NFKC.String(name)) {
+		if folded, ok := confusables[r]; ok {
+			r = folded
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			skeleton.WriteRune(r)
+		}
+	}
+	return skeleton.String()
+}
+
+// NameFilter checks usernames against a deployment's word list. Words are
+// matched anywhere in the skeleton of a name, so "4dm1n" and a cyrillic
+// "аdmin" are both caught by "admin".
+type NameFilter struct {
+	Blocked           []string
+	AllowMixedScripts bool
+}
+
+func NewNameFilter(words []string, allowMixedScripts bool) *NameFilter {
+	filter := &NameFilter{AllowMixedScripts: allowMixedScripts}
+	for _, word := range words {
+		if skeleton := Skeleton(word); skeleton != "" {
+			filter.Blocked = append(filter.Blocked, skeleton)
+		}
+	}
+	return filter
+}
+
+// LoadNameFilter builds the filter from USERNAME_BLOCKED_WORDS and the
+// words file, one word per line, blank lines and lines starting with # are
+// skipped.
+func LoadNameFilter(cfg *config.Config) (*NameFilter, error) {
+	words := cfg.UsernameBlockedWords
+	if cfg.UsernameBlockedWordsFile != "" {
+		data, err := os.ReadFile(cfg.UsernameBlockedWordsFile)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			words = append(words, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return NewNameFilter(words, cfg.UsernameAllowMixedScripts), nil
+}
+
+// Check returns ErrNameMixedScripts or ErrNameBlocked for a name the
+// deployment does not allow.
+func (f *NameFilter) Check(name string) error {
+	if !f.AllowMixedScripts && mixesScripts(norm.NFKC.String(name)) {
+		return ErrNameMixedScripts
+	}
+	skeleton := Skeleton(name)
+	for _, word := range f.Blocked {
+		if strings.Contains(skeleton, word) {
+			return ErrNameBlocked
+		}
+	}
+	return nil
+}
+
+func mixesScripts(name string) bool {
+	var seen *unicode.RangeTable
+	for _, r := range name {
+		for _, script := range confusableScripts {
+			if !unicode.Is(script, r) {
+				continue
+			}
+			if seen != nil && seen != script {
+				return true
+			}
+			seen = script
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// Package policy declares who may call a route. Routes register a Rule with
+// the Table next to their handler, imiddleware.Authorize checks it before
+// the handler runs. A route without a rule is denied to everyone, so a new
+// route is closed until someone decides who may use it.
+package policy
+
+import (
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"slices"
+)
+
+// Subject is the caller a rule decides on.
+type Subject struct {
+	User   models.User
+	Claims *core.Claims
+}
+
+type Rule func(Subject) bool
+
+// Deny is the rule of routes that declared none.
+func Deny(Subject) bool {
+	return false
+}
+
+// Role allows users having any of roles.
+func Role(roles ...models.Role) Rule {
+	return func(s Subject) bool {
+		return slices.Contains(roles, s.User.Role)
+	}
+}
+
+// Scope allows tokens issued for scope, see core.Claims.HasScope.
+func Scope(scope string) Rule {
+	return func(s Subject) bool {
+		return s.Claims != nil && s.Claims.HasScope(scope)
+	}
+}
+
+// All allows whom every rule allows.
+func All(rules ...Rule) Rule {
+	return func(s Subject) bool {
+		for _, rule := range rules {
+			if !rule(s) {
+				return false
+			}
+		}
+		return len(rules) > 0
+	}
+}
+
+// Any allows whom at least one rule allows.
+func Any(rules ...Rule) Rule {
+	return func(s Subject) bool {
+		for _, rule := range rules {
+			if rule(s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Table holds the rule of each route by method and path, the path as
+// registered with echo, e.g. "/admin/users/:id/account".
+type Table struct {
+	rules map[string]Rule
+}
+
+func NewTable() *Table {
+	return &Table{rules: map[string]Rule{}}
+}
+
+func (t *Table) Set(method string, path string, rule Rule) {
+	if rule == nil {
+		rule = Deny
+	}
+	t.rules[method+" "+path] = rule
+}
+
+// Rule is the rule of a route, Deny for routes without one.
+func (t *Table) Rule(method string, path string) Rule {
+	if rule, ok := t.rules[method+" "+path]; ok {
+		return rule
+	}
+	return Deny
+}
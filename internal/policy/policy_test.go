@@ -0,0 +1,47 @@
+package policy
+
+import (
+	"filachat/internal/core"
+	"filachat/internal/models"
+	"net/http"
+	"testing"
+)
+
+func TestTableDeniesByDefault(t *testing.T) {
+	table := NewTable()
+	table.Set(http.MethodGet, "/admin/stats", Role(models.RoleAdmin))
+	admin := Subject{User: models.User{Role: models.RoleAdmin}}
+
+	if !table.Rule(http.MethodGet, "/admin/stats")(admin) {
+		t.Error("Expected an admin to get the stats")
+	}
+	if table.Rule(http.MethodPost, "/admin/stats")(admin) {
+		t.Error("Expected a route without a rule to be denied")
+	}
+	table.Set(http.MethodDelete, "/admin/stats", nil)
+	if table.Rule(http.MethodDelete, "/admin/stats")(admin) {
+		t.Error("Expected a nil rule to deny")
+	}
+}
+
+func TestRoleAndScope(t *testing.T) {
+	staff := All(Role(models.RoleAdmin, models.RoleModerator), Scope(core.ScopeAdmin))
+
+	for _, tc := range []struct {
+		name    string
+		subject Subject
+		allowed bool
+	}{
+		{"moderator", Subject{User: models.User{Role: models.RoleModerator}, Claims: &core.Claims{}}, true},
+		{"user", Subject{User: models.User{}, Claims: &core.Claims{}}, false},
+		{"scoped token", Subject{User: models.User{Role: models.RoleAdmin}, Claims: &core.Claims{Scope: []string{"media"}}}, false},
+		{"no token", Subject{User: models.User{Role: models.RoleAdmin}}, false},
+	} {
+		if got := staff(tc.subject); got != tc.allowed {
+			t.Errorf("%s allowed = %v, want %v", tc.name, got, tc.allowed)
+		}
+	}
+	if All()(Subject{}) {
+		t.Error("Expected All without rules to deny")
+	}
+}
@@ -0,0 +1,195 @@
+package presence
+
+import (
+	"context"
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sync"
+	"time"
+)
+
+// PresenceStore tracks who is online. A user stays online while heartbeats
+// keep arriving within the ttl of the previous one, or until Remove.
+// Stores shared between instances report changes made by any of them.
+type PresenceStore interface {
+	Touch(user bson.ObjectID, ttl time.Duration) error
+	Remove(user bson.ObjectID) error
+	Status(user bson.ObjectID) (models.UserStatus, error)
+	// Refresh makes Watch deliver the current status of user without a
+	// change in connection, e.g. after they picked another state.
+	Refresh(user bson.ObjectID) error
+	// Watch delivers a status every time a user comes online or goes
+	// offline. Slow readers miss changes rather than block the store.
+	Watch() (<-chan models.UserStatus, error)
+}
+
+// Resolve overlays the state a user picked on their connection status, the
+// way peers get to see it. Invisible users look offline, last seen when
+// they went invisible.
+func Resolve(status models.UserStatus, setting *models.StatusSetting) models.UserStatus {
+	if !status.IsOnline {
+		status.State = models.PresenceOffline
+		return status
+	}
+	if setting == nil {
+		status.State = models.PresenceOnline
+		return status
+	}
+	if setting.State == models.PresenceInvisible {
+		status.IsOnline = false
+		status.State = models.PresenceOffline
+		if setting.UpdatedAt.Before(status.LastSeen) {
+			status.LastSeen = setting.UpdatedAt
+		}
+		return status
+	}
+	status.State, status.Text, status.Emoji = setting.State, setting.Text, setting.Emoji
+	return status
+}
+
+// Remembered fills in the last seen time stored with the user when the
+// store knows none or an older one, e.g. after a restart of an in memory
+// store or when the user was last connected to another instance.
+func Remembered(status models.UserStatus, stored time.Time) models.UserStatus {
+	if stored.After(status.LastSeen) {
+		status.LastSeen = stored
+	}
+	return status
+}
+
+// Paused tells peers that notifications are held back by quiet hours, for
+// users who opted in, and until when.
+func Paused(status models.UserStatus, quiet *models.QuietHours, now time.Time) models.UserStatus {
+	if quiet == nil || !quiet.ShowPeers {
+		return status
+	}
+	if until, ok := quiet.Until(now); ok {
+		status.NotificationsPausedUntil = until
+	}
+	return status
+}
+
+// watchers fans changes out to the channels handed out by Watch.
+type watchers struct {
+	mu    sync.Mutex
+	chans []chan models.UserStatus
+}
+
+func (w *watchers) add() <-chan models.UserStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan models.UserStatus, 256)
+	w.chans = append(w.chans, ch)
+	return ch
+}
+
+func (w *watchers) notify(status models.UserStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, ch := range w.chans {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
+}
+
+// MemoryStore keeps presence in process, for single instance deployments.
+type MemoryStore struct {
+	mu       sync.Mutex
+	expires  map[bson.ObjectID]time.Time
+	lastSeen map[bson.ObjectID]time.Time
+	watchers watchers
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		expires:  make(map[bson.ObjectID]time.Time),
+		lastSeen: make(map[bson.ObjectID]time.Time),
+	}
+}
+
+func (s *MemoryStore) Touch(user bson.ObjectID, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	_, online := s.expires[user]
+	s.expires[user] = now.Add(ttl)
+	s.lastSeen[user] = now
+	if !online {
+		s.watchers.notify(models.UserStatus{UserID: user, IsOnline: true, LastSeen: now, Timestamp: now})
+	}
+	return nil
+}
+
+func (s *MemoryStore) Remove(user bson.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, online := s.expires[user]; online {
+		s.goOffline(user, time.Now())
+	}
+	return nil
+}
+
+// goOffline expects s.mu to be held.
+func (s *MemoryStore) goOffline(user bson.ObjectID, now time.Time) {
+	delete(s.expires, user)
+	s.lastSeen[user] = now
+	s.watchers.notify(models.UserStatus{UserID: user, LastSeen: now, Timestamp: now})
+}
+
+func (s *MemoryStore) Status(user bson.ObjectID) (models.UserStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	expires, online := s.expires[user]
+	return models.UserStatus{
+		UserID:    user,
+		IsOnline:  online && expires.After(now),
+		LastSeen:  s.lastSeen[user],
+		Timestamp: now,
+	}, nil
+}
+
+func (s *MemoryStore) Refresh(user bson.ObjectID) error {
+	status, _ := s.Status(user)
+	s.watchers.notify(status)
+	return nil
+}
+
+func (s *MemoryStore) Watch() (<-chan models.UserStatus, error) {
+	return s.watchers.add(), nil
+}
+
+// Run expires users whose heartbeats stopped, checking every interval.
+func (s *MemoryStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.Expire()
+	}
+}
+
+func (s *MemoryStore) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for user, expires := range s.expires {
+		if !expires.After(now) {
+			// last seen at the final heartbeat, not at the sweep
+			s.goOffline(user, s.lastSeen[user])
+		}
+	}
+}
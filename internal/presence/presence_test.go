@@ -0,0 +1,98 @@
+package presence
+
+import (
+	"filachat/internal/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreReportsTransitions(t *testing.T) {
+	store := NewMemoryStore()
+	changes, _ := store.Watch()
+	user := bson.NewObjectID()
+
+	store.Touch(user, time.Minute)
+	store.Touch(user, time.Minute)
+	if status := <-changes; !status.IsOnline || status.UserID != user {
+		t.Fatalf("first heartbeat reported %+v", status)
+	}
+	select {
+	case status := <-changes:
+		t.Fatalf("renewal reported as a change: %+v", status)
+	default:
+	}
+
+	store.Remove(user)
+	if status := <-changes; status.IsOnline {
+		t.Fatal("remove not reported as offline")
+	}
+	if status, _ := store.Status(user); status.IsOnline || status.LastSeen.IsZero() {
+		t.Fatalf("status after remove %+v", status)
+	}
+}
+
+func TestMemoryStoreExpiresMissedHeartbeats(t *testing.T) {
+	store := NewMemoryStore()
+	changes, _ := store.Watch()
+	user := bson.NewObjectID()
+
+	store.Touch(user, time.Millisecond)
+	<-changes
+	time.Sleep(5 * time.Millisecond)
+	store.Expire()
+
+	if status := <-changes; status.IsOnline {
+		t.Fatal("expired user still online")
+	}
+	if status, _ := store.Status(user); status.IsOnline {
+		t.Fatal("status still online")
+	}
+}
+
+func TestResolveHidesInvisibleUsers(t *testing.T) {
+	went := time.Now().Add(-time.Hour)
+	online := models.UserStatus{UserID: bson.NewObjectID(), IsOnline: true, LastSeen: time.Now()}
+
+	status := Resolve(online, &models.StatusSetting{State: models.PresenceInvisible, UpdatedAt: went})
+	if status.IsOnline || status.State != models.PresenceOffline || !status.LastSeen.Equal(went) {
+		t.Fatalf("invisible user resolved to %+v", status)
+	}
+
+	status = Resolve(online, &models.StatusSetting{State: models.PresenceAway, Text: "lunch"})
+	if !status.IsOnline || status.State != models.PresenceAway || status.Text != "lunch" {
+		t.Fatalf("away user resolved to %+v", status)
+	}
+
+	offline := online
+	offline.IsOnline = false
+	if status := Resolve(offline, &models.StatusSetting{State: models.PresenceAway, Text: "lunch"}); status.State != models.PresenceOffline || status.Text != "" {
+		t.Fatalf("offline user resolved to %+v", status)
+	}
+}
+
+func TestPausedOnlyWhenShared(t *testing.T) {
+	quiet := &models.QuietHours{Enabled: true, Timezone: "UTC", Ranges: []models.QuietRange{{Start: "22:00", End: "07:00"}}}
+	night := time.Date(2026, 10, 15, 23, 0, 0, 0, time.UTC)
+
+	if status := Paused(models.UserStatus{}, quiet, night); !status.NotificationsPausedUntil.IsZero() {
+		t.Error("Expected quiet hours to stay private without opt-in")
+	}
+	quiet.ShowPeers = true
+	status := Paused(models.UserStatus{}, quiet, night)
+	if !status.NotificationsPausedUntil.Equal(time.Date(2026, 10, 16, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected paused until 07:00, got %v", status.NotificationsPausedUntil)
+	}
+}
+
+func TestRememberedKeepsLatest(t *testing.T) {
+	stored := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	if status := Remembered(models.UserStatus{}, stored); !status.LastSeen.Equal(stored) {
+		t.Errorf("Expected stored last seen for unknown user, got %v", status.LastSeen)
+	}
+	newer := stored.Add(time.Hour)
+	if status := Remembered(models.UserStatus{LastSeen: newer}, stored); !status.LastSeen.Equal(newer) {
+		t.Errorf("Expected newer last seen of the store, got %v", status.LastSeen)
+	}
+}
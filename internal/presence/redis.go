@@ -0,0 +1,185 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/models"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	onlinePrefix   = "presence:online:"
+	lastSeenPrefix = "presence:seen:"
+	refreshChannel = "presence:refresh"
+)
+
+// RedisStore shares presence between instances. Every online user has a key
+// that expires unless heartbeats renew it, and keyspace notifications tell
+// all instances when one is created, deleted or expires.
+type RedisStore struct {
+	Client   *redis.Client
+	watchers watchers
+}
+
+func NewRedisStore(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	store := &RedisStore{Client: redis.NewClient(opts)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := store.Client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	if err := store.enableNotifications(ctx); err != nil {
+		// managed Redis often disallows CONFIG, it then has to be set up front
+		log.Println("[WARN] keyspace notifications not enabled, set notify-keyspace-events to include E$gx", err)
+	}
+	return store, nil
+}
+
+// enableNotifications adds the event classes Watch relies on to whatever
+// the server already has configured.
+func (s *RedisStore) enableNotifications(ctx context.Context) error {
+	current, err := s.Client.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+	flags := current["notify-keyspace-events"]
+	missing := ""
+	for _, flag := range "E$gx" {
+		if !strings.ContainsRune(flags, flag) && !(flag != 'E' && strings.ContainsRune(flags, 'A')) {
+			missing += string(flag)
+		}
+	}
+	if missing == "" {
+		return nil
+	}
+	return s.Client.ConfigSet(ctx, "notify-keyspace-events", flags+missing).Err()
+}
+
+// Touch renews the key of an online user. Only creating it, the transition
+// to online, emits a set event; renewing uses EXPIRE, which Watch ignores.
+func (s *RedisStore) Touch(user bson.ObjectID, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	created, err := s.Client.SetNX(ctx, onlinePrefix+user.Hex(), now.Unix(), ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		if err := s.Client.Expire(ctx, onlinePrefix+user.Hex(), ttl).Err(); err != nil {
+			return err
+		}
+	}
+	return s.Client.Set(ctx, lastSeenPrefix+user.Hex(), now.Unix(), 0).Err()
+}
+
+func (s *RedisStore) Remove(user bson.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.Client.Set(ctx, lastSeenPrefix+user.Hex(), time.Now().Unix(), 0).Err(); err != nil {
+		return err
+	}
+	return s.Client.Del(ctx, onlinePrefix+user.Hex()).Err()
+}
+
+func (s *RedisStore) Status(user bson.ObjectID) (models.UserStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	online, err := s.Client.Exists(ctx, onlinePrefix+user.Hex()).Result()
+	if err != nil {
+		return models.UserStatus{}, err
+	}
+	status := models.UserStatus{UserID: user, IsOnline: online == 1, Timestamp: time.Now()}
+	status.LastSeen, err = s.lastSeen(ctx, user)
+	return status, err
+}
+
+func (s *RedisStore) lastSeen(ctx context.Context, user bson.ObjectID) (time.Time, error) {
+	raw, err := s.Client.Get(ctx, lastSeenPrefix+user.Hex()).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	unix, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+func (s *RedisStore) Refresh(user bson.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.Client.Publish(ctx, refreshChannel, user.Hex()).Err()
+}
+
+// Watch subscribes to the keyspace events of the online keys and to refresh
+// requests on the first call; later calls share the subscription.
+func (s *RedisStore) Watch() (<-chan models.UserStatus, error) {
+	s.watchers.mu.Lock()
+	first := len(s.watchers.chans) == 0
+	s.watchers.mu.Unlock()
+
+	ch := s.watchers.add()
+	if first {
+		db := strconv.Itoa(s.Client.Options().DB)
+		events := []string{"set", "del", "expired"}
+		channels := []string{refreshChannel}
+		for _, event := range events {
+			channels = append(channels, "__keyevent@"+db+"__:"+event)
+		}
+		sub := s.Client.Subscribe(context.Background(), channels...)
+		go s.listen(sub)
+	}
+	return ch, nil
+}
+
+func (s *RedisStore) listen(sub *redis.PubSub) {
+	for msg := range sub.Channel() {
+		if msg.Channel == refreshChannel {
+			if user, err := bson.ObjectIDFromHex(msg.Payload); err == nil {
+				if status, err := s.Status(user); err == nil {
+					s.watchers.notify(status)
+				}
+			}
+			continue
+		}
+
+		hex, ok := strings.CutPrefix(msg.Payload, onlinePrefix)
+		if !ok {
+			continue
+		}
+		user, err := bson.ObjectIDFromHex(hex)
+		if err != nil {
+			continue
+		}
+
+		now := time.Now()
+		status := models.UserStatus{UserID: user, IsOnline: strings.HasSuffix(msg.Channel, ":set"), LastSeen: now, Timestamp: now}
+		if !status.IsOnline {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if lastSeen, err := s.lastSeen(ctx, user); err == nil {
+				status.LastSeen = lastSeen
+			}
+			cancel()
+		}
+		s.watchers.notify(status)
+	}
+}
@@ -0,0 +1,158 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"filachat/internal/models"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"net/http"
+	"time"
+)
+
+type Notification struct {
+	UserId bson.ObjectID
+	Title  string
+	Body   string
+	Data   map[string]string
+}
+
+type TokenStore interface {
+	GetPushTokens(ctx context.Context, userId bson.ObjectID) ([]models.PushToken, error)
+}
+
+// Preferences lets users hold notifications back, see Worker.
+type Preferences interface {
+	DoNotDisturb(ctx context.Context, userId bson.ObjectID) (bool, error)
+}
+
+type Sender interface {
+	Send(tokens []models.PushToken, notification Notification) error
+}
+
+// Worker delivers notifications in the background so request handlers never
+// wait on the push gateway. When the queue is full notifications are dropped,
+// and so are those for users in do not disturb.
+type Worker struct {
+	queue       chan Notification
+	tokens      TokenStore
+	preferences Preferences
+	sender      Sender
+}
+
+func NewWorker(tokens TokenStore, preferences Preferences, sender Sender, size int) *Worker {
+	return &Worker{queue: make(chan Notification, size), tokens: tokens, preferences: preferences, sender: sender}
+}
+
+func (w *Worker) Notify(notification Notification) {
+	select {
+	case w.queue <- notification:
+	default:
+		log.Println("[WARN] push queue full, notification dropped", notification.UserId.Hex())
+	}
+}
+
+// Len is the number of notifications waiting to be sent.
+func (w *Worker) Len() int {
+	return len(w.queue)
+}
+
+// Run sends queued notifications until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	for {
+		var notification Notification
+		select {
+		case <-ctx.Done():
+			return
+		case notification = <-w.queue:
+		}
+		quiet, err := w.preferences.DoNotDisturb(ctx, notification.UserId)
+		if err != nil {
+			log.Println("[WARN] notification preferences not loaded", notification.UserId.Hex(), err)
+		}
+		if quiet {
+			continue
+		}
+
+		tokens, err := w.tokens.GetPushTokens(ctx, notification.UserId)
+		if err != nil {
+			log.Println("[WARN] push tokens not loaded", notification.UserId.Hex(), err)
+			continue
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		if err := w.sender.Send(tokens, notification); err != nil {
+			log.Println("[WARN] push not sent", notification.UserId.Hex(), err)
+		}
+	}
+}
+
+// GatewaySender posts notifications to a gorush compatible push gateway,
+// which holds the APNs and FCM credentials.
+type GatewaySender struct {
+	URL    string
+	Client *http.Client
+}
+
+type gatewayNotification struct {
+	Tokens   []string          `json:"tokens"`
+	Platform int               `json:"platform"`
+	Title    string            `json:"title,omitempty"`
+	Message  string            `json:"message"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+func (s *GatewaySender) Send(tokens []models.PushToken, notification Notification) error {
+	byPlatform := make(map[int][]string)
+	for _, token := range tokens {
+		platform := 2
+		if token.Platform == models.PlatformIOS {
+			platform = 1
+		}
+		byPlatform[platform] = append(byPlatform[platform], token.Token)
+	}
+
+	var body struct {
+		Notifications []gatewayNotification `json:"notifications"`
+	}
+	for platform, list := range byPlatform {
+		body.Notifications = append(body.Notifications, gatewayNotification{
+			Tokens:   list,
+			Platform: platform,
+			Title:    notification.Title,
+			Message:  notification.Body,
+			Data:     notification.Data,
+		})
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.Client.Post(s.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push gateway returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type LogSender struct{}
+
+func (s *LogSender) Send(tokens []models.PushToken, notification Notification) error {
+	log.Printf("[INFO] push to %s (%d devices): %s", notification.UserId.Hex(), len(tokens), notification.Title)
+	return nil
+}
+
+func NewSender(gatewayURL string) Sender {
+	if gatewayURL == "" {
+		return &LogSender{}
+	}
+	return &GatewaySender{URL: gatewayURL, Client: &http.Client{Timeout: 10 * time.Second}}
+}
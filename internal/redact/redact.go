@@ -0,0 +1,124 @@
+// Package redact strips secrets and personal data from JSON bodies before
+// they are logged. Fields are marked in their struct with `log:"redact"`,
+// the JSON name of every marked field of the types given to New is
+// redacted wherever it appears in a body. Email addresses and secret URL
+// parameters are masked in any string.
+package redact
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values.
+const Mask = "[redacted]"
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// secretParam matches the secret of an otpauth URI and any other URL.
+var secretParam = regexp.MustCompile(`(?i)([?&]secret=)[^&#\s"]*`)
+
+// alwaysRedacted are the names that are redacted without a tag, for the
+// bodies handlers bind to structs of their own.
+var alwaysRedacted = []string{"password", "token", "secret", "recovery_code"}
+
+// exactlyRedacted are redacted without a tag only under exactly this name.
+// device is the device secret of a magic link, as a part of a name it
+// would catch device ids.
+var exactlyRedacted = []string{"device"}
+
+type Redactor struct {
+	keys map[string]bool
+}
+
+// New collects the marked fields of types, which are struct values or
+// pointers to them. Nested structs are followed.
+func New(types ...any) *Redactor {
+	r := &Redactor{keys: map[string]bool{}}
+	for _, name := range exactlyRedacted {
+		r.keys[name] = true
+	}
+	for _, v := range types {
+		r.collect(reflect.TypeOf(v), map[reflect.Type]bool{})
+	}
+	return r
+}
+
+func (r *Redactor) collect(t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		if field.Tag.Get("log") == "redact" && name != "-" {
+			r.keys[strings.ToLower(name)] = true
+		}
+		r.collect(field.Type, seen)
+	}
+}
+
+func (r *Redactor) redacts(key string) bool {
+	key = strings.ToLower(key)
+	if r.keys[key] {
+		return true
+	}
+	for _, word := range alwaysRedacted {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON returns body with the values of redacted fields replaced by Mask and
+// email addresses masked. A body that is not JSON only has its email
+// addresses masked.
+func (r *Redactor) JSON(body []byte) []byte {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []byte(String(string(body)))
+	}
+	redacted, err := json.Marshal(r.value(v))
+	if err != nil {
+		return []byte(Mask)
+	}
+	return redacted
+}
+
+func (r *Redactor) value(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if r.redacts(key) {
+				v[key] = Mask
+			} else {
+				v[key] = r.value(value)
+			}
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = r.value(value)
+		}
+		return v
+	case string:
+		return String(v)
+	}
+	return v
+}
+
+// String masks the email addresses and secret URL parameters in s.
+func String(s string) string {
+	s = secretParam.ReplaceAllString(s, "${1}"+Mask)
+	return emailPattern.ReplaceAllString(s, "[email]")
+}
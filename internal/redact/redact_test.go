@@ -0,0 +1,86 @@
+package redact
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type account struct {
+	Username string   `json:"username"`
+	PIN      string   `json:"pin" log:"redact"`
+	Backup   *backup  `json:"backup"`
+	Devices  []device `json:"devices"`
+}
+
+type backup struct {
+	Phrase string `json:"phrase" log:"redact"`
+}
+
+type device struct {
+	Name string `json:"name"`
+	Key  []byte `json:"identity_key" log:"redact"`
+}
+
+func TestRedactsTaggedFieldsAndEmails(t *testing.T) {
+	r := New(account{})
+	body := `{"username":"ala","pin":"1234","backup":{"phrase":"correct horse"},"devices":[{"name":"phone","identity_key":"AAEC"}],` +
+		`"password":"hunter2","refresh_token":"abc","note":"write to ala@example.com"}`
+
+	var got map[string]any
+	if err := json.Unmarshal(r.JSON([]byte(body)), &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"pin", "password", "refresh_token"} {
+		if got[key] != Mask {
+			t.Errorf("%s = %v, want it redacted", key, got[key])
+		}
+	}
+	if phrase := got["backup"].(map[string]any)["phrase"]; phrase != Mask {
+		t.Errorf("backup phrase = %v, want it redacted", phrase)
+	}
+	if key := got["devices"].([]any)[0].(map[string]any)["identity_key"]; key != Mask {
+		t.Errorf("identity key = %v, want it redacted", key)
+	}
+	if got["username"] != "ala" || got["note"] != "write to [email]" {
+		t.Errorf("Expected the rest kept with the email masked, got %v", got)
+	}
+}
+
+func TestRedactsEmailsOutsideJSON(t *testing.T) {
+	if got := string(New().JSON([]byte("email=ala@example.com&x=1"))); got != "email=[email]&x=1" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRedactsTOTPSecretInURI(t *testing.T) {
+	body := `{"uri":"otpauth://totp/Filagram:ala?secret=JBSWY3DPEHPK3PXP&issuer=Filagram"}`
+	var got map[string]string
+	if err := json.Unmarshal(New().JSON([]byte(body)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["uri"] != "otpauth://totp/Filagram:ala?secret=[redacted]&issuer=Filagram" {
+		t.Errorf("uri = %q, want the secret redacted", got["uri"])
+	}
+}
+
+func TestRedactsRecoveryCodes(t *testing.T) {
+	body := `{"recovery_codes":["K7Q2-9XMD-ABCD-EF12","ABCD-EFGH-IJKL-MNOP"]}`
+	var got map[string]any
+	if err := json.Unmarshal(New().JSON([]byte(body)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["recovery_codes"] != Mask {
+		t.Errorf("recovery_codes = %v, want them redacted", got["recovery_codes"])
+	}
+}
+
+func TestRedactsMagicLinkDevice(t *testing.T) {
+	body := `{"token":"abc","device":"s3cr3t","device_id":"66f1c0ffee"}`
+	var got map[string]any
+	if err := json.Unmarshal(New().JSON([]byte(body)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["device"] != Mask || got["device_id"] != "66f1c0ffee" {
+		t.Errorf("Expected only the device secret redacted, got %v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Result struct {
+	Infected  bool   `json:"infected"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// ClamAVScanner streams files to clamd with the INSTREAM command over a tcp
+// or unix socket.
+type ClamAVScanner struct {
+	Network string
+	Address string
+}
+
+const clamChunkSize = 64 << 10
+
+func (s *ClamAVScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+	chunk := make([]byte, clamChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := conn.Write(append(size, chunk[:n]...)); werr != nil {
+				return Result{}, werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Result{}, err
+	}
+	return parseClamReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamReply understands "stream: OK" and "stream: <signature> FOUND".
+func parseClamReply(reply string) (Result, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// HTTPScanner posts the file to an external scanning service which answers
+// with a JSON encoded Result.
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, r)
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return Result{}, fmt.Errorf("scanner returned %d", resp.StatusCode)
+	}
+
+	var result Result
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&result); err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// NopScanner accepts everything, used when no scanner is configured.
+type NopScanner struct{}
+
+func (NopScanner) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{}, nil
+}
+
+// NewScanner picks a scanner from its address: tcp://host:3310 or
+// unix:///path/to/clamd.sock for clamd, http(s):// for an external service.
+func NewScanner(address string) (Scanner, error) {
+	if address == "" {
+		return NopScanner{}, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		return &ClamAVScanner{Network: "tcp", Address: u.Host}, nil
+	case "unix":
+		return &ClamAVScanner{Network: "unix", Address: u.Path}, nil
+	case "http", "https":
+		return &HTTPScanner{URL: address, Client: &http.Client{Timeout: 2 * time.Minute}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scanner address %q", address)
+	}
+}
@@ -0,0 +1,210 @@
+package spam
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Action string
+
+const (
+	Allow Action = "allow"
+	// Throttle refuses the message, the sender sees a rate limit error.
+	Throttle Action = "throttle"
+	// Shadow accepts the message but drops it, so a spammer gets no signal
+	// that the messages go nowhere.
+	Shadow Action = "shadow"
+)
+
+// Override is set by admins on a user and beats the heuristics.
+type Override string
+
+const (
+	OverrideNone    Override = ""
+	OverrideTrusted Override = "trusted"
+	OverrideLimited Override = "limited"
+)
+
+type Limits struct {
+	PerMinute      int
+	NewRecipients  int
+	Fanout         int
+	ShadowScore    float64
+	ShadowDuration time.Duration
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		PerMinute:      30,
+		NewRecipients:  20,
+		Fanout:         10,
+		ShadowScore:    2,
+		ShadowDuration: time.Hour,
+	}
+}
+
+// Detector scores senders from their recent activity. A score above 1 means
+// a sender went past one of the limits and is throttled, from ShadowScore on
+// the sender is shadow limited for ShadowDuration.
+type Detector struct {
+	Limits Limits
+
+	mu      sync.Mutex
+	senders map[string]*activity
+}
+
+type activity struct {
+	sends         []time.Time
+	newRecipients []time.Time
+	payloads      map[string]*fanout
+	score         float64
+	shadowUntil   time.Time
+}
+
+type fanout struct {
+	recipients map[string]bool
+	last       time.Time
+}
+
+const (
+	rateWindow      = time.Minute
+	recipientWindow = time.Hour
+	fanoutWindow    = 10 * time.Minute
+)
+
+func NewDetector(limits Limits) *Detector {
+	return &Detector{Limits: limits, senders: make(map[string]*activity)}
+}
+
+// SetLimits applies new limits from the next message on.
+func (d *Detector) SetLimits(limits Limits) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Limits = limits
+}
+
+// Observe records one message and returns what to do with it.
+func (d *Detector) Observe(sender, recipient, payloadHash string, newRecipient bool, override Override) (Action, float64) {
+	switch override {
+	case OverrideTrusted:
+		return Allow, 0
+	case OverrideLimited:
+		return Shadow, 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	a := d.senders[sender]
+	if a == nil {
+		a = &activity{payloads: make(map[string]*fanout)}
+		d.senders[sender] = a
+	}
+	a.prune(now)
+
+	a.sends = append(a.sends, now)
+	if newRecipient {
+		a.newRecipients = append(a.newRecipients, now)
+	}
+	f := a.payloads[payloadHash]
+	if f == nil {
+		f = &fanout{recipients: make(map[string]bool)}
+		a.payloads[payloadHash] = f
+	}
+	f.recipients[recipient] = true
+	f.last = now
+
+	a.score = max(
+		float64(len(a.sends))/float64(d.Limits.PerMinute),
+		float64(len(a.newRecipients))/float64(d.Limits.NewRecipients),
+		float64(len(f.recipients))/float64(d.Limits.Fanout),
+	)
+
+	switch {
+	case now.Before(a.shadowUntil):
+		return Shadow, a.score
+	case a.score >= d.Limits.ShadowScore:
+		a.shadowUntil = now.Add(d.Limits.ShadowDuration)
+		return Shadow, a.score
+	case a.score > 1:
+		return Throttle, a.score
+	}
+	return Allow, a.score
+}
+
+func (a *activity) prune(now time.Time) {
+	a.sends = dropBefore(a.sends, now.Add(-rateWindow))
+	a.newRecipients = dropBefore(a.newRecipients, now.Add(-recipientWindow))
+	for hash, f := range a.payloads {
+		if f.last.Before(now.Add(-fanoutWindow)) {
+			delete(a.payloads, hash)
+		}
+	}
+}
+
+func dropBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := sort.Search(len(times), func(i int) bool { return !times[i].Before(cutoff) })
+	return times[i:]
+}
+
+// Reset forgets a sender, used when an admin clears a false positive.
+func (d *Detector) Reset(sender string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.senders, sender)
+}
+
+type Suspect struct {
+	UserId      string    `json:"user_id"`
+	Score       float64   `json:"score"`
+	ShadowUntil time.Time `json:"shadow_until,omitempty"`
+}
+
+// Run drops idle senders so the detector only holds recent activity.
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		d.sweep()
+	}
+}
+
+func (d *Detector) sweep() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for sender, a := range d.senders {
+		a.prune(now)
+		if len(a.sends) == 0 && len(a.newRecipients) == 0 && len(a.payloads) == 0 && now.After(a.shadowUntil) {
+			delete(d.senders, sender)
+		}
+	}
+}
+
+// Suspects lists throttled and shadow limited senders, worst first.
+func (d *Detector) Suspects() []Suspect {
+	d.sweep()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var suspects []Suspect
+	for sender, a := range d.senders {
+		if a.score > 1 || now.Before(a.shadowUntil) {
+			suspects = append(suspects, Suspect{UserId: sender, Score: a.score, ShadowUntil: a.shadowUntil})
+		}
+	}
+	sort.Slice(suspects, func(i, j int) bool { return suspects[i].Score > suspects[j].Score })
+	return suspects
+}
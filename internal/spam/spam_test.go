@@ -0,0 +1,39 @@
+package spam
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFanoutEscalates(t *testing.T) {
+	d := NewDetector(Limits{PerMinute: 1000, NewRecipients: 1000, Fanout: 2, ShadowScore: 2, ShadowDuration: 1 << 40})
+
+	var actions []Action
+	for i := 0; i < 5; i++ {
+		action, _ := d.Observe("spammer", strconv.Itoa(i), "same", false, OverrideNone)
+		actions = append(actions, action)
+	}
+
+	want := []Action{Allow, Allow, Throttle, Shadow, Shadow}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("message %d: got %s, want %s", i, actions[i], want[i])
+		}
+	}
+	if len(d.Suspects()) != 1 {
+		t.Fatal("spammer not listed as suspect")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	d := NewDetector(Limits{PerMinute: 1, NewRecipients: 1, Fanout: 1, ShadowScore: 2})
+
+	for i := 0; i < 5; i++ {
+		if action, _ := d.Observe("trusted", "peer", "hash", true, OverrideTrusted); action != Allow {
+			t.Fatalf("trusted sender got %s", action)
+		}
+	}
+	if action, _ := d.Observe("limited", "peer", "hash", false, OverrideLimited); action != Shadow {
+		t.Fatalf("limited sender got %s", action)
+	}
+}
@@ -0,0 +1,139 @@
+// Package wire holds the encodings clients may ask for instead of JSON.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ContentTypeCBOR is set on publishes carrying CBOR, for MQTT 5 clients
+// that read content types.
+const ContentTypeCBOR = "application/cbor"
+
+// CBOR major types, RFC 8949 section 3.1.
+const (
+	majorUint   = 0 << 5
+	majorNegint = 1 << 5
+	majorText   = 3 << 5
+	majorArray  = 4 << 5
+	majorMap    = 5 << 5
+)
+
+// JSONToCBOR transcodes a JSON document to CBOR. Map keys are sorted the
+// way RFC 8949 deterministic encoding does, integers take the shortest
+// form and floats shrink to single precision where that is exact, so the
+// same JSON always gives the same bytes. Times and ids stay text, as they
+// are in JSON.
+func JSONToCBOR(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after json value")
+	}
+	var out bytes.Buffer
+	if err := encode(&out, value); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func encode(out *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case nil:
+		out.WriteByte(0xf6)
+	case bool:
+		if v {
+			out.WriteByte(0xf5)
+		} else {
+			out.WriteByte(0xf4)
+		}
+	case string:
+		writeHead(out, majorText, uint64(len(v)))
+		out.WriteString(v)
+	case json.Number:
+		return encodeNumber(out, v)
+	case []any:
+		writeHead(out, majorArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encode(out, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// encoded text keys sort by length first, then bytewise
+		slices.SortFunc(keys, func(a, b string) int {
+			if len(a) != len(b) {
+				return len(a) - len(b)
+			}
+			return strings.Compare(a, b)
+		})
+		writeHead(out, majorMap, uint64(len(v)))
+		for _, key := range keys {
+			writeHead(out, majorText, uint64(len(key)))
+			out.WriteString(key)
+			if err := encode(out, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("unexpected json value")
+	}
+	return nil
+}
+
+func encodeNumber(out *bytes.Buffer, number json.Number) error {
+	if i, err := number.Int64(); err == nil {
+		if i >= 0 {
+			writeHead(out, majorUint, uint64(i))
+		} else {
+			writeHead(out, majorNegint, uint64(-(i + 1)))
+		}
+		return nil
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return err
+	}
+	if float64(float32(f)) == f {
+		out.WriteByte(0xfa)
+		out.Write(binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(f))))
+		return nil
+	}
+	out.WriteByte(0xfb)
+	out.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	return nil
+}
+
+// writeHead writes a major type with its argument in the shortest form.
+func writeHead(out *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		out.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		out.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		out.WriteByte(major | 25)
+		out.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		out.WriteByte(major | 26)
+		out.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		out.WriteByte(major | 27)
+		out.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
@@ -0,0 +1,67 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Expected encodings are from RFC 8949 appendix A. Half precision floats
+// are never written, so those examples are left out.
+func TestJSONToCBORMatchesRFC(t *testing.T) {
+	for json, want := range map[string]string{
+		`0`:                    "00",
+		`23`:                   "17",
+		`24`:                   "1818",
+		`1000`:                 "1903e8",
+		`1000000`:              "1a000f4240",
+		`1000000000000`:        "1b000000e8d4a51000",
+		`-1`:                   "20",
+		`-1000`:                "3903e7",
+		`100000.0`:             "fa47c35000",
+		`1.1`:                  "fb3ff199999999999a",
+		`false`:                "f4",
+		`true`:                 "f5",
+		`null`:                 "f6",
+		`""`:                   "60",
+		`"IETF"`:               "6449455446",
+		`"ü"`:                  "62c3bc",
+		`[]`:                   "80",
+		`[1,[2,3],[4,5]]`:      "8301820203820405",
+		`{}`:                   "a0",
+		`{"a":1,"b":[2,3]}`:    "a26161016162820203",
+		`{"b":0,"a":1,"aa":2}`: "a361610161620062616102",
+	} {
+		got, err := JSONToCBOR([]byte(json))
+		if err != nil {
+			t.Errorf("%s: %v", json, err)
+			continue
+		}
+		if hex.EncodeToString(got) != want {
+			t.Errorf("%s: got %x, want %s", json, got, want)
+		}
+	}
+}
+
+func TestJSONToCBORIsSmaller(t *testing.T) {
+	payload := []byte(`{"id":"65f1a2b3c4d5e6f708192a3b","sender_id":"65f1a2b3c4d5e6f708192a3c","type":"message","read":false,"content":"hi","timestamp":"2026-10-15T12:00:00Z"}`)
+	got, err := JSONToCBOR(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) >= len(payload) {
+		t.Fatalf("cbor %d bytes, json %d", len(got), len(payload))
+	}
+	again, _ := JSONToCBOR(payload)
+	if !bytes.Equal(got, again) {
+		t.Fatal("encoding not deterministic")
+	}
+}
+
+func TestJSONToCBORRejectsInvalid(t *testing.T) {
+	for _, json := range []string{``, `{`, `{"a":1}{}`, `[1,]`} {
+		if _, err := JSONToCBOR([]byte(json)); err == nil {
+			t.Errorf("%q accepted", json)
+		}
+	}
+}
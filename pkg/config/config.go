@@ -1,13 +1,309 @@
 package config
 
 import (
+	"github.com/joho/godotenv"
+	"github.com/labstack/gommon/bytes"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	BrokerAdress string
 	ClientID     string
 	DatabaseURL  string
+	// DatabaseName is the database the server keeps its collections in.
+	DatabaseName string
+
+	// DBTimeout bounds a single database query and DBBatchTimeout work over
+	// many documents such as purges and exports.
+	DBTimeout      time.Duration
+	DBBatchTimeout time.Duration
+
+	// Pool and retry settings of the MongoDB client. DBReadPreference is a
+	// mode name such as primary or secondaryPreferred. At startup the
+	// server is pinged up to DBConnectAttempts times, starting
+	// DBConnectBackoff apart and doubling.
+	DBMaxPoolSize            int64
+	DBMinPoolSize            int64
+	DBServerSelectionTimeout time.Duration
+	DBRetryWrites            bool
+	DBReadPreference         string
+	DBConnectAttempts        int64
+	DBConnectBackoff         time.Duration
+
+	// DBTransactions wraps writes across collections, such as purging a
+	// user, in transactions. They are left off anyway against a
+	// standalone server, which has none, see database.UseTransactions.
+	DBTransactions bool
+
+	// BrokerMode is "embedded" to run the MQTT broker in process or "bridge"
+	// to publish through an external one at BrokerAdress, which authenticates
+	// clients against /mqtt/auth and /mqtt/acl.
+	BrokerMode     string
+	BridgeUsername string
+	BridgePassword string
+
+	// MQTTMaxPendingWrites is how many publishes may queue for one client
+	// before the broker drops further ones, see hooks.DeliveryHook.
+	MQTTMaxPendingWrites  int64
+	MQTTTopicAliasMaximum int64
+	// MQTTMaxInflight bounds the unacknowledged QoS 1 and 2 publishes per
+	// client, each way
+	MQTTMaxInflight int64
+	// MQTTMaxConnections bounds connections to the broker, 0 for no limit
+	MQTTMaxConnections        int64
+	MQTTMaxConnectionsPerUser int64
+	// clients with MQTTSlowInflight unacknowledged publishes for
+	// MQTTSlowGrace are evicted, see hooks.LimitsHook
+	MQTTSlowInflight int64
+	MQTTSlowGrace    time.Duration
+	// MQTTQoS overrides the QoS of event classes, see broker.ParseQoS
+	MQTTQoS []string
+	// On shutdown the broker drains for up to MQTTDrainTimeout: clients are
+	// told to reconnect to MQTTDrainServer, the address of the load
+	// balancer or of another instance, and their unacknowledged QoS 1
+	// publishes are let finish before they are disconnected.
+	MQTTDrainServer  string
+	MQTTDrainTimeout time.Duration
+
+	AllowOrigins      []string
+	CSRFEnabled       bool
+	SessionCookieMode bool
+
+	WebAuthnRPID          string
+	WebAuthnRPDisplayName string
+	WebAuthnRPOrigins     []string
+
+	TokenIssuer         string
+	TokenTrustedIssuers []string
+	TokenAudience       string
+
+	UsernameChangeCooldown time.Duration
+	UsernameHoldPeriod     time.Duration
+	// UsernameBlockedWords and the words in UsernameBlockedWordsFile may not
+	// appear in usernames, also spelled with lookalike letters.
+	UsernameBlockedWords      []string
+	UsernameBlockedWordsFile  string
+	UsernameAllowMixedScripts bool
+
+	Environment string
+
+	PublicURL    string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailProvider string
+	MailDir      string
+
+	SendGridAPIKey     string
+	SESRegion          string
+	SESAccessKeyID     string
+	SESSecretAccessKey string
+
+	// Email to username@EmailGatewayDomain is posted to /email/inbound by
+	// the inbound mail provider and arrives as a message from the user
+	// named EmailGatewayUsername. Replies go out through the mail sender.
+	EmailGatewayDomain   string
+	EmailGatewaySecret   string
+	EmailGatewayUsername string
+
+	// TrustedProxies are the networks of the load balancers in front of the
+	// server, client addresses are taken from X-Forwarded-For past them.
+	// Without any, the address of the connection is the client's.
+	TrustedProxies []string
+
+	IPAllowList         []string
+	IPDenyList          []string
+	GeoIPDatabase       string
+	GeoBlockedCountries []string
+
+	PushGatewayURL string
+
+	DefaultEncryption string
+	KMSMasterKey      string
+	KMSVaultAddress   string
+	KMSVaultToken     string
+	KMSVaultKey       string
+	FieldEncryption   bool
+
+	RedisURL    string
+	PresenceTTL time.Duration
+
+	DefaultLocale string
+
+	RetentionInterval time.Duration
+
+	MetricsPassword string
+	StatsInterval   time.Duration
+
+	TrashGracePeriod time.Duration
+
+	MaintenanceMode bool
+
+	StorageDir        string
+	StorageQuota      int64
+	MaxAttachmentSize int64
+	MaxImportSize     int64
+	ScannerAddress    string
+	ScanQuarantine    bool
+	UploadExpiry      time.Duration
+	MediaURLSecret    string
+	MediaURLTTL       time.Duration
+
+	ContactTokenSecret string
+	ContactTokenTTL    time.Duration
+
+	// MQTTClientIDSecret signs the client ids handed out by /mqtt/token,
+	// hex encoded.
+	MQTTClientIDSecret string
+
+	ModerationKeywords      []string
+	ModerationKeywordAction string
+	ModerationPatternsFile  string
+	ModerationPatternAction string
+	ModerationClassifierURL string
+
+	ConfigFile     string
+	ReloadInterval time.Duration
+
+	KeygenOnFirstRun bool
+
+	// The API is served over TLS with certificates from Let's Encrypt,
+	// cached in AutoTLSCacheDir so restarts reuse them. AutoTLSHosts limits
+	// the hosts certificates are requested for, which should be set in
+	// production. HTTPRedirectAddress, e.g. :80, answers ACME challenges
+	// and redirects plain HTTP to HTTPS.
+	HTTPAddress         string
+	HTTPRedirectAddress string
+	AutoTLSCacheDir     string
+	AutoTLSHosts        []string
+	AutoTLSEmail        string
+
+	// The web client is served from StaticDir, or from the copy built into
+	// the binary with StaticEmbed. Files other than index.html and hashed
+	// assets are cached for StaticMaxAge.
+	StaticDir    string
+	StaticEmbed  bool
+	StaticMaxAge time.Duration
+
+	HTTP2Enabled              bool
+	HTTP2MaxConcurrentStreams int64
+	HTTPKeepAlive             bool
+	HTTPReadHeaderTimeout     time.Duration
+	HTTPIdleTimeout           time.Duration
+	// ShutdownTimeout is how long requests in flight may take to finish
+	// when the server is told to stop.
+	ShutdownTimeout time.Duration
+
+	// SentryDSN, when set, sends reports of recovered panics to Sentry
+	// besides the log.
+	SentryDSN string
+
+	// Federation lets users of other servers message users of this one as
+	// username@FederationDomain. FederationAllowedServers, when set, limits
+	// the servers accepted.
+	FederationEnabled        bool
+	FederationDomain         string
+	FederationAllowedServers []string
+	FederationRetryInterval  time.Duration
+	FederationMaxAttempts    int64
+
+	Hot
+}
+
+// Hot holds the settings that are safe to change while the server runs,
+// see Watcher. Everything else in Config needs a restart.
+type Hot struct {
+	// BodyLimit bounds request bodies, AuthBodyLimit those of sign-in and
+	// the other routes served without a token. See api.BodyLimit for the
+	// routes with limits of their own.
+	BodyLimit     int64
+	AuthBodyLimit int64
+	LogLevel      string
+	// LogBodies logs the JSON bodies of requests and responses for
+	// debugging, with secrets and email addresses redacted.
+	LogBodies bool
+
+	// PaddingBuckets are the sizes the content of messages is padded to
+	// before it is stored and relayed, see padding.Buckets. None turn
+	// padding off.
+	PaddingBuckets []int64
+
+	// Requests fail with 504 after RequestTimeout, sign-in after
+	// AuthRequestTimeout and exports and imports after LongRequestTimeout.
+	// See api.Timeout for the routes.
+	RequestTimeout     time.Duration
+	AuthRequestTimeout time.Duration
+	LongRequestTimeout time.Duration
+
+	ExportRateInterval time.Duration
+	ExportRateBurst    int64
+
+	// A user fetches keys in bulk at most KeyFetchRateBurst times per
+	// KeyFetchRateInterval, each fetch takes one-time prekeys of others.
+	KeyFetchRateInterval time.Duration
+	KeyFetchRateBurst    int64
+
+	// TwoFactorMaxFailures wrong codes in a row lock two factor of an
+	// account for TwoFactorLockout, so codes cannot be guessed.
+	TwoFactorMaxFailures int64
+	TwoFactorLockout     time.Duration
+
+	RetentionMaxAge             time.Duration
+	RetentionMaxPerConversation int64
+	RetentionDryRun             bool
+
+	SpamMessagesPerMinute    int64
+	SpamNewRecipientsPerHour int64
+	SpamFanout               int64
+
+	InviteOnly     bool
+	InvitesPerUser int64
+	InviteTTL      time.Duration
+
+	// Sign-in sessions last SessionTTL. With SessionSliding every refresh
+	// extends them by SessionTTL again, up to SessionMaxAge after sign-in.
+	// A session not refreshed for SessionIdleTimeout ends early, 0 keeps
+	// idle sessions alive.
+	SessionTTL         time.Duration
+	SessionSliding     bool
+	SessionMaxAge      time.Duration
+	SessionIdleTimeout time.Duration
+
+	// Support sessions let an admin look at the account of a user for
+	// SupportSessionTTL. An admin starts at most SupportSessionRateBurst
+	// of them per SupportSessionRateInterval.
+	SupportSessionTTL          time.Duration
+	SupportSessionRateInterval time.Duration
+	SupportSessionRateBurst    int64
+
+	// Features that can be switched off, their routes answer 404 and
+	// GET /capabilities tells clients to hide them.
+	FeatureGroups   bool
+	FeatureCalls    bool
+	FeatureChannels bool
+
+	// Clients older than MinClientVersion are refused with 426, those
+	// older than RecommendedClientVersion are told to upgrade. Empty turns
+	// the check off, see imiddleware.ClientVersion.
+	MinClientVersion         string
+	RecommendedClientVersion string
+}
+
+func Load() *Config {
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		processEnv[key] = true
+	}
+	_ = godotenv.Load()
+	cfg := newConfig()
+	loaded := cfg.Hot
+	fromEnv.Store(&loaded)
+	current.Store(cfg)
+	return cfg
 }
 
 func newConfig() *Config {
@@ -15,6 +311,207 @@ func newConfig() *Config {
 		BrokerAdress: getEnv("MQTT_BROKER_ADDRESS", "tcp://localhost:1883"),
 		ClientID:     getEnv("MQTT_CLIENT_ID", "chat-server"),
 		DatabaseURL:  getEnv("DATABASE_URL", "mongodb://localhost:27017"),
+		DatabaseName: getEnv("DATABASE_NAME", "filagram"),
+
+		DBTimeout:      getEnvDuration("DB_TIMEOUT", 5*time.Second),
+		DBBatchTimeout: getEnvDuration("DB_BATCH_TIMEOUT", time.Minute),
+
+		DBMaxPoolSize:            getEnvInt("DB_MAX_POOL_SIZE", 100),
+		DBMinPoolSize:            getEnvInt("DB_MIN_POOL_SIZE", 0),
+		DBServerSelectionTimeout: getEnvDuration("DB_SERVER_SELECTION_TIMEOUT", 10*time.Second),
+		DBRetryWrites:            getEnvBool("DB_RETRY_WRITES", true),
+		DBReadPreference:         getEnv("DB_READ_PREFERENCE", "primary"),
+		DBConnectAttempts:        getEnvInt("DB_CONNECT_ATTEMPTS", 5),
+		DBConnectBackoff:         getEnvDuration("DB_CONNECT_BACKOFF", time.Second),
+		DBTransactions:           getEnvBool("DB_TRANSACTIONS", true),
+
+		BrokerMode:     getEnv("MQTT_BROKER_MODE", "embedded"),
+		BridgeUsername: getEnv("MQTT_BRIDGE_USERNAME", "chat-server"),
+		BridgePassword: getEnv("MQTT_BRIDGE_PASSWORD", ""),
+
+		MQTTMaxPendingWrites:  getEnvInt("MQTT_MAX_PENDING_WRITES", 8192),
+		MQTTTopicAliasMaximum: getEnvInt("MQTT_TOPIC_ALIAS_MAXIMUM", 1024),
+		MQTTMaxInflight:       getEnvInt("MQTT_MAX_INFLIGHT", 1024),
+		MQTTQoS:               getEnvList("MQTT_QOS", nil),
+		MQTTDrainServer:       getEnv("MQTT_DRAIN_SERVER", ""),
+		MQTTDrainTimeout:      getEnvDuration("MQTT_DRAIN_TIMEOUT", 20*time.Second),
+
+		MQTTMaxConnections:        getEnvInt("MQTT_MAX_CONNECTIONS", 0),
+		MQTTMaxConnectionsPerUser: getEnvInt("MQTT_MAX_CONNECTIONS_PER_USER", 10),
+		MQTTSlowInflight:          getEnvInt("MQTT_SLOW_INFLIGHT", 512),
+		MQTTSlowGrace:             getEnvDuration("MQTT_SLOW_GRACE", 30*time.Second),
+
+		AllowOrigins:      getEnvList("CORS_ALLOW_ORIGINS", []string{"https://filagram.pl"}),
+		CSRFEnabled:       getEnvBool("CSRF_ENABLED", true),
+		SessionCookieMode: getEnvBool("SESSION_COOKIE_MODE", false),
+
+		WebAuthnRPID:          getEnv("WEBAUTHN_RP_ID", "filagram.pl"),
+		WebAuthnRPDisplayName: getEnv("WEBAUTHN_RP_DISPLAY_NAME", "Filagram"),
+		WebAuthnRPOrigins:     getEnvList("WEBAUTHN_RP_ORIGINS", []string{"https://filagram.pl"}),
+
+		TokenIssuer:         getEnv("TOKEN_ISSUER", "https://auth.filagram.pl"),
+		TokenTrustedIssuers: getEnvList("TOKEN_TRUSTED_ISSUERS", nil),
+		TokenAudience:       getEnv("TOKEN_AUDIENCE", ""),
+
+		UsernameChangeCooldown:    getEnvDuration("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour),
+		UsernameHoldPeriod:        getEnvDuration("USERNAME_HOLD_PERIOD", 14*24*time.Hour),
+		UsernameBlockedWords:      getEnvList("USERNAME_BLOCKED_WORDS", nil),
+		UsernameBlockedWordsFile:  getEnv("USERNAME_BLOCKED_WORDS_FILE", ""),
+		UsernameAllowMixedScripts: getEnvBool("USERNAME_ALLOW_MIXED_SCRIPTS", false),
+
+		Environment: getEnv("ENVIRONMENT", "production"),
+
+		PublicURL:    getEnv("PUBLIC_URL", "https://filagram.pl"),
+		SMTPAddr:     getEnv("SMTP_ADDR", ""),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", defaultMailFrom(getEnv("ENVIRONMENT", "production"))),
+		MailProvider: getEnv("MAIL_PROVIDER", ""),
+		MailDir:      getEnv("MAIL_DIR", "mail-out"),
+
+		SendGridAPIKey:     getEnv("SENDGRID_API_KEY", ""),
+		SESRegion:          getEnv("SES_REGION", "eu-central-1"),
+		SESAccessKeyID:     getEnv("SES_ACCESS_KEY_ID", ""),
+		SESSecretAccessKey: getEnv("SES_SECRET_ACCESS_KEY", ""),
+
+		EmailGatewayDomain:   getEnv("EMAIL_GATEWAY_DOMAIN", ""),
+		EmailGatewaySecret:   getEnv("EMAIL_GATEWAY_SECRET", ""),
+		EmailGatewayUsername: getEnv("EMAIL_GATEWAY_USERNAME", "email"),
+
+		TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
+
+		IPAllowList:         getEnvList("IP_ALLOW_LIST", nil),
+		IPDenyList:          getEnvList("IP_DENY_LIST", nil),
+		GeoIPDatabase:       getEnv("GEOIP_DATABASE", ""),
+		GeoBlockedCountries: getEnvList("GEO_BLOCKED_COUNTRIES", nil),
+
+		PushGatewayURL: getEnv("PUSH_GATEWAY_URL", ""),
+
+		DefaultEncryption: getEnv("DEFAULT_ENCRYPTION", "e2e"),
+		KMSMasterKey:      getEnv("KMS_MASTER_KEY", ""),
+		KMSVaultAddress:   getEnv("KMS_VAULT_ADDRESS", ""),
+		KMSVaultToken:     getEnv("KMS_VAULT_TOKEN", ""),
+		KMSVaultKey:       getEnv("KMS_VAULT_KEY", "filagram"),
+		FieldEncryption:   getEnvBool("FIELD_ENCRYPTION", true),
+
+		RedisURL:    getEnv("REDIS_URL", ""),
+		PresenceTTL: getEnvDuration("PRESENCE_TTL", time.Minute),
+
+		DefaultLocale: getEnv("DEFAULT_LOCALE", "en"),
+
+		RetentionInterval: getEnvDuration("RETENTION_INTERVAL", time.Hour),
+
+		MetricsPassword: getEnv("METRICS_PASSWORD", ""),
+		StatsInterval:   getEnvDuration("STATS_INTERVAL", 5*time.Minute),
+
+		TrashGracePeriod: getEnvDuration("TRASH_GRACE_PERIOD", 30*24*time.Hour),
+
+		MaintenanceMode: getEnvBool("MAINTENANCE_MODE", false),
+
+		StorageDir:        getEnv("STORAGE_DIR", "./storage"),
+		StorageQuota:      getEnvInt("STORAGE_QUOTA_BYTES", 1<<30),
+		MaxAttachmentSize: getEnvInt("MAX_ATTACHMENT_SIZE_BYTES", 100<<20),
+		MaxImportSize:     getEnvInt("MAX_IMPORT_SIZE_BYTES", 50<<20),
+		ScannerAddress:    getEnv("SCANNER_ADDRESS", ""),
+		ScanQuarantine:    getEnvBool("SCAN_QUARANTINE", false),
+		UploadExpiry:      getEnvDuration("UPLOAD_EXPIRY", 24*time.Hour),
+		MediaURLSecret:    getEnv("MEDIA_URL_SECRET", ""),
+		MediaURLTTL:       getEnvDuration("MEDIA_URL_TTL", 5*time.Minute),
+
+		FederationEnabled:        getEnvBool("FEDERATION_ENABLED", false),
+		FederationDomain:         getEnv("FEDERATION_DOMAIN", ""),
+		FederationAllowedServers: getEnvList("FEDERATION_ALLOWED_SERVERS", nil),
+		FederationRetryInterval:  getEnvDuration("FEDERATION_RETRY_INTERVAL", 30*time.Second),
+		FederationMaxAttempts:    getEnvInt("FEDERATION_MAX_ATTEMPTS", 20),
+
+		ContactTokenSecret: getEnv("CONTACT_TOKEN_SECRET", ""),
+		ContactTokenTTL:    getEnvDuration("CONTACT_TOKEN_TTL", 30*24*time.Hour),
+
+		MQTTClientIDSecret: getEnv("MQTT_CLIENT_ID_SECRET", ""),
+
+		ModerationKeywords:      getEnvList("MODERATION_KEYWORDS", nil),
+		ModerationKeywordAction: getEnv("MODERATION_KEYWORD_ACTION", "hold"),
+		ModerationPatternsFile:  getEnv("MODERATION_PATTERNS_FILE", ""),
+		ModerationPatternAction: getEnv("MODERATION_PATTERN_ACTION", "reject"),
+		ModerationClassifierURL: getEnv("MODERATION_CLASSIFIER_URL", ""),
+
+		ConfigFile:     getEnv("CONFIG_FILE", ".env"),
+		ReloadInterval: getEnvDuration("CONFIG_RELOAD_INTERVAL", 30*time.Second),
+
+		KeygenOnFirstRun: getEnvBool("KEYGEN_ON_FIRST_RUN", false),
+
+		HTTPAddress:         getEnv("HTTP_ADDRESS", "0.0.0.0:8080"),
+		HTTPRedirectAddress: getEnv("HTTP_REDIRECT_ADDRESS", ""),
+		AutoTLSCacheDir:     getEnv("AUTOTLS_CACHE_DIR", "secrets/autocert"),
+		AutoTLSHosts:        getEnvList("AUTOTLS_HOSTS", nil),
+		AutoTLSEmail:        getEnv("AUTOTLS_EMAIL", ""),
+
+		StaticDir:    getEnv("STATIC_DIR", "./public"),
+		StaticEmbed:  getEnvBool("STATIC_EMBED", false),
+		StaticMaxAge: getEnvDuration("STATIC_MAX_AGE", time.Hour),
+
+		HTTP2Enabled:              getEnvBool("HTTP2_ENABLED", true),
+		HTTP2MaxConcurrentStreams: getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 250),
+		HTTPKeepAlive:             getEnvBool("HTTP_KEEP_ALIVE", true),
+		HTTPReadHeaderTimeout:     getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		HTTPIdleTimeout:           getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		ShutdownTimeout:           getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		SentryDSN: getEnv("SENTRY_DSN", ""),
+
+		Hot: newHot(),
+	}
+}
+
+func newHot() Hot {
+	return Hot{
+		BodyLimit:     getEnvBytes("BODY_LIMIT", "1M"),
+		AuthBodyLimit: getEnvBytes("AUTH_BODY_LIMIT", "16K"),
+		LogLevel:      getEnv("LOG_LEVEL", "info"),
+		LogBodies:     getEnvBool("LOG_BODIES", false),
+
+		PaddingBuckets: getEnvSizes("PADDING_BUCKETS", nil),
+
+		RequestTimeout:     getEnvDuration("REQUEST_TIMEOUT", 15*time.Second),
+		AuthRequestTimeout: getEnvDuration("AUTH_REQUEST_TIMEOUT", 5*time.Second),
+		LongRequestTimeout: getEnvDuration("LONG_REQUEST_TIMEOUT", 2*time.Minute),
+
+		ExportRateInterval: getEnvDuration("EXPORT_RATE_INTERVAL", 10*time.Minute),
+		ExportRateBurst:    getEnvInt("EXPORT_RATE_BURST", 3),
+
+		KeyFetchRateInterval: getEnvDuration("KEY_FETCH_RATE_INTERVAL", 6*time.Second),
+		KeyFetchRateBurst:    getEnvInt("KEY_FETCH_RATE_BURST", 10),
+
+		TwoFactorMaxFailures: getEnvInt("TWO_FACTOR_MAX_FAILURES", 5),
+		TwoFactorLockout:     getEnvDuration("TWO_FACTOR_LOCKOUT", 15*time.Minute),
+
+		RetentionMaxAge:             getEnvDuration("RETENTION_MAX_AGE", 0),
+		RetentionMaxPerConversation: getEnvInt("RETENTION_MAX_PER_CONVERSATION", 0),
+		RetentionDryRun:             getEnvBool("RETENTION_DRY_RUN", false),
+
+		SpamMessagesPerMinute:    getEnvInt("SPAM_MESSAGES_PER_MINUTE", 30),
+		SpamNewRecipientsPerHour: getEnvInt("SPAM_NEW_RECIPIENTS_PER_HOUR", 20),
+		SpamFanout:               getEnvInt("SPAM_FANOUT", 10),
+
+		InviteOnly:     getEnvBool("INVITE_ONLY", false),
+		InvitesPerUser: getEnvInt("INVITES_PER_USER", 5),
+		InviteTTL:      getEnvDuration("INVITE_TTL", 14*24*time.Hour),
+
+		SessionTTL:         getEnvDuration("SESSION_TTL", 7*24*time.Hour),
+		SessionSliding:     getEnvBool("SESSION_SLIDING", false),
+		SessionMaxAge:      getEnvDuration("SESSION_MAX_AGE", 30*24*time.Hour),
+		SessionIdleTimeout: getEnvDuration("SESSION_IDLE_TIMEOUT", 0),
+
+		SupportSessionTTL:          getEnvDuration("SUPPORT_SESSION_TTL", 30*time.Minute),
+		SupportSessionRateInterval: getEnvDuration("SUPPORT_SESSION_RATE_INTERVAL", 10*time.Minute),
+		SupportSessionRateBurst:    getEnvInt("SUPPORT_SESSION_RATE_BURST", 5),
+
+		FeatureGroups:   getEnvBool("FEATURE_GROUPS", true),
+		FeatureCalls:    getEnvBool("FEATURE_CALLS", true),
+		FeatureChannels: getEnvBool("FEATURE_CHANNELS", true),
+
+		MinClientVersion:         getEnv("MIN_CLIENT_VERSION", ""),
+		RecommendedClientVersion: getEnv("RECOMMENDED_CLIENT_VERSION", ""),
 	}
 }
 
@@ -24,3 +521,76 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, strconv.FormatBool(defaultValue)))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, defaultValue.String()))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvInt(key string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(getEnv(key, strconv.FormatInt(defaultValue, 10)), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvBytes accepts sizes the way echo's body limit does, e.g. 512K or 4M.
+func getEnvBytes(key string, defaultValue string) int64 {
+	size, err := bytes.Parse(getEnv(key, defaultValue))
+	if err != nil {
+		size, _ = bytes.Parse(defaultValue)
+	}
+	return size
+}
+
+// getEnvSizes reads a list of sizes the way getEnvBytes does, e.g.
+// 256,1K,4K. Sizes that do not parse are skipped.
+func getEnvSizes(key string, defaultValue []int64) []int64 {
+	items := getEnvList(key, nil)
+	if items == nil {
+		return defaultValue
+	}
+	var sizes []int64
+	for _, item := range items {
+		if size, err := bytes.Parse(item); err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// defaultMailFrom gives staging and development setups their own sender,
+// so recipients and filters can tell their mail from production mail.
+func defaultMailFrom(environment string) string {
+	if environment == "production" {
+		return "no-reply@filagram.pl"
+	}
+	return "no-reply@" + environment + ".filagram.pl"
+}
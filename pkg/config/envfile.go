@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// AppendEnv adds entries to the end of an env file, creating it readable by
+// the owner only. The file is appended to rather than rewritten so comments
+// and ordering survive.
+func AppendEnv(path string, entries [][2]string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// make sure the first entry starts on its own line
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := fmt.Fprintln(file); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(file, "%s=%s\n", entry[0], entry[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
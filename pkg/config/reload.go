@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"github.com/joho/godotenv"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	current atomic.Pointer[Config]
+	// fromEnv is what the environment said about Hot settings at the last
+	// load or reload. Reload compares against it, not against Current, so
+	// changes made to Current in code stay until the environment changes.
+	fromEnv atomic.Pointer[Hot]
+	// processEnv remembers what was set before the config file was read, the
+	// real environment keeps winning over the file on reload as it did on
+	// start.
+	processEnv = map[string]bool{}
+)
+
+// Current returns the live configuration. Code reading Hot settings should
+// go through Current instead of holding on to the Config from Load.
+func Current() *Config {
+	return current.Load()
+}
+
+// Use makes cfg the live configuration, for programs that build or change
+// their Config in code instead of taking it from Load as is.
+func Use(cfg *Config) {
+	current.Store(cfg)
+}
+
+// SettingsSource returns overrides keyed like the environment variables,
+// e.g. a settings document in the database. They win over the config file.
+type SettingsSource func() (map[string]string, error)
+
+// Watcher polls the config file and the settings source and applies changes
+// to Hot settings without a restart. Changes to anything else are ignored
+// until the next start, so the broker and its sessions keep running.
+type Watcher struct {
+	Path     string
+	Settings SettingsSource
+	Interval time.Duration
+
+	mu        sync.Mutex
+	listeners []func(*Config)
+}
+
+// OnChange registers fn to be called with the new configuration after Hot
+// settings changed.
+func (w *Watcher) OnChange(fn func(*Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, fn)
+}
+
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.Reload(); err != nil {
+			log.Println("[WARN] config reload failed", err)
+		}
+	}
+}
+
+func (w *Watcher) Reload() error {
+	values := map[string]string{}
+	if w.Path != "" {
+		file, err := godotenv.Read(w.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for key, value := range file {
+			if !processEnv[key] {
+				values[key] = value
+			}
+		}
+	}
+	if w.Settings != nil {
+		settings, err := w.Settings()
+		if err != nil {
+			return err
+		}
+		for key, value := range settings {
+			values[key] = value
+		}
+	}
+	for key, value := range values {
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hot := newHot()
+	// Hot holds slices, == does not compile for it
+	if last := fromEnv.Load(); last != nil && reflect.DeepEqual(hot, *last) {
+		return nil
+	}
+	fromEnv.Store(&hot)
+	next := *Current()
+	next.Hot = hot
+	current.Store(&next)
+
+	log.Printf("[INFO] config reloaded: %+v", hot)
+	for _, fn := range w.listeners {
+		fn(&next)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatcherAppliesHotSettings(t *testing.T) {
+	t.Setenv("BODY_LIMIT", "1M")
+	t.Setenv("STORAGE_DIR", "./storage")
+	current.Store(newConfig())
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("BODY_LIMIT=2M\nSTORAGE_DIR=/elsewhere\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var notified *Config
+	w := &Watcher{Path: path}
+	w.OnChange(func(cfg *Config) { notified = cfg })
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if Current().BodyLimit != 2_000_000 {
+		t.Fatalf("body limit = %d, want 2M", Current().BodyLimit)
+	}
+	if Current().StorageDir != "./storage" {
+		t.Fatalf("storage dir changed to %q without a restart", Current().StorageDir)
+	}
+	if notified != Current() {
+		t.Fatal("listener not called with the new config")
+	}
+}
+
+func TestWatcherSettingsOverrideFile(t *testing.T) {
+	t.Setenv("SPAM_FANOUT", "10")
+	current.Store(newConfig())
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("SPAM_FANOUT=20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w := &Watcher{Path: path, Settings: func() (map[string]string, error) {
+		return map[string]string{"SPAM_FANOUT": "5"}, nil
+	}}
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if Current().SpamFanout != 5 {
+		t.Fatalf("spam fanout = %d, want 5", Current().SpamFanout)
+	}
+}
+
+func TestWatcherKeepsChangesMadeInCode(t *testing.T) {
+	t.Setenv("SPAM_FANOUT", "10")
+	cfg := newConfig()
+	loaded := cfg.Hot
+	fromEnv.Store(&loaded)
+	cfg.SpamFanout = 3
+	Use(cfg)
+
+	path := filepath.Join(t.TempDir(), ".env")
+	w := &Watcher{Path: path}
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if Current().SpamFanout != 3 {
+		t.Fatalf("spam fanout = %d, want 3 until the environment changes", Current().SpamFanout)
+	}
+
+	if err := os.WriteFile(path, []byte("SPAM_FANOUT=20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if Current().SpamFanout != 20 {
+		t.Fatalf("spam fanout = %d, want 20", Current().SpamFanout)
+	}
+}
+
+func TestAppendEnvKeepsExistingEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("# local\nLOG_LEVEL=debug"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AppendEnv(path, [][2]string{{"JWT_ACCESS_SECRET", "aa"}, {"JWT_REFRESH_SECRET", "bb"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# local\nLOG_LEVEL=debug\nJWT_ACCESS_SECRET=aa\nJWT_REFRESH_SECRET=bb\n"
+	if string(b) != want {
+		t.Fatalf("env file = %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,62 @@
+// Package padding hides the length of messages by growing them to one of a
+// few bucket sizes. The server pads what it stores and relays with Filler,
+// clients pad their plain text with Pad before encrypting it, so not even
+// the server learns its length.
+package padding
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidPadding = errors.New("invalid padding")
+
+// Buckets are the sizes in bytes payloads are padded to. No buckets turn
+// padding off.
+type Buckets []int64
+
+// Size is the bucket a payload of n bytes is padded to: the smallest bucket
+// it fits in, past the largest the next multiple of the largest.
+func (b Buckets) Size(n int) int {
+	if len(b) == 0 {
+		return n
+	}
+	sorted := slices.Clone(b)
+	slices.Sort(sorted)
+	for _, size := range sorted {
+		if int64(n) <= size {
+			return int(size)
+		}
+	}
+	largest := sorted[len(sorted)-1]
+	return int((int64(n) + largest - 1) / largest * largest)
+}
+
+// Filler is what the server puts next to a payload of n bytes for the two
+// to take up a whole bucket. Clients ignore it.
+func (b Buckets) Filler(n int) string {
+	return strings.Repeat("0", b.Size(n)-n)
+}
+
+// Pad appends ISO/IEC 7816-4 padding, 0x80 followed by zeros, growing data
+// to the bucket of one byte more than it has.
+func (b Buckets) Pad(data []byte) []byte {
+	size := b.Size(len(data) + 1)
+	padded := make([]byte, size)
+	copy(padded, data)
+	padded[len(data)] = 0x80
+	return padded
+}
+
+// Unpad strips the padding of Pad.
+func Unpad(data []byte) ([]byte, error) {
+	end := len(data) - 1
+	for end >= 0 && data[end] == 0 {
+		end--
+	}
+	if end < 0 || data[end] != 0x80 {
+		return nil, ErrInvalidPadding
+	}
+	return data[:end], nil
+}
@@ -0,0 +1,35 @@
+package padding
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSizePicksBucket(t *testing.T) {
+	buckets := Buckets{1024, 256}
+	for n, expected := range map[int]int{0: 256, 256: 256, 257: 1024, 1024: 1024, 1025: 2048, 3000: 3072} {
+		if size := buckets.Size(n); size != expected {
+			t.Errorf("Expected %d bytes to pad to %d, got %d", n, expected, size)
+		}
+	}
+	if size := (Buckets{}).Size(100); size != 100 {
+		t.Errorf("Expected no padding without buckets, got %d", size)
+	}
+}
+
+func TestPadRoundTrip(t *testing.T) {
+	buckets := Buckets{16, 64}
+	for _, data := range [][]byte{{}, []byte("hej"), bytes.Repeat([]byte{0}, 15), bytes.Repeat([]byte{0x80}, 16)} {
+		padded := buckets.Pad(data)
+		if len(padded) != buckets.Size(len(data)+1) {
+			t.Errorf("Expected %d bytes padded to a bucket, got %d", len(data), len(padded))
+		}
+		unpadded, err := Unpad(padded)
+		if err != nil || !bytes.Equal(unpadded, data) {
+			t.Errorf("Expected %q back, got %q (%v)", data, unpadded, err)
+		}
+	}
+	if _, err := Unpad([]byte{1, 0, 0}); err != ErrInvalidPadding {
+		t.Errorf("Expected ErrInvalidPadding, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultLimit int64 = 50
+	MaxLimit     int64 = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of the last item of a page. It is handed to clients
+// as an opaque string so the sort keys can change without breaking them.
+type Cursor struct {
+	Time time.Time     `json:"t,omitempty"`
+	ID   bson.ObjectID `json:"i"`
+}
+
+type Page struct {
+	Limit int64
+	After *Cursor
+}
+
+type Result[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func Decode(raw string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(b, &cursor); err != nil || cursor.ID.IsZero() {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// Parse builds a page from the raw "cursor" and "limit" query values.
+func Parse(rawCursor string, rawLimit string) (Page, error) {
+	page := Page{Limit: DefaultLimit}
+	if rawLimit != "" {
+		limit, err := strconv.ParseInt(rawLimit, 10, 64)
+		if err != nil || limit <= 0 {
+			return Page{}, errors.New("invalid limit")
+		}
+		page.Limit = min(limit, MaxLimit)
+	}
+	if rawCursor != "" {
+		cursor, err := Decode(rawCursor)
+		if err != nil {
+			return Page{}, err
+		}
+		page.After = &cursor
+	}
+	return page, nil
+}
+
+// Filter restricts a query to the items after the cursor, walking backwards
+// over (field, _id). An empty field pages on _id alone.
+func (p Page) Filter(field string) bson.M {
+	if p.After == nil {
+		return bson.M{}
+	}
+	if field == "" || field == "_id" {
+		return bson.M{"_id": bson.M{"$lt": p.After.ID}}
+	}
+	return bson.M{"$or": []bson.M{
+		{field: bson.M{"$lt": p.After.Time}},
+		{field: p.After.Time, "_id": bson.M{"$lt": p.After.ID}},
+	}}
+}
+
+// FindOptions sorts newest first and fetches one extra item so NewResult can
+// tell whether another page exists.
+func (p Page) FindOptions(field string) *options.FindOptionsBuilder {
+	sort := bson.D{{"_id", -1}}
+	if field != "" && field != "_id" {
+		sort = bson.D{{field, -1}, {"_id", -1}}
+	}
+	return options.Find().SetSort(sort).SetLimit(p.Limit + 1)
+}
+
+func NewResult[T any](items []T, page Page, cursorOf func(T) Cursor) Result[T] {
+	if items == nil {
+		items = []T{}
+	}
+	if int64(len(items)) <= page.Limit {
+		return Result[T]{Items: items}
+	}
+	items = items[:page.Limit]
+	return Result[T]{Items: items, NextCursor: cursorOf(items[len(items)-1]).Encode()}
+}
@@ -0,0 +1,54 @@
+package pagination
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{Time: time.Unix(1700000000, 0).UTC(), ID: bson.NewObjectID()}
+
+	decoded, err := Decode(cursor.Encode())
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !decoded.Time.Equal(cursor.Time) || decoded.ID != cursor.ID {
+		t.Errorf("Expected cursor %+v, got %+v", cursor, decoded)
+	}
+}
+
+func TestParseRejectsGarbage(t *testing.T) {
+	if _, err := Parse("not-a-cursor", ""); err == nil {
+		t.Error("Expected error for invalid cursor")
+	}
+	if _, err := Parse("", "-1"); err == nil {
+		t.Error("Expected error for negative limit")
+	}
+
+	page, err := Parse("", "1000")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if page.Limit != MaxLimit {
+		t.Errorf("Expected limit %d, got %d", MaxLimit, page.Limit)
+	}
+}
+
+func TestNewResultSetsNextCursor(t *testing.T) {
+	ids := []bson.ObjectID{bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()}
+	page := Page{Limit: 2}
+
+	result := NewResult(ids, page, func(id bson.ObjectID) Cursor { return Cursor{ID: id} })
+	if len(result.Items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(result.Items))
+	}
+	if result.NextCursor != (Cursor{ID: ids[1]}).Encode() {
+		t.Errorf("Expected next cursor to point at the last returned item")
+	}
+
+	result = NewResult(ids[:1], page, func(id bson.ObjectID) Cursor { return Cursor{ID: id} })
+	if result.NextCursor != "" {
+		t.Errorf("Expected no next cursor on the last page")
+	}
+}
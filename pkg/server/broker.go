@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/api/hooks"
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/app"
+	"filachat/internal/broker"
+	"filachat/internal/core"
+	"filachat/internal/maintenance"
+	"filachat/internal/presence"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// newBroker sets up the embedded broker with its hooks, or in bridge mode
+// connects to the external one. In bridge mode the hooks have no broker to
+// run in, the external one asks the /mqtt webhooks instead.
+func (s *Server) newBroker(application *app.App, maintenanceState *maintenance.State, restricted *imiddleware.Restrictions, clientIDs *core.ClientIDs) (broker.Publisher, error) {
+	cfg := s.Config
+	switch cfg.BrokerMode {
+	case broker.ModeEmbedded:
+		capabilities := mqtt.NewDefaultServerCapabilities()
+		capabilities.MaximumClientWritesPending = int32(cfg.MQTTMaxPendingWrites)
+		// MQTT 5 clients that announce a topic alias maximum get aliases for
+		// the topics they receive on; this bounds the ones they may set
+		capabilities.TopicAliasMaximum = uint16(cfg.MQTTTopicAliasMaximum)
+		capabilities.ReceiveMaximum = uint16(cfg.MQTTMaxInflight)
+		capabilities.MaximumInflight = uint16(cfg.MQTTMaxInflight)
+		if cfg.MQTTMaxConnections > 0 {
+			capabilities.MaximumClients = cfg.MQTTMaxConnections
+		}
+		s.drain = new(hooks.DrainHook)
+		embedded := mqtt.New(&mqtt.Options{InlineClient: true, Capabilities: capabilities})
+		publisher := broker.Embedded{Server: embedded}
+
+		limits := &hooks.LimitsHook{
+			Server:       embedded,
+			PerUser:      int(cfg.MQTTMaxConnectionsPerUser),
+			SlowInflight: int(cfg.MQTTSlowInflight),
+			SlowGrace:    cfg.MQTTSlowGrace,
+		}
+		for _, hook := range []mqtt.Hook{
+			&hooks.JWTHook{Tokens: application.Tokens, Restricted: restricted, ClientIDs: clientIDs},
+			&hooks.MaintenanceHook{State: maintenanceState},
+			s.drain,
+			new(hooks.PayloadHook),
+			&hooks.DeliveryHook{Broker: publisher},
+			new(hooks.FormatHook),
+			new(hooks.TopicStatsHook),
+			limits,
+		} {
+			if err := embedded.AddHook(hook, nil); err != nil {
+				return nil, err
+			}
+		}
+		s.goes("mqtt-limits", limits.Run)
+
+		tcp := listeners.NewTCP(listeners.Config{Address: "0.0.0.0:1883"})
+		if err := embedded.AddListener(tcp); err != nil {
+			return nil, err
+		}
+		s.Broker = embedded
+		return publisher, nil
+	case broker.ModeBridge:
+		if cfg.BridgePassword == "" {
+			return nil, errors.New("MQTT_BRIDGE_PASSWORD must be set in bridge mode")
+		}
+		// the broker authenticates the bridge through /mqtt/auth, which is
+		// not served yet, so the first attempts may fail and are retried
+		bridge, err := broker.Dial(cfg.BrokerAdress, cfg.ClientID, cfg.BridgeUsername, cfg.BridgePassword)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, func() error {
+			bridge.Close()
+			return nil
+		})
+		return bridge, nil
+	}
+	return nil, errors.New("MQTT_BROKER_MODE must be embedded or bridge")
+}
+
+// newPresence picks the presence store, shared through Redis when several
+// instances run. Presence follows broker connections, which only the
+// embedded broker sees.
+func (s *Server) newPresence(publisher broker.Publisher) (presence.PresenceStore, error) {
+	cfg := s.Config
+	var store presence.PresenceStore
+	if cfg.RedisURL != "" {
+		redis, err := presence.NewRedisStore(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		store = redis
+	} else {
+		memory := presence.NewMemoryStore()
+		s.goes("presence-store", func(ctx context.Context) { memory.Run(ctx, cfg.PresenceTTL/3) })
+		store = memory
+	}
+
+	if s.Broker != nil {
+		presenceHook := &hooks.PresenceHook{Store: store, DB: s.DB, Server: s.Broker, Broker: publisher, TTL: cfg.PresenceTTL}
+		if err := s.Broker.AddHook(presenceHook, nil); err != nil {
+			return nil, err
+		}
+		changes, err := store.Watch()
+		if err != nil {
+			return nil, err
+		}
+		s.goes("presence-hook", presenceHook.Run)
+		s.goes("presence-broadcast", func(ctx context.Context) { presenceHook.Broadcast(ctx, changes) })
+	}
+	return store, nil
+}
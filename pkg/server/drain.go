@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"filachat/internal/broker"
+	"filachat/internal/models"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"log"
+	"time"
+)
+
+// drainPoll is how often Drain looks for unacknowledged publishes.
+const drainPoll = 250 * time.Millisecond
+
+// Drain hands the clients of the embedded broker over to other instances
+// before a shutdown, so a rolling deploy loses no messages. New connections
+// are refused, connected users get a reconnect event naming
+// MQTTDrainServer and once no client has QoS 1 publishes left to
+// acknowledge, or MQTTDrainTimeout passed, the remaining clients are
+// disconnected with "use another server". In bridge mode there is nothing
+// to drain, the external broker keeps the clients.
+func (s *Server) Drain() {
+	if s.Broker == nil {
+		return
+	}
+	cfg := s.Config
+	s.drain.Drain()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.MQTTDrainTimeout)
+	defer cancel()
+
+	event := models.ControlEvent{Id: bson.NewObjectID(), Type: models.ControlReconnect, Timestamp: time.Now()}
+	if cfg.MQTTDrainServer != "" {
+		event.Details = map[string]string{"server": cfg.MQTTDrainServer}
+	}
+	payload, _ := json.Marshal(event)
+	users := s.connectedUsers()
+	log.Printf("[INFO] draining broker, %d users connected", len(users))
+	for _, user := range users {
+		// not retained: the retained event on the topic is kept for clients
+		// that are offline, such as a logout
+		if err := s.Handler.Broker.Publish(ctx, models.ControlTopic(user), payload, false, broker.QoS(broker.ClassSystem)); err != nil {
+			log.Println("[WARN] reconnect event not published", user.Hex(), err)
+		}
+	}
+
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+	for s.inflight() > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+	if pending := s.inflight(); pending > 0 {
+		log.Println("[WARN] broker drain timed out,", pending, "publishes unacknowledged")
+	}
+
+	for _, client := range s.Broker.Clients.GetAll() {
+		if client.Net.Inline || client.Closed() {
+			continue
+		}
+		_ = s.Broker.DisconnectClient(client, packets.ErrUseAnotherServer)
+	}
+}
+
+// connectedUsers are the users with a client on the embedded broker.
+func (s *Server) connectedUsers() []bson.ObjectID {
+	seen := make(map[string]bool)
+	var users []bson.ObjectID
+	for _, client := range s.Broker.Clients.GetAll() {
+		name := string(client.Properties.Username)
+		if client.Net.Inline || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if user, err := bson.ObjectIDFromHex(name); err == nil {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// inflight counts the QoS 1 and 2 publishes connected clients have yet to
+// acknowledge, or the broker has yet to acknowledge to them.
+func (s *Server) inflight() int {
+	var count int
+	for _, client := range s.Broker.Clients.GetAll() {
+		if !client.Net.Inline && !client.Closed() {
+			count += client.State.Inflight.Len()
+		}
+	}
+	return count
+}
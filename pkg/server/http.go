@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/subtle"
+	"filachat/internal/api"
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/crash"
+	"filachat/internal/maintenance"
+	"filachat/internal/metrics"
+	"filachat/internal/models"
+	"filachat/internal/redact"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"net/http"
+)
+
+// newEcho sets up the HTTP server with the middleware every route goes
+// through. The IP filter is returned for its rules to be loaded.
+func (s *Server) newEcho(maintenanceState *maintenance.State) (*imiddleware.IPFilter, error) {
+	cfg := s.Config
+	ipFilter, err := imiddleware.NewIPFilter(cfg.IPAllowList, cfg.IPDenyList, cfg.GeoBlockedCountries, cfg.GeoIPDatabase)
+	if err != nil {
+		return nil, err
+	}
+	extractIP, err := imiddleware.IPExtractor(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	e := echo.New()
+	e.IPExtractor = extractIP
+	e.HTTPErrorHandler = imiddleware.LocalizedErrors(e)
+	e.Use(middleware.Logger())
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			crash.Capture("http "+c.Path(), err, stack)
+			return err
+		},
+	}))
+	e.Use(metrics.Requests())
+	e.Use(ipFilter.Middleware)
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     cfg.AllowOrigins,
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, echo.HeaderXCSRFToken, "Upload-Offset", imiddleware.HeaderClientVersion},
+		ExposeHeaders:    []string{echo.HeaderLocation, echo.HeaderXCSRFToken, "Upload-Offset", "Upload-Length", imiddleware.HeaderMinClientVersion, imiddleware.HeaderClientUpgrade},
+		AllowMethods:     []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
+		AllowCredentials: true,
+	}))
+	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+		XSSProtection:      "1; mode=block",
+		XFrameOptions:      "SAMEORIGIN",
+		ContentTypeNosniff: "nosniff",
+		HSTSMaxAge:         3600,
+	}))
+	e.Use(imiddleware.Maintenance(maintenanceState))
+	e.Use(imiddleware.ClientVersion())
+	e.Use(imiddleware.BodyLimit(api.BodyLimit))
+	e.Use(imiddleware.BodyLog(redact.New(models.User{}, models.EmailChange{}, models.PushToken{}), api.LogsBody))
+	e.Use(imiddleware.Timeout(api.Timeout))
+	if cfg.CSRFEnabled {
+		e.Use(imiddleware.CSRF())
+	}
+	s.Echo = e
+	return ipFilter, nil
+}
+
+func (s *Server) metricsRoute() {
+	password := s.Config.MetricsPassword
+	s.Echo.GET("/metrics", metrics.Handler(), middleware.BasicAuth(func(username, given string, c echo.Context) (bool, error) {
+		return password != "" && username == "metrics" && subtle.ConstantTimeCompare([]byte(given), []byte(password)) == 1, nil
+	}))
+}
+
+// configureTLS sets up certificates, HTTP/2 and keep-alives of the TLS
+// server and the plain HTTP listener when one is configured.
+func (s *Server) configureTLS() error {
+	cfg, e := s.Config, s.Echo
+	e.AutoTLSManager.Cache = autocert.DirCache(cfg.AutoTLSCacheDir)
+	e.AutoTLSManager.Email = cfg.AutoTLSEmail
+	if len(cfg.AutoTLSHosts) > 0 {
+		e.AutoTLSManager.HostPolicy = autocert.HostWhitelist(cfg.AutoTLSHosts...)
+	} else if cfg.HTTPAddress != "" {
+		e.Logger.Warn("AUTOTLS_HOSTS not set, certificates are requested for any host")
+	}
+
+	server := e.TLSServer
+	server.ReadHeaderTimeout = cfg.HTTPReadHeaderTimeout
+	server.IdleTimeout = cfg.HTTPIdleTimeout
+	server.SetKeepAlivesEnabled(cfg.HTTPKeepAlive)
+	e.DisableHTTP2 = !cfg.HTTP2Enabled
+	if cfg.HTTP2Enabled {
+		err := http2.ConfigureServer(server, &http2.Server{
+			MaxConcurrentStreams: uint32(cfg.HTTP2MaxConcurrentStreams),
+			IdleTimeout:          cfg.HTTPIdleTimeout,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.HTTPRedirectAddress != "" {
+		s.redirect = &http.Server{
+			Addr:              cfg.HTTPRedirectAddress,
+			Handler:           e.AutoTLSManager.HTTPHandler(nil),
+			ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+			IdleTimeout:       cfg.HTTPIdleTimeout,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,449 @@
+// Package server assembles the API, the MQTT broker and the background
+// workers from a Config, for cmd/filagram and for Go programs embedding the
+// backend:
+//
+//	srv, err := server.New(config.Load())
+//	if err != nil {
+//		return err
+//	}
+//	return srv.Start(ctx)
+//
+// Programs serving HTTP themselves can mount Server.Echo, which is an
+// http.Handler, and call Start only for the broker and workers by leaving
+// HTTP_ADDRESS empty.
+package server
+
+import (
+	"context"
+	"errors"
+	"filachat/internal/api"
+	"filachat/internal/api/handlers"
+	"filachat/internal/api/hooks"
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/app"
+	"filachat/internal/broker"
+	"filachat/internal/core"
+	"filachat/internal/crash"
+	"filachat/internal/crypto"
+	database "filachat/internal/data"
+	"filachat/internal/federation"
+	"filachat/internal/i18n"
+	"filachat/internal/jobs"
+	"filachat/internal/mail"
+	"filachat/internal/maintenance"
+	"filachat/internal/media"
+	"filachat/internal/models"
+	"filachat/internal/moderation"
+	"filachat/internal/push"
+	"filachat/internal/scan"
+	"filachat/internal/spam"
+	"filachat/internal/storage"
+	"filachat/pkg/config"
+	"filachat/public"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/labstack/echo/v4"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server is the backend built from one Config. New only builds it, nothing
+// listens or runs until Start.
+type Server struct {
+	Config  *config.Config
+	Echo    *echo.Echo
+	Handler *handlers.Handler
+	DB      *database.DB
+	// Broker is the embedded MQTT broker, nil in bridge mode.
+	Broker *mqtt.Server
+
+	workers     []worker
+	stopWorkers context.CancelFunc
+	running     sync.WaitGroup
+	redirect    *http.Server
+	closers     []func() error
+	drain       *hooks.DrainHook
+}
+
+// worker is a background job started with Start. It runs until its ctx is
+// done, which Shutdown sees to before closing the database and the broker.
+type worker struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+func (s *Server) goes(name string, run func(ctx context.Context)) {
+	s.workers = append(s.workers, worker{name, run})
+}
+
+// Options replace parts of the Server New would build from the Config,
+// such as for tests. Fields left nil are built as usual.
+type Options struct {
+	// App signs tokens instead of the keys and secrets of the install.
+	App *app.App
+	// Mailer delivers the mail the server sends.
+	Mailer mail.Sender
+}
+
+// New connects to the database and the broker and wires the handlers,
+// hooks and workers. Settings that do not make sense are reported as
+// errors.
+//
+// cfg becomes config.Current, and New sets the process wide crash.Sink,
+// i18n.Default and broker.Policy from it, so a process runs one Server.
+func New(cfg *config.Config) (*Server, error) {
+	return NewWithOptions(cfg, Options{})
+}
+
+// NewWithOptions is New with parts of the Server replaced by opts.
+func NewWithOptions(cfg *config.Config, opts Options) (_ *Server, err error) {
+	config.Use(cfg)
+	if cfg.SentryDSN != "" {
+		sentry, err := crash.NewSentry(cfg.SentryDSN)
+		if err != nil {
+			return nil, errors.New("SENTRY_DSN: " + err.Error())
+		}
+		crash.Sink = sentry
+	}
+
+	application := opts.App
+	if application == nil {
+		if application, err = loadApp(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if !i18n.Supported(cfg.DefaultLocale) {
+		return nil, errors.New("DEFAULT_LOCALE has no catalog: " + cfg.DefaultLocale)
+	}
+	i18n.Default = cfg.DefaultLocale
+	if !models.EncryptionMode(cfg.DefaultEncryption).Valid() {
+		return nil, errors.New("DEFAULT_ENCRYPTION must be e2e or server")
+	}
+
+	maintenanceState := &maintenance.State{}
+	if cfg.MaintenanceMode {
+		maintenanceState.Enable("", 5*time.Minute)
+	}
+
+	broker.Policy, err = broker.ParseQoS(cfg.MQTTQoS)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Config: cfg}
+	// whatever got connected before a setting turned out wrong
+	defer func() {
+		if err != nil {
+			_ = s.close()
+		}
+	}()
+	restricted := imiddleware.NewRestrictions()
+	// the key is set once the logger for its warning is up, before anyone
+	// can connect
+	clientIDs := &core.ClientIDs{}
+	publisher, err := s.newBroker(application, maintenanceState, restricted, clientIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := database.Connect(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.closers = append(s.closers, func() error { return client.Disconnect(context.Background()) })
+	db := &database.DB{Db: client.Database(cfg.DatabaseName), Timeout: cfg.DBTimeout, BatchTimeout: cfg.DBBatchTimeout, Transactions: database.UseTransactions(context.Background(), client, cfg)}
+	keyProvider, err := crypto.NewKeyProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db.Fields, err = fieldCipher(cfg, db, keyProvider)
+	if err != nil {
+		return nil, err
+	}
+	s.DB = db
+
+	var keyring *crypto.Keyring
+	if keyProvider != nil {
+		keyring = crypto.NewKeyring(keyProvider)
+	}
+	if cfg.DefaultEncryption == string(models.EncryptionServer) && keyring == nil {
+		return nil, errors.New("DEFAULT_ENCRYPTION=server needs KMS_MASTER_KEY or KMS_VAULT_ADDRESS")
+	}
+
+	presenceStore, err := s.newPresence(publisher)
+	if err != nil {
+		return nil, err
+	}
+
+	ipFilter, err := s.newEcho(maintenanceState)
+	if err != nil {
+		return nil, err
+	}
+	e := s.Echo
+
+	passkeys, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPDisplayName,
+		RPOrigins:     cfg.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rules, err := db.GetIPRules(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	ipFilter.SetRules(rules)
+	restrictions := &jobs.RestrictionsLoader{DB: db, Restricted: restricted, Interval: time.Minute}
+	if err := restrictions.Load(context.Background()); err != nil {
+		return nil, err
+	}
+	s.goes("restrictions-loader", restrictions.Run)
+
+	janitor := &jobs.RetentionJanitor{
+		DB:                 db,
+		MaxAge:             cfg.RetentionMaxAge,
+		MaxPerConversation: cfg.RetentionMaxPerConversation,
+		Interval:           cfg.RetentionInterval,
+		DryRun:             cfg.RetentionDryRun,
+	}
+	s.goes("retention-janitor", janitor.Run)
+
+	reaper := &jobs.Reaper{DB: db, Grace: cfg.TrashGracePeriod, Interval: time.Hour}
+	s.goes("reaper", reaper.Run)
+
+	blobs := &storage.DiskStore{Root: cfg.StorageDir}
+	uploads := &jobs.UploadCollector{DB: db, Storage: blobs, Expiry: cfg.UploadExpiry, Interval: time.Hour}
+	s.goes("upload-collector", uploads.Run)
+
+	announcer := &jobs.Announcer{DB: db, Broker: publisher, Interval: time.Minute}
+	s.goes("announcer", announcer.Run)
+
+	typing := &jobs.TypingAggregator{Broker: publisher, Interval: 2 * time.Second, TTL: 5 * time.Second}
+	s.goes("typing-aggregator", typing.Run)
+
+	stats := &jobs.StatsAggregator{DB: db, Interval: cfg.StatsInterval}
+	if s.Broker != nil {
+		embedded := s.Broker
+		stats.Connections = func() int64 { return atomic.LoadInt64(&embedded.Info.ClientsConnected) }
+	}
+	s.goes("stats-aggregator", stats.Run)
+
+	scanner, err := scan.NewScanner(cfg.ScannerAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	moderationPipeline, err := moderation.NewPipeline(cfg)
+	if err != nil {
+		return nil, err
+	}
+	names, err := moderation.LoadNameFilter(cfg)
+	if err != nil {
+		return nil, errors.New("USERNAME_BLOCKED_WORDS_FILE: " + err.Error())
+	}
+
+	spamDetector := spam.NewDetector(spamLimits(cfg))
+	s.goes("spam-detector", spamDetector.Run)
+
+	e.Logger.SetLevel(logLevel(cfg.LogLevel))
+	settings := func() (map[string]string, error) { return db.GetSettings(context.Background()) }
+	watcher := &config.Watcher{Path: cfg.ConfigFile, Settings: settings, Interval: cfg.ReloadInterval}
+	watcher.OnChange(func(next *config.Config) {
+		e.Logger.SetLevel(logLevel(next.LogLevel))
+		janitor.Reconfigure(next.RetentionMaxAge, next.RetentionMaxPerConversation, next.RetentionDryRun)
+		spamDetector.SetLimits(spamLimits(next))
+	})
+	s.goes("config-watcher", watcher.Run)
+
+	mediaKey, err := signingKey(e, "MEDIA_URL_SECRET", cfg.MediaURLSecret)
+	if err != nil {
+		return nil, err
+	}
+	contactKey, err := signingKey(e, "CONTACT_TOKEN_SECRET", cfg.ContactTokenSecret)
+	if err != nil {
+		return nil, err
+	}
+	clientIDs.Key, err = signingKey(e, "MQTT_CLIENT_ID_SECRET", cfg.MQTTClientIDSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := jobs.NewSummaryProjector(db, 4096)
+	s.goes("summary-projector", summaries.Run)
+
+	pushWorker := push.NewWorker(db, db, push.NewSender(cfg.PushGatewayURL), 1024)
+	s.goes("push-worker", pushWorker.Run)
+
+	var federated *federation.Federation
+	if cfg.FederationEnabled {
+		if cfg.FederationDomain == "" {
+			return nil, errors.New("FEDERATION_ENABLED needs FEDERATION_DOMAIN")
+		}
+		federationKey, err := federation.LoadKey(filepath.Join(core.KeyDir, federation.KeyFile))
+		if err != nil {
+			return nil, errors.New("federation key not loaded, run keygen: " + err.Error())
+		}
+		federated = federation.New(cfg.FederationDomain, federationKey, cfg.FederationAllowedServers, db, cfg.FederationRetryInterval, int(cfg.FederationMaxAttempts))
+		s.goes("federation-outbox", federated.Outbox.Run)
+	}
+
+	mailer := opts.Mailer
+	if mailer == nil {
+		mailer = mail.NewSender(cfg)
+	}
+
+	h := &handlers.Handler{
+		App:         application,
+		DB:          db,
+		Config:      cfg,
+		WebAuthn:    passkeys,
+		Mailer:      mailer,
+		Broker:      publisher,
+		Embedded:    s.Broker,
+		IPFilter:    ipFilter,
+		Restricted:  restricted,
+		Push:        pushWorker,
+		Storage:     blobs,
+		Scanner:     scanner,
+		Signer:      &media.URLSigner{Key: mediaKey, TTL: cfg.MediaURLTTL},
+		Contacts:    &core.ContactTokens{Key: contactKey, TTL: cfg.ContactTokenTTL},
+		ClientIDs:   clientIDs,
+		Moderation:  moderationPipeline,
+		Names:       names,
+		Spam:        spamDetector,
+		Announcer:   announcer,
+		Summaries:   summaries,
+		Typing:      typing,
+		Maintenance: maintenanceState,
+		Presence:    presenceStore,
+		Keys:        keyring,
+		Watcher:     watcher,
+		Federation:  federated,
+	}
+	s.Handler = h
+	if federated != nil {
+		federated.Outbox.Dropped = h.FederationDropped
+	}
+	if s.Broker != nil {
+		err = s.Broker.AddHook(&hooks.LocalDeliveryHook{Ingest: h.IngestPublish}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	api.Routes(e, h)
+	s.metricsRoute()
+
+	var staticFiles fs.FS = public.Files
+	if !cfg.StaticEmbed {
+		staticFiles = os.DirFS(cfg.StaticDir)
+	}
+	api.Static(e, staticFiles, cfg.StaticMaxAge)
+
+	if err := s.configureTLS(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start runs the workers and the broker and serves the API over TLS on
+// HTTP_ADDRESS until ctx is done or serving fails. When ctx is done the
+// server drains the broker, see Drain, and is shut down, allowing in-flight
+// requests cfg.ShutdownTimeout.
+func (s *Server) Start(ctx context.Context) error {
+	workers, stop := context.WithCancel(context.Background())
+	s.stopWorkers = stop
+	for _, w := range s.workers {
+		s.running.Add(1)
+		// a panic restarts run, only its return counts as the worker ending
+		crash.Go(w.name, func() {
+			w.run(workers)
+			s.running.Done()
+		})
+	}
+	if s.Broker != nil {
+		if err := s.Broker.Serve(); err != nil {
+			return err
+		}
+	}
+	if s.redirect != nil {
+		go func() {
+			if err := s.redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.Echo.Logger.Error("HTTP redirect listener stopped: ", err)
+			}
+		}()
+	}
+
+	served := make(chan error, 1)
+	if s.Config.HTTPAddress != "" {
+		go func() { served <- s.Echo.StartAutoTLS(s.Config.HTTPAddress) }()
+	}
+
+	select {
+	case err := <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		s.Drain()
+		shutdown, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+		defer cancel()
+		return s.Shutdown(shutdown)
+	}
+}
+
+// Shutdown stops accepting requests and connections, waits for in-flight
+// requests until ctx is done, stops the workers and waits for them, then
+// disconnects from the broker and the database.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s.redirect != nil {
+		errs = append(errs, s.redirect.Shutdown(ctx))
+	}
+	errs = append(errs, s.Echo.Shutdown(ctx))
+	errs = append(errs, s.stop(ctx))
+	errs = append(errs, s.close())
+	if err := errors.Join(errs...); err != nil {
+		log.Println("[WARN] server not shut down cleanly", err)
+		return err
+	}
+	return nil
+}
+
+// stop ends the workers and waits for them until ctx is done.
+func (s *Server) stop(ctx context.Context) error {
+	if s.stopWorkers == nil {
+		return nil
+	}
+	s.stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return errors.New("workers not stopped: " + ctx.Err().Error())
+	}
+}
+
+// close disconnects from the broker and the database.
+func (s *Server) close() error {
+	var errs []error
+	if s.Broker != nil {
+		errs = append(errs, s.Broker.Close())
+	}
+	for _, closer := range s.closers {
+		errs = append(errs, closer())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"filachat/internal/app"
+	"filachat/internal/core"
+	"filachat/internal/crypto"
+	database "filachat/internal/data"
+	"filachat/internal/federation"
+	"filachat/internal/spam"
+	"filachat/pkg/config"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	glog "github.com/labstack/gommon/log"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OpenDB connects to the database of cfg with field encryption set up, for
+// tasks run next to the server.
+func OpenDB(cfg *config.Config) (*database.DB, error) {
+	client, err := database.Connect(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := &database.DB{Db: client.Database(cfg.DatabaseName), Timeout: cfg.DBTimeout, BatchTimeout: cfg.DBBatchTimeout, Transactions: database.UseTransactions(context.Background(), client, cfg)}
+
+	provider, err := crypto.NewKeyProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if db.Fields, err = fieldCipher(cfg, db, provider); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// fieldCipher sets up encryption of sensitive fields with a key kept in the
+// database, wrapped by the KMS, and created on first use. Without a KMS
+// fields are stored in the clear.
+func fieldCipher(cfg *config.Config, db *database.DB, provider crypto.KeyProvider) (*crypto.FieldCipher, error) {
+	if provider == nil || !cfg.FieldEncryption {
+		return nil, nil
+	}
+	wrapped, err := db.EnsureWrappedKey(context.Background(), "fields", func() ([]byte, error) {
+		_, wrapped, err := provider.GenerateDataKey()
+		return wrapped, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	master, err := provider.Unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewFieldCipher(master)
+}
+
+// EnsureKeys generates whatever a fresh install lacks: the signing key pairs
+// and federation key in dir and the token secrets. New secrets are set for
+// this process and appended to envFile unless it is empty; they are
+// returned for printing.
+func EnsureKeys(dir, envFile string) (bool, [][2]string, error) {
+	generated := true
+	if err := core.GenerateKeys(dir, false); err != nil {
+		if !errors.Is(err, core.ErrKeysExist) {
+			return false, nil, err
+		}
+		generated = false
+	}
+	// kept across rotations, other servers cache it
+	if err := federation.GenerateKey(filepath.Join(dir, federation.KeyFile)); err != nil {
+		return generated, nil, err
+	}
+
+	var secrets [][2]string
+	for _, name := range core.MissingSecrets() {
+		secret, err := core.NewSecret()
+		if err != nil {
+			return generated, nil, err
+		}
+		if err := os.Setenv(name, secret); err != nil {
+			return generated, nil, err
+		}
+		secrets = append(secrets, [2]string{name, secret})
+	}
+	if envFile != "" && len(secrets) > 0 {
+		if err := config.AppendEnv(envFile, secrets); err != nil {
+			return generated, nil, err
+		}
+	}
+	return generated, secrets, nil
+}
+
+// loadApp loads the signing keys and token secrets, generating them on the
+// first run when KEYGEN_ON_FIRST_RUN is set.
+func loadApp(cfg *config.Config) (*app.App, error) {
+	if cfg.KeygenOnFirstRun {
+		generated, secrets, err := EnsureKeys(core.KeyDir, cfg.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if generated {
+			fmt.Println("first run: signing keys written to", core.KeyDir)
+		}
+		if len(secrets) > 0 {
+			fmt.Println("first run: token secrets appended to", cfg.ConfigFile)
+		}
+	}
+
+	if missing := core.MissingSecrets(); len(missing) > 0 {
+		return nil, fmt.Errorf("%s must be 32 byte hex keys, run keygen", strings.Join(missing, ", "))
+	}
+	application, err := app.New(cfg, core.KeyDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w, run keygen or set KEYGEN_ON_FIRST_RUN=true", err)
+	}
+	return application, err
+}
+
+func spamLimits(cfg *config.Config) spam.Limits {
+	limits := spam.DefaultLimits()
+	limits.PerMinute = int(cfg.SpamMessagesPerMinute)
+	limits.NewRecipients = int(cfg.SpamNewRecipientsPerHour)
+	limits.Fanout = int(cfg.SpamFanout)
+	return limits
+}
+
+func logLevel(name string) glog.Lvl {
+	switch name {
+	case "debug":
+		return glog.DEBUG
+	case "warn":
+		return glog.WARN
+	case "error":
+		return glog.ERROR
+	case "off":
+		return glog.OFF
+	}
+	return glog.INFO
+}
+
+// signingKey decodes a hex secret for signed links and tokens. Without one
+// a random key is used, so whatever was issued before a restart stops
+// working, fine for development.
+func signingKey(e *echo.Echo, name string, secret string) ([]byte, error) {
+	key, err := hex.DecodeString(secret)
+	if err != nil {
+		return nil, errors.New(name + " must be hex encoded")
+	}
+	if len(key) == 0 {
+		e.Logger.Warn(name + " not set, using a random key")
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,18 @@
+//go:build testcontainers
+
+package testserver
+
+import (
+	"context"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+)
+
+// startMongo runs a single node replica set, repositories use
+// transactions when the database supports them.
+func startMongo(ctx context.Context) (string, error) {
+	container, err := mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs0"))
+	if err != nil {
+		return "", err
+	}
+	return container.ConnectionString(ctx)
+}
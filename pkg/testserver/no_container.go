@@ -0,0 +1,12 @@
+//go:build !testcontainers
+
+package testserver
+
+import (
+	"context"
+	"errors"
+)
+
+func startMongo(ctx context.Context) (string, error) {
+	return "", errors.New("TEST_DATABASE_URL not set, build with -tags testcontainers to start a container")
+}
@@ -0,0 +1,269 @@
+// Package testserver runs the API end to end for tests: the Server of
+// package server with its middleware, routes and workers, over TLS, on a
+// throwaway MongoDB database and with the embedded broker.
+// It is exported so code embedding the server can test against it too.
+//
+// MongoDB comes from TEST_DATABASE_URL when set. Built with the
+// testcontainers tag, a container is started once per test binary instead,
+// which needs Docker and the testcontainers module. Tests are skipped when
+// neither is available.
+package testserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"filachat/internal/api/handlers"
+	imiddleware "filachat/internal/api/middleware"
+	"filachat/internal/app"
+	"filachat/internal/core"
+	database "filachat/internal/data"
+	"filachat/internal/mail"
+	"filachat/internal/models"
+	"filachat/pkg/config"
+	"filachat/pkg/server"
+	"github.com/labstack/echo/v4"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a running API. Requests go to URL through Client, which trusts
+// the test certificate.
+type Server struct {
+	*httptest.Server
+	Handler *handlers.Handler
+	DB      *database.DB
+	Broker  *mqtt.Server
+	Config  *config.Config
+}
+
+var (
+	mongoOnce sync.Once
+	mongoURL  string
+	mongoErr  error
+)
+
+// databaseURL starts the shared MongoDB container on first use.
+func databaseURL() (string, error) {
+	mongoOnce.Do(func() {
+		if mongoURL = os.Getenv("TEST_DATABASE_URL"); mongoURL != "" {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		mongoURL, mongoErr = startMongo(ctx)
+	})
+	return mongoURL, mongoErr
+}
+
+// NewTestServer starts a server on a database of its own, stopped and
+// dropped when the test ends. It is built by server.New from a config that
+// starts from the environment like the real one, with the embedded broker
+// and no listener of its own; tests may change the config before sending
+// requests.
+func NewTestServer(t testing.TB) *Server {
+	t.Helper()
+
+	url, err := databaseURL()
+	if err != nil {
+		t.Skip("no MongoDB for integration tests:", err)
+	}
+
+	cfg := config.Load()
+	cfg.DatabaseURL = url
+	cfg.DatabaseName = "filagram_test_" + bson.NewObjectID().Hex()
+	cfg.HTTPAddress = ""
+	cfg.HTTPRedirectAddress = ""
+	cfg.BrokerMode = "embedded"
+	cfg.CSRFEnabled = false
+	cfg.FieldEncryption = false
+	cfg.SessionCookieMode = false
+	cfg.FederationEnabled = false
+	cfg.StorageDir = t.TempDir()
+
+	srv, err := server.NewWithOptions(cfg, server.Options{App: testApp(t, cfg), Mailer: &mailer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dropped with a client of its own, the server's is gone by then
+	t.Cleanup(func() { drop(t, url, cfg.DatabaseName) })
+	if err := srv.DB.Migrate(context.Background()); err != nil {
+		_ = srv.Shutdown(context.Background())
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Error("server not shut down:", err)
+		}
+	})
+
+	// off unless a test turns it on, the server installs it once in New
+	csrf := imiddleware.CSRF()
+	srv.Echo.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !cfg.CSRFEnabled {
+				return next(c)
+			}
+			return csrf(next)(c)
+		}
+	})
+	served := httptest.NewTLSServer(srv.Echo)
+	t.Cleanup(served.Close)
+
+	return &Server{Server: served, Handler: srv.Handler, DB: srv.DB, Broker: srv.Broker, Config: cfg}
+}
+
+// drop removes the database of a test.
+func drop(t testing.TB, url, name string) {
+	client, err := mongo.Connect(options.Client().ApplyURI(url).SetTimeout(10 * time.Second))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() { _ = client.Disconnect(context.Background()) }()
+	if err := client.Database(name).Drop(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// testApp signs tokens with keys and secrets made up for the test, the key
+// files and secrets of a real install are not needed.
+func testApp(t testing.TB, cfg *config.Config) *app.App {
+	secrets := make([]byte, 64)
+	if _, err := rand.Read(secrets); err != nil {
+		t.Fatal(err)
+	}
+	accessPublic, accessPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshPublic, refreshPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &app.App{
+		Tokens: &core.JWTTokens{
+			Issuer:   cfg.TokenIssuer,
+			Audience: cfg.TokenAudience,
+			Keys: &core.EdDSA{
+				AccessPublicKey:   accessPublic,
+				AccessPrivateKey:  accessPrivate,
+				RefreshPublicKey:  refreshPublic,
+				RefreshPrivateKey: refreshPrivate,
+			},
+			AccessSecret:  secrets[:32],
+			RefreshSecret: secrets[32:],
+		},
+		Hashing: core.NewHashing(),
+	}
+}
+
+// Do sends a JSON request, authenticated when token is not empty, and
+// decodes a JSON response into out when given. It returns the status.
+func (s *Server) Do(t testing.TB, method, path, token string, body, out any) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if out != nil && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+// SignUp creates a user and signs them in, the user returned carries the
+// tokens.
+func (s *Server) SignUp(t testing.TB, username string) models.User {
+	t.Helper()
+
+	credentials := map[string]string{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": "correct horse battery staple",
+	}
+	if status := s.Do(t, http.MethodPost, "/signup", "", credentials, nil); status != http.StatusCreated {
+		t.Fatalf("signup %s: status %d", username, status)
+	}
+	var user models.User
+	if status := s.Do(t, http.MethodPost, "/signin", "", credentials, &user); status != http.StatusOK {
+		t.Fatalf("signin %s: status %d", username, status)
+	}
+	return user
+}
+
+// Subscribe collects what the broker publishes on filter, as the client of
+// a connected user would receive it.
+func (s *Server) Subscribe(t testing.TB, filter string) <-chan []byte {
+	t.Helper()
+
+	received := make(chan []byte, 16)
+	err := s.Broker.Subscribe(filter, 1, func(cl *mqtt.Client, sub packets.Subscription, pk packets.Packet) {
+		select {
+		case received <- pk.Payload:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+// mailer keeps sent mail instead of delivering it.
+type mailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *mailer) Send(message mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+// SentMail returns the mail the server sent so far.
+func (s *Server) SentMail() []mail.Message {
+	m := s.Handler.Mailer.(*mailer)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mail.Message(nil), m.sent...)
+}
@@ -0,0 +1,12 @@
+// Package public holds the web client. A build of it goes here the way
+// bundlers lay it out, index.html next to an assets directory of files
+// with hashed names, and is compiled into the binary.
+package public
+
+import "embed"
+
+// Files is the web client as of the build, served with STATIC_EMBED
+// instead of the files on disk.
+//
+//go:embed index.html all:assets
+var Files embed.FS